@@ -3,6 +3,7 @@ package kvql
 import (
 	"fmt"
 	"strings"
+	"time"
 )
 
 var (
@@ -536,6 +537,8 @@ func (a *AggregatePlan) convertToBytes(val any) ([]byte, error) {
 		return []byte(fmt.Sprintf("%d", value)), nil
 	case float32, float64:
 		return []byte(fmt.Sprintf("%f", value)), nil
+	case time.Time:
+		return []byte(value.Format(time.RFC3339Nano)), nil
 	default:
 		if val == nil {
 			return nil, nil
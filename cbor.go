@@ -0,0 +1,270 @@
+package kvql
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+const (
+	cborUint = iota
+	cborNegInt
+	cborBytes
+	cborText
+	cborArray
+	cborMap
+	cborTag
+	cborSimple
+)
+
+// cborIndefinite is the additional information of indefinite length item
+const cborIndefinite = 31
+
+const cborBreak = 0xff
+
+// cborReader decodes the CBOR data, integers are int64 or uint64, byte
+// strings are converted to string by binaryString, the date time tags are
+// converted to time and the other tags are ignored.
+type cborReader struct {
+	data []byte
+	pos  int
+}
+
+func decodeCBOR(data []byte, args []any) (any, error) {
+	r := &cborReader{data: data}
+	ret, err := r.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(data) {
+		return nil, fmt.Errorf("extra data at %d", r.pos)
+	}
+	return ret, nil
+}
+
+func (r *cborReader) read(n int) ([]byte, error) {
+	if n < 0 || len(r.data)-r.pos < n {
+		return nil, errInsufficientData
+	}
+	ret := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return ret, nil
+}
+
+// readHead reads the initial byte and the argument of data item, info is
+// cborIndefinite when the length of item is indefinite.
+func (r *cborReader) readHead() (major byte, info byte, arg uint64, err error) {
+	b, err := r.read(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info = b[0]>>5, b[0]&0x1f
+	switch {
+	case info < 24:
+		return major, info, uint64(info), nil
+	case info <= 27:
+		data, err := r.read(1 << (info - 24))
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		for _, c := range data {
+			arg = arg<<8 | uint64(c)
+		}
+		return major, info, arg, nil
+	case info == cborIndefinite:
+		return major, info, 0, nil
+	}
+	return 0, 0, 0, fmt.Errorf("invalid additional information %d at %d", info, r.pos-1)
+}
+
+func (r *cborReader) isBreak() bool {
+	if r.pos < len(r.data) && r.data[r.pos] == cborBreak {
+		r.pos++
+		return true
+	}
+	return false
+}
+
+func (r *cborReader) decode(depth int) (any, error) {
+	if depth > maxDecodeDepth {
+		return nil, errMaxDecodeDepth
+	}
+	major, info, arg, err := r.readHead()
+	if err != nil {
+		return nil, err
+	}
+	if info == cborIndefinite && (major == cborUint || major == cborNegInt || major == cborTag) {
+		return nil, fmt.Errorf("invalid indefinite length at %d", r.pos-1)
+	}
+	switch major {
+	case cborUint:
+		return uintValue(arg), nil
+	case cborNegInt:
+		if arg > math.MaxInt64 {
+			return nil, errors.New("negative integer overflow")
+		}
+		return -1 - int64(arg), nil
+	case cborBytes, cborText:
+		data, err := r.decodeString(major, info, arg)
+		if err != nil {
+			return nil, err
+		}
+		return binaryString(data), nil
+	case cborArray:
+		return r.decodeArray(info, arg, depth)
+	case cborMap:
+		return r.decodeMap(info, arg, depth)
+	case cborTag:
+		return r.decodeTag(arg, depth)
+	}
+	return r.decodeSimple(info, arg)
+}
+
+func (r *cborReader) decodeString(major byte, info byte, arg uint64) ([]byte, error) {
+	if info != cborIndefinite {
+		if arg > uint64(len(r.data)) {
+			return nil, errInsufficientData
+		}
+		return r.read(int(arg))
+	}
+	// Indefinite length string is the concatenation of definite length
+	// chunks of the same major type
+	var ret []byte
+	for !r.isBreak() {
+		chunkMajor, chunkInfo, chunkArg, err := r.readHead()
+		if err != nil {
+			return nil, err
+		}
+		if chunkMajor != major || chunkInfo == cborIndefinite {
+			return nil, fmt.Errorf("invalid string chunk at %d", r.pos-1)
+		}
+		chunk, err := r.decodeString(chunkMajor, chunkInfo, chunkArg)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, chunk...)
+	}
+	return ret, nil
+}
+
+func (r *cborReader) decodeArray(info byte, arg uint64, depth int) (any, error) {
+	if info == cborIndefinite {
+		ret := []any{}
+		for !r.isBreak() {
+			item, err := r.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, item)
+		}
+		return ret, nil
+	}
+	// Each item has at least 1 byte
+	if arg > uint64(len(r.data)-r.pos) {
+		return nil, errInsufficientData
+	}
+	ret := make([]any, arg)
+	for i := range ret {
+		item, err := r.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		ret[i] = item
+	}
+	return ret, nil
+}
+
+func (r *cborReader) decodeMap(info byte, arg uint64, depth int) (any, error) {
+	if info != cborIndefinite && arg > uint64(len(r.data)-r.pos) {
+		return nil, errInsufficientData
+	}
+	ret := make(map[string]any)
+	for i := uint64(0); ; i++ {
+		if info == cborIndefinite {
+			if r.isBreak() {
+				break
+			}
+		} else if i >= arg {
+			break
+		}
+		key, err := r.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		val, err := r.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		ret[mapKeyString(key)] = val
+	}
+	return ret, nil
+}
+
+func (r *cborReader) decodeTag(tag uint64, depth int) (any, error) {
+	val, err := r.decode(depth + 1)
+	if err != nil {
+		return nil, err
+	}
+	switch tag {
+	case 0:
+		// Standard date/time string
+		s, ok := val.(string)
+		if !ok {
+			return nil, errors.New("invalid date/time string")
+		}
+		return time.Parse(time.RFC3339Nano, s)
+	case 1:
+		// Epoch-based date/time
+		switch v := val.(type) {
+		case int64:
+			return time.Unix(v, 0).UTC(), nil
+		case uint64:
+			return nil, errors.New("epoch date/time overflow")
+		case float64:
+			sec, frac := math.Modf(v)
+			return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+		}
+		return nil, errors.New("invalid epoch date/time")
+	}
+	return val, nil
+}
+
+func (r *cborReader) decodeSimple(info byte, arg uint64) (any, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		// null and undefined
+		return nil, nil
+	case 25:
+		return float64(halfToFloat32(uint16(arg))), nil
+	case 26:
+		return float64(math.Float32frombits(uint32(arg))), nil
+	case 27:
+		return math.Float64frombits(arg), nil
+	}
+	return nil, fmt.Errorf("unsupported simple value %d", arg)
+}
+
+// halfToFloat32 converts IEEE 754 half-precision float into float32
+func halfToFloat32(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	frac := uint32(h & 0x3ff)
+	switch {
+	case exp == 0x1f:
+		// Inf and NaN
+		return math.Float32frombits(sign | 0x7f800000 | frac<<13)
+	case exp == 0:
+		// Zero and subnormal number
+		v := float32(math.Ldexp(float64(frac), -24))
+		if sign != 0 {
+			v = -v
+		}
+		return v
+	}
+	return math.Float32frombits(sign | (exp+112)<<23 | frac<<13)
+}
@@ -13,7 +13,7 @@ func (e *BinaryOpExpr) Check(ctx *CheckCtx) error {
 		return e.checkWithAndOr(ctx)
 	case Not:
 		return NewSyntaxError(e.GetPos(), "Invalid operator !")
-	case Add, Sub, Mul, Div:
+	case Add, Sub, Mul, Div, Mod:
 		return e.checkWithMath(ctx)
 	case In:
 		return e.checkWithIn(ctx)
@@ -48,8 +48,8 @@ func (e *BinaryOpExpr) tryRewriteExpr(ctx *CheckCtx) {
 func (e *BinaryOpExpr) checkWithAndOr(ctx *CheckCtx) error {
 	op := OperatorToString[e.Op]
 	switch exp := e.Left.(type) {
-	case *BinaryOpExpr, *FunctionCallExpr, *NotExpr, *FieldReferenceExpr:
-		if e.Left.ReturnType() != TBOOL {
+	case *BinaryOpExpr, *FunctionCallExpr, *NotExpr, *FieldReferenceExpr, *CTEColumnExpr, *ParamExpr, *LambdaArgExpr:
+		if e.Left.ReturnType() != TBOOL && !isUnboundParam(exp) {
 			return NewSyntaxError(e.Left.GetPos(), "%s operator has wrong type of left expression %s", op, exp)
 		}
 	default:
@@ -57,8 +57,8 @@ func (e *BinaryOpExpr) checkWithAndOr(ctx *CheckCtx) error {
 	}
 
 	switch exp := e.Right.(type) {
-	case *BinaryOpExpr, *FunctionCallExpr, *NotExpr, *FieldReferenceExpr:
-		if exp.ReturnType() != TBOOL {
+	case *BinaryOpExpr, *FunctionCallExpr, *NotExpr, *FieldReferenceExpr, *CTEColumnExpr, *ParamExpr, *LambdaArgExpr:
+		if exp.ReturnType() != TBOOL && !isUnboundParam(exp) {
 			return NewSyntaxError(e.Right.GetPos(), "%s operator has wrong type of right expression %s", op, exp)
 		}
 	default:
@@ -72,9 +72,9 @@ func (e *BinaryOpExpr) checkWithMath(ctx *CheckCtx) error {
 	lstring := false
 	rstring := false
 	switch exp := e.Left.(type) {
-	case *BinaryOpExpr, *FunctionCallExpr, *NumberExpr, *FloatExpr, *FieldReferenceExpr:
-		if e.Left.ReturnType() != TNUMBER {
-			if e.Left.ReturnType() == TSTR {
+	case *BinaryOpExpr, *FunctionCallExpr, *NumberExpr, *FloatExpr, *FieldReferenceExpr, *CTEColumnExpr, *ParamExpr, *LambdaArgExpr:
+		if e.Left.ReturnType() != TNUMBER && !isUnboundParam(exp) {
+			if isStringType(e.Left.ReturnType()) {
 				lstring = true
 			} else {
 				return NewSyntaxError(e.Left.GetPos(), "%s operator has wrong type of left expression %s", op, exp)
@@ -87,9 +87,9 @@ func (e *BinaryOpExpr) checkWithMath(ctx *CheckCtx) error {
 	}
 
 	switch exp := e.Right.(type) {
-	case *BinaryOpExpr, *FunctionCallExpr, *NumberExpr, *FloatExpr, *FieldReferenceExpr:
-		if e.Right.ReturnType() != TNUMBER {
-			if e.Right.ReturnType() == TSTR {
+	case *BinaryOpExpr, *FunctionCallExpr, *NumberExpr, *FloatExpr, *FieldReferenceExpr, *CTEColumnExpr, *ParamExpr, *LambdaArgExpr:
+		if e.Right.ReturnType() != TNUMBER && !isUnboundParam(exp) {
+			if isStringType(e.Right.ReturnType()) {
 				rstring = true
 			} else {
 				return NewSyntaxError(e.Right.GetPos(), "%s operator has wrong type of right expression %s", op, exp)
@@ -101,6 +101,12 @@ func (e *BinaryOpExpr) checkWithMath(ctx *CheckCtx) error {
 		return NewSyntaxError(e.Right.GetPos(), "%s operator with invalid right expression %s", op, exp)
 	}
 
+	// Unbound parameter follows the type of the other side
+	if isUnboundParam(e.Left) {
+		lstring = rstring
+	} else if isUnboundParam(e.Right) {
+		rstring = lstring
+	}
 	if op == "+" && lstring && rstring {
 	} else {
 		if lstring {
@@ -110,15 +116,15 @@ func (e *BinaryOpExpr) checkWithMath(ctx *CheckCtx) error {
 			return NewSyntaxError(e.Right.GetPos(), "%s operator with invalid right expression %s", op, e.Left)
 		}
 	}
-	if op == "/" {
-		switch rval := e.Right.(type) {
+	if op == "/" || op == "%" {
+		switch rval := boundValue(e.Right).(type) {
 		case *NumberExpr:
 			if rval.Int == 0 {
-				return NewSyntaxError(e.Right.GetPos(), "/ operator divide by zero")
+				return NewSyntaxError(e.Right.GetPos(), "%s operator divide by zero", op)
 			}
 		case *FloatExpr:
 			if rval.Float == 0.0 {
-				return NewSyntaxError(e.Right.GetPos(), "/ operator divide by zero")
+				return NewSyntaxError(e.Right.GetPos(), "%s operator divide by zero", op)
 			}
 		}
 	}
@@ -141,9 +147,9 @@ func (e *BinaryOpExpr) checkWithCompares(ctx *CheckCtx) error {
 		case ValueKW:
 			numValueFieldExpr++
 		}
-	case *FunctionCallExpr, *FieldReferenceExpr:
+	case *FunctionCallExpr, *FieldReferenceExpr, *CTEColumnExpr:
 		numCallExpr++
-	case *StringExpr, *BoolExpr, *NumberExpr, *FloatExpr, *BinaryOpExpr, *FieldAccessExpr:
+	case *StringExpr, *BoolExpr, *NumberExpr, *FloatExpr, *BinaryOpExpr, *FieldAccessExpr, *ParamExpr, *LambdaArgExpr:
 	default:
 		return NewSyntaxError(e.Left.GetPos(), "%s operator with invalid left expression", op)
 	}
@@ -156,9 +162,9 @@ func (e *BinaryOpExpr) checkWithCompares(ctx *CheckCtx) error {
 		case ValueKW:
 			numValueFieldExpr++
 		}
-	case *FunctionCallExpr, *FieldReferenceExpr:
+	case *FunctionCallExpr, *FieldReferenceExpr, *CTEColumnExpr:
 		numCallExpr++
-	case *StringExpr, *BoolExpr, *NumberExpr, *FloatExpr, *BinaryOpExpr, *FieldAccessExpr:
+	case *StringExpr, *BoolExpr, *NumberExpr, *FloatExpr, *BinaryOpExpr, *FieldAccessExpr, *ParamExpr, *LambdaArgExpr:
 	default:
 		return NewSyntaxError(e.Right.GetPos(), "%s operator with invalid right expression", op)
 	}
@@ -167,18 +173,32 @@ func (e *BinaryOpExpr) checkWithCompares(ctx *CheckCtx) error {
 		return NewSyntaxError(e.GetPos(), "%s operator with two same field", op)
 	}
 
+	// JSON value type is known at runtime, see execJSONCompare
+	if e.isJSONCompare() {
+		switch e.Op {
+		case Eq, NotEq, Gt, Gte, Lt, Lte:
+			return nil
+		}
+	}
+
 	ltype := e.Left.ReturnType()
 	rtype := e.Right.ReturnType()
-	if ltype != rtype {
+	// Unbound parameter will be checked again after bind
+	if isUnboundParam(e.Left) {
+		ltype = rtype
+	} else if isUnboundParam(e.Right) {
+		rtype = ltype
+	}
+	if !isSameType(ltype, rtype) {
 		return NewSyntaxError(e.GetPos(), "%s operator left and right type not same", op)
 	}
 	switch e.Op {
 	case Gt, Gte, Lt, Lte:
-		if ltype != TNUMBER && ltype != TSTR {
+		if ltype != TNUMBER && !isStringType(ltype) && ltype != TTIME {
 			return NewSyntaxError(e.Left.GetPos(), "%s operator has wrong type of left expression", op)
 		}
 	case PrefixMatch, RegExpMatch:
-		if ltype != TSTR {
+		if !isStringType(ltype) {
 			return NewSyntaxError(e.Left.GetPos(), "%s operator has wrong type of left expression", op)
 		}
 	}
@@ -190,11 +210,11 @@ func (e *BinaryOpExpr) checkWithIn(ctx *CheckCtx) error {
 	switch r := e.Right.(type) {
 	case *ListExpr:
 		for _, expr := range r.List {
-			if expr.ReturnType() != ltype {
+			if !isSameType(expr.ReturnType(), ltype) && !isUnboundParam(expr) && !isUnboundParam(e.Left) {
 				return NewSyntaxError(expr.GetPos(), "in operator element has wrong type")
 			}
 		}
-	case *FunctionCallExpr, *FieldReferenceExpr:
+	case *FunctionCallExpr, *FieldReferenceExpr, *CTEColumnExpr:
 		if r.ReturnType() != TLIST {
 			return NewSyntaxError(r.GetPos(), "in operator element has wrong type")
 		}
@@ -211,15 +231,18 @@ func (e *BinaryOpExpr) checkWithBetween(ctx *CheckCtx) error {
 		return NewSyntaxError(e.Right.GetPos(), "between operator invalid right expression")
 	}
 
+	lexpr := rlist.List[0]
+	uexpr := rlist.List[1]
+	if isUnboundParam(e.Left) {
+		return nil
+	}
 	switch ltype {
-	case TSTR, TNUMBER:
+	case TSTR, TBYTES, TNUMBER:
 	default:
 		return NewSyntaxError(e.Left.GetPos(), "between operator only support string and number type")
 	}
 
-	lexpr := rlist.List[0]
-	uexpr := rlist.List[1]
-	if lexpr.ReturnType() != ltype || uexpr.ReturnType() != ltype {
+	if (!isSameType(lexpr.ReturnType(), ltype) && !isUnboundParam(lexpr)) || (!isSameType(uexpr.ReturnType(), ltype) && !isUnboundParam(uexpr)) {
 		return NewSyntaxError(e.Right.GetPos(), "between operator right expression with wrong type")
 	}
 	return nil
@@ -253,12 +276,108 @@ func (e *FunctionCallExpr) Check(ctx *CheckCtx) error {
 	}
 	if len(e.Args) > 0 {
 		for i, a := range e.Args {
+			if _, ok := a.(*LambdaExpr); ok {
+				// Lambda is checked after the element type is known
+				continue
+			}
 			a = e.tryRewriteExpr(i, ctx)
 			if err := a.Check(ctx); err != nil {
 				return err
 			}
 		}
 	}
+	return e.checkLambdaArgs(ctx)
+}
+
+// checkLambdaArgs binds the parameter types of lambda argument and checks
+// the lambda body, the element type comes from the list argument.
+func (e *FunctionCallExpr) checkLambdaArgs(ctx *CheckCtx) error {
+	fname, _ := GetFuncNameFromExpr(e)
+	lidx, isLambdaFunc := lambdaFuncs[fname]
+	if isLambdaFunc && lidx >= len(e.Args) {
+		return NewSyntaxError(e.GetPos(), "%s function require lambda expression as argument %d", fname, lidx+1)
+	}
+	for i, a := range e.Args {
+		lambda, ok := a.(*LambdaExpr)
+		if !isLambdaFunc || i != lidx {
+			if ok {
+				return NewSyntaxError(lambda.GetPos(), "%s function argument %d cannot be lambda expression", fname, i+1)
+			}
+			continue
+		}
+		if !ok {
+			return NewSyntaxError(a.GetPos(), "%s function require lambda expression as argument %d", fname, i+1)
+		}
+		types := []Type{listElemType(e.Args[0])}
+		if fname == "list_reduce" {
+			// (acc, x) -> ..., accumulator starts from the initial value
+			types = []Type{e.Args[1].ReturnType(), types[0]}
+		}
+		if len(lambda.Params) != len(types) {
+			return NewSyntaxError(lambda.GetPos(), "%s function require lambda expression with %d parameters", fname, len(types))
+		}
+		lambda.bindTypes(types)
+		if err := lambda.Body.Check(ctx); err != nil {
+			return err
+		}
+		switch fname {
+		case "list_filter", "list_any", "list_all":
+			// JSON value is checked at runtime
+			if lambda.ReturnType() != TBOOL && !isUnboundParam(lambda.Body) && !isJSONValueExpr(lambda.Body) {
+				return NewSyntaxError(lambda.Body.GetPos(), "%s function require lambda expression return bool type", fname)
+			}
+		}
+	}
+	return nil
+}
+
+// bindTypes sets the types of the parameter references in lambda body
+func (e *LambdaExpr) bindTypes(types []Type) {
+	e.Body.Walk(func(ne Expression) bool {
+		if arg, ok := ne.(*LambdaArgExpr); ok && arg.Index >= e.Index && arg.Index < e.Index+len(types) {
+			arg.Type = types[arg.Index-e.Index]
+		}
+		return true
+	})
+}
+
+// listElemType returns the element type of list expression, TUNKNOWN means
+// the type is only known at runtime such as JSON array.
+func listElemType(e Expression) Type {
+	switch expr := e.(type) {
+	case *FieldReferenceExpr:
+		return listElemType(expr.FieldExpr)
+	case *FunctionCallExpr:
+		fname, err := GetFuncNameFromExpr(expr)
+		if err != nil {
+			return TUNKNOWN
+		}
+		switch fname {
+		case "split", "json_keys":
+			return TSTR
+		case "list", "int_list", "float_list", "ilist", "flist":
+			return TNUMBER
+		case "list_map":
+			if len(expr.Args) < 2 {
+				return TUNKNOWN
+			}
+			if lambda, ok := expr.Args[1].(*LambdaExpr); ok && !isJSONValueExpr(lambda.Body) {
+				return lambda.ReturnType()
+			}
+		case "list_filter", "list_slice", "list_sort", "list_distinct":
+			if len(expr.Args) > 0 {
+				return listElemType(expr.Args[0])
+			}
+		}
+	}
+	return TUNKNOWN
+}
+
+func (e *LambdaExpr) Check(ctx *CheckCtx) error {
+	return NewSyntaxError(e.GetPos(), "Lambda expression can only be used as argument of list function")
+}
+
+func (e *LambdaArgExpr) Check(ctx *CheckCtx) error {
 	return nil
 }
 
@@ -294,14 +413,23 @@ func (e *BoolExpr) Check(ctx *CheckCtx) error {
 	return nil
 }
 
+func (e *ParamExpr) Check(ctx *CheckCtx) error {
+	return nil
+}
+
 func (e *ListExpr) Check(ctx *CheckCtx) error {
 	if len(e.List) == 0 {
 		return NewSyntaxError(e.GetPos(), "Empty list")
 	}
 	if len(e.List) > 1 {
-		ftype := e.List[0].ReturnType()
-		for i, item := range e.List[1:] {
-			if item.ReturnType() != ftype {
+		ftype := TUNKNOWN
+		for i, item := range e.List {
+			if isUnboundParam(item) {
+				continue
+			}
+			if ftype == TUNKNOWN {
+				ftype = item.ReturnType()
+			} else if !isSameType(item.ReturnType(), ftype) {
 				return NewSyntaxError(item.GetPos(), "List %d item has wrong type", i)
 			}
 		}
@@ -310,19 +438,22 @@ func (e *ListExpr) Check(ctx *CheckCtx) error {
 }
 
 func (e *FieldAccessExpr) Check(ctx *CheckCtx) error {
-	_, leftIsFAE := e.Left.(*FieldAccessExpr)
+	leftIsFAE := isJSONValueExpr(e.Left)
 	lrType := e.Left.ReturnType()
 	switch lrType {
 	case TJSON, TLIST:
 	default:
 		if leftIsFAE {
 			// Support cascade field access such as:
-			// json(value)['x']['y']
+			// json(value)['x']['y'] or json_extract(value, '$.x')['y']
 			return nil
 		}
 		return NewSyntaxError(e.Left.GetPos(), "Field access expression left require JSON or List type")
 	}
-	switch e.FieldName.(type) {
+	if isUnboundParam(e.FieldName) {
+		return nil
+	}
+	switch boundValue(e.FieldName).(type) {
 	case *StringExpr:
 		if lrType == TJSON {
 			return nil
@@ -346,3 +477,7 @@ func (e *FieldAccessExpr) Check(ctx *CheckCtx) error {
 func (e *FieldReferenceExpr) Check(ctx *CheckCtx) error {
 	return nil
 }
+
+func (e *CTEColumnExpr) Check(ctx *CheckCtx) error {
+	return nil
+}
@@ -0,0 +1,268 @@
+package kvql
+
+// stmtCloner deep copies the statement, so one prepared statement can be
+// instantiated to many plans which are executed concurrently. The shared
+// expressions (e.g. field reference and the field it refers to) are still
+// shared in the copy. pos maps the positions of the copied nodes, nil
+// means keep the positions.
+type stmtCloner struct {
+	exprs map[Expression]Expression
+	ctes  map[*CTEStmt]*CTEStmt
+	pos   func(int) int
+}
+
+func newStmtCloner(pos func(int) int) *stmtCloner {
+	if pos == nil {
+		pos = func(p int) int { return p }
+	}
+	return &stmtCloner{
+		exprs: make(map[Expression]Expression),
+		ctes:  make(map[*CTEStmt]*CTEStmt),
+		pos:   pos,
+	}
+}
+
+func (c *stmtCloner) statement(stmt Statement) Statement {
+	switch s := stmt.(type) {
+	case *SelectStmt:
+		return c.selectStmt(s)
+	case *PutStmt:
+		ret := *s
+		ret.Pos = c.pos(s.Pos)
+		ret.KVPairs = make([]*PutKVPair, len(s.KVPairs))
+		for i, kvp := range s.KVPairs {
+			ret.KVPairs[i] = &PutKVPair{
+				Key:   c.expr(kvp.Key),
+				Value: c.expr(kvp.Value),
+			}
+		}
+		if s.Select != nil {
+			ret.Select = c.selectStmt(s.Select)
+		}
+		ret.OldValue = c.expr(s.OldValue)
+		return &ret
+	case *RemoveStmt:
+		ret := *s
+		ret.Pos = c.pos(s.Pos)
+		ret.Keys = c.exprList(s.Keys)
+		ret.Returning = c.returning(s.Returning)
+		return &ret
+	case *DeleteStmt:
+		ret := *s
+		ret.Pos = c.pos(s.Pos)
+		ret.Where = c.where(s.Where)
+		ret.Limit = c.limit(s.Limit)
+		ret.Returning = c.returning(s.Returning)
+		return &ret
+	case *UpdateStmt:
+		ret := *s
+		ret.Pos = c.pos(s.Pos)
+		ret.Value = c.expr(s.Value)
+		ret.Where = c.where(s.Where)
+		ret.Limit = c.limit(s.Limit)
+		ret.Returning = c.returning(s.Returning)
+		return &ret
+	}
+	return stmt
+}
+
+func (c *stmtCloner) selectStmt(s *SelectStmt) *SelectStmt {
+	ret := *s
+	ret.Pos = c.pos(s.Pos)
+	if s.With != nil {
+		ret.With = &WithStmt{
+			Pos:  c.pos(s.With.Pos),
+			CTEs: make([]*CTEStmt, len(s.With.CTEs)),
+		}
+		for i, cte := range s.With.CTEs {
+			ret.With.CTEs[i] = c.cte(cte)
+		}
+	}
+	if s.From != nil {
+		ret.From = &FromStmt{
+			Pos:  c.pos(s.From.Pos),
+			CTE:  c.cte(s.From.CTE),
+			Scan: c.cte(s.From.Scan),
+		}
+	}
+	ret.FieldNames = append([]string{}, s.FieldNames...)
+	ret.FieldTypes = append([]Type{}, s.FieldTypes...)
+	ret.Fields = c.exprList(s.Fields)
+	ret.Where = c.where(s.Where)
+	if s.Order != nil {
+		ret.Order = &OrderStmt{
+			Pos:    c.pos(s.Order.Pos),
+			Orders: make([]OrderField, len(s.Order.Orders)),
+		}
+		for i, o := range s.Order.Orders {
+			o.Field = c.expr(o.Field)
+			ret.Order.Orders[i] = o
+		}
+	}
+	if s.GroupBy != nil {
+		ret.GroupBy = &GroupByStmt{
+			Pos:    c.pos(s.GroupBy.Pos),
+			Fields: make([]GroupByField, len(s.GroupBy.Fields)),
+		}
+		for i, f := range s.GroupBy.Fields {
+			f.Expr = c.expr(f.Expr)
+			ret.GroupBy.Fields[i] = f
+		}
+	}
+	ret.Limit = c.limit(s.Limit)
+	return &ret
+}
+
+func (c *stmtCloner) cte(cte *CTEStmt) *CTEStmt {
+	if cte == nil {
+		return nil
+	}
+	if ret, have := c.ctes[cte]; have {
+		return ret
+	}
+	ret := *cte
+	ret.Pos = c.pos(cte.Pos)
+	ret.result = nil
+	c.ctes[cte] = &ret
+	ret.Select = c.selectStmt(cte.Select)
+	return &ret
+}
+
+func (c *stmtCloner) where(w *WhereStmt) *WhereStmt {
+	if w == nil {
+		return nil
+	}
+	return &WhereStmt{
+		Pos:  c.pos(w.Pos),
+		Expr: c.expr(w.Expr),
+	}
+}
+
+func (c *stmtCloner) limit(l *LimitStmt) *LimitStmt {
+	if l == nil {
+		return nil
+	}
+	ret := *l
+	ret.Pos = c.pos(l.Pos)
+	return &ret
+}
+
+func (c *stmtCloner) returning(r *ReturningStmt) *ReturningStmt {
+	if r == nil {
+		return nil
+	}
+	return &ReturningStmt{
+		Pos:        c.pos(r.Pos),
+		Fields:     c.exprList(r.Fields),
+		FieldNames: append([]string{}, r.FieldNames...),
+		FieldTypes: append([]Type{}, r.FieldTypes...),
+	}
+}
+
+func (c *stmtCloner) exprList(list []Expression) []Expression {
+	if list == nil {
+		return nil
+	}
+	ret := make([]Expression, len(list))
+	for i, e := range list {
+		ret[i] = c.expr(e)
+	}
+	return ret
+}
+
+func (c *stmtCloner) expr(expr Expression) Expression {
+	if expr == nil {
+		return nil
+	}
+	if ret, have := c.exprs[expr]; have {
+		return ret
+	}
+	var ret Expression
+	switch e := expr.(type) {
+	case *BinaryOpExpr:
+		n := *e
+		n.Pos = c.pos(e.Pos)
+		n.Left = c.expr(e.Left)
+		n.Right = c.expr(e.Right)
+		ret = &n
+	case *FieldExpr:
+		n := *e
+		n.Pos = c.pos(e.Pos)
+		ret = &n
+	case *StringExpr:
+		n := *e
+		n.Pos = c.pos(e.Pos)
+		ret = &n
+	case *NotExpr:
+		n := *e
+		n.Pos = c.pos(e.Pos)
+		n.Right = c.expr(e.Right)
+		ret = &n
+	case *FunctionCallExpr:
+		n := *e
+		n.Pos = c.pos(e.Pos)
+		n.Name = c.expr(e.Name)
+		n.Args = c.exprList(e.Args)
+		// Result is set by aggregate plan
+		n.Result = nil
+		ret = &n
+	case *NameExpr:
+		n := *e
+		n.Pos = c.pos(e.Pos)
+		ret = &n
+	case *FieldReferenceExpr:
+		n := *e
+		if e.Name != nil {
+			n.Name = c.expr(e.Name).(*NameExpr)
+		}
+		n.FieldExpr = c.expr(e.FieldExpr)
+		ret = &n
+	case *CTEColumnExpr:
+		n := *e
+		n.Pos = c.pos(e.Pos)
+		n.CTE = c.cte(e.CTE)
+		ret = &n
+	case *NumberExpr:
+		n := *e
+		n.Pos = c.pos(e.Pos)
+		ret = &n
+	case *FloatExpr:
+		n := *e
+		n.Pos = c.pos(e.Pos)
+		ret = &n
+	case *BoolExpr:
+		n := *e
+		n.Pos = c.pos(e.Pos)
+		ret = &n
+	case *ParamExpr:
+		n := *e
+		n.Pos = c.pos(e.Pos)
+		n.Value = c.expr(e.Value)
+		ret = &n
+	case *ListExpr:
+		n := *e
+		n.Pos = c.pos(e.Pos)
+		n.List = c.exprList(e.List)
+		ret = &n
+	case *FieldAccessExpr:
+		n := *e
+		n.Pos = c.pos(e.Pos)
+		n.Left = c.expr(e.Left)
+		n.FieldName = c.expr(e.FieldName)
+		ret = &n
+	case *LambdaExpr:
+		n := *e
+		n.Pos = c.pos(e.Pos)
+		n.Params = append([]string(nil), e.Params...)
+		n.Body = c.expr(e.Body)
+		ret = &n
+	case *LambdaArgExpr:
+		n := *e
+		n.Pos = c.pos(e.Pos)
+		ret = &n
+	default:
+		ret = expr
+	}
+	c.exprs[expr] = ret
+	return ret
+}
@@ -0,0 +1,207 @@
+// Package backend is the registry of storage backends of kvql command,
+// the backend is selected by `-store scheme://addr` flag. `mem` and `file`
+// backends are built in, other backends register themselves in init and
+// are linked by a blank import in a file with build tag of cmd/kvql:
+//
+//	//go:build tikv
+//
+//	package main
+//
+//	import _ "example.com/kvql-tikv"
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/c4pt0r/kvql"
+	"github.com/c4pt0r/kvql/memstore"
+)
+
+// Opener opens the storage by the address after `scheme://`, the returned
+// closer is called when the CLI exits.
+type Opener func(addr string) (kvql.Storage, io.Closer, error)
+
+var (
+	backendsMu sync.RWMutex
+	backends   = map[string]Opener{
+		"mem":  openMemStorage,
+		"file": openFileStorage,
+	}
+)
+
+// Register adds the storage backend of scheme, it replaces the backend
+// registered with the same scheme.
+func Register(scheme string, o Opener) {
+	backendsMu.Lock()
+	defer backendsMu.Unlock()
+	backends[scheme] = o
+}
+
+// Names returns the sorted schemes of registered backends
+func Names() []string {
+	backendsMu.RLock()
+	defer backendsMu.RUnlock()
+	ret := make([]string, 0, len(backends))
+	for scheme := range backends {
+		ret = append(ret, scheme)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Open opens the storage of uri in `scheme://address` format
+func Open(uri string) (kvql.Storage, io.Closer, error) {
+	scheme, addr, ok := strings.Cut(uri, "://")
+	if !ok {
+		return nil, nil, fmt.Errorf("invalid store %s, should be scheme://address", uri)
+	}
+	backendsMu.RLock()
+	o, have := backends[scheme]
+	backendsMu.RUnlock()
+	if !have {
+		return nil, nil, fmt.Errorf("unknown store %s, supported: %s", scheme, strings.Join(Names(), ", "))
+	}
+	return o(addr)
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error {
+	return nil
+}
+
+// openMemStorage opens an empty in memory storage
+func openMemStorage(addr string) (kvql.Storage, io.Closer, error) {
+	return memstore.New(), nopCloser{}, nil
+}
+
+// kvLine is a line of file storage, key and value are encoded in base64
+// by JSON so binary data is kept.
+type kvLine struct {
+	Key   []byte `json:"key"`
+	Value []byte `json:"value"`
+}
+
+// fileStorage is the in memory storage loaded from JSON Lines file, the
+// data is written back to file on close if it is changed.
+type fileStorage struct {
+	path  string
+	store *memstore.Store
+	// digest is the checksum of data when loaded
+	digest []byte
+}
+
+func openFileStorage(path string) (kvql.Storage, io.Closer, error) {
+	fs := &fileStorage{
+		path:  path,
+		store: memstore.New(),
+	}
+	if err := fs.load(); err != nil {
+		return nil, nil, err
+	}
+	digest, err := fs.checksum()
+	if err != nil {
+		return nil, nil, err
+	}
+	fs.digest = digest
+	return fs.store, fs, nil
+}
+
+func (fs *fileStorage) load() error {
+	f, err := os.Open(fs.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(bufio.NewReader(f))
+	var kvs []kvql.KVPair
+	for {
+		var kv kvLine
+		err = dec.Decode(&kv)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("load %s: %w", fs.path, err)
+		}
+		kvs = append(kvs, kvql.NewKVP(kv.Key, kv.Value))
+	}
+	return fs.store.BatchPut(kvs)
+}
+
+// checksum returns the digest of data, the file is not rewritten if data
+// is not changed, such as in read only mode.
+func (fs *fileStorage) checksum() ([]byte, error) {
+	h := sha256.New()
+	if err := fs.save(h); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// Close writes data to a temporary file and renames it to the path, so
+// the file is not broken if write fails.
+func (fs *fileStorage) Close() error {
+	digest, err := fs.checksum()
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(digest, fs.digest) {
+		return nil
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(fs.path), filepath.Base(fs.path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	err = fs.save(tmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err = os.Rename(tmp.Name(), fs.path); err != nil {
+		return err
+	}
+	fs.digest = digest
+	return nil
+}
+
+func (fs *fileStorage) save(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	cursor, err := fs.store.Cursor()
+	if err != nil {
+		return err
+	}
+	if err = cursor.Seek(nil); err != nil {
+		return err
+	}
+	for {
+		key, value, err := cursor.Next()
+		if err != nil {
+			return err
+		}
+		if key == nil {
+			break
+		}
+		if err = enc.Encode(&kvLine{Key: key, Value: value}); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
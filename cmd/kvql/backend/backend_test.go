@@ -0,0 +1,111 @@
+package backend
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/kvql"
+	"github.com/c4pt0r/kvql/memstore"
+)
+
+func TestFileStorage(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.jsonl")
+	s, closer, err := Open("file://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put([]byte("b"), []byte("2"))
+	s.Put([]byte("a"), []byte("1"))
+	if err = closer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if string(data) != "{\"key\":\"YQ==\",\"value\":\"MQ==\"}\n{\"key\":\"Yg==\",\"value\":\"Mg==\"}\n" {
+		t.Fatal("Unexpected file data", string(data))
+	}
+	s, _, err = Open("file://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := s.Get([]byte("b")); string(val) != "2" {
+		t.Fatal("Unexpected value", string(val))
+	}
+}
+
+func TestFileStorageBinary(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.jsonl")
+	s, closer, err := Open("file://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = kvql.NewSession(s).Execute("put (x'00ff80', x'c3')", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err = closer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, _, err = Open("file://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := s.Get([]byte{0x00, 0xff, 0x80}); string(val) != "\xc3" {
+		t.Fatalf("Unexpected value %q", val)
+	}
+}
+
+func TestFileStorageUnchanged(t *testing.T) {
+	dir := t.TempDir()
+	// The file is not created if nothing is written
+	path := filepath.Join(dir, "empty.jsonl")
+	_, closer, err := Open("file://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = closer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("Should not create file", err)
+	}
+
+	// The file is not rewritten if data is not changed
+	path = filepath.Join(dir, "data.jsonl")
+	data := "{\"key\": \"YQ==\", \"value\": \"MQ==\"}\n"
+	if err = os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, closer, err := Open("file://" + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Put([]byte("a"), []byte("1"))
+	if err = closer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != data {
+		t.Fatal("Should not rewrite file", string(got))
+	}
+}
+
+func TestRegister(t *testing.T) {
+	if _, _, err := Open("tikv://127.0.0.1:2379"); err == nil {
+		t.Fatal("Should get unknown store error")
+	}
+	Register("tikv", func(addr string) (kvql.Storage, io.Closer, error) {
+		return memstore.New(), nopCloser{}, nil
+	})
+	defer func() {
+		backendsMu.Lock()
+		delete(backends, "tikv")
+		backendsMu.Unlock()
+	}()
+	if _, _, err := Open("tikv://127.0.0.1:2379"); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(Names(), ",") != "file,mem,tikv" {
+		t.Fatal("Unexpected backends", Names())
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/kvql"
+)
+
+const helpText = `Statements end with ';' and can span multiple lines.
+
+Meta commands:
+  \explain <query>   show the plan of query
+  \timing [on|off]   toggle or set printing execution time
+  \format [name]     show or set output format: %s
+  \help              show this help
+  \quit              exit
+`
+
+type cli struct {
+	sess   *kvql.Session
+	out    io.Writer
+	errOut io.Writer
+	format string
+	timing bool
+	// failed is set if any statement or command fails
+	failed bool
+}
+
+// statementBuffer collects input lines until the statement ends with `;`
+// out of string.
+type statementBuffer struct {
+	lines []string
+}
+
+func (b *statementBuffer) empty() bool {
+	return len(b.lines) == 0
+}
+
+func (b *statementBuffer) reset() {
+	b.lines = b.lines[:0]
+}
+
+func (b *statementBuffer) String() string {
+	return strings.Join(b.lines, "\n")
+}
+
+// add appends the line and returns the statements if they are complete
+func (b *statementBuffer) add(line string) (string, bool) {
+	if b.empty() && strings.TrimSpace(line) == "" {
+		return "", false
+	}
+	b.lines = append(b.lines, line)
+	query := b.String()
+	toks := kvql.NewLexer(query).Split()
+	if len(toks) == 0 || toks[len(toks)-1].Tp != kvql.SEMI {
+		return "", false
+	}
+	b.reset()
+	return query, true
+}
+
+// feed handles an input line, meta commands are executed at once and the
+// statements are executed when they are complete. It returns the executed
+// input for history.
+func (c *cli) feed(buf *statementBuffer, line string) (string, bool) {
+	if buf.empty() {
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, `\`) || isQuitCommand(trimmed) {
+			return trimmed, c.runCommand(trimmed)
+		}
+	}
+	query, ok := buf.add(line)
+	if !ok {
+		return "", false
+	}
+	c.runQuery(query)
+	return query, false
+}
+
+func isQuitCommand(cmd string) bool {
+	switch strings.ToLower(strings.TrimSuffix(cmd, ";")) {
+	case "exit", "quit":
+		return true
+	}
+	return false
+}
+
+// runCommand runs the meta command, it returns true if CLI should exit
+func (c *cli) runCommand(line string) bool {
+	if isQuitCommand(line) {
+		return true
+	}
+	cmd, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+	switch cmd {
+	case `\q`, `\quit`:
+		return true
+	case `\h`, `\help`, `\?`:
+		fmt.Fprintf(c.out, helpText, strings.Join(formats, ", "))
+	case `\timing`:
+		switch strings.ToLower(arg) {
+		case "":
+			c.timing = !c.timing
+		case "on":
+			c.timing = true
+		case "off":
+			c.timing = false
+		default:
+			c.printError(fmt.Errorf("invalid timing option %s, should be on or off", arg))
+			return false
+		}
+		fmt.Fprintf(c.out, "Timing is %s\n", onOff(c.timing))
+	case `\format`:
+		if arg == "" {
+			fmt.Fprintf(c.out, "Format is %s\n", c.format)
+			return false
+		}
+		if _, err := newFormatter(arg, c.out); err != nil {
+			c.printError(err)
+			return false
+		}
+		c.format = arg
+	case `\explain`:
+		c.explain(arg)
+	default:
+		c.printError(fmt.Errorf("unknown command %s, type \\help for help", cmd))
+	}
+	return false
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
+
+func (c *cli) printError(err error) {
+	c.failed = true
+	fmt.Fprintf(c.errOut, "ERROR: %s\n", err)
+}
+
+func (c *cli) explain(query string) {
+	query = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(query), ";"))
+	if query == "" {
+		c.printError(fmt.Errorf("usage: \\explain <query>"))
+		return
+	}
+	plan, err := kvql.NewOptimizer(query).BuildPlan(c.sess.Storage, c.sess.Policy)
+	if err != nil {
+		if qerr, ok := err.(kvql.QueryBinder); ok {
+			qerr.BindQuery(query)
+		}
+		c.printError(err)
+		return
+	}
+	for _, line := range plan.Explain() {
+		fmt.Fprintln(c.out, line)
+	}
+}
+
+// runQuery executes the statements, the time of each statement includes
+// building plan and writing result.
+func (c *cli) runQuery(query string) {
+	start := time.Now()
+	err := c.sess.Execute(query, func(stmt string, plan kvql.FinalPlan) error {
+		err := c.writeResult(plan)
+		if c.timing {
+			fmt.Fprintf(c.out, "Time: %s\n", time.Since(start).Round(time.Microsecond))
+		}
+		start = time.Now()
+		return err
+	})
+	if err != nil {
+		c.printError(err)
+	}
+}
+
+func (c *cli) writeResult(plan kvql.FinalPlan) error {
+	f, err := newFormatter(c.format, c.out)
+	if err != nil {
+		return err
+	}
+	if err = f.begin(plan.FieldNameList()); err != nil {
+		return err
+	}
+	ctx := kvql.NewExecuteCtx()
+	for {
+		rows, err := plan.Batch(ctx)
+		if err != nil {
+			f.end()
+			return err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		for _, row := range rows {
+			if err = f.row(row); err != nil {
+				return err
+			}
+		}
+		ctx.Clear()
+	}
+	if err = f.end(); err != nil {
+		return err
+	}
+	for _, warning := range ctx.Warnings {
+		fmt.Fprintf(c.errOut, "Warning: %s\n", warning)
+	}
+	return nil
+}
+
+// runScript executes the statements read from r, the statement at the end
+// of input can omit `;`.
+func (c *cli) runScript(r io.Reader) error {
+	var (
+		buf     statementBuffer
+		scanner = bufio.NewScanner(r)
+	)
+	scanner.Buffer(nil, 1<<24)
+	for scanner.Scan() {
+		if _, quit := c.feed(&buf, scanner.Text()); quit {
+			return nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if !buf.empty() {
+		c.runQuery(buf.String())
+	}
+	return nil
+}
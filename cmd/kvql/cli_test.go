@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/kvql"
+	"github.com/c4pt0r/kvql/memstore"
+)
+
+func newTestCli(format string) (*cli, *bytes.Buffer, *bytes.Buffer) {
+	s := memstore.New()
+	s.Put([]byte("k1"), []byte(`{"id": 1}`))
+	s.Put([]byte("k2"), []byte("v\t2"))
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+	return &cli{
+		sess:   kvql.NewSession(s),
+		out:    out,
+		errOut: errOut,
+		format: format,
+	}, out, errOut
+}
+
+func TestStatementBuffer(t *testing.T) {
+	var buf statementBuffer
+	for _, line := range []string{"", "select key,", "value where key = 'a;", "b'"} {
+		if _, ok := buf.add(line); ok {
+			t.Fatal("Statement should not be complete", line)
+		}
+	}
+	query, ok := buf.add("  ;")
+	if !ok || query != "select key,\nvalue where key = 'a;\nb'\n  ;" || !buf.empty() {
+		t.Fatal("Unexpected statement", query, ok)
+	}
+}
+
+func TestRunScript(t *testing.T) {
+	c, out, errOut := newTestCli(FormatTable)
+	script := `put ('k3', 'v3');
+select key, value
+  where key ^= 'k';
+\format csv
+select key where key = 'k1';
+\explain select * where key = 'k1';
+select count(1) where key ^= 'k'`
+	if err := c.runScript(strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+	expect := `+------+
+| Rows |
++------+
+| 1    |
++------+
+1 row in set
++-----+-----------+
+| KEY | VALUE     |
++-----+-----------+
+| k1  | {"id": 1} |
+| k2  | v\t2      |
+| k3  | v3        |
++-----+-----------+
+3 rows in set
+KEY
+k1
+ProjectionPlan{Fields = <*>}
+MultiGetPlan{Keys = <k1>, Filter = '(KEY = 'k1')'}
+count(1)
+3
+`
+	if out.String() != expect || errOut.Len() != 0 || c.failed {
+		t.Fatal("Unexpected output", out.String(), errOut.String())
+	}
+}
+
+func TestRunScriptError(t *testing.T) {
+	c, out, errOut := newTestCli(FormatTable)
+	script := `\timing maybe
+select * where key ^=;
+\quit
+select * where key ^= 'k';`
+	if err := c.runScript(strings.NewReader(script)); err != nil {
+		t.Fatal(err)
+	}
+	if !c.failed || out.Len() != 0 {
+		t.Fatal("Unexpected output", out.String())
+	}
+	errs := errOut.String()
+	if !strings.Contains(errs, "ERROR: invalid timing option maybe") || !strings.Contains(errs, "Syntax Error") {
+		t.Fatal("Unexpected errors", errs)
+	}
+}
+
+func TestOutputFormats(t *testing.T) {
+	query := "select key, json(value)['id'] as id, key = 'k1' as b where key ^= 'k';"
+	expects := map[string]string{
+		FormatVertical: `*************************** 1. row ***************************
+KEY: k1
+ id: 1
+  b: true
+*************************** 2. row ***************************
+KEY: k2
+ id: 
+  b: false
+2 rows in set
+`,
+		FormatCSV: "KEY,id,b\nk1,1,true\nk2,,false\n",
+		FormatTSV: "KEY\tid\tb\nk1\t1\ttrue\nk2\t\tfalse\n",
+		FormatJSON: `[
+  {"KEY":"k1","id":1,"b":true},
+  {"KEY":"k2","id":"","b":false}
+]
+`,
+		FormatJSONL: `{"KEY":"k1","id":1,"b":true}
+{"KEY":"k2","id":"","b":false}
+`,
+	}
+	for format, expect := range expects {
+		c, out, errOut := newTestCli(format)
+		c.runQuery(query)
+		if out.String() != expect {
+			t.Fatal("Unexpected output", format, out.String(), errOut.String())
+		}
+	}
+}
+
+func TestOutputBinary(t *testing.T) {
+	query := "select unhex('00ff') as b, e'a\\x1b' as e, 'text' as s where key = 'k1';"
+	expects := map[string]string{
+		FormatCSV:   "b,e,s\nx'00ff',e'a\\x1b',text\n",
+		FormatJSONL: `{"b":"x'00ff'","e":"a\u001b","s":"text"}` + "\n",
+	}
+	for format, expect := range expects {
+		c, out, errOut := newTestCli(format)
+		c.runQuery(query)
+		if out.String() != expect {
+			t.Fatal("Unexpected output", format, out.String(), errOut.String())
+		}
+	}
+}
+
+func TestRunFmt(t *testing.T) {
+	out, errOut := &bytes.Buffer{}, &bytes.Buffer{}
+	code := runFmt(nil, strings.NewReader("BEGIN; PUT ('k','v'); select key,value where key^='k' LIMIT 0,10; commit"), out, errOut)
+	expect := "begin;\nput ('k', 'v');\nselect key, value\nwhere key ^= 'k'\nlimit 10;\ncommit;\n"
+	if code != 0 || out.String() != expect {
+		t.Fatal("Unexpected output", code, out.String(), errOut.String())
+	}
+	out.Reset()
+	code = runFmt([]string{"-oneline", "delete  where key ^= 'k'", "limit 1"}, nil, out, errOut)
+	if code != 0 || out.String() != "delete where key ^= 'k' limit 1;\n" {
+		t.Fatal("Unexpected output", code, out.String(), errOut.String())
+	}
+	if code = runFmt([]string{"select * where"}, nil, out, errOut); code != 1 {
+		t.Fatal("Should get syntax error")
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/c4pt0r/kvql"
+)
+
+// runFmt implements `kvql fmt`, it formats the statements in arguments or
+// read from stdin:
+//
+//	$ kvql fmt "SELECT key,value WHERE key^='k' LIMIT 0,10"
+//	select key, value
+//	where key ^= 'k'
+//	limit 10;
+func runFmt(args []string, in io.Reader, out io.Writer, errOut io.Writer) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	flags.SetOutput(errOut)
+	oneLine := flags.Bool("oneline", false, "format each statement in one line")
+	flags.Usage = func() {
+		fmt.Fprintf(errOut, "Usage: kvql fmt [flags] [query]\n\nFlags:\n")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	query := strings.Join(flags.Args(), " ")
+	if query == "" {
+		data, err := io.ReadAll(in)
+		if err != nil {
+			fmt.Fprintln(errOut, err)
+			return 1
+		}
+		query = string(data)
+	}
+	f := &kvql.Formatter{Pretty: !*oneLine}
+	ret, err := f.FormatQuery(query)
+	if err != nil {
+		fmt.Fprintln(errOut, err)
+		return 1
+	}
+	if ret != "" {
+		fmt.Fprintf(out, "%s;\n", ret)
+	}
+	return 0
+}
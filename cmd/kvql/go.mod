@@ -0,0 +1,18 @@
+module github.com/c4pt0r/kvql/cmd/kvql
+
+go 1.21.1
+
+require (
+	github.com/c4pt0r/kvql v0.0.0
+	github.com/peterh/liner v1.2.2
+	golang.org/x/term v0.15.0
+)
+
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/mattn/go-runewidth v0.0.3 // indirect
+	golang.org/x/sys v0.15.0 // indirect
+	google.golang.org/protobuf v1.34.2 // indirect
+)
+
+replace github.com/c4pt0r/kvql => ../../
@@ -0,0 +1,187 @@
+// Command kvql is the interactive client of kvql:
+//
+//	$ kvql -store file://data.jsonl
+//	kvql> select key, value
+//	   -> where key ^= 'user_';
+//	kvql> \format vertical
+//	kvql> \explain select * where key in ('k1', 'k2')
+//
+// Statements can also be executed by -e flag or read from stdin:
+//
+//	$ kvql -store file://data.jsonl -format csv -e "select * where key ^= 'k'"
+//	$ kvql -store file://data.jsonl < script.sql
+//
+// The storage is selected by -store flag in `scheme://address` format,
+// `mem://` is an empty in memory storage and `file://path` is an in memory
+// storage loaded from and saved to a JSON Lines file with base64 encoded key
+// and value, the file is rewritten only if data is changed. Other storages
+// are registered by package backend.
+//
+// The fmt subcommand prints the statements in canonical format:
+//
+//	$ kvql fmt < script.sql
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/c4pt0r/kvql"
+	"github.com/c4pt0r/kvql/cmd/kvql/backend"
+	"github.com/peterh/liner"
+	"golang.org/x/term"
+)
+
+const historyFileName = ".kvql_history"
+
+var (
+	storeURI    = flag.String("store", "mem://", "storage in scheme://address format")
+	execute     = flag.String("e", "", "execute the statements and exit")
+	format      = flag.String("format", FormatTable, "output format: "+strings.Join(formats, ", "))
+	timing      = flag.Bool("timing", false, "print execution time of statements")
+	readOnly    = flag.Bool("read-only", false, "reject write statements")
+	historyFile = flag.String("history", defaultHistoryFile(), "history file, empty means no history")
+)
+
+func defaultHistoryFile() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, historyFileName)
+}
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: kvql [flags]\n       kvql fmt [flags] [query]\n\nStores: %s\n\nFlags:\n", strings.Join(backend.Names(), ", "))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.Arg(0) == "fmt" {
+		os.Exit(runFmt(flag.Args()[1:], os.Stdin, os.Stdout, os.Stderr))
+	}
+	os.Exit(run())
+}
+
+func run() int {
+	if _, err := newFormatter(*format, io.Discard); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	storage, closer, err := backend.Open(*storeURI)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	c := &cli{
+		sess:   kvql.NewSession(storage),
+		out:    os.Stdout,
+		errOut: os.Stderr,
+		format: *format,
+		timing: *timing,
+	}
+	if *readOnly {
+		c.sess.Policy = &kvql.ExecutePolicy{ReadOnly: true}
+	}
+	switch {
+	case strings.HasPrefix(strings.TrimSpace(*execute), `\`):
+		c.runCommand(strings.TrimSpace(*execute))
+	case *execute != "":
+		c.runQuery(*execute)
+	case term.IsTerminal(int(os.Stdin.Fd())):
+		err = c.repl()
+	default:
+		err = c.runScript(os.Stdin)
+	}
+	if c.sess.InTxn() {
+		c.sess.Rollback()
+	}
+	if cerr := closer.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if c.failed {
+		return 1
+	}
+	return 0
+}
+
+// repl reads statements with line editing, the history is loaded from
+// and saved to history file.
+func (c *cli) repl() error {
+	line := liner.NewLiner()
+	defer line.Close()
+	line.SetCtrlCAborts(true)
+	line.SetMultiLineMode(true)
+	if *historyFile != "" {
+		if f, err := os.Open(*historyFile); err == nil {
+			line.ReadHistory(f)
+			f.Close()
+		}
+		defer func() {
+			if f, err := os.Create(*historyFile); err == nil {
+				line.WriteHistory(f)
+				f.Close()
+			}
+		}()
+	}
+	fmt.Fprintln(c.out, `Welcome to kvql, type \help for help.`)
+	var buf statementBuffer
+	line.SetWordCompleter(c.completer(&buf))
+	for {
+		prompt := "kvql> "
+		if !buf.empty() {
+			prompt = "   -> "
+		}
+		input, err := line.Prompt(prompt)
+		switch err {
+		case nil:
+		case liner.ErrPromptAborted:
+			buf.reset()
+			continue
+		case io.EOF:
+			fmt.Fprintln(c.out)
+			return nil
+		default:
+			return err
+		}
+		executed, quit := c.feed(&buf, input)
+		if executed != "" {
+			line.AppendHistory(executed)
+		}
+		if quit {
+			return nil
+		}
+		// Errors of interactive statements do not change exit code
+		c.failed = false
+	}
+}
+
+// completer completes the word at cursor, the previous lines of statement
+// are used as the context.
+func (c *cli) completer(buf *statementBuffer) liner.WordCompleter {
+	comp := &kvql.Completer{Storage: c.sess.Storage}
+	return func(input string, pos int) (string, []string, string) {
+		var head string
+		if !buf.empty() {
+			head = buf.String() + "\n"
+		}
+		cursor := len(string([]rune(input)[:pos]))
+		ret, err := comp.Complete(head+input, len(head)+cursor)
+		if err != nil || ret.Start < len(head) {
+			return input[:cursor], nil, input[cursor:]
+		}
+		cands := make([]string, len(ret.Candidates))
+		for i, cand := range ret.Candidates {
+			cands[i] = cand.Text
+		}
+		return input[:ret.Start-len(head)], cands, input[ret.End-len(head):]
+	}
+}
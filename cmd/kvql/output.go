@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/c4pt0r/kvql"
+)
+
+const (
+	FormatTable    = "table"
+	FormatVertical = "vertical"
+	FormatCSV      = "csv"
+	FormatTSV      = "tsv"
+	FormatJSON     = "json"
+	FormatJSONL    = "jsonl"
+)
+
+var formats = []string{FormatTable, FormatVertical, FormatCSV, FormatTSV, FormatJSON, FormatJSONL}
+
+// resultFormatter writes the result set, rows are passed batch by batch
+// so the formatters except table can write them without buffering.
+type resultFormatter interface {
+	begin(cols []string) error
+	row(row []kvql.Column) error
+	end() error
+}
+
+func newFormatter(format string, w io.Writer) (resultFormatter, error) {
+	switch format {
+	case FormatTable:
+		return &tableFormatter{w: w}, nil
+	case FormatVertical:
+		return &verticalFormatter{w: w}, nil
+	case FormatCSV:
+		return &csvFormatter{w: csv.NewWriter(w)}, nil
+	case FormatTSV:
+		cw := csv.NewWriter(w)
+		cw.Comma = '\t'
+		return &csvFormatter{w: cw}, nil
+	case FormatJSON:
+		return &jsonFormatter{w: bufio.NewWriter(w)}, nil
+	case FormatJSONL:
+		return &jsonFormatter{w: bufio.NewWriter(w), lines: true}, nil
+	}
+	return nil, fmt.Errorf("unknown format %s, supported: %s", format, strings.Join(formats, ", "))
+}
+
+const nullText = "NULL"
+
+// formatText formats the column as text, nil is returned as NULL and the
+// binary data is returned as hex string literal.
+func formatText(col kvql.Column) string {
+	switch v := col.(type) {
+	case nil:
+		return nullText
+	case string:
+		return kvql.DisplayString(v)
+	case []byte:
+		return kvql.DisplayString(string(v))
+	case bool:
+		return strconv.FormatBool(v)
+	case int:
+		return strconv.Itoa(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	}
+	data, err := json.Marshal(col)
+	if err != nil {
+		return fmt.Sprint(col)
+	}
+	return string(data)
+}
+
+// tableFormatter buffers all rows to align the columns:
+//
+//	+-----+-------+
+//	| KEY | VALUE |
+//	+-----+-------+
+//	| k1  | v1    |
+//	+-----+-------+
+//	1 row in set
+type tableFormatter struct {
+	w      io.Writer
+	cols   []string
+	rows   [][]string
+	widths []int
+}
+
+func (f *tableFormatter) begin(cols []string) error {
+	f.cols = cols
+	f.widths = make([]int, len(cols))
+	for i, col := range cols {
+		f.widths[i] = textWidth(col)
+	}
+	return nil
+}
+
+func (f *tableFormatter) row(row []kvql.Column) error {
+	vals := make([]string, len(f.cols))
+	for i := range vals {
+		vals[i] = nullText
+		if i < len(row) {
+			vals[i] = cellEscaper.Replace(formatText(row[i]))
+		}
+		if w := textWidth(vals[i]); w > f.widths[i] {
+			f.widths[i] = w
+		}
+	}
+	f.rows = append(f.rows, vals)
+	return nil
+}
+
+func (f *tableFormatter) end() error {
+	bw := bufio.NewWriter(f.w)
+	sep := "+"
+	for _, w := range f.widths {
+		sep += strings.Repeat("-", w+2) + "+"
+	}
+	writeLine := func(vals []string) {
+		bw.WriteString("|")
+		for i, val := range vals {
+			bw.WriteString(" " + val + strings.Repeat(" ", f.widths[i]-textWidth(val)) + " |")
+		}
+		bw.WriteString("\n")
+	}
+	bw.WriteString(sep + "\n")
+	writeLine(f.cols)
+	bw.WriteString(sep + "\n")
+	for _, row := range f.rows {
+		writeLine(row)
+	}
+	if len(f.rows) > 0 {
+		bw.WriteString(sep + "\n")
+	}
+	bw.WriteString(rowsInSet(len(f.rows)) + "\n")
+	return bw.Flush()
+}
+
+// cellEscaper escapes the line breaks and tabs to keep table aligned
+var cellEscaper = strings.NewReplacer("\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+// textWidth is the display width of text
+func textWidth(s string) int {
+	return utf8.RuneCountInString(s)
+}
+
+func rowsInSet(n int) string {
+	switch n {
+	case 0:
+		return "Empty set"
+	case 1:
+		return "1 row in set"
+	}
+	return fmt.Sprintf("%d rows in set", n)
+}
+
+// verticalFormatter writes each column in a line:
+//
+//	*************************** 1. row ***************************
+//	  KEY: k1
+//	VALUE: v1
+type verticalFormatter struct {
+	w     io.Writer
+	cols  []string
+	width int
+	rows  int
+}
+
+func (f *verticalFormatter) begin(cols []string) error {
+	f.cols = cols
+	for _, col := range cols {
+		if w := textWidth(col); w > f.width {
+			f.width = w
+		}
+	}
+	return nil
+}
+
+func (f *verticalFormatter) row(row []kvql.Column) error {
+	f.rows++
+	bw := bufio.NewWriter(f.w)
+	stars := strings.Repeat("*", 27)
+	fmt.Fprintf(bw, "%s %d. row %s\n", stars, f.rows, stars)
+	for i, col := range f.cols {
+		val := nullText
+		if i < len(row) {
+			val = formatText(row[i])
+		}
+		fmt.Fprintf(bw, "%s%s: %s\n", strings.Repeat(" ", f.width-textWidth(col)), col, val)
+	}
+	return bw.Flush()
+}
+
+func (f *verticalFormatter) end() error {
+	_, err := fmt.Fprintln(f.w, rowsInSet(f.rows))
+	return err
+}
+
+// csvFormatter writes header and rows as CSV or TSV
+type csvFormatter struct {
+	w    *csv.Writer
+	vals []string
+}
+
+func (f *csvFormatter) begin(cols []string) error {
+	f.vals = make([]string, len(cols))
+	return f.w.Write(cols)
+}
+
+func (f *csvFormatter) row(row []kvql.Column) error {
+	for i := range f.vals {
+		f.vals[i] = ""
+		if i < len(row) && row[i] != nil {
+			f.vals[i] = formatText(row[i])
+		}
+	}
+	return f.w.Write(f.vals)
+}
+
+func (f *csvFormatter) end() error {
+	f.w.Flush()
+	return f.w.Error()
+}
+
+// jsonFormatter writes rows as objects keyed by column names, in an array
+// or one object per line.
+type jsonFormatter struct {
+	w     *bufio.Writer
+	lines bool
+	cols  [][]byte
+	rows  int
+}
+
+func (f *jsonFormatter) begin(cols []string) error {
+	f.cols = make([][]byte, len(cols))
+	for i, col := range cols {
+		f.cols[i], _ = json.Marshal(col)
+	}
+	if !f.lines {
+		f.w.WriteString("[")
+	}
+	return nil
+}
+
+func (f *jsonFormatter) row(row []kvql.Column) error {
+	if !f.lines {
+		if f.rows > 0 {
+			f.w.WriteString(",")
+		}
+		f.w.WriteString("\n  ")
+	}
+	f.rows++
+	f.w.WriteString("{")
+	for i, col := range f.cols {
+		if i > 0 {
+			f.w.WriteString(",")
+		}
+		f.w.Write(col)
+		f.w.WriteString(":")
+		var val kvql.Column
+		if i < len(row) {
+			val = row[i]
+		}
+		val = jsonText(val)
+		data, err := json.Marshal(val)
+		if err != nil {
+			return err
+		}
+		f.w.Write(data)
+	}
+	f.w.WriteString("}")
+	if f.lines {
+		f.w.WriteString("\n")
+	}
+	return f.w.Flush()
+}
+
+// jsonText converts bytes to string, the binary data which is not valid
+// UTF-8 is converted to hex string literal.
+func jsonText(col kvql.Column) kvql.Column {
+	var s string
+	switch v := col.(type) {
+	case string:
+		s = v
+	case []byte:
+		s = string(v)
+	default:
+		return col
+	}
+	if !utf8.ValidString(s) {
+		return kvql.DisplayString(s)
+	}
+	return s
+}
+
+func (f *jsonFormatter) end() error {
+	if !f.lines {
+		if f.rows > 0 {
+			f.w.WriteString("\n")
+		}
+		f.w.WriteString("]\n")
+	}
+	return f.w.Flush()
+}
@@ -0,0 +1,149 @@
+package kvql
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"unicode/utf8"
+)
+
+/*
+Binary key codec used by encode_* and decode_* functions. The memcomparable
+format is compatible with TiDB/TiKV codec package:
+
+  - Integer is encoded as 8 bytes big-endian with the sign bit flipped, so
+    the negative numbers are sorted before positive numbers.
+  - Bytes is split into groups of 8 bytes, each group is padded with 0x00
+    and followed by a marker byte 0xFF - padding size. The encoded data
+    keeps the order of original data and has no common prefix problem.
+*/
+
+const (
+	encGroupSize = 8
+	encMarker    = byte(0xFF)
+	encPad       = byte(0x0)
+
+	signMask uint64 = 0x8000000000000000
+)
+
+var (
+	errInsufficientData = errors.New("insufficient data")
+	errInvalidMarker    = errors.New("invalid marker byte")
+	errInvalidPadding   = errors.New("invalid padding byte")
+	errVarintOverflow   = errors.New("varint overflow")
+)
+
+func encodeMemcomparableInt(b []byte, v int64) []byte {
+	return binary.BigEndian.AppendUint64(b, uint64(v)^signMask)
+}
+
+// decodeMemcomparableInt returns the integer and the number of bytes read
+func decodeMemcomparableInt(b []byte) (int64, int, error) {
+	if len(b) < 8 {
+		return 0, 0, errInsufficientData
+	}
+	return int64(binary.BigEndian.Uint64(b) ^ signMask), 8, nil
+}
+
+func encodeMemcomparableBytes(b []byte, data []byte) []byte {
+	for idx := 0; idx <= len(data); idx += encGroupSize {
+		remain := len(data) - idx
+		padCount := 0
+		if remain >= encGroupSize {
+			b = append(b, data[idx:idx+encGroupSize]...)
+		} else {
+			padCount = encGroupSize - remain
+			b = append(b, data[idx:]...)
+			for i := 0; i < padCount; i++ {
+				b = append(b, encPad)
+			}
+		}
+		b = append(b, encMarker-byte(padCount))
+	}
+	return b
+}
+
+// decodeMemcomparableBytes returns the data and the number of bytes read
+func decodeMemcomparableBytes(b []byte) ([]byte, int, error) {
+	data := make([]byte, 0, len(b))
+	read := 0
+	for {
+		if len(b)-read < encGroupSize+1 {
+			return nil, 0, errInsufficientData
+		}
+		group := b[read : read+encGroupSize]
+		marker := b[read+encGroupSize]
+		read += encGroupSize + 1
+		padCount := encMarker - marker
+		if padCount > encGroupSize {
+			return nil, 0, errInvalidMarker
+		}
+		realGroupSize := encGroupSize - int(padCount)
+		data = append(data, group[:realGroupSize]...)
+		if padCount != 0 {
+			for _, v := range group[realGroupSize:] {
+				if v != encPad {
+					return nil, 0, errInvalidPadding
+				}
+			}
+			return data, read, nil
+		}
+	}
+}
+
+// decodeUvarint returns the unsigned varint and the number of bytes read
+func decodeUvarint(b []byte) (uint64, int, error) {
+	v, n := binary.Uvarint(b)
+	if n == 0 {
+		return 0, 0, errInsufficientData
+	}
+	if n < 0 {
+		return 0, 0, errVarintOverflow
+	}
+	return v, n, nil
+}
+
+// decodeVarint returns the zig-zag encoded varint and the number of bytes
+// read
+func decodeVarint(b []byte) (int64, int, error) {
+	v, n := binary.Varint(b)
+	if n == 0 {
+		return 0, 0, errInsufficientData
+	}
+	if n < 0 {
+		return 0, 0, errVarintOverflow
+	}
+	return v, n, nil
+}
+
+// prefixNext returns the smallest key that is greater than all the keys
+// have the prefix, nil means there is no such key.
+func prefixNext(prefix []byte) []byte {
+	next := make([]byte, len(prefix))
+	copy(next, prefix)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			return next[:i+1]
+		}
+	}
+	return nil
+}
+
+// uintValue returns the unsigned integer value, number larger than max int64
+// is represented as uint64.
+func uintValue(v uint64) any {
+	if v > math.MaxInt64 {
+		return v
+	}
+	return int64(v)
+}
+
+// binaryString converts the binary data in structured value into string,
+// the data which is not valid UTF-8 is converted to hex string literal.
+func binaryString(b []byte) string {
+	if utf8.Valid(b) {
+		return string(b)
+	}
+	return formatString(string(b))
+}
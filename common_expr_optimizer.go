@@ -0,0 +1,117 @@
+package kvql
+
+// nonDeterministicFuncs may return different result for the same row
+var nonDeterministicFuncs = map[string]bool{
+	"now": true,
+}
+
+// CommonExprOptimizer finds the identical deterministic sub-expressions in
+// the expressions executed on the same rows, such as WHERE, select fields,
+// GROUP BY and ORDER BY, and assigns the same result slot to them. The
+// expression with slot is executed once per row or chunk, the result is
+// shared by ExecuteCtx.
+type CommonExprOptimizer struct {
+	Roots []Expression
+	// NumSlots is the number of slots already assigned, slots of different
+	// statements should not be overlapped.
+	NumSlots int
+}
+
+func (o *CommonExprOptimizer) Optimize() int {
+	var (
+		counts = make(map[string]int)
+		exprs  []Expression
+	)
+	for _, root := range o.Roots {
+		if root == nil {
+			continue
+		}
+		// Field reference shares the node with select field, it is
+		// counted again because it is executed again. Lambda body is
+		// skipped because it is executed for each list element.
+		root.Walk(func(e Expression) bool {
+			if _, ok := e.(*LambdaExpr); ok {
+				return false
+			}
+			if isCommonExprCandidate(e) {
+				counts[e.String()]++
+				exprs = append(exprs, e)
+			}
+			return true
+		})
+	}
+	slots := make(map[string]int)
+	for _, e := range exprs {
+		key := e.String()
+		if counts[key] < 2 {
+			continue
+		}
+		slot, have := slots[key]
+		if !have {
+			o.NumSlots++
+			slot = o.NumSlots
+			slots[key] = slot
+		}
+		setExprSlot(e, slot)
+	}
+	return o.NumSlots
+}
+
+// isCommonExprCandidate reports whether the result of e can be shared.
+// Aggregate functions are executed by aggregate plan, and parameters are
+// excluded because the same string may be bound to different values.
+func isCommonExprCandidate(e Expression) bool {
+	switch e.(type) {
+	case *FunctionCallExpr, *FieldAccessExpr, *BinaryOpExpr:
+	default:
+		return false
+	}
+	ok := true
+	e.Walk(func(ne Expression) bool {
+		switch n := ne.(type) {
+		case *FunctionCallExpr:
+			fname, err := GetFuncNameFromExpr(n)
+			if err != nil || IsAggrFunc(fname) || nonDeterministicFuncs[fname] {
+				ok = false
+			}
+		case *ParamExpr:
+			ok = false
+		}
+		return ok
+	})
+	return ok
+}
+
+func setExprSlot(e Expression, slot int) {
+	switch n := e.(type) {
+	case *FunctionCallExpr:
+		n.Slot = slot
+	case *FieldAccessExpr:
+		n.Slot = slot
+	case *BinaryOpExpr:
+		n.Slot = slot
+	}
+}
+
+func selectStmtExprs(stmt *SelectStmt) []Expression {
+	exprs := []Expression{stmt.Where.Expr}
+	exprs = append(exprs, stmt.Fields...)
+	if stmt.GroupBy != nil {
+		for _, f := range stmt.GroupBy.Fields {
+			exprs = append(exprs, f.Expr)
+		}
+	}
+	if stmt.Order != nil {
+		for _, f := range stmt.Order.Orders {
+			exprs = append(exprs, f.Field)
+		}
+	}
+	return exprs
+}
+
+func returningExprs(stmt *ReturningStmt) []Expression {
+	if stmt == nil {
+		return nil
+	}
+	return stmt.Fields
+}
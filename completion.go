@@ -0,0 +1,386 @@
+package kvql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type CompletionKind int
+
+const (
+	CompletionKeyword CompletionKind = iota + 1
+	CompletionFunction
+	CompletionAggrFunction
+	CompletionField
+	CompletionAlias
+	CompletionKeyPrefix
+)
+
+var CompletionKindToString = map[CompletionKind]string{
+	CompletionKeyword:      "keyword",
+	CompletionFunction:     "function",
+	CompletionAggrFunction: "aggregate function",
+	CompletionField:        "field",
+	CompletionAlias:        "alias",
+	CompletionKeyPrefix:    "key prefix",
+}
+
+func (k CompletionKind) String() string {
+	if s, have := CompletionKindToString[k]; have {
+		return s
+	}
+	return "unknown"
+}
+
+// Completion is a candidate of completion, Detail is the signature of
+// function such as `substr(a1, a2, a3) STR`.
+type Completion struct {
+	Text   string
+	Kind   CompletionKind
+	Detail string
+}
+
+// CompletionResult is the candidates which replace query[Start:End]
+type CompletionResult struct {
+	Start      int
+	End        int
+	Candidates []Completion
+}
+
+var (
+	// DefaultMaxKeyPrefixes is the max number of key prefix candidates
+	DefaultMaxKeyPrefixes = 10
+	// DefaultKeyScanLimit is the max number of keys scanned for prefixes
+	DefaultKeyScanLimit = 1000
+	// DefaultKeySeparators ends the key prefix candidates
+	DefaultKeySeparators = ":_/-.#|"
+
+	stmtKeywords   = []string{"select", "put", "remove", "delete", "update", "with", "dry run", "begin", "commit", "rollback"}
+	clauseKeywords = []string{"where", "limit", "order by", "group by", "as", "asc", "desc", "and", "or", "in", "between", "from", "returning", "set", "if", "if not exists"}
+)
+
+// Completer completes query at the cursor position. If Storage is not nil,
+// the string after key operators is completed by the key prefixes sampled
+// from storage:
+//
+//	c := &Completer{Storage: storage}
+//	ret, err := c.Complete("select * where key ^= 'user", 27)
+type Completer struct {
+	Storage Storage
+	// MaxKeyPrefixes limits the key prefix candidates, zero means
+	// DefaultMaxKeyPrefixes
+	MaxKeyPrefixes int
+	// KeyScanLimit limits the keys scanned, zero means DefaultKeyScanLimit
+	KeyScanLimit int
+	// KeySeparators ends the key prefix candidates, empty means
+	// DefaultKeySeparators
+	KeySeparators string
+}
+
+// Complete returns the candidates of keywords, functions, fields and
+// aliases at the cursor without storage.
+func Complete(query string, pos int) *CompletionResult {
+	ret, _ := (&Completer{}).Complete(query, pos)
+	return ret
+}
+
+// Complete returns the candidates at cursor pos in query, the candidates
+// are filtered by the word before cursor. Error is only returned when
+// sampling key prefixes from storage fails.
+func (c *Completer) Complete(query string, pos int) (*CompletionResult, error) {
+	if pos < 0 || pos > len(query) {
+		pos = len(query)
+	}
+	var (
+		toks = Tokenize(query)
+		ret  = &CompletionResult{Start: pos, End: pos}
+		// prev is the last token before the word at cursor
+		prev     *SpanToken
+		prevPrev *SpanToken
+		word     *SpanToken
+		stmtAt   int
+	)
+	for i, tok := range toks {
+		if tok.Start >= pos {
+			break
+		}
+		if tok.Tp == SEMI {
+			stmtAt = i + 1
+			prev, prevPrev = nil, nil
+			continue
+		}
+		if tok.End >= pos && (tok.End > pos || tok.Unterminated || isWordToken(tok)) {
+			word = tok
+			break
+		}
+		prev, prevPrev = tok, prev
+	}
+	stmt := toks[stmtAt:]
+	if word != nil && word.Kind == TokenString {
+		// Complete key in string, the string should be closed by candidate
+		ret.Start = word.Start + 1
+		ret.End = pos
+		if c.Storage == nil || !isKeyOperand(stmt, word) {
+			return ret, nil
+		}
+		prefix := query[ret.Start:pos]
+		keys, err := c.sampleKeyPrefixes(prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			ret.Candidates = append(ret.Candidates, Completion{Text: key, Kind: CompletionKeyPrefix})
+		}
+		return ret, nil
+	}
+	if word == nil && prev != nil && prev.End == pos && endsOperand(prev, prevPrev) {
+		// Cursor is right after the operand, such as `'k1'|`
+		return ret, nil
+	}
+	prefix := ""
+	if word != nil {
+		if !isWordToken(word) {
+			return ret, nil
+		}
+		ret.Start = word.Start
+		prefix = strings.ToLower(query[word.Start:pos])
+	}
+	var cands []Completion
+	switch {
+	case prev == nil:
+		cands = keywordCandidates(stmtKeywords)
+	case prev.Tp == AS || prev.Tp == WITH:
+		// Defining new name
+		return ret, nil
+	case prev.Tp == ORDER || prev.Tp == GROUP:
+		cands = keywordCandidates([]string{"by"})
+	case prev.Tp == IF:
+		cands = keywordCandidates([]string{"not exists", "value"})
+	case prev.Tp == NAME && prev.Data == "dry" && prev == stmt[0]:
+		cands = keywordCandidates([]string{"run"})
+	case prev.Tp == PUT:
+		cands = keywordCandidates([]string{"if not exists", "select"})
+	case prev.Tp == UPDATE:
+		cands = keywordCandidates([]string{"set"})
+	case prev.Tp == DELETE:
+		cands = keywordCandidates([]string{"where"})
+	case prev.Tp == FROM:
+		cands = cteCandidates(stmt, pos)
+	case endsOperand(prev, prevPrev):
+		cands = keywordCandidates(clauseKeywords)
+	default:
+		cands = append(cands, Completion{Text: "key", Kind: CompletionField}, Completion{Text: "value", Kind: CompletionField})
+		cands = append(cands, aliasCandidates(stmt, pos)...)
+		cands = append(cands, functionCandidates()...)
+		cands = append(cands, keywordCandidates([]string{"true", "false"})...)
+	}
+	for _, cand := range cands {
+		if strings.HasPrefix(strings.ToLower(cand.Text), prefix) && cand.Text != prefix {
+			ret.Candidates = append(ret.Candidates, cand)
+		}
+	}
+	return ret, nil
+}
+
+// isWordToken returns true if the token can be completed as a word
+func isWordToken(tok *SpanToken) bool {
+	switch tok.Kind {
+	case TokenKeyword, TokenFunction, TokenField, TokenIdent, TokenBool:
+		return tok.Tp != OPERATOR || isLetters(tok.Data)
+	}
+	return false
+}
+
+func isLetters(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_') {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+// endsOperand returns true if the token can be the end of an operand, so
+// the next token should be an operator or a clause keyword. The `*` is
+// operand only after select.
+func endsOperand(tok *SpanToken, prev *SpanToken) bool {
+	switch tok.Tp {
+	case STRING, NUMBER, FLOAT, TRUE, FALSE, PARAM, KEY, VALUE, RPAREN, RBRACK:
+		return true
+	case NAME:
+		return tok.Kind == TokenIdent
+	case OPERATOR:
+		return tok.Data == "*" && prev != nil && prev.Tp == SELECT
+	}
+	return false
+}
+
+// isKeyOperand returns true if the string is compared with key, such as
+// `key ^= '...'`, `key between '...' and '...'` or `key in ('...')`.
+func isKeyOperand(stmt []*SpanToken, str *SpanToken) bool {
+	idx := -1
+	for i, tok := range stmt {
+		if tok == str {
+			idx = i
+			break
+		}
+	}
+	for i := idx - 1; i >= 0; i-- {
+		tok := stmt[i]
+		switch tok.Tp {
+		case KEY:
+			return true
+		case OPERATOR:
+			switch tok.Data {
+			case "=", "^=", ">", ">=", "<", "<=", "in", "between", "and":
+				continue
+			}
+			return false
+		case STRING, LPAREN, SEP:
+			continue
+		}
+		return false
+	}
+	return false
+}
+
+func keywordCandidates(keywords []string) []Completion {
+	ret := make([]Completion, len(keywords))
+	for i, kw := range keywords {
+		ret[i] = Completion{Text: kw, Kind: CompletionKeyword}
+	}
+	return ret
+}
+
+// functionCandidates returns the builtin and registered functions
+func functionCandidates() []Completion {
+	ret := make([]Completion, 0, len(funcMap)+len(aggrFuncMap))
+	for name, f := range funcMap {
+		ret = append(ret, Completion{
+			Text:   name,
+			Kind:   CompletionFunction,
+			Detail: funcSignature(name, f.NumArgs, f.VarArgs, f.ReturnType),
+		})
+	}
+	for name, f := range aggrFuncMap {
+		ret = append(ret, Completion{
+			Text:   name,
+			Kind:   CompletionAggrFunction,
+			Detail: funcSignature(name, f.NumArgs, f.VarArgs, f.ReturnType),
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Text < ret[j].Text
+	})
+	return ret
+}
+
+func funcSignature(name string, numArgs int, varArgs bool, ret Type) string {
+	args := make([]string, 0, numArgs+1)
+	for i := 1; i <= numArgs; i++ {
+		args = append(args, fmt.Sprintf("a%d", i))
+	}
+	if varArgs {
+		args = append(args, "...")
+	}
+	return fmt.Sprintf("%s(%s) %s", name, strings.Join(args, ", "), TypeToString[ret])
+}
+
+// aliasCandidates returns the aliases of select fields which can be used
+// at pos, they are in scope in where, group by and order by clauses after
+// the fields. The aliases of CTE are only in scope of the CTE.
+func aliasCandidates(stmt []*SpanToken, pos int) []Completion {
+	var (
+		aliases []string
+		inScope bool
+	)
+	for i, tok := range stmt {
+		if tok.Start >= pos {
+			break
+		}
+		switch tok.Tp {
+		case SELECT:
+			aliases = aliases[:0]
+			inScope = false
+		case WHERE, BY:
+			inScope = true
+		case AS:
+			if i+1 < len(stmt) && stmt[i+1].Tp == NAME && stmt[i+1].End < pos && (i+2 >= len(stmt) || stmt[i+2].Tp != LPAREN) {
+				aliases = append(aliases, stmt[i+1].Data)
+			}
+		}
+	}
+	if !inScope {
+		return nil
+	}
+	var (
+		ret  []Completion
+		seen = make(map[string]bool)
+	)
+	for _, alias := range aliases {
+		if !seen[alias] {
+			seen[alias] = true
+			ret = append(ret, Completion{Text: alias, Kind: CompletionAlias})
+		}
+	}
+	return ret
+}
+
+// cteCandidates returns the CTE names defined before pos
+func cteCandidates(stmt []*SpanToken, pos int) []Completion {
+	var ret []Completion
+	for i := 0; i+2 < len(stmt) && stmt[i].Start < pos; i++ {
+		if stmt[i].Tp == NAME && stmt[i+1].Tp == AS && stmt[i+2].Tp == LPAREN {
+			ret = append(ret, Completion{Text: stmt[i].Data, Kind: CompletionAlias})
+		}
+	}
+	return ret
+}
+
+// sampleKeyPrefixes scans the keys with prefix and returns the distinct
+// prefixes ended by the first separator after prefix.
+func (c *Completer) sampleKeyPrefixes(prefix string) ([]string, error) {
+	var (
+		maxPrefixes = c.MaxKeyPrefixes
+		scanLimit   = c.KeyScanLimit
+		separators  = c.KeySeparators
+		ret         []string
+		seen        = make(map[string]bool)
+	)
+	if maxPrefixes <= 0 {
+		maxPrefixes = DefaultMaxKeyPrefixes
+	}
+	if scanLimit <= 0 {
+		scanLimit = DefaultKeyScanLimit
+	}
+	if separators == "" {
+		separators = DefaultKeySeparators
+	}
+	cursor, err := c.Storage.Cursor()
+	if err != nil {
+		return nil, err
+	}
+	if err = cursor.Seek([]byte(prefix)); err != nil {
+		return nil, err
+	}
+	for i := 0; i < scanLimit && len(ret) < maxPrefixes; i++ {
+		key, _, err := cursor.Next()
+		if err != nil {
+			return nil, err
+		}
+		if key == nil || !strings.HasPrefix(string(key), prefix) {
+			break
+		}
+		cand := string(key)
+		if idx := strings.IndexAny(cand[len(prefix):], separators); idx >= 0 {
+			cand = cand[:len(prefix)+idx+1]
+		}
+		if cand != prefix && !seen[cand] {
+			seen[cand] = true
+			ret = append(ret, cand)
+		}
+	}
+	return ret, nil
+}
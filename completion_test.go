@@ -0,0 +1,169 @@
+package kvql
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func spanTokensString(toks []*SpanToken) string {
+	ret := make([]string, len(toks))
+	for i, tok := range toks {
+		ret[i] = fmt.Sprintf("%s:%s:%d", tok.Kind, tok.Text, tok.Start)
+		if tok.Unterminated {
+			ret[i] += ":unterminated"
+		}
+	}
+	return strings.Join(ret, " ")
+}
+
+func TestTokenize(t *testing.T) {
+	tcases := []struct {
+		query  string
+		expect string
+	}{
+		{
+			"SELECT key, upper(`Value`) as v where key ^= 'k' & v in (?, 1.5) limit 10",
+			"keyword:SELECT:0 field:key:7 punct:,:10 function:upper:12 punct:(:17 ident:`Value`:18 punct:):25 keyword:as:27 ident:v:30 " +
+				"keyword:where:32 field:key:38 operator:^=:42 string:'k':45 operator:&:49 ident:v:51 keyword:in:53 punct:(:56 param:?:57 " +
+				"punct:,:58 number:1.5:60 punct:):63 keyword:limit:65 number:10:71",
+		},
+		{
+			"begin; dry run delete where key = \"a'b\"; commit",
+			`keyword:begin:0 punct:;:5 keyword:dry:7 keyword:run:11 keyword:delete:15 keyword:where:22 field:key:28 operator:=:32 string:"a'b":34 punct:;:39 keyword:commit:41`,
+		},
+		{
+			"put if not exists ('k', 'v",
+			"keyword:put:0 keyword:if:4 keyword:not:7 keyword:exists:11 punct:(:18 string:'k':19 punct:,:22 string:'v:24:unterminated",
+		},
+		{
+			"select * where key ^= '",
+			"keyword:select:0 operator:*:7 keyword:where:9 field:key:15 operator:^=:19 string:':22:unterminated",
+		},
+		{
+			"select * where key ^= x'00ff' | value = e'it\\'s' | value = E'a",
+			"keyword:select:0 operator:*:7 keyword:where:9 field:key:15 operator:^=:19 string:x'00ff':22 operator:|:30 field:value:32 operator:=:38 string:e'it\\'s':40 operator:|:49 field:value:51 operator:=:57 string:E'a:59:unterminated",
+		},
+		{
+			"select json(value)['a'] where value != true",
+			"keyword:select:0 function:json:7 punct:(:11 field:value:12 punct:):17 punct:[:18 string:'a':19 punct:]:22 keyword:where:24 field:value:30 operator:!=:36 bool:true:39",
+		},
+	}
+	for _, c := range tcases {
+		ret := spanTokensString(Tokenize(c.query))
+		if ret != c.expect {
+			t.Fatalf("Unexpected tokens of %s\n got: %s\nwant: %s", c.query, ret, c.expect)
+		}
+	}
+}
+
+func candidateTexts(ret *CompletionResult) []string {
+	texts := make([]string, len(ret.Candidates))
+	for i, cand := range ret.Candidates {
+		texts[i] = cand.Text
+	}
+	return texts
+}
+
+func TestComplete(t *testing.T) {
+	tcases := []struct {
+		query   string
+		start   int
+		expect  string
+		exclude string
+	}{
+		{"", 0, "select,put,remove,delete,update,with,dry run,begin,commit,rollback", ""},
+		{"sel", 0, "select", ""},
+		{"put ('k', 'v'); de", 16, "delete", ""},
+		{"select ke", 7, "key", "value"},
+		{"select key, up", 12, "upper", "value"},
+		{"select count(1) as cnt, substr(key, 0, 2) as p where ", 53, "key,value,cnt,p", "select"},
+		{"select key as k1 where k", 23, "key,k1", "value"},
+		{"select key as k1, ", 18, "key,value", "k1"},
+		{"select * ", 9, "where,limit,order by,group by", "key"},
+		{"select key * ", 13, "key,value", "where"},
+		{"select * where key ^= 'k' ", 26, "and,or,limit", "key"},
+		{"select * where key ^= 'k' o", 26, "order by,or", "limit"},
+		{"select * where key ^= 'k'", 25, "", ""},
+		{"select key order ", 17, "by", ""},
+		{"with t as (select key as k2 where k2 > 'a') select * from ", 58, "t", ""},
+		{"with t as (select key as k2 where k2 > 'a') select key as k3 where ", 67, "k3", "k2"},
+		{"select key as ", 14, "", ""},
+		{"dry ", 4, "run", ""},
+		{"put ", 4, "if not exists,select", ""},
+		{"update ", 7, "set", ""},
+	}
+	for _, c := range tcases {
+		ret := Complete(c.query, len(c.query))
+		texts := candidateTexts(ret)
+		joined := "," + strings.Join(texts, ",") + ","
+		if ret.Start != c.start {
+			t.Fatalf("Unexpected start of %q: %d", c.query, ret.Start)
+		}
+		if c.expect == "" && len(texts) != 0 {
+			t.Fatalf("Unexpected candidates of %q: %v", c.query, texts)
+		}
+		for _, exp := range strings.Split(c.expect, ",") {
+			if exp != "" && !strings.Contains(joined, ","+exp+",") {
+				t.Fatalf("Candidates of %q should contain %s: %v", c.query, exp, texts)
+			}
+		}
+		for _, exc := range strings.Split(c.exclude, ",") {
+			if exc != "" && strings.Contains(joined, ","+exc+",") {
+				t.Fatalf("Candidates of %q should not contain %s: %v", c.query, exc, texts)
+			}
+		}
+	}
+
+	// Cursor in the middle of query
+	query := "select upp where key = 'k'"
+	ret := Complete(query, 10)
+	if ret.Start != 7 || ret.End != 10 || strings.Join(candidateTexts(ret), ",") != "upper" || ret.Candidates[0].Detail != "upper(a1) STR" {
+		t.Fatal("Unexpected completion", ret)
+	}
+}
+
+func TestCompleteFunctions(t *testing.T) {
+	AddScalarFunction(&Function{"completion_test_func", 2, true, TNUMBER, nil, nil})
+	defer delete(funcMap, "completion_test_func")
+	ret := Complete("select completion_t", 19)
+	if len(ret.Candidates) != 1 || ret.Candidates[0].Kind != CompletionFunction || ret.Candidates[0].Detail != "completion_test_func(a1, a2, ...) NUMBER" {
+		t.Fatal("Unexpected completion", ret.Candidates)
+	}
+	ret = Complete("select key, group_", 18)
+	if len(ret.Candidates) != 1 || ret.Candidates[0].Kind != CompletionAggrFunction || ret.Candidates[0].Detail != "group_concat(a1, a2) STR" {
+		t.Fatal("Unexpected completion", ret.Candidates)
+	}
+}
+
+func TestCompleteKeyPrefix(t *testing.T) {
+	s := newMockTxnStorage()
+	for _, key := range []string{"user:1:name", "user:1:age", "user:2:name", "users", "order:1"} {
+		s.Put([]byte(key), []byte("v"))
+	}
+	c := &Completer{Storage: s}
+	query := "select * where key ^= 'us"
+	ret, err := c.Complete(query, len(query))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret.Start != 23 || strings.Join(candidateTexts(ret), ",") != "user:,users" {
+		t.Fatal("Unexpected key prefixes", ret)
+	}
+	query = "select * where key in ('order:1', 'user:1:"
+	ret, _ = c.Complete(query, len(query))
+	if strings.Join(candidateTexts(ret), ",") != "user:1:age,user:1:name" {
+		t.Fatal("Unexpected key prefixes", ret)
+	}
+	query = "select * where value = 'us"
+	ret, _ = c.Complete(query, len(query))
+	if len(ret.Candidates) != 0 {
+		t.Fatal("Value should not be completed", ret)
+	}
+	c.MaxKeyPrefixes = 1
+	query = "select * where key between 'u"
+	ret, _ = c.Complete(query, len(query))
+	if strings.Join(candidateTexts(ret), ",") != "user:" {
+		t.Fatal("Unexpected key prefixes", ret)
+	}
+}
@@ -0,0 +1,330 @@
+package kvql
+
+/*
+Common table expression:
+
+	with t as (select key, int(value) as v where key ^= 'k_')
+	select key, v * 2 as v2 from t where v > 10
+
+CTE referenced once and has no aggregate, order by or limit statement will
+be inlined: the columns are replaced by CTE field expressions and the CTE
+where expression is merged into outer where expression, so the outer query
+can still use scan optimization. Otherwise the CTE will be materialized
+and outer query reads the CTE rows by CTEScanPlan.
+*/
+
+func (p *Parser) parseWith() (*SelectStmt, error) {
+	var (
+		pos  = p.tok.Pos
+		ctes = []*CTEStmt{}
+		refs = p.countCTERefs()
+		err  error
+	)
+	err = p.expect(&Token{Tp: WITH, Data: "with"})
+	if err != nil {
+		return nil, err
+	}
+	p.ctes = make(map[string]*CTEStmt)
+	for {
+		cte, err := p.parseCTE()
+		if err != nil {
+			return nil, err
+		}
+		cte.Refs = refs[cte.Name]
+		cte.Materialized = cte.Refs > 1 || !cte.inlinable()
+		if !cte.Materialized {
+			err = cte.prepareInline()
+			if err != nil {
+				return nil, err
+			}
+		}
+		p.ctes[cte.Name] = cte
+		ctes = append(ctes, cte)
+		if p.tok == nil {
+			return nil, NewSyntaxError(-1, "Expect select statement after with statement")
+		}
+		if p.tok.Tp != SEP {
+			break
+		}
+		p.next()
+	}
+	if p.tok.Tp != SELECT {
+		return nil, NewSyntaxError(p.tok.Pos, "Expect select statement after with statement")
+	}
+	stmt, err := p.parseSelectStmt()
+	if err != nil {
+		return nil, err
+	}
+	stmt.With = &WithStmt{
+		Pos:  pos,
+		CTEs: ctes,
+	}
+	return stmt, nil
+}
+
+func (p *Parser) parseCTE() (*CTEStmt, error) {
+	if p.tok == nil {
+		return nil, NewSyntaxError(-1, "Require CTE name")
+	} else if p.tok.Tp != NAME {
+		return nil, NewSyntaxError(p.tok.Pos, "Invalid CTE name")
+	}
+	cte := &CTEStmt{
+		Pos:  p.tok.Pos,
+		Name: p.tok.Data,
+	}
+	if _, have := p.ctes[cte.Name]; have {
+		return nil, NewSyntaxError(p.tok.Pos, "Duplicate CTE name %s", cte.Name)
+	}
+	p.next()
+	err := p.expect(&Token{Tp: AS, Data: "as"})
+	if err != nil {
+		return nil, err
+	}
+	if p.tok == nil {
+		return nil, NewSyntaxError(-1, "Expect token ( but got EOF")
+	} else if p.tok.Tp != LPAREN {
+		return nil, NewSyntaxError(p.tok.Pos, "Expect token ( bug got %s", p.tok.Data)
+	}
+	lpos := p.tok.Pos
+	end := p.findCloseParen(p.pos - 1)
+	if end < 0 {
+		return nil, NewSyntaxError(lpos, "Missing ) for CTE %s", cte.Name)
+	}
+	sub := &Parser{
+		Query:   p.Query,
+		lex:     p.lex,
+		toks:    p.toks[p.pos:end],
+		numToks: end - p.pos,
+		ctes:    p.ctes,
+	}
+	sub.next()
+	if sub.tok == nil || sub.tok.Tp != SELECT {
+		return nil, NewSyntaxError(lpos, "CTE %s require select statement", cte.Name)
+	}
+	cte.Select, err = sub.parseSelectStmt()
+	if err != nil {
+		return nil, err
+	}
+	p.params = append(p.params, sub.params...)
+	// Move to the token after `)`
+	p.pos = end
+	p.next()
+	p.next()
+	return cte, nil
+}
+
+func (p *Parser) findCloseParen(idx int) int {
+	depth := 0
+	for i := idx; i < p.numToks; i++ {
+		switch p.toks[i].Tp {
+		case LPAREN:
+			depth++
+		case RPAREN:
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// countCTERefs returns how many times each name used in from statement
+func (p *Parser) countCTERefs() map[string]int {
+	ret := make(map[string]int)
+	for i := 0; i < p.numToks-1; i++ {
+		if p.toks[i].Tp == FROM && p.toks[i+1].Tp == NAME {
+			ret[p.toks[i+1].Data]++
+		}
+	}
+	return ret
+}
+
+func (p *Parser) parseFrom(stmt *SelectStmt) error {
+	pos := p.tok.Pos
+	p.next()
+	if p.tok == nil {
+		return NewSyntaxError(-1, "Require CTE name after from")
+	} else if p.tok.Tp != NAME {
+		return NewSyntaxError(p.tok.Pos, "Invalid CTE name")
+	}
+	cte, have := p.ctes[p.tok.Data]
+	if !have {
+		return NewSyntaxError(p.tok.Pos, "Cannot find CTE %s", p.tok.Data)
+	}
+	p.next()
+	from := &FromStmt{
+		Pos: pos,
+		CTE: cte,
+	}
+	if cte.Materialized {
+		from.Scan = cte
+	} else if cte.Select.From != nil {
+		from.Scan = cte.Select.From.Scan
+	}
+	stmt.From = from
+	return p.resolveCTEFields(stmt)
+}
+
+func (p *Parser) resolveCTEFields(stmt *SelectStmt) error {
+	cte := stmt.From.CTE
+	if stmt.AllFields {
+		if !cte.Materialized && cte.Select.AllFields {
+			// Inline `select *` CTE keep reading key and value
+			return nil
+		}
+		names := cte.FieldNames()
+		fields := make([]Expression, len(names))
+		for i := range names {
+			fields[i] = cte.column(i, stmt.Pos)
+		}
+		stmt.AllFields = false
+		stmt.Fields = fields
+		stmt.FieldNames = append([]string{}, names...)
+		stmt.FieldTypes = cte.FieldTypes()
+		return nil
+	}
+	for i, f := range stmt.Fields {
+		nf, err := p.resolveCTEExpr(cte, f)
+		if err != nil {
+			return err
+		}
+		stmt.Fields[i] = nf
+		stmt.FieldTypes[i] = nf.ReturnType()
+	}
+	return nil
+}
+
+// resolveCTEExpr replace the name and key value field expressions with
+// the CTE columns
+func (p *Parser) resolveCTEExpr(cte *CTEStmt, expr Expression) (Expression, error) {
+	return rewriteExpr(expr, func(e Expression) (Expression, error) {
+		switch ne := e.(type) {
+		case *NameExpr:
+			if idx := cte.fieldIndex(ne.Data); idx >= 0 {
+				return cte.column(idx, ne.Pos), nil
+			}
+		case *FieldExpr:
+			idx := cte.fieldIndex(ne.String())
+			if idx < 0 {
+				return nil, NewSyntaxError(ne.Pos, "Cannot find field %s in CTE %s", ne.String(), cte.Name)
+			}
+			return cte.column(idx, ne.Pos), nil
+		}
+		return nil, nil
+	})
+}
+
+func (c *CTEStmt) FieldNames() []string {
+	return c.Select.FieldNames
+}
+
+func (c *CTEStmt) FieldTypes() []Type {
+	if c.Select.AllFields {
+		return []Type{TSTR, TSTR}
+	}
+	return append([]Type{}, c.Select.FieldTypes...)
+}
+
+func (c *CTEStmt) fieldIndex(name string) int {
+	for i, fname := range c.Select.FieldNames {
+		if fname == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// column returns the expression that reads idx-th column of CTE
+func (c *CTEStmt) column(idx int, pos int) Expression {
+	if !c.Materialized {
+		return c.Select.Fields[idx]
+	}
+	return &CTEColumnExpr{
+		Pos:   pos,
+		Name:  c.Select.FieldNames[idx],
+		Index: idx,
+		Type:  c.FieldTypes()[idx],
+		CTE:   c,
+	}
+}
+
+func (c *CTEStmt) inlinable() bool {
+	stmt := c.Select
+	if stmt.GroupBy != nil || stmt.Order != nil || stmt.Limit != nil {
+		return false
+	}
+	hasAggr := false
+	for _, f := range stmt.Fields {
+		f.Walk(func(e Expression) bool {
+			if _, ok := e.(*FunctionCallExpr); ok && IsAggrFuncExpr(e) {
+				hasAggr = true
+			}
+			return !hasAggr
+		})
+	}
+	return !hasAggr
+}
+
+// prepareInline removes the field references in CTE, because the fields
+// are inlined into the outer query which may define a field with same name.
+func (c *CTEStmt) prepareInline() error {
+	var err error
+	for i, f := range c.Select.Fields {
+		c.Select.Fields[i], err = rewriteExpr(f, unwrapFieldReference)
+		if err != nil {
+			return err
+		}
+	}
+	c.Select.Where.Expr, err = rewriteExpr(c.Select.Where.Expr, unwrapFieldReference)
+	return err
+}
+
+func unwrapFieldReference(e Expression) (Expression, error) {
+	if fr, ok := e.(*FieldReferenceExpr); ok {
+		return rewriteExpr(fr.FieldExpr, unwrapFieldReference)
+	}
+	return nil, nil
+}
+
+// rewriteExpr walks the expression tree and replaces the node by the non-nil
+// result of callback, children of the replaced node will not be visited.
+func rewriteExpr(expr Expression, cb func(e Expression) (Expression, error)) (Expression, error) {
+	nexpr, err := cb(expr)
+	if err != nil {
+		return nil, err
+	}
+	if nexpr != nil {
+		return nexpr, nil
+	}
+	switch e := expr.(type) {
+	case *BinaryOpExpr:
+		if e.Left, err = rewriteExpr(e.Left, cb); err != nil {
+			return nil, err
+		}
+		if e.Right, err = rewriteExpr(e.Right, cb); err != nil {
+			return nil, err
+		}
+	case *NotExpr:
+		if e.Right, err = rewriteExpr(e.Right, cb); err != nil {
+			return nil, err
+		}
+	case *FunctionCallExpr:
+		for i, arg := range e.Args {
+			if e.Args[i], err = rewriteExpr(arg, cb); err != nil {
+				return nil, err
+			}
+		}
+	case *ListExpr:
+		for i, item := range e.List {
+			if e.List[i], err = rewriteExpr(item, cb); err != nil {
+				return nil, err
+			}
+		}
+	case *FieldAccessExpr:
+		if e.Left, err = rewriteExpr(e.Left, cb); err != nil {
+			return nil, err
+		}
+	}
+	return expr, nil
+}
@@ -0,0 +1,168 @@
+package kvql
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strconv"
+)
+
+type cteResult struct {
+	plan   FinalPlan
+	rows   [][]Column
+	loaded bool
+}
+
+func (r *cteResult) load() error {
+	if r.loaded {
+		return nil
+	}
+	err := r.plan.Init()
+	if err != nil {
+		return err
+	}
+	ctx := NewExecuteCtx()
+	for {
+		rows, err := r.plan.Batch(ctx)
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			break
+		}
+		for _, row := range rows {
+			r.rows = append(r.rows, r.normalizeRow(row))
+		}
+	}
+	r.loaded = true
+	return nil
+}
+
+// normalizeRow converts the columns to the type declared by CTE fields.
+// Aggregate plan returns group by fields as bytes, so number, boolean and
+// time fields should be restored.
+func (r *cteResult) normalizeRow(row []Column) []Column {
+	types := r.plan.FieldTypeList()
+	for i, col := range row {
+		if i >= len(types) {
+			break
+		}
+		bval, ok := convertToByteArray(col)
+		if !ok {
+			continue
+		}
+		switch types[i] {
+		case TNUMBER:
+			if ival, err := strconv.ParseInt(string(bval), 10, 64); err == nil {
+				row[i] = ival
+			} else if fval, err := strconv.ParseFloat(string(bval), 64); err == nil {
+				row[i] = fval
+			}
+		case TBOOL:
+			row[i] = string(bval) == "true"
+		case TTIME:
+			if tval, ok := toTime(bval); ok {
+				row[i] = tval
+			}
+		}
+	}
+	return row
+}
+
+// CTE row is identified by row id which is encoded as key of KVPair
+func encodeCTERowID(idx int) []byte {
+	ret := make([]byte, 8)
+	binary.BigEndian.PutUint64(ret, uint64(idx))
+	return ret
+}
+
+func (c *CTEStmt) getRow(key []byte) ([]Column, bool) {
+	if c.result == nil || len(key) != 8 {
+		return nil, false
+	}
+	idx := binary.BigEndian.Uint64(key)
+	if idx >= uint64(len(c.result.rows)) {
+		return nil, false
+	}
+	return c.result.rows[idx], true
+}
+
+type CTEScanPlan struct {
+	Storage Storage
+	Filter  *FilterExec
+	CTE     *CTEStmt
+	idx     int
+}
+
+func NewCTEScanPlan(s Storage, f *FilterExec, cte *CTEStmt) Plan {
+	return &CTEScanPlan{
+		Storage: s,
+		Filter:  f,
+		CTE:     cte,
+	}
+}
+
+func (p *CTEScanPlan) Init() error {
+	p.idx = 0
+	return nil
+}
+
+func (p *CTEScanPlan) Next(ctx *ExecuteCtx) ([]byte, []byte, error) {
+	err := p.CTE.result.load()
+	if err != nil {
+		return nil, nil, err
+	}
+	numRows := len(p.CTE.result.rows)
+	for p.idx < numRows {
+		key := encodeCTERowID(p.idx)
+		p.idx++
+		ok, err := p.Filter.Filter(NewKVP(key, nil), ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if ok {
+			return key, []byte{}, nil
+		}
+	}
+	return nil, nil, nil
+}
+
+func (p *CTEScanPlan) Batch(ctx *ExecuteCtx) ([]KVPair, error) {
+	err := p.CTE.result.load()
+	if err != nil {
+		return nil, err
+	}
+	var (
+		ret         = make([]KVPair, 0, PlanBatchSize)
+		filterBatch = make([]KVPair, 0, PlanBatchSize)
+		numRows     = len(p.CTE.result.rows)
+	)
+	for p.idx < numRows && len(ret) < PlanBatchSize {
+		filterBatch = filterBatch[:0]
+		for i := 0; i < PlanBatchSize && p.idx < numRows; i++ {
+			filterBatch = append(filterBatch, NewKVP(encodeCTERowID(p.idx), []byte{}))
+			p.idx++
+		}
+		matchs, err := p.Filter.FilterBatch(filterBatch, ctx)
+		if err != nil {
+			return nil, err
+		}
+		for i, m := range matchs {
+			if m {
+				ret = append(ret, filterBatch[i])
+			}
+		}
+	}
+	return ret, nil
+}
+
+func (p *CTEScanPlan) String() string {
+	return fmt.Sprintf("CTEScanPlan{Name = %s, Filter = '%s'}", p.CTE.Name, p.Filter.Explain())
+}
+
+func (p *CTEScanPlan) Explain() []string {
+	ret := []string{p.String()}
+	for _, plan := range p.CTE.result.plan.Explain() {
+		ret = append(ret, plan)
+	}
+	return ret
+}
@@ -0,0 +1,175 @@
+package kvql
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func collectRows(t *testing.T, s Storage, query string, batch bool) []string {
+	opt := NewOptimizer(query)
+	plan, err := opt.BuildPlan(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return collectPlanRows(t, plan, batch)
+}
+
+func collectPlanRows(t *testing.T, plan FinalPlan, batch bool) []string {
+	var (
+		ret = []string{}
+		ctx = NewExecuteCtx()
+		err error
+	)
+	for {
+		var rows [][]Column
+		if batch {
+			rows, err = plan.Batch(ctx)
+		} else {
+			var cols []Column
+			cols, err = plan.Next(ctx)
+			if cols != nil {
+				rows = [][]Column{cols}
+			}
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(rows) == 0 {
+			break
+		}
+		for _, row := range rows {
+			items := make([]string, len(row))
+			for i, col := range row {
+				switch c := col.(type) {
+				case []byte:
+					items[i] = string(c)
+				default:
+					items[i] = fmt.Sprint(c)
+				}
+			}
+			ret = append(ret, strings.Join(items, ","))
+		}
+		ctx.Clear()
+	}
+	return ret
+}
+
+func newCTETestStorage() Storage {
+	data := []KVPair{}
+	for i := 0; i < 50; i++ {
+		data = append(data, NewKVPStr(fmt.Sprintf("k_%02d", i), fmt.Sprintf("%d", i%5)))
+		data = append(data, NewKVPStr(fmt.Sprintf("x_%02d", i), "100"))
+	}
+	return newMockQueryStorage(data)
+}
+
+func TestCTEInline(t *testing.T) {
+	query := "with t as (select key, int(value) as v where key ^= 'k_') select key, v * 2 as v2 from t where v > 3 & key < 'k_20'"
+	stmt, err := parseQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cte := stmt.With.CTEs[0]
+	if cte.Materialized {
+		t.Fatal("CTE should be inlined")
+	}
+	if stmt.FieldTypes[1] != TNUMBER {
+		t.Fatal("v2 should be number type")
+	}
+
+	opt := NewOptimizer(query)
+	plan, err := opt.BuildPlan(newCTETestStorage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	explain := strings.Join(plan.Explain(), "\n")
+	if !strings.Contains(explain, "PrefixScanPlan") && !strings.Contains(explain, "RangeScanPlan") {
+		t.Fatal("Inline CTE should use scan optimization, got:", explain)
+	}
+
+	expected := []string{"k_04,8", "k_09,8", "k_14,8", "k_19,8"}
+	for _, batch := range []bool{false, true} {
+		rows := collectRows(t, newCTETestStorage(), query, batch)
+		if strings.Join(rows, "|") != strings.Join(expected, "|") {
+			t.Fatal("Unexpected result", rows)
+		}
+	}
+}
+
+func TestCTEMaterialize(t *testing.T) {
+	query := "with t as (select int(value) as v, count(1) as cnt where key ^= 'k_' group by v) select v, cnt * 10 as c from t where v >= 3 order by v desc"
+	stmt, err := parseQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stmt.With.CTEs[0].Materialized {
+		t.Fatal("CTE with aggregate function should be materialized")
+	}
+	if stmt.FieldTypes[0] != TNUMBER || stmt.FieldTypes[1] != TNUMBER {
+		t.Fatal("Wrong field types", stmt.FieldTypes)
+	}
+	expected := []string{"4,100", "3,100"}
+	for _, batch := range []bool{false, true} {
+		rows := collectRows(t, newCTETestStorage(), query, batch)
+		if strings.Join(rows, "|") != strings.Join(expected, "|") {
+			t.Fatal("Unexpected result", rows)
+		}
+	}
+}
+
+func TestCTEMultipleReference(t *testing.T) {
+	query := "with t as (select key, int(value) as v where key ^= 'k_'), t2 as (select key, v from t where v = 1), t3 as (select key from t where v = 2) select * from t2 limit 3"
+	stmt, err := parseQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stmt.With.CTEs[0].Materialized {
+		t.Fatal("CTE t should be materialized")
+	}
+	if stmt.With.CTEs[1].Materialized {
+		t.Fatal("CTE t2 should be inlined")
+	}
+	if stmt.AllFields || len(stmt.Fields) != 2 {
+		t.Fatal("select * should expand CTE columns")
+	}
+	expected := []string{"k_01,1", "k_06,1", "k_11,1"}
+	for _, batch := range []bool{false, true} {
+		rows := collectRows(t, newCTETestStorage(), query, batch)
+		if strings.Join(rows, "|") != strings.Join(expected, "|") {
+			t.Fatal("Unexpected result", rows)
+		}
+	}
+}
+
+func TestCTEAllFields(t *testing.T) {
+	query := "with t as (select * where key ^= 'x_') select * from t limit 2"
+	expected := []string{"x_00,100", "x_01,100"}
+	for _, batch := range []bool{false, true} {
+		rows := collectRows(t, newCTETestStorage(), query, batch)
+		if strings.Join(rows, "|") != strings.Join(expected, "|") {
+			t.Fatal("Unexpected result", rows)
+		}
+	}
+}
+
+func TestCTESyntaxError(t *testing.T) {
+	queries := []string{
+		"with t as (select key where key ^= 'k') select * from t2",
+		"with t as (select key where key ^= 'k') select value from t",
+		"with t as (select key where key ^= 'k'), t as (select key where true) select * from t",
+		"with t as (where key ^= 'k') select * from t",
+		"with t as (select key where key ^= 'k' select * from t",
+		"with t as (select key where key ^= 'k') delete where key = 'k'",
+		"select * from t where key = 'k'",
+	}
+	for _, query := range queries {
+		_, err := parseQuery(query)
+		if err == nil {
+			t.Fatal("Should get syntax error:", query)
+		}
+		if _, ok := err.(*SyntaxError); !ok {
+			t.Fatal("Should be syntax error:", query, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package kvql
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxDecodeDepth limits the nesting depth of decoded value
+const maxDecodeDepth = 1000
+
+var errMaxDecodeDepth = errors.New("exceeds max nesting depth")
+
+// Decoder decodes value into structured data. The decoder is registered as
+// scalar function which name is decoder name, the first argument is value
+// and the other arguments are passed to Decode.
+//
+// The decoder which ReturnType is TJSON should return map[string]any and
+// TLIST should return []any, the nested maps and lists use the same types
+// so the result can be accessed by field access expression such as
+// msgpack(value)['a'][0].
+type Decoder struct {
+	Name       string
+	NumArgs    int
+	VarArgs    bool
+	ReturnType Type
+	Decode     func(data []byte, args []any) (any, error)
+}
+
+// AddDecoder registers the decoder as scalar function
+func AddDecoder(d *Decoder) {
+	fn := decoderFunc(d)
+	AddScalarFunction(&Function{
+		Name:       d.Name,
+		NumArgs:    d.NumArgs,
+		VarArgs:    d.VarArgs,
+		ReturnType: d.ReturnType,
+		Body: func(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+			return execValueFunc(kv, args, ctx, fn)
+		},
+		BodyVec: func(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+			return execValueFuncVec(chunk, args, ctx, fn)
+		},
+	})
+}
+
+func decoderFunc(d *Decoder) valueFunc {
+	return func(args []Expression, vals []any, regs regexpCache) (any, error) {
+		ret, err := d.Decode(bytesValue(vals[0]), vals[1:])
+		if err != nil {
+			return nil, NewExecuteError(args[0].GetPos(), "%s function cannot decode value: %v", d.Name, err)
+		}
+		switch d.ReturnType {
+		case TJSON:
+			obj, ok := ret.(map[string]any)
+			if !ok {
+				return nil, NewExecuteError(args[0].GetPos(), "%s function value is not a map", d.Name)
+			}
+			return JSON(obj), nil
+		case TLIST:
+			if _, ok := ret.([]any); !ok {
+				return nil, NewExecuteError(args[0].GetPos(), "%s function value is not a list", d.Name)
+			}
+		}
+		return ret, nil
+	}
+}
+
+func init() {
+	for _, d := range []*Decoder{
+		{"msgpack", 1, false, TJSON, decodeMsgpack},
+		{"msgpack_list", 1, false, TLIST, decodeMsgpack},
+		{"cbor", 1, false, TJSON, decodeCBOR},
+		{"cbor_list", 1, false, TLIST, decodeCBOR},
+		{"csv", 1, true, TLIST, decodeCSV},
+		{"proto", 2, false, TJSON, decodeProto},
+	} {
+		AddDecoder(d)
+	}
+}
+
+// decodeCSV decodes one CSV line into list of strings, the optional
+// argument is the separator which default is comma.
+func decodeCSV(data []byte, args []any) (any, error) {
+	if len(args) > 1 {
+		return nil, errors.New("too many arguments")
+	}
+	r := csv.NewReader(strings.NewReader(string(data)))
+	r.FieldsPerRecord = -1
+	if len(args) > 0 {
+		sep := toString(args[0])
+		comma, size := utf8.DecodeRuneInString(sep)
+		if size == 0 || size != len(sep) {
+			return nil, fmt.Errorf("separator %q should be one character", sep)
+		}
+		r.Comma = comma
+	}
+	record, err := r.Read()
+	if err == io.EOF {
+		return []any{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]any, len(record))
+	for i, field := range record {
+		ret[i] = field
+	}
+	return ret, nil
+}
+
+// mapKeyString converts the key of decoded map into string
+func mapKeyString(key any) string {
+	switch k := key.(type) {
+	case string:
+		return k
+	case []byte:
+		return binaryString(k)
+	case nil:
+		return "null"
+	}
+	return fmt.Sprint(key)
+}
@@ -1,20 +1,30 @@
 package kvql
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+)
 
 type DeletePlan struct {
 	Storage   Storage
 	ChildPlan Plan
+	Returning *ReturningStmt
+	DryRun    bool
+	RowsLimit *RowsLimit
 	executed  bool
+	buffer    [][]Column
+	affected  int
 }
 
 func (p *DeletePlan) Init() error {
 	p.executed = false
+	p.buffer = nil
+	p.affected = 0
 	return p.ChildPlan.Init()
 }
 
 func (p *DeletePlan) String() string {
-	return fmt.Sprintf("DeletePlan{}")
+	return fmt.Sprintf("DeletePlan{%s}", strings.Join(writePlanOptions(p.Returning, p.DryRun), ", "))
 }
 
 func (p *DeletePlan) Explain() []string {
@@ -26,14 +36,17 @@ func (p *DeletePlan) Explain() []string {
 }
 
 func (p *DeletePlan) FieldNameList() []string {
-	return []string{"Rows"}
+	return writeFieldNameList(p.Returning)
 }
 
 func (p *DeletePlan) FieldTypeList() []Type {
-	return []Type{TNUMBER}
+	return writeFieldTypeList(p.Returning)
 }
 
 func (p *DeletePlan) Next(ctx *ExecuteCtx) ([]Column, error) {
+	if p.Returning != nil {
+		return nextReturningRow(&p.buffer, ctx, p.executeReturning)
+	}
 	if !p.executed {
 		n, err := p.execute(ctx)
 		p.executed = true
@@ -43,6 +56,9 @@ func (p *DeletePlan) Next(ctx *ExecuteCtx) ([]Column, error) {
 }
 
 func (p *DeletePlan) Batch(ctx *ExecuteCtx) ([][]Column, error) {
+	if p.Returning != nil {
+		return p.executeReturning(ctx)
+	}
 	if !p.executed {
 		n, err := p.execute(ctx)
 		p.executed = true
@@ -52,11 +68,38 @@ func (p *DeletePlan) Batch(ctx *ExecuteCtx) ([][]Column, error) {
 	return nil, nil
 }
 
+// deleteBatch deletes next batch of rows from child plan and returns the
+// deleted rows, if dry run the rows will not be deleted.
+func (p *DeletePlan) deleteBatch(ctx *ExecuteCtx) ([]KVPair, error) {
+	ctx.Clear()
+	rows, err := p.ChildPlan.Batch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nrows := len(rows)
+	if nrows == 0 || p.DryRun {
+		return rows, nil
+	}
+	err = p.RowsLimit.Check(p.affected, nrows)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([][]byte, nrows)
+	for i, kv := range rows {
+		keys[i] = kv.Key
+	}
+	err = p.Storage.BatchDelete(keys)
+	if err != nil {
+		return nil, err
+	}
+	p.affected += nrows
+	return rows, nil
+}
+
 func (p *DeletePlan) execute(ctx *ExecuteCtx) (int, error) {
 	count := 0
 	for {
-		ctx.Clear()
-		rows, err := p.ChildPlan.Batch(ctx)
+		rows, err := p.deleteBatch(ctx)
 		if err != nil {
 			return count, err
 		}
@@ -64,14 +107,14 @@ func (p *DeletePlan) execute(ctx *ExecuteCtx) (int, error) {
 		if nrows == 0 {
 			return count, nil
 		}
-		keys := make([][]byte, nrows)
-		for i, kv := range rows {
-			keys[i] = kv.Key
-		}
-		err = p.Storage.BatchDelete(keys)
-		if err != nil {
-			return count, err
-		}
 		count += nrows
 	}
 }
+
+func (p *DeletePlan) executeReturning(ctx *ExecuteCtx) ([][]Column, error) {
+	rows, err := p.deleteBatch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return p.Returning.project(rows, ctx)
+}
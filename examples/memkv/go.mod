@@ -4,6 +4,9 @@ go 1.21.1
 
 require github.com/c4pt0r/kvql v0.0.0-20240506034307-5d9245a7865c
 
-require github.com/beorn7/perks v1.0.1 // indirect
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	google.golang.org/protobuf v1.34.2 // indirect
+)
 
 replace github.com/c4pt0r/kvql => ../../
@@ -156,6 +156,9 @@ func repl(storage kvql.Storage) {
 				fmt.Println()
 			}
 		}
+		for _, warning := range execCtx.Warnings {
+			fmt.Println("Warning:", warning)
+		}
 	}
 }
 
@@ -42,6 +42,7 @@ const (
 	Between     Operator = 17
 	KWAnd       Operator = 18
 	KWOr        Operator = 19
+	Mod         Operator = 20
 
 	TUNKNOWN Type = 0
 	TBOOL    Type = 1
@@ -50,9 +51,23 @@ const (
 	TIDENT   Type = 4
 	TLIST    Type = 5
 	TJSON    Type = 6
+	TTIME    Type = 7
+	TBYTES   Type = 8
 )
 
 var (
+	TypeToString = map[Type]string{
+		TUNKNOWN: "UNKNOWN",
+		TBOOL:    "BOOL",
+		TSTR:     "STR",
+		TNUMBER:  "NUMBER",
+		TIDENT:   "IDENT",
+		TLIST:    "LIST",
+		TJSON:    "JSON",
+		TTIME:    "TIME",
+		TBYTES:   "BYTES",
+	}
+
 	KVKeywordToString = map[KVKeyword]string{
 		KeyKW:   "KEY",
 		ValueKW: "VALUE",
@@ -78,6 +93,7 @@ var (
 		Between:     "between",
 		KWAnd:       "and",
 		KWOr:        "or",
+		Mod:         "%",
 	}
 
 	StringToOperator = map[string]Operator{
@@ -92,6 +108,7 @@ var (
 		"-":       Sub,
 		"*":       Mul,
 		"/":       Div,
+		"%":       Mod,
 		">":       Gt,
 		">=":      Gte,
 		"<":       Lt,
@@ -134,13 +151,17 @@ var (
 	_ Expression = (*BinaryOpExpr)(nil)
 	_ Expression = (*FieldExpr)(nil)
 	_ Expression = (*FieldReferenceExpr)(nil)
+	_ Expression = (*CTEColumnExpr)(nil)
 	_ Expression = (*StringExpr)(nil)
 	_ Expression = (*NotExpr)(nil)
 	_ Expression = (*FunctionCallExpr)(nil)
 	_ Expression = (*NameExpr)(nil)
 	_ Expression = (*NumberExpr)(nil)
 	_ Expression = (*FloatExpr)(nil)
+	_ Expression = (*LambdaExpr)(nil)
+	_ Expression = (*LambdaArgExpr)(nil)
 	_ Expression = (*BoolExpr)(nil)
+	_ Expression = (*ParamExpr)(nil)
 	_ Expression = (*ListExpr)(nil)
 	_ Expression = (*FieldAccessExpr)(nil)
 )
@@ -181,6 +202,8 @@ type BinaryOpExpr struct {
 	Op    Operator
 	Left  Expression
 	Right Expression
+	// Slot of common sub-expression result, 0 means not shared
+	Slot int
 }
 
 func (e *BinaryOpExpr) String() string {
@@ -197,6 +220,17 @@ func (e *BinaryOpExpr) String() string {
 	}
 }
 
+// isStringType returns true for string and bytes type, they are compared
+// and concatenated at bytes level.
+func isStringType(tp Type) bool {
+	return tp == TSTR || tp == TBYTES
+}
+
+// isSameType returns true if the types can be compared with each other
+func isSameType(l, r Type) bool {
+	return l == r || (isStringType(l) && isStringType(r))
+}
+
 func (e *BinaryOpExpr) GetPos() int {
 	return e.Pos
 }
@@ -205,10 +239,14 @@ func (e *BinaryOpExpr) ReturnType() Type {
 	switch e.Op {
 	case And, Or, Not, Eq, NotEq, PrefixMatch, RegExpMatch, Gt, Gte, Lt, Lte, In, Between, KWAnd, KWOr:
 		return TBOOL
-	case Sub, Mul, Div:
+	case Sub, Mul, Div, Mod:
 		return TNUMBER
 	case Add:
-		if e.Left.ReturnType() == TSTR {
+		ltype := e.Left.ReturnType()
+		if ltype == TBYTES || (ltype == TSTR && e.Right.ReturnType() == TBYTES) {
+			return TBYTES
+		}
+		if ltype == TSTR {
 			return TSTR
 		}
 		return TNUMBER
@@ -272,6 +310,8 @@ type FunctionCallExpr struct {
 	Name   Expression
 	Args   []Expression
 	Result any
+	// Slot of common sub-expression result, 0 means not shared
+	Slot int
 }
 
 func (e *FunctionCallExpr) GetPos() int {
@@ -292,6 +332,10 @@ func (e *FunctionCallExpr) ReturnType() Type {
 		return TUNKNOWN
 	}
 
+	// Result of list_reduce is the accumulator, same type as initial value
+	if fname == "list_reduce" && len(e.Args) == 3 {
+		return e.Args[1].ReturnType()
+	}
 	if funcObj, have := GetScalarFunctionByName(fname); have {
 		return funcObj.ReturnType
 	}
@@ -335,6 +379,27 @@ func (e *FieldReferenceExpr) ReturnType() Type {
 	return e.FieldExpr.ReturnType()
 }
 
+// CTEColumnExpr reads column from materialized CTE row
+type CTEColumnExpr struct {
+	Pos   int
+	Name  string
+	Index int
+	Type  Type
+	CTE   *CTEStmt
+}
+
+func (e *CTEColumnExpr) GetPos() int {
+	return e.Pos
+}
+
+func (e *CTEColumnExpr) String() string {
+	return fmt.Sprintf("%s.%s", e.CTE.Name, e.Name)
+}
+
+func (e *CTEColumnExpr) ReturnType() Type {
+	return e.Type
+}
+
 type NumberExpr struct {
 	Pos  int
 	Data string
@@ -413,6 +478,52 @@ func (e *BoolExpr) GetPos() int {
 	return e.Pos
 }
 
+// ParamExpr is the placeholder of prepared statement, Value is the
+// literal expression bound by PreparedStmt.Bind.
+type ParamExpr struct {
+	Pos   int
+	Name  string
+	Index int
+	Value Expression
+}
+
+func (e *ParamExpr) String() string {
+	return e.Name
+}
+
+func (e *ParamExpr) ReturnType() Type {
+	if e.Value == nil {
+		return TUNKNOWN
+	}
+	return e.Value.ReturnType()
+}
+
+func (e *ParamExpr) GetPos() int {
+	return e.Pos
+}
+
+// stringValue returns the bound string value, it is used by filter
+// optimizer to calculate scan range.
+func (e *ParamExpr) stringValue() (string, bool) {
+	if sexpr, ok := e.Value.(*StringExpr); ok {
+		return sexpr.Data, true
+	}
+	return "", false
+}
+
+// boundValue returns the bound value if e is parameter
+func boundValue(e Expression) Expression {
+	if pexpr, ok := e.(*ParamExpr); ok && pexpr.Value != nil {
+		return pexpr.Value
+	}
+	return e
+}
+
+func isUnboundParam(e Expression) bool {
+	pexpr, ok := e.(*ParamExpr)
+	return ok && pexpr.Value == nil
+}
+
 type ListExpr struct {
 	Pos  int
 	List []Expression
@@ -438,6 +549,8 @@ type FieldAccessExpr struct {
 	Pos       int
 	Left      Expression
 	FieldName Expression
+	// Slot of common sub-expression result, 0 means not shared
+	Slot int
 }
 
 func (e *FieldAccessExpr) GetPos() int {
@@ -453,3 +566,54 @@ func (e *FieldAccessExpr) String() string {
 func (e *FieldAccessExpr) ReturnType() Type {
 	return TSTR
 }
+
+// LambdaExpr is the function argument such as x -> x * 2 or
+// (acc, x) -> acc + x, it is called by list functions for each element.
+type LambdaExpr struct {
+	Pos    int
+	Params []string
+	// Index of the first parameter in lambda arguments of ExecuteCtx,
+	// parameters of nested lambda follow the enclosing ones.
+	Index int
+	Body  Expression
+}
+
+func (e *LambdaExpr) GetPos() int {
+	return e.Pos
+}
+
+func (e *LambdaExpr) String() string {
+	if len(e.Params) == 1 {
+		return fmt.Sprintf("%s -> %s", e.Params[0], e.Body.String())
+	}
+	return fmt.Sprintf("(%s) -> %s", strings.Join(e.Params, ", "), e.Body.String())
+}
+
+func (e *LambdaExpr) ReturnType() Type {
+	return e.Body.ReturnType()
+}
+
+// LambdaArgExpr references the parameter of lambda expression, Type is
+// the element type of list resolved by checker, TUNKNOWN means the type
+// is only known at runtime such as JSON array element.
+type LambdaArgExpr struct {
+	Pos   int
+	Name  string
+	Index int
+	Type  Type
+}
+
+func (e *LambdaArgExpr) GetPos() int {
+	return e.Pos
+}
+
+func (e *LambdaArgExpr) String() string {
+	return e.Name
+}
+
+func (e *LambdaArgExpr) ReturnType() Type {
+	if e.Type == TUNKNOWN {
+		return TSTR
+	}
+	return e.Type
+}
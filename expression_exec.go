@@ -3,6 +3,7 @@ package kvql
 import (
 	"bytes"
 	"regexp"
+	"time"
 )
 
 type FilterExec struct {
@@ -23,7 +24,12 @@ func (e *FilterExec) Filter(kvp KVPair, ctx *ExecuteCtx) (bool, error) {
 
 func (e *FilterExec) FilterBatch(chunk []KVPair, ctx *ExecuteCtx) ([]bool, error) {
 	// return e.filterBatch(chunk)
-	return e.filterChunk(chunk, ctx)
+	ret, err := e.filterChunk(chunk, ctx)
+	if err != nil {
+		return nil, err
+	}
+	ctx.filterSlots(chunk, ret)
+	return ret, nil
 }
 
 func (e *FilterExec) filterChunk(chunk []KVPair, ctx *ExecuteCtx) ([]bool, error) {
@@ -75,8 +81,21 @@ func (e *FieldExpr) Execute(kv KVPair, ctx *ExecuteCtx) (any, error) {
 }
 
 func (e *BinaryOpExpr) Execute(kv KVPair, ctx *ExecuteCtx) (any, error) {
+	if e.Slot > 0 {
+		return ctx.execSlot(e.Slot, kv, e.execute)
+	}
+	return e.execute(kv, ctx)
+}
+
+func (e *BinaryOpExpr) execute(kv KVPair, ctx *ExecuteCtx) (any, error) {
 	leftTp := e.Left.ReturnType()
 	switch e.Op {
+	case Gt, Gte, Lt, Lte:
+		if e.isJSONCompare() {
+			return e.execJSONCompare(kv, OperatorToString[e.Op], ctx)
+		}
+	}
+	switch e.Op {
 	case Eq:
 		return e.execEqual(kv, ctx)
 	case NotEq:
@@ -90,7 +109,7 @@ func (e *BinaryOpExpr) Execute(kv KVPair, ctx *ExecuteCtx) (any, error) {
 	case Or, KWOr:
 		return e.execOr(kv, ctx)
 	case Add:
-		if e.Left.ReturnType() == TSTR {
+		if isStringType(e.Left.ReturnType()) {
 			return e.execStringConcate(kv, ctx)
 		}
 		return e.execMath(kv, '+', ctx)
@@ -100,44 +119,54 @@ func (e *BinaryOpExpr) Execute(kv KVPair, ctx *ExecuteCtx) (any, error) {
 		return e.execMath(kv, '*', ctx)
 	case Div:
 		return e.execMath(kv, '/', ctx)
+	case Mod:
+		return e.execMath(kv, '%', ctx)
 	case Gt:
 		switch leftTp {
-		case TSTR:
+		case TSTR, TBYTES:
 			return e.execStringCompare(kv, ">", ctx)
+		case TTIME:
+			return e.execTimeCompare(kv, ">", ctx)
 		default:
 			return e.execNumberCompare(kv, ">", ctx)
 		}
 	case Gte:
 		switch leftTp {
-		case TSTR:
+		case TSTR, TBYTES:
 			return e.execStringCompare(kv, ">=", ctx)
+		case TTIME:
+			return e.execTimeCompare(kv, ">=", ctx)
 		default:
 			return e.execNumberCompare(kv, ">=", ctx)
 		}
 	case Lt:
 		switch leftTp {
-		case TSTR:
+		case TSTR, TBYTES:
 			return e.execStringCompare(kv, "<", ctx)
+		case TTIME:
+			return e.execTimeCompare(kv, "<", ctx)
 		default:
 			return e.execNumberCompare(kv, "<", ctx)
 		}
 	case Lte:
 		switch leftTp {
-		case TSTR:
+		case TSTR, TBYTES:
 			return e.execStringCompare(kv, "<=", ctx)
+		case TTIME:
+			return e.execTimeCompare(kv, "<=", ctx)
 		default:
 			return e.execNumberCompare(kv, "<=", ctx)
 		}
 	case In:
 		switch leftTp {
-		case TSTR:
+		case TSTR, TBYTES:
 			return e.execStringIn(kv, ctx)
 		default:
 			return e.execNumberIn(kv, ctx)
 		}
 	case Between:
 		switch leftTp {
-		case TSTR:
+		case TSTR, TBYTES:
 			return e.execStringBetween(kv, ctx)
 		default:
 			return e.execNumberBetween(kv, ctx)
@@ -147,43 +176,64 @@ func (e *BinaryOpExpr) Execute(kv KVPair, ctx *ExecuteCtx) (any, error) {
 }
 
 func (e *BinaryOpExpr) execEqual(kv KVPair, ctx *ExecuteCtx) (bool, error) {
+	ret, _, err := e.compareEqual(kv, ctx)
+	return ret, err
+}
+
+func (e *BinaryOpExpr) execNotEqual(kv KVPair, ctx *ExecuteCtx) (bool, error) {
+	ret, null, err := e.compareEqual(kv, ctx)
+	if err != nil || null {
+		return false, err
+	}
+	return !ret, nil
+}
+
+// compareEqual returns whether the operands are equal, null is true if
+// one of operands is null so both = and != are false.
+func (e *BinaryOpExpr) compareEqual(kv KVPair, ctx *ExecuteCtx) (bool, bool, error) {
 	rleft, err := e.Left.Execute(kv, ctx)
 	if err != nil {
-		return false, err
+		return false, false, err
 	}
 	rright, err := e.Right.Execute(kv, ctx)
 	if err != nil {
-		return false, err
+		return false, false, err
+	}
+	if e.isJSONCompare() {
+		ret, err := execJSONCompare(rleft, rright, "=")
+		return ret, false, err
 	}
 	switch rleft.(type) {
+	case nil:
+		return false, true, nil
 	case string, []byte:
 		left, lok := convertToByteArray(rleft)
 		right, rok := convertToByteArray(rright)
 		if lok && rok {
-			return bytes.Equal(left, right), nil
+			return bytes.Equal(left, right), false, nil
 		}
-	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
-		lint, lok := convertToInt(rleft)
-		rint, rok := convertToInt(rright)
-		if lok && rok {
-			return lint == rint, nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		// Mixed integer and float are compared as float
+		if ret, err := execNumberCompare(rleft, rright, "="); err == nil {
+			return ret, rright == nil, nil
 		}
 	case bool:
 		lbool, lok := rleft.(bool)
 		rbool, rok := rright.(bool)
 		if lok && rok {
-			return lbool == rbool, nil
+			return lbool == rbool, false, nil
+		}
+	case time.Time:
+		ltime, lok := toTime(rleft)
+		rtime, rok := toTime(rright)
+		if lok && rok {
+			return ltime.Equal(rtime), false, nil
 		}
 	}
-	return false, NewExecuteError(e.GetPos(), "= operator left or right expression has wrong type")
-}
-
-func (e *BinaryOpExpr) execNotEqual(kv KVPair, ctx *ExecuteCtx) (bool, error) {
-	ret, err := e.execEqual(kv, ctx)
-	if err != nil {
-		return false, err
+	if rright == nil {
+		return false, true, nil
 	}
-	return !ret, nil
+	return false, false, NewExecuteError(e.GetPos(), "= operator left or right expression has wrong type")
 }
 
 func (e *BinaryOpExpr) execPrefixMatch(kv KVPair, ctx *ExecuteCtx) (bool, error) {
@@ -306,6 +356,30 @@ func (e *BinaryOpExpr) execStringCompare(kv KVPair, op string, ctx *ExecuteCtx)
 	return execStringCompare(left, right, op)
 }
 
+func (e *BinaryOpExpr) execJSONCompare(kv KVPair, op string, ctx *ExecuteCtx) (any, error) {
+	left, err := e.Left.Execute(kv, ctx)
+	if err != nil {
+		return false, err
+	}
+	right, err := e.Right.Execute(kv, ctx)
+	if err != nil {
+		return false, err
+	}
+	return execJSONCompare(left, right, op)
+}
+
+func (e *BinaryOpExpr) execTimeCompare(kv KVPair, op string, ctx *ExecuteCtx) (any, error) {
+	left, err := e.Left.Execute(kv, ctx)
+	if err != nil {
+		return false, err
+	}
+	right, err := e.Right.Execute(kv, ctx)
+	if err != nil {
+		return false, err
+	}
+	return execTimeCompare(left, right, op)
+}
+
 func (e *BinaryOpExpr) execStringIn(kv KVPair, ctx *ExecuteCtx) (any, error) {
 	left, err := e.Left.Execute(kv, ctx)
 	if err != nil {
@@ -314,7 +388,7 @@ func (e *BinaryOpExpr) execStringIn(kv KVPair, ctx *ExecuteCtx) (any, error) {
 	switch rlist := e.Right.(type) {
 	case *ListExpr:
 		for _, expr := range rlist.List {
-			if expr.ReturnType() != TSTR {
+			if !isStringType(expr.ReturnType()) {
 				return false, NewExecuteError(expr.GetPos(), "in operator right expression element has wrong type")
 			}
 			lvalue, err := expr.Execute(kv, ctx)
@@ -417,10 +491,10 @@ func (e *BinaryOpExpr) execStringBetween(kv KVPair, ctx *ExecuteCtx) (any, error
 	}
 	lexpr := rlist.List[0]
 	uexpr := rlist.List[1]
-	if lexpr.ReturnType() != TSTR {
+	if !isStringType(lexpr.ReturnType()) {
 		return false, NewExecuteError(lexpr.GetPos(), "between operator lower boundary expression has wrong type, not string")
 	}
-	if uexpr.ReturnType() != TSTR {
+	if !isStringType(uexpr.ReturnType()) {
 		return false, NewExecuteError(uexpr.GetPos(), "between operator upper boundary expression has wrong type, not string")
 	}
 	lval, err := lexpr.Execute(kv, ctx)
@@ -532,6 +606,13 @@ func (e *FunctionCallExpr) Execute(kv KVPair, ctx *ExecuteCtx) (any, error) {
 	if e.Result != nil {
 		return e.Result, nil
 	}
+	if e.Slot > 0 {
+		return ctx.execSlot(e.Slot, kv, e.execute)
+	}
+	return e.execute(kv, ctx)
+}
+
+func (e *FunctionCallExpr) execute(kv KVPair, ctx *ExecuteCtx) (any, error) {
 	funcObj, err := GetScalarFunction(e)
 	if err != nil {
 		return nil, err
@@ -566,17 +647,31 @@ func (e *BoolExpr) Execute(kv KVPair, ctx *ExecuteCtx) (any, error) {
 	return e.Bool, nil
 }
 
+func (e *ParamExpr) Execute(kv KVPair, ctx *ExecuteCtx) (any, error) {
+	if e.Value == nil {
+		return nil, NewExecuteError(e.Pos, "Parameter %s not bound", e.Name)
+	}
+	return e.Value.Execute(kv, ctx)
+}
+
 func (e *ListExpr) Execute(kv KVPair, ctx *ExecuteCtx) (any, error) {
 	return e.List, nil
 }
 
 func (e *FieldAccessExpr) Execute(kv KVPair, ctx *ExecuteCtx) (any, error) {
+	if e.Slot > 0 {
+		return ctx.execSlot(e.Slot, kv, e.execute)
+	}
+	return e.execute(kv, ctx)
+}
+
+func (e *FieldAccessExpr) execute(kv KVPair, ctx *ExecuteCtx) (any, error) {
 	left, err := e.Left.Execute(kv, ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	switch fnval := e.FieldName.(type) {
+	switch fnval := boundValue(e.FieldName).(type) {
 	case *StringExpr:
 		return e.execDictAccess(fnval.Data, left)
 	case *NumberExpr:
@@ -595,6 +690,9 @@ func (e *FieldAccessExpr) execDictAccess(fieldName string, left any) (any, error
 		fval, have = lval[fieldName]
 	case JSON:
 		fval, have = lval[fieldName]
+	case nil:
+		// JSON null or missing value
+		have = false
 	case string:
 		if lval == "" {
 			have = false
@@ -622,6 +720,9 @@ func (e *FieldAccessExpr) execListAccess(idx int, left any) (any, error) {
 			have = true
 			fval = lval[idx]
 		}
+	case nil:
+		// JSON null or missing value
+		have = false
 	case string:
 		if lval == "" {
 			have = false
@@ -638,19 +739,36 @@ func (e *FieldAccessExpr) execListAccess(idx int, left any) (any, error) {
 }
 
 func (e *FieldReferenceExpr) Execute(kv KVPair, ctx *ExecuteCtx) (any, error) {
-	if ctx != nil {
-		cval, have := ctx.GetFieldResult(e.Name.Data)
-		if have {
-			ctx.UpdateHit()
-			return cval, nil
-		}
+	// Result is shared with the field by the slot of field expression
+	return e.FieldExpr.Execute(kv, ctx)
+}
+
+func (e *CTEColumnExpr) Execute(kv KVPair, ctx *ExecuteCtx) (any, error) {
+	row, ok := e.CTE.getRow(kv.Key)
+	if !ok || e.Index >= len(row) {
+		return nil, NewExecuteError(e.Pos, "Cannot find column %s in CTE %s", e.Name, e.CTE.Name)
 	}
-	ret, err := e.FieldExpr.Execute(kv, ctx)
-	if err != nil {
-		return ret, err
+	return row[e.Index], nil
+}
+
+func (e *LambdaExpr) Execute(kv KVPair, ctx *ExecuteCtx) (any, error) {
+	return nil, NewExecuteError(e.Pos, "Lambda expression can only be used as argument of list function")
+}
+
+// call executes the lambda body for the row with the arguments
+func (e *LambdaExpr) call(kv KVPair, ctx *ExecuteCtx, args ...any) (any, error) {
+	if len(args) != len(e.Params) {
+		return nil, NewExecuteError(e.Pos, "Lambda expression requires %d arguments but got %d", len(e.Params), len(args))
 	}
-	if ctx != nil {
-		ctx.SetFieldResult(e.Name.Data, ret)
+	for i, arg := range args {
+		ctx.setLambdaArg(e.Index+i, arg)
 	}
-	return ret, err
+	return e.Body.Execute(kv, ctx)
+}
+
+func (e *LambdaArgExpr) Execute(kv KVPair, ctx *ExecuteCtx) (any, error) {
+	if ctx == nil || e.Index >= len(ctx.lambdaArgs) {
+		return nil, NewExecuteError(e.Pos, "Lambda argument %s is not bound", e.Name)
+	}
+	return ctx.lambdaArgs[e.Index], nil
 }
@@ -3,6 +3,8 @@ package kvql
 import (
 	"bytes"
 	"fmt"
+	"math"
+	"strings"
 	"testing"
 )
 
@@ -210,3 +212,140 @@ func TestExec6(t *testing.T) {
 		fmt.Println("Hits:", ctx.Hit)
 	}
 }
+
+func TestMathOperators(t *testing.T) {
+	kv := NewKVPStr("k", "v")
+	tcases := []struct {
+		expr   string
+		expect any
+	}{
+		{"7 % 3", int64(1)},
+		{"(0 - 7) % 3", int64(-1)},
+		{"7 % (0 - 3)", int64(1)},
+		{"7.5 % 2", 1.5},
+		{"1 + 7 % 4 * 2", int64(7)},
+		{"7 / 2", int64(3)},
+		{"(0 - 7) / 2", int64(-3)},
+		{"7 / 2.0", 3.5},
+		{"1 + 0.5", 1.5},
+		{"3 * 0.5", 1.5},
+		{"9223372036854775806 + 1", int64(9223372036854775807)},
+	}
+	for _, c := range tcases {
+		ret, err := execFuncExpr(t, c.expr, kv)
+		if err != nil {
+			t.Fatal(c.expr, err)
+		}
+		if ret != c.expect {
+			t.Fatalf("%s expect %#v but got %#v", c.expr, c.expect, ret)
+		}
+	}
+}
+
+func TestMathOperatorErrors(t *testing.T) {
+	kv := NewKVPStr("k", "0")
+	tcases := []struct {
+		expr string
+		err  string
+	}{
+		{"9223372036854775807 + 1", "Integer overflow"},
+		{"(0 - 9223372036854775807) - 2", "Integer overflow"},
+		{"4611686018427387904 * 2", "Integer overflow"},
+		{"(0 - 9223372036854775807 - 1) / (0 - 1)", "Integer overflow"},
+		{"1 / int(value)", "Divide by zero"},
+		{"1 % int(value)", "Divide by zero"},
+		{"1.5 / int(value)", "Divide by zero"},
+		{"1.5 % float(value)", "Divide by zero"},
+	}
+	for _, c := range tcases {
+		_, err := execFuncExpr(t, c.expr, kv)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("%s expect error %s but got %v", c.expr, c.err, err)
+		}
+	}
+	for _, query := range []string{"select 1 % 0 where true", "select 1 / 0 where true"} {
+		if _, err := NewParser(query).Parse(); err == nil || !strings.Contains(err.Error(), "divide by zero") {
+			t.Fatalf("%s expect divide by zero error but got %v", query, err)
+		}
+	}
+}
+
+func TestMathConstantFolding(t *testing.T) {
+	tcases := []struct {
+		expr   string
+		expect Expression
+	}{
+		{"1 + 0.5", &FloatExpr{Data: "1.5", Float: 1.5}},
+		{"7 % 3 + 1", &NumberExpr{Data: "2", Int: 2}},
+	}
+	for _, c := range tcases {
+		stmt, err := NewParser("select * where " + c.expr + " > 0").Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		where := stmt.(*SelectStmt).Where.Expr.(*BinaryOpExpr)
+		o := ExpressionOptimizer{Root: where.Left}
+		ret := o.Optimize()
+		if ret.String() != c.expect.String() {
+			t.Fatalf("%s expect folded to %s but got %s", c.expr, c.expect.String(), ret.String())
+		}
+	}
+}
+
+func TestNumberConversion(t *testing.T) {
+	if _, ok := convertToInt(uint64(math.MaxUint64)); ok {
+		t.Fatal("uint64 exceeds int64 should not be converted")
+	}
+	if ret, ok := convertToInt(uint64(42)); !ok || ret != 42 {
+		t.Fatal("Unexpected convert result", ret)
+	}
+	if ret, ok := convertToFloat(int32(3)); !ok || ret != 3.0 {
+		t.Fatal("Integer should be promoted to float", ret)
+	}
+	ret, err := executeMathOp(int64(1), 0.5, '+', &NumberExpr{})
+	if err != nil || ret != 1.5 {
+		t.Fatal("Mixed operands should be promoted to float", ret, err)
+	}
+}
+
+func TestNumberEqual(t *testing.T) {
+	kvs := []KVPair{
+		NewKVPStr("k1", "4"),
+		NewKVPStr("k2", "2"),
+		NewKVPStr("k3", "3"),
+	}
+	tdata := []struct {
+		query  string
+		expect string
+	}{
+		{"where sqrt(int(value)) = 2", "[true false false]"},
+		{"where round(float(value) / 2) = 1", "[false true false]"},
+		{"where int(value) = 1.5 * 2", "[false false true]"},
+		{"where float(value) != 4", "[false true true]"},
+		{"where int(value) * 0.5 = 1", "[false true false]"},
+	}
+	for _, item := range tdata {
+		_, exec, err := BuildExecutor(item.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx := NewExecuteCtx()
+		ret, err := exec.FilterBatch(kvs, ctx)
+		if err != nil {
+			t.Fatal(item.query, err)
+		}
+		if fmt.Sprintf("%v", ret) != item.expect {
+			t.Fatal(item.query, "batch got", ret)
+		}
+		rows := make([]bool, len(kvs))
+		for i, kv := range kvs {
+			ctx.Clear()
+			if rows[i], err = exec.Filter(kv, ctx); err != nil {
+				t.Fatal(item.query, err)
+			}
+		}
+		if fmt.Sprintf("%v", rows) != item.expect {
+			t.Fatal(item.query, "got", rows)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package kvql
 import (
 	"bytes"
 	"regexp"
+	"time"
 )
 
 func (e *StringExpr) ExecuteBatch(chunk []KVPair, ctx *ExecuteCtx) ([]any, error) {
@@ -76,6 +77,13 @@ func (e *BoolExpr) ExecuteBatch(chunk []KVPair, ctx *ExecuteCtx) ([]any, error)
 	return ret, nil
 }
 
+func (e *ParamExpr) ExecuteBatch(chunk []KVPair, ctx *ExecuteCtx) ([]any, error) {
+	if e.Value == nil {
+		return nil, NewExecuteError(e.Pos, "Parameter %s not bound", e.Name)
+	}
+	return e.Value.ExecuteBatch(chunk, ctx)
+}
+
 func (e *ListExpr) ExecuteBatch(chunk []KVPair, ctx *ExecuteCtx) ([]any, error) {
 	ret := make([]any, len(chunk))
 	for i := 0; i < len(chunk); i++ {
@@ -85,8 +93,21 @@ func (e *ListExpr) ExecuteBatch(chunk []KVPair, ctx *ExecuteCtx) ([]any, error)
 }
 
 func (e *BinaryOpExpr) ExecuteBatch(chunk []KVPair, ctx *ExecuteCtx) ([]any, error) {
+	if e.Slot > 0 {
+		return ctx.execSlotBatch(e.Slot, chunk, e.executeBatch)
+	}
+	return e.executeBatch(chunk, ctx)
+}
+
+func (e *BinaryOpExpr) executeBatch(chunk []KVPair, ctx *ExecuteCtx) ([]any, error) {
 	leftTp := e.Left.ReturnType()
 	switch e.Op {
+	case Eq, NotEq, Gt, Gte, Lt, Lte:
+		if e.isJSONCompare() {
+			return e.execJSONCompareBatch(chunk, ctx)
+		}
+	}
+	switch e.Op {
 	case Eq:
 		return e.execEqualBatch(chunk, false, ctx)
 	case NotEq:
@@ -100,7 +121,7 @@ func (e *BinaryOpExpr) ExecuteBatch(chunk []KVPair, ctx *ExecuteCtx) ([]any, err
 	case Or, KWOr:
 		return e.execAndOrBatch(chunk, false, ctx)
 	case Add:
-		if e.Left.ReturnType() == TSTR {
+		if isStringType(e.Left.ReturnType()) {
 			return e.execStringConcateBatch(chunk, ctx)
 		}
 		return e.execMathBatch(chunk, '+', ctx)
@@ -110,44 +131,54 @@ func (e *BinaryOpExpr) ExecuteBatch(chunk []KVPair, ctx *ExecuteCtx) ([]any, err
 		return e.execMathBatch(chunk, '*', ctx)
 	case Div:
 		return e.execMathBatch(chunk, '/', ctx)
+	case Mod:
+		return e.execMathBatch(chunk, '%', ctx)
 	case Gt:
 		switch leftTp {
-		case TSTR:
+		case TSTR, TBYTES:
 			return e.execStringCompareBatch(chunk, ">", ctx)
+		case TTIME:
+			return e.execTimeCompareBatch(chunk, ">", ctx)
 		default:
 			return e.execNumberCompareBatch(chunk, ">", ctx)
 		}
 	case Gte:
 		switch leftTp {
-		case TSTR:
+		case TSTR, TBYTES:
 			return e.execStringCompareBatch(chunk, ">=", ctx)
+		case TTIME:
+			return e.execTimeCompareBatch(chunk, ">=", ctx)
 		default:
 			return e.execNumberCompareBatch(chunk, ">=", ctx)
 		}
 	case Lt:
 		switch leftTp {
-		case TSTR:
+		case TSTR, TBYTES:
 			return e.execStringCompareBatch(chunk, "<", ctx)
+		case TTIME:
+			return e.execTimeCompareBatch(chunk, "<", ctx)
 		default:
 			return e.execNumberCompareBatch(chunk, "<", ctx)
 		}
 	case Lte:
 		switch leftTp {
-		case TSTR:
+		case TSTR, TBYTES:
 			return e.execStringCompareBatch(chunk, "<=", ctx)
+		case TTIME:
+			return e.execTimeCompareBatch(chunk, "<=", ctx)
 		default:
 			return e.execNumberCompareBatch(chunk, "<=", ctx)
 		}
 	case In:
 		switch leftTp {
-		case TSTR:
+		case TSTR, TBYTES:
 			return e.execInBatch(chunk, false, ctx)
 		default:
 			return e.execInBatch(chunk, true, ctx)
 		}
 	case Between:
 		switch leftTp {
-		case TSTR:
+		case TSTR, TBYTES:
 			return e.execBetweenBatch(chunk, false, ctx)
 		default:
 			return e.execBetweenBatch(chunk, true, ctx)
@@ -166,26 +197,43 @@ func (e *BinaryOpExpr) execEqualBatch(chunk []KVPair, not bool, ctx *ExecuteCtx)
 		return nil, err
 	}
 	var (
-		isStr  = false
-		isInt  = false
-		isBool = false
+		isStr    = false
+		isNumber = false
+		isBool   = false
+		isTime   = false
 	)
 	if len(chunk) == 0 {
 		return nil, nil
 	}
 
-	switch rleft[0].(type) {
+	// The type is decided by the first non-null value, comparison with
+	// null is false for both = and !=
+	var first any
+	for _, val := range rleft {
+		if val != nil {
+			first = val
+			break
+		}
+	}
+	switch first.(type) {
+	case nil:
 	case string, []byte:
 		isStr = true
-	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
-		isInt = true
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+		isNumber = true
 	case bool:
 		isBool = true
+	case time.Time:
+		isTime = true
 	default:
 		return nil, NewExecuteError(e.GetPos(), "= operator left expression has wrong type")
 	}
 
 	for i := 0; i < len(chunk); i++ {
+		if rleft[i] == nil || rright[i] == nil {
+			rleft[i] = false
+			continue
+		}
 		if isStr {
 			left, lok := convertToByteArray(rleft[i])
 			right, rok := convertToByteArray(rright[i])
@@ -198,9 +246,17 @@ func (e *BinaryOpExpr) execEqualBatch(chunk []KVPair, not bool, ctx *ExecuteCtx)
 				rleft[i] = bytes.Equal(left, right)
 			}
 		}
-		if isInt {
-			left, lok := convertToInt(rleft[i])
-			right, rok := convertToInt(rright[i])
+		if isNumber {
+			// Mixed integer and float are compared as float
+			ret, err := execNumberCompare(rleft[i], rright[i], "=")
+			if err != nil {
+				return nil, NewExecuteError(e.GetPos(), "= operator left or right expression has wrong type")
+			}
+			rleft[i] = ret != not
+		}
+		if isBool {
+			left, lok := rleft[i].(bool)
+			right, rok := rright[i].(bool)
 			if !lok || !rok {
 				return nil, NewExecuteError(e.GetPos(), "= operator left or right expression has wrong type")
 			}
@@ -210,22 +266,45 @@ func (e *BinaryOpExpr) execEqualBatch(chunk []KVPair, not bool, ctx *ExecuteCtx)
 				rleft[i] = left == right
 			}
 		}
-		if isBool {
-			left, lok := rleft[i].(bool)
-			right, rok := rright[i].(bool)
+		if isTime {
+			left, lok := toTime(rleft[i])
+			right, rok := toTime(rright[i])
 			if !lok || !rok {
 				return nil, NewExecuteError(e.GetPos(), "= operator left or right expression has wrong type")
 			}
 			if not {
-				rleft[i] = left != right
+				rleft[i] = !left.Equal(right)
 			} else {
-				rleft[i] = left == right
+				rleft[i] = left.Equal(right)
 			}
 		}
 	}
 	return rleft, nil
 }
 
+func (e *BinaryOpExpr) execJSONCompareBatch(chunk []KVPair, ctx *ExecuteCtx) ([]any, error) {
+	rleft, err := e.Left.ExecuteBatch(chunk, ctx)
+	if err != nil {
+		return nil, err
+	}
+	rright, err := e.Right.ExecuteBatch(chunk, ctx)
+	if err != nil {
+		return nil, err
+	}
+	op := OperatorToString[e.Op]
+	if e.Op == NotEq {
+		op = "="
+	}
+	for i := 0; i < len(chunk); i++ {
+		ret, err := execJSONCompare(rleft[i], rright[i], op)
+		if err != nil {
+			return nil, err
+		}
+		rleft[i] = ret != (e.Op == NotEq)
+	}
+	return rleft, nil
+}
+
 func (e *BinaryOpExpr) execPrefixMatchBatch(chunk []KVPair, ctx *ExecuteCtx) ([]any, error) {
 	rleft, err := e.Left.ExecuteBatch(chunk, ctx)
 	if err != nil {
@@ -363,6 +442,25 @@ func (e *BinaryOpExpr) execStringCompareBatch(chunk []KVPair, op string, ctx *Ex
 	return rleft, nil
 }
 
+func (e *BinaryOpExpr) execTimeCompareBatch(chunk []KVPair, op string, ctx *ExecuteCtx) ([]any, error) {
+	rleft, err := e.Left.ExecuteBatch(chunk, ctx)
+	if err != nil {
+		return nil, err
+	}
+	rright, err := e.Right.ExecuteBatch(chunk, ctx)
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < len(chunk); i++ {
+		val, err := execTimeCompare(rleft[i], rright[i], op)
+		if err != nil {
+			return nil, err
+		}
+		rleft[i] = val
+	}
+	return rleft, nil
+}
+
 func (e *BinaryOpExpr) execInBatch(chunk []KVPair, number bool, ctx *ExecuteCtx) ([]any, error) {
 	rleft, err := e.Left.ExecuteBatch(chunk, ctx)
 	if err != nil {
@@ -384,7 +482,7 @@ func (e *BinaryOpExpr) execInBatch(chunk []KVPair, number bool, ctx *ExecuteCtx)
 			if number && expr.ReturnType() != TNUMBER {
 				return nil, NewExecuteError(expr.GetPos(), "in operator right expression element has wrong type, not number")
 			}
-			if !number && expr.ReturnType() != TSTR {
+			if !number && !isStringType(expr.ReturnType()) {
 				return nil, NewExecuteError(expr.GetPos(), "in operator right expression element has wrong type, not string")
 			}
 			values, err = expr.ExecuteBatch(chunk, ctx)
@@ -414,7 +512,7 @@ func (e *BinaryOpExpr) execInBatch(chunk []KVPair, number bool, ctx *ExecuteCtx)
 			rleft[i] = cmpRet
 		}
 		return rleft, nil
-	case *FunctionCallExpr, *FieldReferenceExpr:
+	case *FunctionCallExpr, *FieldReferenceExpr, *CTEColumnExpr:
 		frets, err := rlist.ExecuteBatch(chunk, ctx)
 		if err != nil {
 			return nil, err
@@ -460,10 +558,10 @@ func (e *BinaryOpExpr) execBetweenBatch(chunk []KVPair, number bool, ctx *Execut
 	}
 	lexpr := rlist.List[0]
 	uexpr := rlist.List[1]
-	if !number && lexpr.ReturnType() != TSTR {
+	if !number && !isStringType(lexpr.ReturnType()) {
 		return nil, NewExecuteError(lexpr.GetPos(), "between operator lower boundary expression has wrong type, not string")
 	}
-	if !number && lexpr.ReturnType() != TSTR {
+	if !number && !isStringType(uexpr.ReturnType()) {
 		return nil, NewExecuteError(uexpr.GetPos(), "between operator upper boundary expression has wrong type, not string")
 	}
 	if number && lexpr.ReturnType() != TNUMBER {
@@ -555,7 +653,13 @@ func (e *FunctionCallExpr) ExecuteBatch(chunk []KVPair, ctx *ExecuteCtx) ([]any,
 		}
 		return ret, nil
 	}
+	if e.Slot > 0 {
+		return ctx.execSlotBatch(e.Slot, chunk, e.executeBatch)
+	}
+	return e.executeBatch(chunk, ctx)
+}
 
+func (e *FunctionCallExpr) executeBatch(chunk []KVPair, ctx *ExecuteCtx) ([]any, error) {
 	funcObj, err := GetScalarFunction(e)
 	if err != nil {
 		return nil, err
@@ -585,11 +689,18 @@ func (e *FunctionCallExpr) executeFuncBatch(funcObj *Function, chunk []KVPair, c
 }
 
 func (e *FieldAccessExpr) ExecuteBatch(chunk []KVPair, ctx *ExecuteCtx) ([]any, error) {
+	if e.Slot > 0 {
+		return ctx.execSlotBatch(e.Slot, chunk, e.executeBatch)
+	}
+	return e.executeBatch(chunk, ctx)
+}
+
+func (e *FieldAccessExpr) executeBatch(chunk []KVPair, ctx *ExecuteCtx) ([]any, error) {
 	left, err := e.Left.ExecuteBatch(chunk, ctx)
 	if err != nil {
 		return nil, err
 	}
-	switch fnval := e.FieldName.(type) {
+	switch fnval := boundValue(e.FieldName).(type) {
 	case *StringExpr:
 		return e.execDictAccessBatch(fnval.Data, left)
 	case *NumberExpr:
@@ -609,6 +720,9 @@ func (e *FieldAccessExpr) execDictAccessBatch(fieldName string, left []any) ([]a
 			fval, have = lval[fieldName]
 		case JSON:
 			fval, have = lval[fieldName]
+		case nil:
+			// JSON null or missing value
+			have = false
 		case string:
 			if lval == "" {
 				have = false
@@ -658,6 +772,9 @@ func (e *FieldAccessExpr) execListAccessBatch(idx int, left []any) ([]any, error
 				have = true
 				fval = lval[idx]
 			}
+		case nil:
+			// JSON null or missing value
+			have = false
 		case string:
 			if lval == "" {
 				have = false
@@ -677,25 +794,33 @@ func (e *FieldAccessExpr) execListAccessBatch(idx int, left []any) ([]any, error
 }
 
 func (e *FieldReferenceExpr) ExecuteBatch(chunk []KVPair, ctx *ExecuteCtx) ([]any, error) {
-	if ctx != nil {
-		cval, have := ctx.GetChunkFieldResult(e.Name.Data, chunk[0].Key)
-		if have {
-			// Copy cached data
-			retCopy := make([]any, len(cval))
-			copy(retCopy, cval)
-			ctx.UpdateHit()
-			return retCopy, nil
+	return e.FieldExpr.ExecuteBatch(chunk, ctx)
+}
+
+func (e *CTEColumnExpr) ExecuteBatch(chunk []KVPair, ctx *ExecuteCtx) ([]any, error) {
+	ret := make([]any, len(chunk))
+	for i, kv := range chunk {
+		val, err := e.Execute(kv, ctx)
+		if err != nil {
+			return nil, err
 		}
+		ret[i] = val
 	}
-	ret, err := e.FieldExpr.ExecuteBatch(chunk, ctx)
-	if err != nil {
-		return ret, err
-	}
-	if ctx != nil {
-		// We should copy result to refuse result overwrite by later execute functions
-		retCopy := make([]any, len(ret))
-		copy(retCopy, ret)
-		ctx.SetChunkFieldResult(e.Name.Data, chunk[0].Key, retCopy)
+	return ret, nil
+}
+
+func (e *LambdaExpr) ExecuteBatch(chunk []KVPair, ctx *ExecuteCtx) ([]any, error) {
+	return nil, NewExecuteError(e.Pos, "Lambda expression can only be used as argument of list function")
+}
+
+func (e *LambdaArgExpr) ExecuteBatch(chunk []KVPair, ctx *ExecuteCtx) ([]any, error) {
+	ret := make([]any, len(chunk))
+	for i, kv := range chunk {
+		val, err := e.Execute(kv, ctx)
+		if err != nil {
+			return nil, err
+		}
+		ret[i] = val
 	}
-	return ret, err
+	return ret, nil
 }
@@ -126,23 +126,18 @@ func (o *ExpressionOptimizer) tryOptimizeBinaryOpExecute(e *BinaryOpExpr) (Expre
 	}
 	leftPos := e.Left.GetPos()
 	switch e.Op {
-	case Add, Sub, Mul, Div:
+	case Add, Sub, Mul, Div, Mod:
 		ret, err := e.Execute(NewKVP(nil, nil), nil)
 		if err == nil {
 			switch e.Left.(type) {
 			case *StringExpr:
 				return &StringExpr{Pos: leftPos, Data: ret.(string)}, true
-			case *NumberExpr:
+			case *NumberExpr, *FloatExpr:
+				// Keep the result type, integer is promoted to float
+				// if any operand is float.
 				switch cret := ret.(type) {
 				case int64:
 					return &NumberExpr{Pos: leftPos, Data: fmt.Sprintf("%v", cret), Int: cret}, true
-				case float64:
-					return &NumberExpr{Pos: leftPos, Data: fmt.Sprintf("%v", int64(cret)), Int: int64(cret)}, true
-				}
-			case *FloatExpr:
-				switch cret := ret.(type) {
-				case int64:
-					return &FloatExpr{Pos: leftPos, Data: fmt.Sprintf("%v", float64(cret)), Float: float64(cret)}, true
 				case float64:
 					return &FloatExpr{Pos: leftPos, Data: fmt.Sprintf("%v", cret), Float: cret}, true
 				}
@@ -271,11 +266,15 @@ func (o *ExpressionOptimizer) tryOptimizeFunctionCall(e *FunctionCallExpr) (Expr
 	case TJSON:
 		return e, false
 	}
+	// JSON value keeps its type for JSON comparison
+	if isJSONValueExpr(e) {
+		return e, false
+	}
 	ret, err := e.Execute(NewKVP(nil, nil), nil)
-	if err == nil {
+	if err == nil && ret != nil {
 		switch retTp {
-		case TSTR:
-			return &StringExpr{Pos: e.GetPos(), Data: ret.(string)}, true
+		case TSTR, TBYTES:
+			return &StringExpr{Pos: e.GetPos(), Data: toString(ret)}, true
 		case TNUMBER:
 			iret, ok := ret.(int64)
 			if ok {
@@ -32,51 +32,78 @@ func (t *mockQueryStorage) Get(key []byte) ([]byte, error) {
 }
 
 func (t *mockQueryStorage) Put(key []byte, value []byte) error {
+	idx := sort.Search(len(t.data), func(i int) bool {
+		return bytes.Compare(t.data[i].Key, key) >= 0
+	})
+	if idx < len(t.data) && bytes.Equal(t.data[idx].Key, key) {
+		t.data[idx].Value = value
+		return nil
+	}
+	t.data = append(t.data, KVPair{})
+	copy(t.data[idx+1:], t.data[idx:])
+	t.data[idx] = NewKVP(key, value)
 	return nil
 }
 
 func (t *mockQueryStorage) BatchPut(kvs []KVPair) error {
+	for _, kvp := range kvs {
+		t.Put(kvp.Key, kvp.Value)
+	}
 	return nil
 }
 
 func (t *mockQueryStorage) Delete(key []byte) error {
+	for i, kvp := range t.data {
+		if bytes.Equal(kvp.Key, key) {
+			t.data = append(t.data[:i:i], t.data[i+1:]...)
+			break
+		}
+	}
 	return nil
 }
 
-func (t *mockQueryStorage) BatchDelete(key [][]byte) error {
+func (t *mockQueryStorage) BatchDelete(keys [][]byte) error {
+	for _, key := range keys {
+		t.Delete(key)
+	}
 	return nil
 }
 
 func (t *mockQueryStorage) Cursor() (Cursor, error) {
 	return &mockCursor{
-		data:   t.data,
-		idx:    0,
-		length: len(t.data),
+		storage: t,
 	}, nil
 }
 
+// mockCursor reads the latest data of storage, so it can see the keys
+// written during iteration.
 type mockCursor struct {
-	data   []KVPair
-	idx    int
-	length int
+	storage *mockQueryStorage
+	idx     int
+	last    []byte
 }
 
 func (c *mockCursor) Seek(key []byte) error {
-	for c.idx < c.length {
-		row := c.data[c.idx]
-		if bytes.Compare(row.Key, key) >= 0 {
-			break
-		}
-		c.idx++
-	}
+	data := c.storage.data
+	c.last = nil
+	c.idx = sort.Search(len(data), func(i int) bool {
+		return bytes.Compare(data[i].Key, key) >= 0
+	})
 	return nil
 }
 
 func (c *mockCursor) Next() (key []byte, val []byte, err error) {
-	if c.idx >= c.length {
+	data := c.storage.data
+	if c.last != nil {
+		c.idx = sort.Search(len(data), func(i int) bool {
+			return bytes.Compare(data[i].Key, c.last) > 0
+		})
+	}
+	if c.idx >= len(data) {
 		return nil, nil, nil
 	}
-	ret := c.data[c.idx]
+	ret := data[c.idx]
+	c.last = ret.Key
 	c.idx++
 	return ret.Key, ret.Value, nil
 }
@@ -2,6 +2,7 @@ package kvql
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"strings"
 )
@@ -27,6 +28,11 @@ type FilterOptimizer struct {
 	expr    Expression
 	filter  *FilterExec
 	storage Storage
+
+	// keyPrefix is the key prefix required by the AND expression which is
+	// optimizing, it is used to calculate the scan range of decoded key
+	// fields.
+	keyPrefix []byte
 }
 
 func (st *ScanType) String() string {
@@ -97,6 +103,10 @@ func (o *FilterOptimizer) Optimize() Plan {
 func (o *FilterOptimizer) optimizeExpr(expr Expression) *ScanType {
 	switch e := expr.(type) {
 	case *BinaryOpExpr:
+		if stype, ok := o.optimizeKeyDecodeExpr(e); ok {
+			// It may use PREFIX, RANGE or EMPTY
+			return stype
+		}
 		switch e.Op {
 		case And, KWAnd:
 			return o.optimizeAndExpr(e)
@@ -157,6 +167,12 @@ func (o *FilterOptimizer) optimizeInExpr(e *BinaryOpExpr) *ScanType {
 					// Can calculate in optimize step
 					key := []byte(item.Data)
 					keys = append(keys, key)
+				case *ParamExpr:
+					if data, ok := item.stringValue(); ok {
+						keys = append(keys, []byte(data))
+					} else {
+						canUseMget = false
+					}
 				default:
 					canUseMget = false
 					break
@@ -203,6 +219,15 @@ func (o *FilterOptimizer) optimizeBetweenExpr(e *BinaryOpExpr) *ScanType {
 				} else if i == 1 {
 					upper = []byte(item.Data)
 				}
+			case *ParamExpr:
+				data, ok := item.stringValue()
+				if !ok {
+					canUseRange = false
+				} else if i == 0 {
+					lower = []byte(data)
+				} else if i == 1 {
+					upper = []byte(data)
+				}
 			default:
 				canUseRange = false
 				break
@@ -227,6 +252,10 @@ func (o *FilterOptimizer) optimizeGtGteExpr(e *BinaryOpExpr) *ScanType {
 	switch left := e.Left.(type) {
 	case *StringExpr:
 		key = []byte(left.Data)
+	case *ParamExpr:
+		if data, ok := left.stringValue(); ok {
+			key = []byte(data)
+		}
 	case *FieldExpr:
 		field = left.Field
 	}
@@ -234,6 +263,10 @@ func (o *FilterOptimizer) optimizeGtGteExpr(e *BinaryOpExpr) *ScanType {
 	switch right := e.Right.(type) {
 	case *StringExpr:
 		key = []byte(right.Data)
+	case *ParamExpr:
+		if data, ok := right.stringValue(); ok {
+			key = []byte(data)
+		}
 	case *FieldExpr:
 		field = right.Field
 	}
@@ -261,6 +294,10 @@ func (o *FilterOptimizer) optimizeLtLteExpr(e *BinaryOpExpr) *ScanType {
 	switch left := e.Left.(type) {
 	case *StringExpr:
 		key = []byte(left.Data)
+	case *ParamExpr:
+		if data, ok := left.stringValue(); ok {
+			key = []byte(data)
+		}
 	case *FieldExpr:
 		field = left.Field
 	}
@@ -268,6 +305,10 @@ func (o *FilterOptimizer) optimizeLtLteExpr(e *BinaryOpExpr) *ScanType {
 	switch right := e.Right.(type) {
 	case *StringExpr:
 		key = []byte(right.Data)
+	case *ParamExpr:
+		if data, ok := right.stringValue(); ok {
+			key = []byte(data)
+		}
 	case *FieldExpr:
 		field = right.Field
 	}
@@ -295,6 +336,10 @@ func (o *FilterOptimizer) optimizePrefixMatchExpr(e *BinaryOpExpr) *ScanType {
 	switch left := e.Left.(type) {
 	case *StringExpr:
 		key = []byte(left.Data)
+	case *ParamExpr:
+		if data, ok := left.stringValue(); ok {
+			key = []byte(data)
+		}
 	case *FieldExpr:
 		field = left.Field
 	}
@@ -302,6 +347,10 @@ func (o *FilterOptimizer) optimizePrefixMatchExpr(e *BinaryOpExpr) *ScanType {
 	switch right := e.Right.(type) {
 	case *StringExpr:
 		key = []byte(right.Data)
+	case *ParamExpr:
+		if data, ok := right.stringValue(); ok {
+			key = []byte(data)
+		}
 	case *FieldExpr:
 		field = right.Field
 	}
@@ -324,6 +373,10 @@ func (o *FilterOptimizer) optimizeEqualExpr(e *BinaryOpExpr) *ScanType {
 	switch left := e.Left.(type) {
 	case *StringExpr:
 		key = []byte(left.Data)
+	case *ParamExpr:
+		if data, ok := left.stringValue(); ok {
+			key = []byte(data)
+		}
 	case *FieldExpr:
 		field = left.Field
 	}
@@ -331,6 +384,10 @@ func (o *FilterOptimizer) optimizeEqualExpr(e *BinaryOpExpr) *ScanType {
 	switch right := e.Right.(type) {
 	case *StringExpr:
 		key = []byte(right.Data)
+	case *ParamExpr:
+		if data, ok := right.stringValue(); ok {
+			key = []byte(data)
+		}
 	case *FieldExpr:
 		field = right.Field
 	}
@@ -346,6 +403,12 @@ func (o *FilterOptimizer) optimizeEqualExpr(e *BinaryOpExpr) *ScanType {
 }
 
 func (o *FilterOptimizer) optimizeAndExpr(e *BinaryOpExpr) *ScanType {
+	// Both sides of AND require the key has the prefix
+	if prefix := o.andKeyPrefix(e); len(prefix) > len(o.keyPrefix) {
+		outer := o.keyPrefix
+		o.keyPrefix = prefix
+		defer func() { o.keyPrefix = outer }()
+	}
 	lstype := o.optimizeExpr(e.Left)
 	rstype := o.optimizeExpr(e.Right)
 	if lstype.scanTp == rstype.scanTp {
@@ -900,3 +963,204 @@ func (o *FilterOptimizer) unionPrefixAndRange(prefix, srange *ScanType) *ScanTyp
 	// scan to make sure the correctness
 	return &ScanType{FULL, nil}
 }
+
+// andKeyPrefix returns the longest key prefix required by the prefix match
+// and key field equal expressions connected with AND.
+func (o *FilterOptimizer) andKeyPrefix(expr Expression) []byte {
+	e, ok := expr.(*BinaryOpExpr)
+	if !ok {
+		return nil
+	}
+	switch e.Op {
+	case And, KWAnd:
+		lprefix := o.andKeyPrefix(e.Left)
+		rprefix := o.andKeyPrefix(e.Right)
+		if bytes.HasPrefix(lprefix, rprefix) {
+			return lprefix
+		}
+		if bytes.HasPrefix(rprefix, lprefix) {
+			return rprefix
+		}
+		// Prefixes are conflict, the scan type is EMPTY
+	case PrefixMatch:
+		stype := o.optimizePrefixMatchExpr(e)
+		if stype.scanTp == PREFIX {
+			return stype.keys[0]
+		}
+	case Eq:
+		stype, ok := o.optimizeKeyDecodeExpr(e)
+		if ok && stype.scanTp == PREFIX {
+			return stype.keys[0]
+		}
+	}
+	return nil
+}
+
+// keyField describes the key field decoded by function, the field is after
+// the key prefix of prefixLen bytes and the separator. The encoder is order
+// preserving, so the encoded bound value can be used as scan range of key,
+// it returns false if the value is not in the domain of the encoder.
+type keyField struct {
+	prefixLen int
+	sep       string
+	encode    func(val Expression) ([]byte, bool)
+}
+
+// keyFieldFuncs are the functions decode key field, the prefix length of
+// decode_* functions is the offset argument.
+var keyFieldFuncs = map[string]keyField{
+	"decode_uint16_be":           {-1, "", uintKeyFieldEncoder(2)},
+	"decode_uint32_be":           {-1, "", uintKeyFieldEncoder(4)},
+	"decode_uint64_be":           {-1, "", uintKeyFieldEncoder(8)},
+	"decode_memcomparable_int":   {-1, "", memcomparableIntKeyFieldEncoder},
+	"decode_memcomparable_bytes": {-1, "", memcomparableBytesKeyFieldEncoder},
+	"tidb_table":                 {0, tidbTableKeyPrefix, memcomparableIntKeyFieldEncoder},
+	"tidb_handle":                {tidbPrefixLen, tidbRecordPrefixSep, memcomparableIntKeyFieldEncoder},
+	"tidb_index_id":              {tidbPrefixLen, tidbIndexPrefixSep, memcomparableIntKeyFieldEncoder},
+}
+
+func uintKeyFieldEncoder(size int) func(val Expression) ([]byte, bool) {
+	return func(val Expression) ([]byte, bool) {
+		num, ok := val.(*NumberExpr)
+		if !ok || num.Int < 0 || (size < 8 && num.Int >= 1<<(8*size)) {
+			return nil, false
+		}
+		ret := binary.BigEndian.AppendUint64(nil, uint64(num.Int))
+		return ret[8-size:], true
+	}
+}
+
+func memcomparableIntKeyFieldEncoder(val Expression) ([]byte, bool) {
+	num, ok := val.(*NumberExpr)
+	if !ok {
+		return nil, false
+	}
+	return encodeMemcomparableInt(nil, num.Int), true
+}
+
+func memcomparableBytesKeyFieldEncoder(val Expression) ([]byte, bool) {
+	str, ok := val.(*StringExpr)
+	if !ok {
+		return nil, false
+	}
+	return encodeMemcomparableBytes(nil, []byte(str.Data)), true
+}
+
+// getKeyField returns the key field if expr decodes the field of key at
+// the constant offset.
+func getKeyField(expr Expression) (keyField, bool) {
+	fc, ok := expr.(*FunctionCallExpr)
+	if !ok || len(fc.Args) == 0 {
+		return keyField{}, false
+	}
+	fname, err := GetFuncNameFromExpr(fc)
+	if err != nil {
+		return keyField{}, false
+	}
+	kf, have := keyFieldFuncs[fname]
+	if !have {
+		return keyField{}, false
+	}
+	if field, ok := fc.Args[0].(*FieldExpr); !ok || field.Field != KeyKW {
+		return keyField{}, false
+	}
+	if kf.prefixLen >= 0 {
+		return kf, len(fc.Args) == 1
+	}
+	kf.prefixLen = 0
+	if len(fc.Args) == 2 {
+		num, ok := boundValue(fc.Args[1]).(*NumberExpr)
+		if !ok || num.Int < 0 {
+			return keyField{}, false
+		}
+		kf.prefixLen = int(num.Int)
+	}
+	return kf, len(fc.Args) <= 2
+}
+
+/*
+optimizeKeyDecodeExpr calculates the scan range of comparison between the
+decoded key field and constant value, such as:
+
+	key ^= 'u' & decode_uint64_be(key, 1) >= 10
+
+The key field is right after the key prefix 'u', so the keys are in range
+['u' + encode_uint64_be(10), prefixNext('u')]. The offset of key field must
+be the length of prefix, offset 0 requires no prefix. The TiDB key fields
+have the separator before them, e.g. tidb_table(key) = 45 is prefix
+'t' + encode_memcomparable_int(45).
+
+The keys too short to decode the field have null field and the comparison
+is false, so skipping them by the range returns the same result as filtering
+them by full scan.
+*/
+func (o *FilterOptimizer) optimizeKeyDecodeExpr(e *BinaryOpExpr) (*ScanType, bool) {
+	var (
+		op     = e.Op
+		field  = e.Left
+		values = []Expression{boundValue(e.Right)}
+	)
+	switch op {
+	case Eq, Gt, Gte, Lt, Lte:
+		if _, ok := getKeyField(field); !ok {
+			// Swap the operands: VALUE op FIELD
+			field, values[0] = e.Right, boundValue(e.Left)
+			switch op {
+			case Gt:
+				op = Lt
+			case Gte:
+				op = Lte
+			case Lt:
+				op = Gt
+			case Lte:
+				op = Gte
+			}
+		}
+	case Between:
+		list, ok := e.Right.(*ListExpr)
+		if !ok || len(list.List) != 2 {
+			return nil, false
+		}
+		values = []Expression{boundValue(list.List[0]), boundValue(list.List[1])}
+	default:
+		return nil, false
+	}
+
+	kf, ok := getKeyField(field)
+	if !ok || kf.prefixLen > len(o.keyPrefix) {
+		return nil, false
+	}
+	prefix := append(append([]byte{}, o.keyPrefix[:kf.prefixLen]...), kf.sep...)
+	bounds := make([][]byte, len(values))
+	for i, val := range values {
+		encoded, ok := kf.encode(val)
+		if !ok {
+			return nil, false
+		}
+		bounds[i] = append(append([]byte{}, prefix...), encoded...)
+	}
+
+	var start, end []byte
+	switch op {
+	case Eq:
+		return &ScanType{PREFIX, [][]byte{bounds[0]}}, true
+	case Gt, Gte:
+		start, end = bounds[0], prefixNext(prefix)
+	case Lt:
+		start, end = prefix, bounds[0]
+	case Lte:
+		start, end = prefix, prefixNext(bounds[0])
+	case Between:
+		if bytes.Compare(bounds[0], bounds[1]) > 0 {
+			return nil, false
+		}
+		start, end = bounds[0], prefixNext(bounds[1])
+	}
+	if len(start) == 0 {
+		start = nil
+	}
+	if start == nil && end == nil {
+		return &ScanType{FULL, nil}, true
+	}
+	return &ScanType{RANGE, [][]byte{start, end}}, true
+}
@@ -221,3 +221,91 @@ func TestOptimizers(t *testing.T) {
 		assertScanTypeWithID(i, t, st, item.scanTp, item.keys)
 	}
 }
+
+func TestOptimizerKeyDecode(t *testing.T) {
+	tdata := []optTData{
+		optTData{
+			"select * where key ^= 'u' & decode_uint64_be(key, 1) = 10",
+			PREFIX, []string{"u\x00\x00\x00\x00\x00\x00\x00\x0a"},
+		},
+		optTData{
+			"select * where key ^= 'u' & decode_uint64_be(key, 1) > 10",
+			RANGE, []string{"u\x00\x00\x00\x00\x00\x00\x00\x0a", "v"},
+		},
+		optTData{
+			"select * where key ^= 'u' & decode_uint16_be(key, 1) < 10",
+			RANGE, []string{"u", "u\x00\x0a"},
+		},
+		optTData{
+			"select * where key ^= 'u' & decode_uint16_be(key, 1) <= 10",
+			RANGE, []string{"u", "u\x00\x0b"},
+		},
+		optTData{
+			"select * where key ^= 'u' & 10 >= decode_uint16_be(key, 1)",
+			RANGE, []string{"u", "u\x00\x0b"},
+		},
+		optTData{
+			"select * where key ^= 'u' & decode_uint16_be(key, 1) >= 1 & decode_uint16_be(key, 1) <= 2",
+			RANGE, []string{"u\x00\x01", "u\x00\x03"},
+		},
+		optTData{
+			"select * where decode_uint32_be(key) between 1 and 2",
+			RANGE, []string{"\x00\x00\x00\x01", "\x00\x00\x00\x03"},
+		},
+		optTData{
+			"select * where key ^= 'u' & decode_memcomparable_int(key, 1) < 0",
+			RANGE, []string{"u", "u\x80\x00\x00\x00\x00\x00\x00\x00"},
+		},
+		optTData{
+			"select * where key ^= 'i' & decode_memcomparable_bytes(key, 1) = 'abc'",
+			PREFIX, []string{"iabc\x00\x00\x00\x00\x00\xfa"},
+		},
+		// Offset is not the length of prefix
+		optTData{
+			"select * where key ^= 'u' & decode_uint64_be(key, 2) = 10",
+			PREFIX, []string{"u"},
+		},
+		optTData{
+			"select * where decode_uint64_be(key, 1) = 10",
+			FULL, nil,
+		},
+		// Not order preserving or value out of range
+		optTData{
+			"select * where key ^= 'u' & decode_varint(key, 1) = 10",
+			PREFIX, []string{"u"},
+		},
+		optTData{
+			"select * where key ^= 'u' & decode_uint16_be(key, 1) = 65536",
+			PREFIX, []string{"u"},
+		},
+		optTData{
+			"select * where key ^= 'u' | decode_uint64_be(key, 1) = 10",
+			FULL, nil,
+		},
+		// TiDB key fields
+		optTData{
+			"select * where tidb_table(key) = 45",
+			PREFIX, []string{"t\x80\x00\x00\x00\x00\x00\x00\x2d"},
+		},
+		optTData{
+			"select * where tidb_table(key) < 45",
+			RANGE, []string{"t", "t\x80\x00\x00\x00\x00\x00\x00\x2d"},
+		},
+		optTData{
+			"select * where tidb_table(key) = 45 & tidb_handle(key) <= 1",
+			RANGE, []string{"t\x80\x00\x00\x00\x00\x00\x00\x2d_r", "t\x80\x00\x00\x00\x00\x00\x00\x2d_r\x80\x00\x00\x00\x00\x00\x00\x02"},
+		},
+		optTData{
+			"select * where tidb_handle(key) = 1",
+			FULL, nil,
+		},
+	}
+
+	for i, item := range tdata {
+		st, err := optimizeQuery(item.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertScanTypeWithID(i, t, st, item.scanTp, item.keys)
+	}
+}
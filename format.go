@@ -0,0 +1,523 @@
+package kvql
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+/*
+Formatter prints the parsed statement back as canonical kvql query. The
+output uses lower case keywords, single spaces and the minimal parentheses
+required by operator precedence, and parses to an equivalent statement:
+
+	SELECT key,upper(value) AS v WHERE (key^='k') & v!=''  LIMIT 0,10
+
+is formatted as:
+
+	select key, upper(value) as v where key ^= 'k' & v != '' limit 10
+
+In pretty mode each clause is on its own line and the CTE select statements
+are indented.
+*/
+type Formatter struct {
+	// Pretty puts each clause of statement on its own line
+	Pretty bool
+	// Indent is the indent of nested statement in pretty mode, default
+	// is two spaces.
+	Indent string
+}
+
+// FormatStatement formats the statement in one line
+func FormatStatement(stmt Statement) (string, error) {
+	f := &Formatter{}
+	return f.Format(stmt)
+}
+
+// FormatQuery parses the statements in query and formats them, the
+// statements are joined by `;`.
+func FormatQuery(query string) (string, error) {
+	f := &Formatter{}
+	return f.FormatQuery(query)
+}
+
+func (f *Formatter) FormatQuery(query string) (string, error) {
+	stmts := SplitStatements(query)
+	ret := make([]string, len(stmts))
+	sep := "; "
+	if f.Pretty {
+		sep = ";\n"
+	}
+	for i, q := range stmts {
+		if txnStmt := isTxnStatement(q); txnStmt != "" {
+			ret[i] = txnStmt
+			continue
+		}
+		stmt, err := NewParser(q).Parse()
+		if err != nil {
+			return "", err
+		}
+		ret[i], err = f.Format(stmt)
+		if err != nil {
+			return "", err
+		}
+	}
+	return strings.Join(ret, sep), nil
+}
+
+func (f *Formatter) Format(stmt Statement) (string, error) {
+	indent := f.Indent
+	if indent == "" {
+		indent = "  "
+	}
+	w := &formatWriter{pretty: f.Pretty, indent: indent}
+	switch s := stmt.(type) {
+	case *SelectStmt:
+		w.writeSelect(s)
+	case *PutStmt:
+		w.writePut(s)
+	case *RemoveStmt:
+		w.writeRemove(s)
+	case *DeleteStmt:
+		w.writeDelete(s)
+	case *UpdateStmt:
+		w.writeUpdate(s)
+	default:
+		return "", fmt.Errorf("cannot format %s statement", stmt.Name())
+	}
+	return w.String(), nil
+}
+
+type formatWriter struct {
+	strings.Builder
+	pretty bool
+	indent string
+	level  int
+	// cte is the CTE which current select statement reads from, the
+	// expressions resolved from CTE columns are printed as column names.
+	cte *CTEStmt
+}
+
+// clause starts a new clause, it is a new line in pretty mode.
+func (w *formatWriter) clause(keyword string) {
+	if w.Len() > 0 {
+		if w.pretty {
+			w.WriteByte('\n')
+			w.WriteString(strings.Repeat(w.indent, w.level))
+		} else {
+			w.WriteByte(' ')
+		}
+	}
+	w.WriteString(keyword)
+}
+
+func (w *formatWriter) writeSelect(s *SelectStmt) {
+	if s.With != nil {
+		w.writeWith(s.With)
+	}
+	prevCTE := w.cte
+	defer func() {
+		w.cte = prevCTE
+	}()
+	w.cte = nil
+	if s.From != nil {
+		w.cte = s.From.CTE
+	}
+	w.clause("select ")
+	if s.AllFields {
+		w.WriteString("*")
+	} else {
+		w.writeFields(s.Fields, s.FieldNames)
+	}
+	if s.From != nil {
+		w.clause("from ")
+		w.WriteString(formatName(s.From.CTE.Name))
+	}
+	if where := s.whereExpr(); where != nil {
+		w.clause("where ")
+		w.WriteString(w.expr(where))
+	}
+	if s.GroupBy != nil {
+		names := make([]string, len(s.GroupBy.Fields))
+		for i, f := range s.GroupBy.Fields {
+			names[i] = w.fieldName(s, f.Name)
+		}
+		w.clause("group by ")
+		w.WriteString(strings.Join(names, ", "))
+	}
+	if s.Order != nil {
+		orders := make([]string, len(s.Order.Orders))
+		for i, o := range s.Order.Orders {
+			orders[i] = w.fieldName(s, o.Name)
+			if o.Order == DESC {
+				orders[i] += " desc"
+			}
+		}
+		w.clause("order by ")
+		w.WriteString(strings.Join(orders, ", "))
+	}
+	w.writeLimit(s.Limit)
+}
+
+// whereExpr returns the where expression written in statement, the CTE
+// where expression merged by inline CTE is removed.
+func (s *SelectStmt) whereExpr() Expression {
+	if s.Where == nil {
+		return nil
+	}
+	expr := s.Where.Expr
+	if s.From == nil {
+		return expr
+	}
+	if !s.From.CTE.Materialized {
+		if e, ok := expr.(*BinaryOpExpr); ok && e.Op == And && e.Left == s.From.CTE.Select.Where.Expr {
+			expr = e.Right
+		}
+	}
+	if e, ok := expr.(*BoolExpr); ok && e.Bool && e.Pos == s.From.Pos {
+		// From CTE statement without where statement
+		return nil
+	}
+	return expr
+}
+
+func (w *formatWriter) writeWith(with *WithStmt) {
+	w.clause("with ")
+	for i, cte := range with.CTEs {
+		if i > 0 {
+			w.WriteString(", ")
+		}
+		w.WriteString(formatName(cte.Name))
+		w.WriteString(" as (")
+		sub := &formatWriter{pretty: w.pretty, indent: w.indent, level: w.level + 1}
+		sub.writeSelect(cte.Select)
+		if w.pretty {
+			w.WriteByte('\n')
+			w.WriteString(strings.Repeat(w.indent, w.level+1))
+			w.WriteString(sub.String())
+			w.WriteByte('\n')
+			w.WriteString(strings.Repeat(w.indent, w.level))
+		} else {
+			w.WriteString(sub.String())
+		}
+		w.WriteString(")")
+	}
+}
+
+// writeFields writes the field list with `as` alias if the field name is
+// not the default name of field expression.
+func (w *formatWriter) writeFields(fields []Expression, names []string) {
+	for i, field := range fields {
+		if i > 0 {
+			w.WriteString(", ")
+		}
+		text := w.expr(field)
+		w.WriteString(text)
+		if i < len(names) && names[i] != defaultFieldName(text) {
+			w.WriteString(" as ")
+			w.WriteString(formatName(names[i]))
+		}
+	}
+}
+
+// fieldName returns the text that references the field named name in
+// select statement, which is used by order by and group by statements.
+func (w *formatWriter) fieldName(s *SelectStmt, name string) string {
+	switch name {
+	case "KEY", "VALUE":
+		return strings.ToLower(name)
+	}
+	for i, fname := range s.FieldNames {
+		if fname != name || i >= len(s.Fields) {
+			continue
+		}
+		if text := w.expr(s.Fields[i]); defaultFieldName(text) == name {
+			return text
+		}
+		break
+	}
+	return formatName(name)
+}
+
+func (w *formatWriter) writeLimit(limit *LimitStmt) {
+	if limit == nil {
+		return
+	}
+	w.clause("limit ")
+	if limit.Start > 0 {
+		fmt.Fprintf(w, "%d, ", limit.Start)
+	}
+	fmt.Fprintf(w, "%d", limit.Count)
+}
+
+func (w *formatWriter) writeReturning(returning *ReturningStmt) {
+	if returning == nil {
+		return
+	}
+	w.clause("returning ")
+	w.writeFields(returning.Fields, returning.FieldNames)
+}
+
+func (w *formatWriter) writeDryRun(dryRun bool) {
+	if dryRun {
+		w.WriteString("dry run ")
+	}
+}
+
+func (w *formatWriter) writePut(s *PutStmt) {
+	w.WriteString("put ")
+	if s.Select != nil {
+		sub := &formatWriter{pretty: w.pretty, indent: w.indent, level: w.level}
+		sub.writeSelect(s.Select)
+		w.WriteString(sub.String())
+		return
+	}
+	if s.IfNotExists {
+		w.WriteString("if not exists ")
+	}
+	for i, kvp := range s.KVPairs {
+		if i > 0 {
+			w.WriteString(", ")
+		}
+		fmt.Fprintf(w, "(%s, %s)", w.expr(kvp.Key), w.expr(kvp.Value))
+	}
+	if s.OldValue != nil {
+		w.clause("if value = ")
+		w.WriteString(w.expr(s.OldValue))
+	}
+}
+
+func (w *formatWriter) writeRemove(s *RemoveStmt) {
+	w.writeDryRun(s.DryRun)
+	w.WriteString("remove ")
+	for i, key := range s.Keys {
+		if i > 0 {
+			w.WriteString(", ")
+		}
+		w.WriteString(w.expr(key))
+	}
+	w.writeReturning(s.Returning)
+}
+
+func (w *formatWriter) writeDelete(s *DeleteStmt) {
+	w.writeDryRun(s.DryRun)
+	w.WriteString("delete")
+	w.clause("where ")
+	w.WriteString(w.expr(s.Where.Expr))
+	w.writeLimit(s.Limit)
+	w.writeReturning(s.Returning)
+}
+
+func (w *formatWriter) writeUpdate(s *UpdateStmt) {
+	w.writeDryRun(s.DryRun)
+	w.WriteString("update set value = ")
+	w.WriteString(w.expr(s.Value))
+	w.clause("where ")
+	w.WriteString(w.expr(s.Where.Expr))
+	w.writeLimit(s.Limit)
+	w.writeReturning(s.Returning)
+}
+
+// expr formats the expression, the child expression is quoted by
+// parentheses only if its precedence is lower than the parent operator.
+func (w *formatWriter) expr(e Expression) string {
+	if name, ok := w.cteColumnName(e); ok {
+		return name
+	}
+	switch e := e.(type) {
+	case *BinaryOpExpr:
+		prec := exprPrec(e)
+		left := w.operand(e.Left, prec, false)
+		switch list, ok := e.Right.(*ListExpr); {
+		case e.Op == Between && ok && len(list.List) == 2:
+			// Bounds of between are parsed with higher precedence
+			return fmt.Sprintf("%s between %s and %s", left, w.operand(list.List[0], prec, true), w.operand(list.List[1], prec, true))
+		case e.Op == In && ok:
+			return fmt.Sprintf("%s in %s", left, w.expr(list))
+		}
+		return fmt.Sprintf("%s %s %s", left, OperatorToString[e.Op], w.operand(e.Right, prec, true))
+	case *NotExpr:
+		return "!" + w.operand(e.Right, UnaryPrec, false)
+	case *FieldExpr:
+		return strings.ToLower(KVKeywordToString[e.Field])
+	case *StringExpr:
+		return formatString(e.Data)
+	case *NumberExpr:
+		return e.Data
+	case *FloatExpr:
+		return e.Data
+	case *BoolExpr:
+		if e.Bool {
+			return "true"
+		}
+		return "false"
+	case *NameExpr:
+		return formatName(e.Data)
+	case *ParamExpr:
+		return e.Name
+	case *FieldReferenceExpr:
+		return formatName(e.Name.Data)
+	case *CTEColumnExpr:
+		return formatColumnName(e.Name)
+	case *FunctionCallExpr:
+		args := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = w.expr(arg)
+		}
+		return fmt.Sprintf("%s(%s)", w.operand(e.Name, HighestPrec, false), strings.Join(args, ", "))
+	case *FieldAccessExpr:
+		return fmt.Sprintf("%s[%s]", w.operand(e.Left, HighestPrec, false), w.expr(e.FieldName))
+	case *LambdaExpr:
+		if len(e.Params) == 1 {
+			return fmt.Sprintf("%s -> %s", formatName(e.Params[0]), w.expr(e.Body))
+		}
+		params := make([]string, len(e.Params))
+		for i, param := range e.Params {
+			params[i] = formatName(param)
+		}
+		return fmt.Sprintf("(%s) -> %s", strings.Join(params, ", "), w.expr(e.Body))
+	case *LambdaArgExpr:
+		return formatName(e.Name)
+	case *ListExpr:
+		items := make([]string, len(e.List))
+		for i, item := range e.List {
+			items[i] = w.expr(item)
+		}
+		return fmt.Sprintf("(%s)", strings.Join(items, ", "))
+	}
+	return e.String()
+}
+
+// operand formats the child expression of operator with precedence prec,
+// right operand requires parentheses for equal precedence because binary
+// operators are left associative.
+func (w *formatWriter) operand(e Expression, prec int, right bool) string {
+	cprec := exprPrec(e)
+	if _, ok := w.cteColumnName(e); ok {
+		cprec = HighestPrec
+	}
+	if cprec < prec || (right && cprec == prec) {
+		return "(" + w.expr(e) + ")"
+	}
+	return w.expr(e)
+}
+
+// cteColumnName returns the column name if e is the field expression of
+// inline CTE, the expression is copied to outer statement by parser.
+func (w *formatWriter) cteColumnName(e Expression) (string, bool) {
+	if w.cte == nil || w.cte.Materialized || w.cte.Select.AllFields {
+		return "", false
+	}
+	for i, field := range w.cte.Select.Fields {
+		if field == e {
+			return formatColumnName(w.cte.Select.FieldNames[i]), true
+		}
+	}
+	return "", false
+}
+
+func exprPrec(e Expression) int {
+	switch e := e.(type) {
+	case *BinaryOpExpr:
+		tok := &Token{Tp: OPERATOR, Data: OperatorToString[e.Op]}
+		return tok.Precedence()
+	case *NotExpr:
+		return UnaryPrec
+	}
+	return HighestPrec
+}
+
+// defaultFieldName returns the field name of field expression text
+// without `as` alias, it is the same as parser.
+func defaultFieldName(text string) string {
+	p := NewParser(text)
+	p.next()
+	if p.tok == nil {
+		return ""
+	}
+	e, err := p.parseExpr()
+	if err != nil || p.tok != nil {
+		return ""
+	}
+	return e.String()
+}
+
+func formatColumnName(name string) string {
+	switch name {
+	case "KEY", "VALUE":
+		return strings.ToLower(name)
+	}
+	return formatName(name)
+}
+
+// formatName quotes the name by backtick if it will not be lexed as the
+// same name.
+func formatName(name string) string {
+	if isPlainName(name) {
+		if tok := buildToken(name, 0); tok != nil && tok.Tp == NAME && !isParam(name) {
+			return name
+		}
+	}
+	return "`" + name + "`"
+}
+
+func isPlainName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		switch {
+		case c == '_', c >= 'a' && c <= 'z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// formatString quotes the string by single quote, or double quote if the
+// string contains single quote. String contains both quotes or control
+// characters is formatted as escape string, and binary data which is not
+// valid UTF-8 is formatted as hex string.
+func formatString(s string) string {
+	if !utf8.ValidString(s) {
+		return "x'" + hex.EncodeToString([]byte(s)) + "'"
+	}
+	sq := strings.ContainsRune(s, '\'')
+	if !isPrintable(s) || (sq && strings.ContainsRune(s, '"')) {
+		quoted := strconv.Quote(s)
+		quoted = strings.ReplaceAll(quoted[1:len(quoted)-1], `\"`, `"`)
+		return "e'" + strings.ReplaceAll(quoted, "'", `\'`) + "'"
+	}
+	if sq {
+		return `"` + s + `"`
+	}
+	return "'" + s + "'"
+}
+
+// isPrintable returns true if the string only contains graphic characters
+// and whitespaces.
+func isPrintable(s string) bool {
+	for _, r := range s {
+		if !unicode.IsGraphic(r) && r != '\t' && r != '\n' && r != '\r' {
+			return false
+		}
+	}
+	return true
+}
+
+// DisplayString returns s as is if it is printable text, otherwise returns
+// it as string literal which can be used in query, e.g. binary key is
+// returned as x'00ff'. It is used by the printers to render the data
+// safely.
+func DisplayString(s string) string {
+	if utf8.ValidString(s) && isPrintable(s) {
+		return s
+	}
+	return formatString(s)
+}
@@ -0,0 +1,161 @@
+package kvql
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	tcases := []struct {
+		query  string
+		expect string
+	}{
+		{"WHERE key^='k'", "select * where key ^= 'k'"},
+		{"SELECT key,upper(value) AS v WHERE (key^='k') & v!=''  LIMIT 0,10", "select key, upper(value) as v where key ^= 'k' & v != '' limit 10"},
+		{"select * where (key = 'a' | key = 'b') & !(value = 'c') order by key desc limit 2, 3", "select * where (key = 'a' | key = 'b') & !(value = 'c') order by key desc limit 2, 3"},
+		{"select int(value) - (1 - 2) * 3 as v where key between 'a' and 'z' and int(value) in (1, 2.5)", "select int(value) - (1 - 2) * 3 as v where key between 'a' and 'z' and int(value) in (1, 2.5)"},
+		{"select `Value`, json(value)['a'][0] where \"it's\" = value", "select `Value`, json(value)['a'][0] where \"it's\" = value"},
+		{"select key, count(1) as `count` where true group by key order by `count` desc", "select key, count(1) as count where true group by key order by count desc"},
+		{"select substr(key, 0, 2), count(1) where true group by substr(key, 0, 2)", "select substr(key, 0, 2), count(1) where true group by substr(key, 0, 2)"},
+		{"with t as (select key, int(value) as v where key ^= 'k_') select key, v * 2 as v2 from t where v > 10", "with t as (select key, int(value) as v where key ^= 'k_') select key, v * 2 as v2 from t where v > 10"},
+		{"with t as (select key where key ^= 'k') select * from t", "with t as (select key where key ^= 'k') select key from t"},
+		{"with t as (select key, count(1) as c where true group by key) select key from t where c > 1", "with t as (select key, count(1) as c where true group by key) select key from t where c > 1"},
+		{"put if not exists ('k1', 'v1'),('k2','v2')", "put if not exists ('k1', 'v1'), ('k2', 'v2')"},
+		{"put ('k', 'new') if value = 'old'", "put ('k', 'new') if value = 'old'"},
+		{"put select 'v2:' + key, upper(value) where key ^= 'v1:'", "put select 'v2:' + key, upper(value) where key ^= 'v1:'"},
+		{"dry-run remove 'k1','k2' returning key", "dry run remove 'k1', 'k2' returning key"},
+		{"delete where key ^= 'k' limit 10 returning key, value as v", "delete where key ^= 'k' limit 10 returning key, value as v"},
+		{"update set value = upper(value) where key in ($1, :name) returning *", "update set value = upper(value) where key in ($1, :name) returning key, value"},
+		{"begin; put ('k', 'v'); COMMIT;", "begin; put ('k', 'v'); commit"},
+		{`select * where key ^= X'7400FF' | value in (x'6869', e'it\'s "x"', e'\t\x01')`, `select * where key ^= x'7400ff' | value in ('hi', e'it\'s "x"', e'\t\x01')`},
+		{"select list_map(split(value, ','), x->int(x)*2) where list_reduce(list(1, 2), 0, (acc,`y`)->acc+y) > 1", "select list_map(split(value, ','), x -> int(x) * 2) where list_reduce(list(1, 2), 0, (acc, y) -> acc + y) > 1"},
+	}
+	for _, c := range tcases {
+		ret, err := FormatQuery(c.query)
+		if err != nil {
+			t.Fatal(c.query, err)
+		}
+		if ret != c.expect {
+			t.Fatalf("Unexpected format of %s\n got: %s\nwant: %s", c.query, ret, c.expect)
+		}
+	}
+}
+
+func TestFormatPretty(t *testing.T) {
+	f := &Formatter{Pretty: true}
+	query := "with t as (select key, int(value) as v where key ^= 'k_' order by v limit 5) select key, v from t where v > 1 limit 1; update set value = 'v' where key = 'k' returning key"
+	ret, err := f.FormatQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := `with t as (
+  select key, int(value) as v
+  where key ^= 'k_'
+  order by v
+  limit 5
+)
+select key, v
+from t
+where v > 1
+limit 1;
+update set value = 'v'
+where key = 'k'
+returning key`
+	if ret != expect {
+		t.Fatalf("Unexpected format\n%s", ret)
+	}
+}
+
+// corpusQueries returns the string literals in test files which can be
+// parsed as statement.
+func corpusQueries(t *testing.T) []string {
+	files, err := filepath.Glob("*_test.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := []string{}
+	fset := token.NewFileSet()
+	for _, file := range files {
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			lit, ok := n.(*ast.BasicLit)
+			if !ok || lit.Kind != token.STRING {
+				return true
+			}
+			query, err := strconv.Unquote(lit.Value)
+			if err != nil {
+				return true
+			}
+			for _, stmt := range SplitStatements(query) {
+				if isTxnStatement(stmt) != "" {
+					continue
+				}
+				if _, err := NewParser(stmt).Parse(); err == nil {
+					ret = append(ret, stmt)
+				}
+			}
+			return true
+		})
+	}
+	return ret
+}
+
+func explainQuery(query string) (string, bool) {
+	plan, err := NewOptimizer(query).buildPlan(&fuzzQueryStorage{})
+	if err != nil {
+		return "", false
+	}
+	return strings.Join(plan.Explain(), "\n"), true
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	queries := corpusQueries(t)
+	if len(queries) < 100 {
+		t.Fatal("Too few queries in corpus", len(queries))
+	}
+	for _, pretty := range []bool{false, true} {
+		f := &Formatter{Pretty: pretty}
+		for _, query := range queries {
+			stmt, _ := NewParser(query).Parse()
+			formatted, err := f.Format(stmt)
+			if err != nil {
+				t.Fatal(query, err)
+			}
+			stmt, err = NewParser(formatted).Parse()
+			if err != nil {
+				t.Fatalf("Cannot parse formatted query of %s\n%s\n%v", query, formatted, err)
+			}
+			again, err := f.Format(stmt)
+			if err != nil || again != formatted {
+				t.Fatalf("Format is not stable for %s\n%s\n%s", query, formatted, again)
+			}
+			if expect, ok := explainQuery(query); ok {
+				if ret, _ := explainQuery(formatted); ret != expect {
+					t.Fatalf("Formatted query has different plan %s\n%s\n got: %s\nwant: %s", query, formatted, ret, expect)
+				}
+			}
+		}
+	}
+}
+
+func TestDisplayString(t *testing.T) {
+	tcases := map[string]string{
+		"hello 世界\n": "hello 世界\n",
+		"t\x00\x01":  `e't\x00\x01'`,
+		"t\x00\xff":  "x'7400ff'",
+		"\x1b[31m":   `e'\x1b[31m'`,
+	}
+	for s, expect := range tcases {
+		if ret := DisplayString(s); ret != expect {
+			t.Fatalf("Display %q expect %s but got %s", s, expect, ret)
+		}
+	}
+}
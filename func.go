@@ -1,9 +1,12 @@
 package kvql
 
 import (
+	"encoding/json"
 	"fmt"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"time"
 )
 
 var (
@@ -27,10 +30,130 @@ var (
 		"join":       &Function{"join", 2, true, TSTR, funcJoin, funcJoinVec},
 		"strlen":     &Function{"strlen", 1, false, TNUMBER, funcStrlen, funcStrlenVec},
 
+		"replace":        &Function{"replace", 3, false, TSTR, funcReplace, funcReplaceVec},
+		"trim":           &Function{"trim", 1, true, TSTR, funcTrim, funcTrimVec},
+		"ltrim":          &Function{"ltrim", 1, true, TSTR, funcLTrim, funcLTrimVec},
+		"rtrim":          &Function{"rtrim", 1, true, TSTR, funcRTrim, funcRTrimVec},
+		"concat":         &Function{"concat", 1, true, TSTR, funcConcat, funcConcatVec},
+		"starts_with":    &Function{"starts_with", 2, false, TBOOL, funcStartsWith, funcStartsWithVec},
+		"ends_with":      &Function{"ends_with", 2, false, TBOOL, funcEndsWith, funcEndsWithVec},
+		"contains":       &Function{"contains", 2, false, TBOOL, funcContains, funcContainsVec},
+		"index_of":       &Function{"index_of", 2, false, TNUMBER, funcIndexOf, funcIndexOfVec},
+		"repeat":         &Function{"repeat", 2, false, TSTR, funcRepeat, funcRepeatVec},
+		"reverse":        &Function{"reverse", 1, false, TSTR, funcReverse, funcReverseVec},
+		"lpad":           &Function{"lpad", 2, true, TSTR, funcLPad, funcLPadVec},
+		"rpad":           &Function{"rpad", 2, true, TSTR, funcRPad, funcRPadVec},
+		"format":         &Function{"format", 1, true, TSTR, funcFormat, funcFormatVec},
+		"printf":         &Function{"printf", 1, true, TSTR, funcFormat, funcFormatVec},
+		"regexp_extract": &Function{"regexp_extract", 2, true, TSTR, funcRegexpExtract, funcRegexpExtractVec},
+		"regexp_replace": &Function{"regexp_replace", 3, false, TSTR, funcRegexpReplace, funcRegexpReplaceVec},
+
+		"abs":      &Function{"abs", 1, false, TNUMBER, funcAbs, funcAbsVec},
+		"sign":     &Function{"sign", 1, false, TNUMBER, funcSign, funcSignVec},
+		"ceil":     &Function{"ceil", 1, false, TNUMBER, funcCeil, funcCeilVec},
+		"floor":    &Function{"floor", 1, false, TNUMBER, funcFloor, funcFloorVec},
+		"trunc":    &Function{"trunc", 1, false, TNUMBER, funcTrunc, funcTruncVec},
+		"round":    &Function{"round", 1, true, TNUMBER, funcRound, funcRoundVec},
+		"pow":      &Function{"pow", 2, false, TNUMBER, funcPow, funcPowVec},
+		"power":    &Function{"power", 2, false, TNUMBER, funcPow, funcPowVec},
+		"sqrt":     &Function{"sqrt", 1, false, TNUMBER, funcSqrt, funcSqrtVec},
+		"exp":      &Function{"exp", 1, false, TNUMBER, funcExp, funcExpVec},
+		"ln":       &Function{"ln", 1, false, TNUMBER, funcLn, funcLnVec},
+		"log":      &Function{"log", 1, true, TNUMBER, funcLog, funcLogVec},
+		"log2":     &Function{"log2", 1, false, TNUMBER, funcLog2, funcLog2Vec},
+		"log10":    &Function{"log10", 1, false, TNUMBER, funcLog10, funcLog10Vec},
+		"mod":      &Function{"mod", 2, false, TNUMBER, funcMod, funcModVec},
+		"greatest": &Function{"greatest", 1, true, TNUMBER, funcGreatest, funcGreatestVec},
+		"least":    &Function{"least", 1, true, TNUMBER, funcLeast, funcLeastVec},
+		"pi":       &Function{"pi", 0, false, TNUMBER, funcPi, funcPiVec},
+
+		"now":           &Function{"now", 0, false, TTIME, funcNow, funcNowVec},
+		"from_unixtime": &Function{"from_unixtime", 1, true, TTIME, funcFromUnixTime, funcFromUnixTimeVec},
+		"to_unixtime":   &Function{"to_unixtime", 1, true, TNUMBER, funcToUnixTime, funcToUnixTimeVec},
+		"parse_time":    &Function{"parse_time", 1, true, TTIME, funcParseTime, funcParseTimeVec},
+		"format_time":   &Function{"format_time", 1, true, TSTR, funcFormatTime, funcFormatTimeVec},
+		"date_trunc":    &Function{"date_trunc", 2, false, TTIME, funcDateTrunc, funcDateTruncVec},
+		"date_add":      &Function{"date_add", 2, true, TTIME, funcDateAdd, funcDateAddVec},
+		"date_sub":      &Function{"date_sub", 2, true, TTIME, funcDateSub, funcDateSubVec},
+		"year":          &Function{"year", 1, false, TNUMBER, funcYear, funcYearVec},
+		"month":         &Function{"month", 1, false, TNUMBER, funcMonth, funcMonthVec},
+		"day":           &Function{"day", 1, false, TNUMBER, funcDay, funcDayVec},
+		"hour":          &Function{"hour", 1, false, TNUMBER, funcHour, funcHourVec},
+		"minute":        &Function{"minute", 1, false, TNUMBER, funcMinute, funcMinuteVec},
+		"second":        &Function{"second", 1, false, TNUMBER, funcSecond, funcSecondVec},
+
+		"hex":         &Function{"hex", 1, false, TSTR, funcHex, funcHexVec},
+		"unhex":       &Function{"unhex", 1, false, TBYTES, funcUnhex, funcUnhexVec},
+		"to_base64":   &Function{"to_base64", 1, false, TSTR, funcToBase64, funcToBase64Vec},
+		"from_base64": &Function{"from_base64", 1, false, TBYTES, funcFromBase64, funcFromBase64Vec},
+		"to_bytes":    &Function{"to_bytes", 1, false, TBYTES, funcToBytes, funcToBytesVec},
+
+		"decode_uint16_be":           &Function{"decode_uint16_be", 1, true, TNUMBER, funcDecodeUint16BE, funcDecodeUint16BEVec},
+		"decode_uint32_be":           &Function{"decode_uint32_be", 1, true, TNUMBER, funcDecodeUint32BE, funcDecodeUint32BEVec},
+		"decode_uint64_be":           &Function{"decode_uint64_be", 1, true, TNUMBER, funcDecodeUint64BE, funcDecodeUint64BEVec},
+		"decode_int64_be":            &Function{"decode_int64_be", 1, true, TNUMBER, funcDecodeInt64BE, funcDecodeInt64BEVec},
+		"decode_varint":              &Function{"decode_varint", 1, true, TNUMBER, funcDecodeVarint, funcDecodeVarintVec},
+		"decode_uvarint":             &Function{"decode_uvarint", 1, true, TNUMBER, funcDecodeUvarint, funcDecodeUvarintVec},
+		"decode_memcomparable_bytes": &Function{"decode_memcomparable_bytes", 1, true, TBYTES, funcDecodeMemcomparableBytes, funcDecodeMemcomparableBytesVec},
+		"decode_memcomparable_int":   &Function{"decode_memcomparable_int", 1, true, TNUMBER, funcDecodeMemcomparableInt, funcDecodeMemcomparableIntVec},
+		"encode_uint16_be":           &Function{"encode_uint16_be", 1, false, TBYTES, funcEncodeUint16BE, funcEncodeUint16BEVec},
+		"encode_uint32_be":           &Function{"encode_uint32_be", 1, false, TBYTES, funcEncodeUint32BE, funcEncodeUint32BEVec},
+		"encode_uint64_be":           &Function{"encode_uint64_be", 1, false, TBYTES, funcEncodeUint64BE, funcEncodeUint64BEVec},
+		"encode_int64_be":            &Function{"encode_int64_be", 1, false, TBYTES, funcEncodeInt64BE, funcEncodeInt64BEVec},
+		"encode_varint":              &Function{"encode_varint", 1, false, TBYTES, funcEncodeVarint, funcEncodeVarintVec},
+		"encode_uvarint":             &Function{"encode_uvarint", 1, false, TBYTES, funcEncodeUvarint, funcEncodeUvarintVec},
+		"encode_memcomparable_bytes": &Function{"encode_memcomparable_bytes", 1, false, TBYTES, funcEncodeMemcomparableBytes, funcEncodeMemcomparableBytesVec},
+		"encode_memcomparable_int":   &Function{"encode_memcomparable_int", 1, false, TBYTES, funcEncodeMemcomparableInt, funcEncodeMemcomparableIntVec},
+
+		"tidb_table":         &Function{"tidb_table", 1, false, TNUMBER, funcTiDBTable, funcTiDBTableVec},
+		"tidb_index_id":      &Function{"tidb_index_id", 1, false, TNUMBER, funcTiDBIndexID, funcTiDBIndexIDVec},
+		"tidb_handle":        &Function{"tidb_handle", 1, false, TNUMBER, funcTiDBHandle, funcTiDBHandleVec},
+		"tidb_common_handle": &Function{"tidb_common_handle", 1, false, TLIST, funcTiDBCommonHandle, funcTiDBCommonHandleVec},
+		"tidb_index_values":  &Function{"tidb_index_values", 1, false, TLIST, funcTiDBIndexValues, funcTiDBIndexValuesVec},
+		"tidb_is_record":     &Function{"tidb_is_record", 1, false, TBOOL, funcTiDBIsRecord, funcTiDBIsRecordVec},
+		"tidb_is_index":      &Function{"tidb_is_index", 1, false, TBOOL, funcTiDBIsIndex, funcTiDBIsIndexVec},
+		"tidb_row":           &Function{"tidb_row", 1, true, TJSON, funcTiDBRow, funcTiDBRowVec},
+		"tidb_table_prefix":  &Function{"tidb_table_prefix", 1, false, TBYTES, funcTiDBTablePrefix, funcTiDBTablePrefixVec},
+		"tidb_record_prefix": &Function{"tidb_record_prefix", 1, false, TBYTES, funcTiDBRecordPrefix, funcTiDBRecordPrefixVec},
+		"tidb_index_prefix":  &Function{"tidb_index_prefix", 2, false, TBYTES, funcTiDBIndexPrefix, funcTiDBIndexPrefixVec},
+		"tidb_record_key":    &Function{"tidb_record_key", 2, false, TBYTES, funcTiDBRecordKey, funcTiDBRecordKeyVec},
+
+		"json_extract":  &Function{"json_extract", 2, false, TSTR, funcJsonExtract, funcJsonExtractVec},
+		"json_keys":     &Function{"json_keys", 1, true, TLIST, funcJsonKeys, funcJsonKeysVec},
+		"json_length":   &Function{"json_length", 1, true, TNUMBER, funcJsonLength, funcJsonLengthVec},
+		"json_type":     &Function{"json_type", 1, true, TSTR, funcJsonType, funcJsonTypeVec},
+		"json_contains": &Function{"json_contains", 2, true, TBOOL, funcJsonContains, funcJsonContainsVec},
+		"json_set":      &Function{"json_set", 3, true, TJSON, funcJsonSet, funcJsonSetVec},
+		"json_remove":   &Function{"json_remove", 2, true, TJSON, funcJsonRemove, funcJsonRemoveVec},
+		"json_object":   &Function{"json_object", 0, true, TJSON, funcJsonObject, funcJsonObjectVec},
+		"json_array":    &Function{"json_array", 0, true, TLIST, funcJsonArray, funcJsonArrayVec},
+
+		"list_map":      &Function{"list_map", 2, false, TLIST, funcListMap, funcListMapVec},
+		"list_filter":   &Function{"list_filter", 2, false, TLIST, funcListFilter, funcListFilterVec},
+		"list_reduce":   &Function{"list_reduce", 3, false, TUNKNOWN, funcListReduce, funcListReduceVec},
+		"list_any":      &Function{"list_any", 2, false, TBOOL, funcListAny, funcListAnyVec},
+		"list_all":      &Function{"list_all", 2, false, TBOOL, funcListAll, funcListAllVec},
+		"list_contains": &Function{"list_contains", 2, false, TBOOL, funcListContains, funcListContainsVec},
+		"list_slice":    &Function{"list_slice", 2, true, TLIST, funcListSlice, funcListSliceVec},
+		"list_sort":     &Function{"list_sort", 1, true, TLIST, funcListSort, funcListSortVec},
+		"list_distinct": &Function{"list_distinct", 1, false, TLIST, funcListDistinct, funcListDistinctVec},
+		"list_sum":      &Function{"list_sum", 1, false, TNUMBER, funcListSum, funcListSumVec},
+		"list_avg":      &Function{"list_avg", 1, false, TNUMBER, funcListAvg, funcListAvgVec},
+
 		"cosine_distance": &Function{"cosine_distance", 2, false, TNUMBER, funcCosineDistance, funcCosineDistanceVec},
 		"l2_distance":     &Function{"l2_distance", 2, false, TNUMBER, funcL2Distance, funcL2DistanceVec},
 	}
 
+	// lambdaFuncs are the list functions with lambda argument, the value is
+	// the position of lambda argument.
+	lambdaFuncs = map[string]int{
+		"list_map":    1,
+		"list_filter": 1,
+		"list_reduce": 2,
+		"list_any":    1,
+		"list_all":    1,
+	}
+
 	aggrFuncMap = map[string]*AggrFunc{
 		"count":         &AggrFunc{"count", 1, false, TNUMBER, newAggrCountFunc},
 		"sum":           &AggrFunc{"sum", 1, false, TNUMBER, newAggrSumFunc},
@@ -43,6 +166,10 @@ var (
 	}
 )
 
+// funcVersion is increased when function is registered, plan cache uses it
+// to invalidate the cached statements.
+var funcVersion atomic.Uint64
+
 type FunctionBody func(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error)
 type VectorFunctionBody func(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error)
 
@@ -112,11 +239,13 @@ func GetAggrFunctionByName(name string) (*AggrFunc, bool) {
 func AddScalarFunction(f *Function) {
 	fname := strings.ToLower(f.Name)
 	funcMap[fname] = f
+	funcVersion.Add(1)
 }
 
 func AddAggrFunction(f *AggrFunc) {
 	fname := strings.ToLower(f.Name)
 	aggrFuncMap[fname] = f
+	funcVersion.Add(1)
 }
 
 func IsScalarFuncExpr(expr Expression) bool {
@@ -161,6 +290,13 @@ func toString(value any) string {
 			return "true"
 		}
 		return "false"
+	case time.Time:
+		return val.Format(time.RFC3339Nano)
+	case JSON, map[string]any, []any, []string, []int64, []float64:
+		// JSON and list are converted to JSON text, so they can be
+		// written by put and update statements
+		data, _ := json.Marshal(val)
+		return string(data)
 	default:
 		if val == nil {
 			return "<nil>"
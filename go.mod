@@ -3,3 +3,5 @@ module github.com/c4pt0r/kvql
 go 1.21.1
 
 require github.com/beorn7/perks v1.0.1
+
+require google.golang.org/protobuf v1.34.2
@@ -0,0 +1,324 @@
+// Package httpserver serves kvql over HTTP with JSON responses, the
+// Handler can be mounted in any http.ServeMux:
+//
+//	h := httpserver.NewHandler(storage)
+//	mux.Handle("/kvql/", http.StripPrefix("/kvql", h))
+//
+//	$ curl -H 'Content-Type: application/json' -d '{"query": "select key, value where key ^= ?", "args": ["k"]}' localhost:8080/kvql/query
+//
+// The handler serves two endpoints:
+//
+//	/query    executes the statement and streams the result
+//	/explain  returns the plan tree of the statement
+//
+// Query can be sent by POST request with JSON body, the Content-Type must be
+// application/json so a cross site form can not post queries. It can also be
+// sent by GET request with `q` and `format` parameters. GET request is executed in read only mode,
+// write statements are only accepted by POST request. The result is streamed batch by batch as
+// JSON Lines (format `jsonl`, the default) or as a chunked JSON object with
+// rows array (format `json`).
+package httpserver
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/c4pt0r/kvql"
+)
+
+const (
+	FormatJSONLines = "jsonl"
+	FormatJSON      = "json"
+
+	// maxRequestSize limits the request body size
+	maxRequestSize = 1 << 20
+)
+
+// Request is the body of query and explain requests. Args is the array
+// of positional parameters or the object of named parameters.
+type Request struct {
+	Query  string          `json:"query"`
+	Args   json.RawMessage `json:"args,omitempty"`
+	Format string          `json:"format,omitempty"`
+}
+
+type ColumnInfo struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Error is the error in response, Pos is the position in query of syntax,
+// execute and policy errors, and -1 for other errors.
+type Error struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+	Pos     int    `json:"pos"`
+	Rule    string `json:"rule,omitempty"`
+}
+
+type ErrorResponse struct {
+	Error *Error `json:"error"`
+}
+
+// WarningsResponse is the line of warnings generated during execution in
+// JSON Lines result, such as the non-atomic fallback of conditional put.
+type WarningsResponse struct {
+	Warnings []string `json:"warnings"`
+}
+
+type ExplainResponse struct {
+	Columns []ColumnInfo `json:"columns"`
+	Plan    []string     `json:"plan"`
+}
+
+type Handler struct {
+	Storage kvql.Storage
+	// Policy restricts the write statements, nil means no restriction
+	Policy *kvql.ExecutePolicy
+	// PlanCache caches the statements without parameters if it is not nil
+	PlanCache *kvql.PlanCache
+	// ErrorLog logs the errors of writing response if it is not nil
+	ErrorLog func(err error)
+}
+
+func NewHandler(s kvql.Storage) *Handler {
+	return &Handler{
+		Storage: s,
+	}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch strings.TrimSuffix(r.URL.Path, "/") {
+	case "/query", "":
+		h.serveQuery(w, r)
+	case "/explain":
+		h.serveExplain(w, r)
+	default:
+		writeError(w, http.StatusNotFound, &Error{Type: "request", Message: "Not found", Pos: -1})
+	}
+}
+
+func (h *Handler) serveQuery(w http.ResponseWriter, r *http.Request) {
+	req, status, herr := readRequest(r)
+	if herr != nil {
+		writeError(w, status, herr)
+		return
+	}
+	policy := h.Policy
+	if r.Method == http.MethodGet {
+		policy = readOnlyPolicy(policy)
+	}
+	plan, err := h.buildPlan(req, policy)
+	if err != nil {
+		writeQueryError(w, req.Query, err)
+		return
+	}
+	var rw resultWriter
+	switch req.Format {
+	case FormatJSONLines, "":
+		rw = &jsonLinesWriter{}
+	case FormatJSON:
+		rw = &jsonWriter{}
+	default:
+		writeError(w, http.StatusBadRequest, &Error{Type: "request", Message: fmt.Sprintf("Unknown format %s", req.Format), Pos: -1})
+		return
+	}
+	h.logError(h.streamResult(w, r, req.Query, plan, rw))
+}
+
+func (h *Handler) serveExplain(w http.ResponseWriter, r *http.Request) {
+	req, status, herr := readRequest(r)
+	if herr != nil {
+		writeError(w, status, herr)
+		return
+	}
+	plan, err := h.buildPlan(req, h.Policy)
+	if err != nil {
+		writeQueryError(w, req.Query, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, &ExplainResponse{
+		Columns: columnInfos(plan),
+		Plan:    plan.Explain(),
+	})
+}
+
+func (h *Handler) logError(err error) {
+	if h.ErrorLog != nil && err != nil {
+		h.ErrorLog(err)
+	}
+}
+
+func readRequest(r *http.Request) (*Request, int, *Error) {
+	req := &Request{}
+	switch r.Method {
+	case http.MethodGet:
+		q := r.URL.Query()
+		req.Query = q.Get("q")
+		req.Format = q.Get("format")
+	case http.MethodPost:
+		// Browsers can post forms cross site without preflight, but not JSON
+		if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+			return nil, http.StatusUnsupportedMediaType, &Error{Type: "request", Message: "Content-Type must be application/json", Pos: -1}
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize+1))
+		if err != nil {
+			return nil, http.StatusBadRequest, &Error{Type: "request", Message: err.Error(), Pos: -1}
+		}
+		if len(body) > maxRequestSize {
+			return nil, http.StatusBadRequest, &Error{Type: "request", Message: "Request body too large", Pos: -1}
+		}
+		if err = json.Unmarshal(body, req); err != nil {
+			return nil, http.StatusBadRequest, &Error{Type: "request", Message: "Invalid request body: " + err.Error(), Pos: -1}
+		}
+	default:
+		return nil, http.StatusBadRequest, &Error{Type: "request", Message: fmt.Sprintf("Method %s not allowed", r.Method), Pos: -1}
+	}
+	if strings.TrimSpace(req.Query) == "" {
+		return nil, http.StatusBadRequest, &Error{Type: "request", Message: "Query is empty", Pos: -1}
+	}
+	return req, http.StatusOK, nil
+}
+
+// readOnlyPolicy returns the copy of policy in read only mode
+func readOnlyPolicy(policy *kvql.ExecutePolicy) *kvql.ExecutePolicy {
+	ret := kvql.ExecutePolicy{}
+	if policy != nil {
+		ret = *policy
+	}
+	ret.ReadOnly = true
+	return &ret
+}
+
+func (h *Handler) buildPlan(req *Request, policy *kvql.ExecutePolicy) (kvql.FinalPlan, error) {
+	args, err := parseArgs(req.Args)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 0 && h.PlanCache != nil {
+		return h.PlanCache.BuildPlan(req.Query, h.Storage, policy)
+	}
+	stmt, err := kvql.Prepare(req.Query)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) > 0 || stmt.NumParams() > 0 {
+		if err = stmt.Bind(args...); err != nil {
+			var serr *kvql.SyntaxError
+			if errors.As(err, &serr) {
+				return nil, err
+			}
+			// Arguments do not match the parameters
+			return nil, &Error{Type: "request", Message: err.Error(), Pos: -1}
+		}
+	}
+	return stmt.BuildPlan(h.Storage, policy)
+}
+
+// parseArgs parses the parameters, integer numbers are bound as integer
+// and other numbers as float.
+func parseArgs(data json.RawMessage) ([]any, error) {
+	if len(bytes.TrimSpace(data)) == 0 || string(data) == "null" {
+		return nil, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var args any
+	if err := dec.Decode(&args); err != nil {
+		return nil, &Error{Type: "request", Message: "Invalid args: " + err.Error(), Pos: -1}
+	}
+	var ret []any
+	switch v := args.(type) {
+	case []any:
+		for _, arg := range v {
+			ret = append(ret, argValue(arg))
+		}
+	case map[string]any:
+		for name, arg := range v {
+			ret = append(ret, kvql.Named(name, argValue(arg)))
+		}
+	default:
+		return nil, &Error{Type: "request", Message: "Args should be array or object", Pos: -1}
+	}
+	return ret, nil
+}
+
+func argValue(arg any) any {
+	n, ok := arg.(json.Number)
+	if !ok {
+		return arg
+	}
+	if i, err := n.Int64(); err == nil {
+		return i
+	}
+	if f, err := n.Float64(); err == nil {
+		return f
+	}
+	return n.String()
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// toError converts kvql errors to response error and HTTP status, the
+// position -1 which means end of query is converted to query length.
+func toError(err error, query string) (int, *Error) {
+	var (
+		herr *Error
+		serr *kvql.SyntaxError
+		eerr *kvql.ExecuteError
+		perr *kvql.PolicyError
+	)
+	switch {
+	case errors.As(err, &herr):
+		return http.StatusBadRequest, herr
+	case errors.As(err, &serr):
+		return http.StatusBadRequest, &Error{Type: "syntax", Message: serr.Message, Pos: queryPos(query, serr.Pos)}
+	case errors.As(err, &eerr):
+		return http.StatusBadRequest, &Error{Type: "execute", Message: eerr.Message, Pos: queryPos(query, eerr.Pos)}
+	case errors.As(err, &perr):
+		return http.StatusForbidden, &Error{Type: "policy", Message: perr.Message, Pos: queryPos(query, perr.Pos), Rule: perr.Rule.String()}
+	}
+	return http.StatusInternalServerError, &Error{Type: "internal", Message: err.Error(), Pos: -1}
+}
+
+func queryPos(query string, pos int) int {
+	if pos < 0 || pos > len(query) {
+		return len(query)
+	}
+	return pos
+}
+
+func writeQueryError(w http.ResponseWriter, query string, err error) {
+	status, herr := toError(err, query)
+	writeError(w, status, herr)
+}
+
+func writeError(w http.ResponseWriter, status int, err *Error) {
+	writeJSON(w, status, &ErrorResponse{Error: err})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func columnInfos(plan kvql.FinalPlan) []ColumnInfo {
+	var (
+		names = plan.FieldNameList()
+		types = plan.FieldTypeList()
+		ret   = make([]ColumnInfo, len(names))
+	)
+	for i, name := range names {
+		ret[i] = ColumnInfo{Name: name, Type: kvql.TypeToString[types[i]]}
+	}
+	return ret
+}
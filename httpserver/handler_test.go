@@ -0,0 +1,217 @@
+package httpserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/kvql"
+	"github.com/c4pt0r/kvql/memstore"
+)
+
+func newTestServer(t *testing.T, setup func(h *Handler)) *httptest.Server {
+	s := memstore.New()
+	for i := 0; i < 10; i++ {
+		s.Put([]byte(fmt.Sprintf("k_%02d", i)), []byte(fmt.Sprintf(`{"id": %d}`, i)))
+	}
+	h := NewHandler(s)
+	if setup != nil {
+		setup(h)
+	}
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func post(t *testing.T, srv *httptest.Server, path string, body string) (int, string) {
+	resp, err := http.Post(srv.URL+path, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(data)
+}
+
+func TestQueryJSONLines(t *testing.T) {
+	srv := newTestServer(t, nil)
+	status, body := post(t, srv, "/query", `{"query": "select key, int(json(value)['id']) * 2 as v, json(value) where key ^= ? & int(json(value)['id']) >= ?", "args": ["k_0", 8]}`)
+	if status != http.StatusOK {
+		t.Fatal("Unexpected status", status, body)
+	}
+	expect := `{"columns":[{"name":"KEY","type":"STR"},{"name":"v","type":"NUMBER"},{"name":"json(VALUE)","type":"JSON"}]}
+["k_08",16,{"id":8}]
+["k_09",18,{"id":9}]
+`
+	if body != expect {
+		t.Fatal("Unexpected body", body)
+	}
+
+	status, body = post(t, srv, "/query", `{"query": "select count(1) where key between :start and :end", "args": {"start": "k_02", "end": "k_05"}}`)
+	if status != http.StatusOK || !strings.HasSuffix(body, "\n[4]\n") {
+		t.Fatal("Unexpected named args result", status, body)
+	}
+}
+
+func TestQueryJSON(t *testing.T) {
+	srv := newTestServer(t, nil)
+	q := url.Values{}
+	q.Set("q", "select key where key ^= 'k_0' limit 3")
+	q.Set("format", "json")
+	resp, err := http.Get(srv.URL + "/query?" + q.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var ret struct {
+		Columns []ColumnInfo `json:"columns"`
+		Rows    [][]any      `json:"rows"`
+		Error   *Error       `json:"error"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+		t.Fatal(err)
+	}
+	if len(ret.Columns) != 1 || ret.Columns[0].Name != "KEY" || len(ret.Rows) != 3 || ret.Rows[2][0] != "k_02" || ret.Error != nil {
+		t.Fatal("Unexpected result", ret)
+	}
+
+	status, body := post(t, srv, "/query", `{"query": "put ('a', '1'), ('b', '2')", "format": "json"}`)
+	if status != http.StatusOK || body != `{"columns":[{"name":"Rows","type":"NUMBER"}],"rows":[[2]]}`+"\n" {
+		t.Fatal("Unexpected put result", status, body)
+	}
+
+	// GET request can not write
+	for _, query := range []string{"put ('c', '3')", "delete where key ^= 'k_0'", "update set value = 'x' where key = 'a'", "remove 'a'"} {
+		q.Set("q", query)
+		resp, err := http.Get(srv.URL + "/query?" + q.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusForbidden || !strings.Contains(string(data), `"rule":"read only"`) {
+			t.Fatal("Unexpected GET write", query, resp.StatusCode, string(data))
+		}
+	}
+	status, body = post(t, srv, "/query", `{"query": "select count(1) where key >= ''"}`)
+	if status != http.StatusOK || !strings.HasSuffix(body, "\n[12]\n") {
+		t.Fatal("Unexpected count after GET writes", status, body)
+	}
+}
+
+func TestQueryError(t *testing.T) {
+	srv := newTestServer(t, func(h *Handler) {
+		h.Policy = &kvql.ExecutePolicy{ReadOnly: true}
+		h.PlanCache = kvql.NewPlanCache(16)
+		for i := 0; i < 2*kvql.PlanBatchSize; i++ {
+			h.Storage.Put([]byte(fmt.Sprintf("m_%02d", i)), []byte(fmt.Sprintf(`{"id": %d}`, i)))
+		}
+	})
+	status, body := post(t, srv, "/query", `{"query": "select * where key ^="}`)
+	if status != http.StatusBadRequest || body != `{"error":{"type":"syntax","message":"Unexpected EOF","pos":21}}`+"\n" {
+		t.Fatal("Unexpected syntax error", status, body)
+	}
+	status, body = post(t, srv, "/query", `{"query": "put ('a', '1')"}`)
+	if status != http.StatusForbidden || !strings.Contains(body, `"rule":"read only"`) {
+		t.Fatal("Unexpected policy error", status, body)
+	}
+	status, body = post(t, srv, "/query", `{"query": "select key where key ^= ?", "args": [1, 2]}`)
+	if status != http.StatusBadRequest || !strings.Contains(body, "Too many arguments") {
+		t.Fatal("Unexpected bind error", status, body)
+	}
+	status, body = post(t, srv, "/query", `{"query": ""}`)
+	if status != http.StatusBadRequest || !strings.Contains(body, `"type":"request"`) {
+		t.Fatal("Unexpected empty query error", status, body)
+	}
+	// Posted form and text are rejected, they can be sent cross site
+	for _, ct := range []string{"text/plain", "application/x-www-form-urlencoded", ""} {
+		resp, err := http.Post(srv.URL+"/query", ct, strings.NewReader(`{"query": "select key"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnsupportedMediaType {
+			t.Fatal("Unexpected content type status", ct, resp.StatusCode)
+		}
+	}
+	resp, err := http.Post(srv.URL+"/query", "application/json; charset=utf-8", strings.NewReader(`{"query": "select key where key = 'k_01'"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatal("Unexpected status with charset", resp.StatusCode)
+	}
+
+	// Error after the first batch is written after rows
+	status, body = post(t, srv, "/query", `{"query": "select key, 10 / (40 - int(json(value)['id'])) where key ^= 'm_'", "format": "json"}`)
+	var ret struct {
+		Rows  [][]any `json:"rows"`
+		Error *Error  `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(body), &ret); err != nil {
+		t.Fatal(err, body)
+	}
+	if status != http.StatusOK || len(ret.Rows) != kvql.PlanBatchSize || ret.Error == nil || ret.Error.Type != "execute" || ret.Error.Pos < 0 {
+		t.Fatal("Unexpected execute error", status, body)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	srv := newTestServer(t, nil)
+	status, body := post(t, srv, "/explain", `{"query": "select key where key ^= ? limit 2", "args": ["k_0"]}`)
+	var ret ExplainResponse
+	if err := json.Unmarshal([]byte(body), &ret); err != nil {
+		t.Fatal(err, body)
+	}
+	if status != http.StatusOK || len(ret.Plan) == 0 || !strings.Contains(strings.Join(ret.Plan, "\n"), "PrefixScanPlan") {
+		t.Fatal("Unexpected explain", status, body)
+	}
+	// Explain does not execute write statements
+	post(t, srv, "/explain", `{"query": "delete where key ^= 'k_0'"}`)
+	status, body = post(t, srv, "/query", `{"query": "select count(1) where key ^= 'k_0'"}`)
+	if status != http.StatusOK || !strings.HasSuffix(body, "\n[10]\n") {
+		t.Fatal("Unexpected count after explain", status, body)
+	}
+}
+
+// nonAtomicStorage hides the atomic writes of memstore
+type nonAtomicStorage struct {
+	kvql.Storage
+}
+
+func TestQueryWarnings(t *testing.T) {
+	srv := newTestServer(t, func(h *Handler) {
+		h.Storage = nonAtomicStorage{h.Storage}
+	})
+	status, body := post(t, srv, "/query", `{"query": "put if not exists ('a', '1')"}`)
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	var ret WarningsResponse
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &ret); err != nil {
+		t.Fatal(err, body)
+	}
+	if status != http.StatusOK || len(ret.Warnings) != 1 || !strings.Contains(ret.Warnings[0], "not atomic") {
+		t.Fatal("Unexpected warnings", status, body)
+	}
+
+	status, body = post(t, srv, "/query", `{"query": "put if not exists ('b', '1')", "format": "json"}`)
+	if err := json.Unmarshal([]byte(body), &ret); err != nil {
+		t.Fatal(err, body)
+	}
+	if status != http.StatusOK || len(ret.Warnings) != 1 {
+		t.Fatal("Unexpected warnings", status, body)
+	}
+
+	status, body = post(t, srv, "/query", `{"query": "select count(1) where key ^= 'k_'"}`)
+	if status != http.StatusOK || strings.Contains(body, "warnings") {
+		t.Fatal("Unexpected warnings of select", status, body)
+	}
+}
@@ -0,0 +1,206 @@
+package httpserver
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"unicode/utf8"
+
+	"github.com/c4pt0r/kvql"
+)
+
+// resultWriter writes the result set in response format, the error in the
+// middle of result and the warnings of execution are written after the rows.
+type resultWriter interface {
+	contentType() string
+	writeColumns(w *bufio.Writer, cols []ColumnInfo) error
+	writeRow(w *bufio.Writer, row []byte) error
+	writeEnd(w *bufio.Writer, err *Error, warnings []string) error
+}
+
+// streamResult writes the rows of plan batch by batch and flushes response
+// after each batch. The first batch is read before writing header, so the
+// error of it is responded with error status.
+func (h *Handler) streamResult(w http.ResponseWriter, r *http.Request, query string, plan kvql.FinalPlan, rw resultWriter) error {
+	ctx := kvql.NewExecuteCtx()
+	rows, err := plan.Batch(ctx)
+	if err != nil {
+		writeQueryError(w, query, err)
+		return nil
+	}
+	w.Header().Set("Content-Type", rw.contentType())
+	w.WriteHeader(http.StatusOK)
+	var (
+		bw         = bufio.NewWriter(w)
+		flusher, _ = w.(http.Flusher)
+		buf        []byte
+	)
+	if err = rw.writeColumns(bw, columnInfos(plan)); err != nil {
+		return err
+	}
+	for len(rows) > 0 {
+		for _, row := range rows {
+			buf, err = appendRow(buf[:0], row)
+			if err != nil {
+				return abortResult(bw, rw, query, err, ctx.Warnings)
+			}
+			if err = rw.writeRow(bw, buf); err != nil {
+				return err
+			}
+		}
+		if err = bw.Flush(); err != nil {
+			return err
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		if err = r.Context().Err(); err != nil {
+			return err
+		}
+		ctx.Clear()
+		rows, err = plan.Batch(ctx)
+		if err != nil {
+			return abortResult(bw, rw, query, err, ctx.Warnings)
+		}
+	}
+	if err = rw.writeEnd(bw, nil, ctx.Warnings); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// abortResult ends the result with the error
+func abortResult(w *bufio.Writer, rw resultWriter, query string, err error, warnings []string) error {
+	_, herr := toError(err, query)
+	if err = rw.writeEnd(w, herr, warnings); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// appendRow appends the row as JSON array, bytes are encoded as string
+// and binary data which is not valid UTF-8 is encoded as hex string
+// literal such as x'00ff'.
+func appendRow(buf []byte, row []kvql.Column) ([]byte, error) {
+	buf = append(buf, '[')
+	for i, col := range row {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		if b, ok := col.([]byte); ok {
+			col = string(b)
+		}
+		if s, ok := col.(string); ok && !utf8.ValidString(s) {
+			col = kvql.DisplayString(s)
+		}
+		data, err := json.Marshal(col)
+		if err != nil {
+			return buf, err
+		}
+		buf = append(buf, data...)
+	}
+	return append(buf, ']'), nil
+}
+
+// jsonLinesWriter writes columns object, row arrays, the warnings object and
+// the error object in separate lines:
+//
+//	{"columns":[{"name":"KEY","type":"STR"},{"name":"VALUE","type":"STR"}]}
+//	["k1","v1"]
+//	["k2","v2"]
+//	{"warnings":["..."]}
+type jsonLinesWriter struct{}
+
+func (jw *jsonLinesWriter) contentType() string {
+	return "application/x-ndjson"
+}
+
+func (jw *jsonLinesWriter) writeColumns(w *bufio.Writer, cols []ColumnInfo) error {
+	data, err := json.Marshal(struct {
+		Columns []ColumnInfo `json:"columns"`
+	}{cols})
+	if err != nil {
+		return err
+	}
+	w.Write(data)
+	return w.WriteByte('\n')
+}
+
+func (jw *jsonLinesWriter) writeRow(w *bufio.Writer, row []byte) error {
+	w.Write(row)
+	return w.WriteByte('\n')
+}
+
+func (jw *jsonLinesWriter) writeEnd(w *bufio.Writer, herr *Error, warnings []string) error {
+	if len(warnings) > 0 {
+		data, err := json.Marshal(&WarningsResponse{Warnings: warnings})
+		if err != nil {
+			return err
+		}
+		w.Write(data)
+		w.WriteByte('\n')
+	}
+	if herr == nil {
+		return nil
+	}
+	data, err := json.Marshal(&ErrorResponse{Error: herr})
+	if err != nil {
+		return err
+	}
+	w.Write(data)
+	return w.WriteByte('\n')
+}
+
+// jsonWriter writes one JSON object, the rows array is written in chunks
+// and followed by the warnings and the error if there are:
+//
+//	{"columns":[{"name":"KEY","type":"STR"}],"rows":[["k1"],["k2"]],"warnings":["..."]}
+type jsonWriter struct {
+	rows int
+}
+
+func (jw *jsonWriter) contentType() string {
+	return "application/json"
+}
+
+func (jw *jsonWriter) writeColumns(w *bufio.Writer, cols []ColumnInfo) error {
+	data, err := json.Marshal(cols)
+	if err != nil {
+		return err
+	}
+	w.WriteString(`{"columns":`)
+	w.Write(data)
+	_, err = w.WriteString(`,"rows":[`)
+	return err
+}
+
+func (jw *jsonWriter) writeRow(w *bufio.Writer, row []byte) error {
+	if jw.rows > 0 {
+		w.WriteByte(',')
+	}
+	jw.rows++
+	_, err := w.Write(row)
+	return err
+}
+
+func (jw *jsonWriter) writeEnd(w *bufio.Writer, herr *Error, warnings []string) error {
+	w.WriteByte(']')
+	if len(warnings) > 0 {
+		data, err := json.Marshal(warnings)
+		if err != nil {
+			return err
+		}
+		w.WriteString(`,"warnings":`)
+		w.Write(data)
+	}
+	if herr != nil {
+		data, err := json.Marshal(herr)
+		if err != nil {
+			return err
+		}
+		w.WriteString(`,"error":`)
+		w.Write(data)
+	}
+	_, err := w.WriteString("}\n")
+	return err
+}
@@ -0,0 +1,569 @@
+package kvql
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+/*
+JSON path used by json_* functions:
+
+	$                 the root value
+	.name, ."name"    object member, quoted name can contain any character
+	['name']          object member
+	[1], [-1]         array element, negative index counts from the end
+	.*, [*]           all members of object or all elements of array
+	..name, ..[*]     recursive descent, applies the leg to the value and
+	                  all of its descendants
+
+The path with wildcard or recursive descent may match multiple values and
+json_extract returns the list of matched values.
+*/
+
+type jsonPathLeg struct {
+	recursive bool
+	wildcard  bool
+	isIndex   bool
+	key       string
+	index     int
+}
+
+type jsonPath struct {
+	legs []jsonPathLeg
+	// definite is true if the path matches at most one value
+	definite bool
+}
+
+func parseJSONPath(path string) (*jsonPath, error) {
+	path = strings.TrimSpace(path)
+	if !strings.HasPrefix(path, "$") {
+		return nil, errors.New("path should start with $")
+	}
+	ret := &jsonPath{definite: true}
+	pos := 1
+	for pos < len(path) {
+		leg := jsonPathLeg{}
+		if strings.HasPrefix(path[pos:], "..") {
+			leg.recursive = true
+			pos++
+			if pos+1 < len(path) && path[pos+1] == '[' {
+				pos++
+			}
+		}
+		var err error
+		switch path[pos] {
+		case '.':
+			pos, err = parseJSONPathMember(path, pos+1, &leg)
+		case '[':
+			pos, err = parseJSONPathIndex(path, pos+1, &leg)
+		default:
+			err = fmt.Errorf("unexpected character %q at %d", path[pos], pos)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if leg.recursive || leg.wildcard {
+			ret.definite = false
+		}
+		ret.legs = append(ret.legs, leg)
+	}
+	return ret, nil
+}
+
+func parseJSONPathMember(path string, pos int, leg *jsonPathLeg) (int, error) {
+	if pos >= len(path) {
+		return pos, errors.New("missing member name at end of path")
+	}
+	switch path[pos] {
+	case '*':
+		leg.wildcard = true
+		return pos + 1, nil
+	case '"':
+		return parseJSONPathQuoted(path, pos, leg)
+	}
+	end := pos
+	for end < len(path) {
+		c := rune(path[end])
+		if c == '.' || c == '[' || c == ']' || c == '"' || c == '\'' || unicode.IsSpace(c) {
+			break
+		}
+		end++
+	}
+	if end == pos {
+		return pos, fmt.Errorf("missing member name at %d", pos)
+	}
+	leg.key = path[pos:end]
+	return end, nil
+}
+
+func parseJSONPathQuoted(path string, pos int, leg *jsonPathLeg) (int, error) {
+	quote := path[pos]
+	for end := pos + 1; end < len(path); end++ {
+		switch path[end] {
+		case '\\':
+			end++
+		case quote:
+			lit := path[pos+1 : end]
+			if quote == '\'' {
+				lit = strings.ReplaceAll(lit, "\\'", "'")
+				lit = strings.ReplaceAll(lit, "\"", "\\\"")
+			}
+			key, err := strconv.Unquote("\"" + lit + "\"")
+			if err != nil {
+				return pos, fmt.Errorf("invalid quoted member name at %d", pos)
+			}
+			leg.key = key
+			return end + 1, nil
+		}
+	}
+	return pos, fmt.Errorf("unterminated quoted member name at %d", pos)
+}
+
+func parseJSONPathIndex(path string, pos int, leg *jsonPathLeg) (int, error) {
+	end := strings.IndexByte(path[pos:], ']')
+	if pos < len(path) && (path[pos] == '"' || path[pos] == '\'') {
+		next, err := parseJSONPathQuoted(path, pos, leg)
+		if err != nil {
+			return pos, err
+		}
+		if next >= len(path) || path[next] != ']' {
+			return pos, fmt.Errorf("missing ] at %d", next)
+		}
+		return next + 1, nil
+	}
+	if end < 0 {
+		return pos, fmt.Errorf("missing ] at %d", pos)
+	}
+	leg.isIndex = true
+	idx := strings.TrimSpace(path[pos : pos+end])
+	if idx == "*" {
+		leg.wildcard = true
+		return pos + end + 1, nil
+	}
+	n, err := strconv.Atoi(idx)
+	if err != nil {
+		return pos, fmt.Errorf("invalid array index %q at %d", idx, pos)
+	}
+	leg.index = n
+	return pos + end + 1, nil
+}
+
+// arrayIndex returns the element index of array with n elements, negative
+// index counts from the end.
+func (l jsonPathLeg) arrayIndex(n int) (int, bool) {
+	idx := l.index
+	if idx < 0 {
+		idx += n
+	}
+	return idx, idx >= 0 && idx < n
+}
+
+// selectChildren appends the values selected by the leg from val
+func (l jsonPathLeg) selectChildren(ret []any, val any) []any {
+	switch v := val.(type) {
+	case map[string]any:
+		if l.isIndex && l.wildcard {
+			return ret
+		}
+		if l.wildcard {
+			for _, k := range sortedKeys(v) {
+				ret = append(ret, v[k])
+			}
+		} else if !l.isIndex {
+			if child, have := v[l.key]; have {
+				ret = append(ret, child)
+			}
+		}
+	case []any:
+		if l.wildcard && l.isIndex {
+			ret = append(ret, v...)
+		} else if l.isIndex {
+			if idx, ok := l.arrayIndex(len(v)); ok {
+				ret = append(ret, v[idx])
+			}
+		}
+	}
+	return ret
+}
+
+// descendants appends val and all the values nested in it
+func descendants(ret []any, val any) []any {
+	ret = append(ret, val)
+	switch v := val.(type) {
+	case map[string]any:
+		for _, k := range sortedKeys(v) {
+			ret = descendants(ret, v[k])
+		}
+	case []any:
+		for _, item := range v {
+			ret = descendants(ret, item)
+		}
+	}
+	return ret
+}
+
+// eval returns all the values matched by path in document order
+func (p *jsonPath) eval(doc any) []any {
+	current := []any{doc}
+	for _, leg := range p.legs {
+		var next []any
+		for _, val := range current {
+			if leg.recursive {
+				for _, d := range descendants(nil, val) {
+					next = leg.selectChildren(next, d)
+				}
+			} else {
+				next = leg.selectChildren(next, val)
+			}
+		}
+		if len(next) == 0 {
+			return nil
+		}
+		current = next
+	}
+	return current
+}
+
+// set replaces or inserts the value at the definite path, the parent of
+// the path should exist. Index larger than array length appends the value.
+// The document is modified in place and the new root is returned.
+func (p *jsonPath) set(doc any, val any) any {
+	if len(p.legs) == 0 {
+		return val
+	}
+	parent := (&jsonPath{legs: p.legs[:len(p.legs)-1]}).eval(doc)
+	if len(parent) == 0 {
+		return doc
+	}
+	leg := p.legs[len(p.legs)-1]
+	switch v := parent[0].(type) {
+	case map[string]any:
+		if !leg.isIndex {
+			v[leg.key] = val
+		}
+	case []any:
+		if !leg.isIndex {
+			break
+		}
+		if idx, ok := leg.arrayIndex(len(v)); ok {
+			v[idx] = val
+		} else if leg.index >= len(v) {
+			return (&jsonPath{legs: p.legs[:len(p.legs)-1]}).set(doc, append(v, val))
+		}
+	}
+	return doc
+}
+
+// remove removes the value at the definite path, the document is modified
+// in place and the new root is returned.
+func (p *jsonPath) remove(doc any) any {
+	parentPath := &jsonPath{legs: p.legs[:len(p.legs)-1]}
+	parent := parentPath.eval(doc)
+	if len(parent) == 0 {
+		return doc
+	}
+	leg := p.legs[len(p.legs)-1]
+	switch v := parent[0].(type) {
+	case map[string]any:
+		if !leg.isIndex {
+			delete(v, leg.key)
+		}
+	case []any:
+		if idx, ok := leg.arrayIndex(len(v)); ok && leg.isIndex {
+			items := make([]any, 0, len(v)-1)
+			items = append(items, v[:idx]...)
+			items = append(items, v[idx+1:]...)
+			return parentPath.set(doc, items)
+		}
+	}
+	return doc
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// parseJSONDocument parses the JSON text, the other values are normalized
+// by jsonNormalize.
+func parseJSONDocument(val any) (any, error) {
+	data, ok := convertToByteArray(val)
+	if !ok {
+		return jsonNormalize(val), nil
+	}
+	var ret any
+	if err := json.Unmarshal(data, &ret); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// jsonNormalize converts the value into the types used by JSON document,
+// []byte is converted to string, JSON to map[string]any and the lists to
+// []any.
+func jsonNormalize(val any) any {
+	switch v := val.(type) {
+	case []byte:
+		return string(v)
+	case JSON:
+		return map[string]any(v)
+	case float32:
+		return float64(v)
+	case []string:
+		ret := make([]any, len(v))
+		for i, item := range v {
+			ret[i] = item
+		}
+		return ret
+	case []float64, []int64, []int:
+		ret, _ := unpackArray(v)
+		return ret
+	}
+	return val
+}
+
+// jsonCopy returns the deep copy of JSON document
+func jsonCopy(val any) any {
+	switch v := val.(type) {
+	case map[string]any:
+		ret := make(map[string]any, len(v))
+		for k, item := range v {
+			ret[k] = jsonCopy(item)
+		}
+		return ret
+	case []any:
+		ret := make([]any, len(v))
+		for i, item := range v {
+			ret[i] = jsonCopy(item)
+		}
+		return ret
+	}
+	return val
+}
+
+// jsonTypeName returns the type name of JSON value, the number is INTEGER
+// if it has no fraction part.
+func jsonTypeName(val any) string {
+	switch v := jsonNormalize(val).(type) {
+	case nil:
+		return "NULL"
+	case bool:
+		return "BOOLEAN"
+	case string:
+		return "STRING"
+	case map[string]any:
+		return "OBJECT"
+	case []any:
+		return "ARRAY"
+	case time.Time:
+		return "DATETIME"
+	case float64:
+		if v == float64(int64(v)) {
+			return "INTEGER"
+		}
+		return "DOUBLE"
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return "INTEGER"
+	}
+	return "UNKNOWN"
+}
+
+/*
+JSON comparison rules, used when either side of comparison is JSON value
+such as field access or json_extract:
+
+  - Numbers are compared by value, integer and float can be compared.
+  - Strings are compared byte-wise, bool false is less than true, time
+    values are compared by time.
+  - Null equals null only, arrays and objects are equal if they have the
+    same items, they cannot be ordered.
+  - Values of different types are not equal and not ordered, so `=`, `<`,
+    `>`, `<=` and `>=` are false and `!=` is true.
+*/
+
+// jsonCompareScalar returns the order of scalar values of same type, ok is
+// false if the values cannot be ordered.
+func jsonCompareScalar(left any, right any) (int, bool) {
+	if lint, lok := convertToInt(left); lok {
+		if rint, rok := convertToInt(right); rok {
+			switch {
+			case lint < rint:
+				return -1, true
+			case lint > rint:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	if lfloat, lok := convertToFloat(left); lok {
+		rfloat, rok := convertToFloat(right)
+		if !rok {
+			return 0, false
+		}
+		switch {
+		case lfloat < rfloat:
+			return -1, true
+		case lfloat > rfloat:
+			return 1, true
+		}
+		return 0, lfloat == rfloat
+	}
+	switch l := left.(type) {
+	case string:
+		if r, ok := right.(string); ok {
+			return bytes.Compare([]byte(l), []byte(r)), true
+		}
+	case bool:
+		if r, ok := right.(bool); ok {
+			switch {
+			case l == r:
+				return 0, true
+			case r:
+				return -1, true
+			}
+			return 1, true
+		}
+	case time.Time:
+		if r, ok := right.(time.Time); ok {
+			return l.Compare(r), true
+		}
+	}
+	return 0, false
+}
+
+func jsonEqual(left any, right any) bool {
+	left, right = jsonNormalize(left), jsonNormalize(right)
+	switch l := left.(type) {
+	case nil:
+		return right == nil
+	case map[string]any:
+		r, ok := right.(map[string]any)
+		if !ok || len(l) != len(r) {
+			return false
+		}
+		for k, item := range l {
+			ritem, have := r[k]
+			if !have || !jsonEqual(item, ritem) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		r, ok := right.([]any)
+		if !ok || len(l) != len(r) {
+			return false
+		}
+		for i := range l {
+			if !jsonEqual(l[i], r[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	cmp, ok := jsonCompareScalar(left, right)
+	return ok && cmp == 0
+}
+
+func execJSONCompare(left any, right any, op string) (bool, error) {
+	if op == "=" {
+		return jsonEqual(left, right), nil
+	}
+	cmp, ok := jsonCompareScalar(jsonNormalize(left), jsonNormalize(right))
+	if !ok {
+		return false, nil
+	}
+	switch op {
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	}
+	return false, errors.New("Unknown operator")
+}
+
+// jsonContains reports whether the candidate is contained in target: the
+// scalars are contained if they are equal, the object is contained if all
+// of its members are contained in the target object, and the array is
+// contained if all of its elements are contained in the target array.
+// A scalar or object is contained in array if it is contained in any
+// element.
+func jsonContains(target any, candidate any) bool {
+	target, candidate = jsonNormalize(target), jsonNormalize(candidate)
+	switch t := target.(type) {
+	case map[string]any:
+		c, ok := candidate.(map[string]any)
+		if !ok {
+			return false
+		}
+		for k, citem := range c {
+			titem, have := t[k]
+			if !have || !jsonContains(titem, citem) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		if c, ok := candidate.([]any); ok {
+			for _, citem := range c {
+				if !jsonContains(t, citem) {
+					return false
+				}
+			}
+			return true
+		}
+		for _, titem := range t {
+			if jsonContains(titem, candidate) {
+				return true
+			}
+		}
+		return false
+	}
+	return jsonEqual(target, candidate)
+}
+
+// jsonValueFuncs are the functions return JSON value, or nil if the path
+// does not match.
+var jsonValueFuncs = map[string]bool{
+	"json_extract": true,
+	"json_keys":    true,
+	"json_length":  true,
+	"json_type":    true,
+}
+
+// isJSONValueExpr reports whether the expression returns JSON value which
+// type is only known at runtime, such as json(value)['a'].
+func isJSONValueExpr(e Expression) bool {
+	switch expr := e.(type) {
+	case *FieldAccessExpr:
+		return true
+	case *FieldReferenceExpr:
+		return isJSONValueExpr(expr.FieldExpr)
+	case *FunctionCallExpr:
+		fname, err := GetFuncNameFromExpr(expr)
+		return err == nil && jsonValueFuncs[fname]
+	case *LambdaArgExpr:
+		// Element of JSON array
+		return expr.Type == TUNKNOWN
+	}
+	return false
+}
+
+// isJSONCompare reports whether the comparison uses JSON comparison rules
+func (e *BinaryOpExpr) isJSONCompare() bool {
+	return isJSONValueExpr(e.Left) || isJSONValueExpr(e.Right)
+}
@@ -9,6 +9,19 @@ type Storage interface {
 	Cursor() (cursor Cursor, err error)
 }
 
+// AtomicStorage is an optional interface for Storage which supports atomic
+// conditional writes. If Storage not implements it, conditional put will
+// fallback to get then put which is not atomic. Txn implements it should
+// keep the conditions when commit, e.g. fail the commit if they changed.
+type AtomicStorage interface {
+	// PutIfNotExists puts the key value pair only if key not exists,
+	// returns true if the pair is written.
+	PutIfNotExists(key []byte, value []byte) (bool, error)
+	// CompareAndSwap puts the value only if current value of key equals
+	// to oldValue, returns true if the value is written.
+	CompareAndSwap(key []byte, oldValue []byte, newValue []byte) (bool, error)
+}
+
 type Cursor interface {
 	Seek(prefix []byte) error
 	Next() (key []byte, value []byte, err error)
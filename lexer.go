@@ -1,80 +1,100 @@
 package kvql
 
 import (
+	"encoding/hex"
 	"fmt"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 type TokenType byte
 
 const (
-	SELECT   TokenType = 1
-	WHERE    TokenType = 2
-	KEY      TokenType = 3
-	VALUE    TokenType = 4
-	OPERATOR TokenType = 5
-	STRING   TokenType = 6
-	LPAREN   TokenType = 7
-	RPAREN   TokenType = 8
-	NAME     TokenType = 9
-	SEP      TokenType = 10
-	NUMBER   TokenType = 11
-	FLOAT    TokenType = 12
-	LIMIT    TokenType = 13
-	ORDER    TokenType = 14
-	BY       TokenType = 15
-	ASC      TokenType = 16
-	DESC     TokenType = 17
-	TRUE     TokenType = 18
-	FALSE    TokenType = 19
-	AS       TokenType = 20
-	GROUP    TokenType = 21
-	IN       TokenType = 22
-	BETWEEN  TokenType = 23
-	AND      TokenType = 24
-	LBRACK   TokenType = 25
-	RBRACK   TokenType = 26
-	PUT      TokenType = 27
-	REMOVE   TokenType = 28
-	SEMI     TokenType = 29
-	OR       TokenType = 30
-	DELETE   TokenType = 31
+	SELECT    TokenType = 1
+	WHERE     TokenType = 2
+	KEY       TokenType = 3
+	VALUE     TokenType = 4
+	OPERATOR  TokenType = 5
+	STRING    TokenType = 6
+	LPAREN    TokenType = 7
+	RPAREN    TokenType = 8
+	NAME      TokenType = 9
+	SEP       TokenType = 10
+	NUMBER    TokenType = 11
+	FLOAT     TokenType = 12
+	LIMIT     TokenType = 13
+	ORDER     TokenType = 14
+	BY        TokenType = 15
+	ASC       TokenType = 16
+	DESC      TokenType = 17
+	TRUE      TokenType = 18
+	FALSE     TokenType = 19
+	AS        TokenType = 20
+	GROUP     TokenType = 21
+	IN        TokenType = 22
+	BETWEEN   TokenType = 23
+	AND       TokenType = 24
+	LBRACK    TokenType = 25
+	RBRACK    TokenType = 26
+	PUT       TokenType = 27
+	REMOVE    TokenType = 28
+	SEMI      TokenType = 29
+	OR        TokenType = 30
+	DELETE    TokenType = 31
+	WITH      TokenType = 32
+	FROM      TokenType = 33
+	UPDATE    TokenType = 34
+	SET       TokenType = 35
+	IF        TokenType = 36
+	EXISTS    TokenType = 37
+	RETURNING TokenType = 38
+	PARAM     TokenType = 39
+	INVALID   TokenType = 40
 )
 
 var (
 	TokenTypeToString = map[TokenType]string{
-		SELECT:   "SELECT",
-		WHERE:    "WHERE",
-		KEY:      "KEY",
-		VALUE:    "VALUE",
-		OPERATOR: "OP",
-		STRING:   "STR",
-		LPAREN:   "(",
-		RPAREN:   ")",
-		NAME:     "NAME",
-		SEP:      "SEP",
-		NUMBER:   "NUM",
-		FLOAT:    "FLOAT",
-		LIMIT:    "LIMIT",
-		ORDER:    "ORDER",
-		BY:       "BY",
-		ASC:      "ASC",
-		DESC:     "DESC",
-		TRUE:     "true",
-		FALSE:    "false",
-		AS:       "AS",
-		GROUP:    "GROUP",
-		IN:       "IN",
-		BETWEEN:  "BETWEEN",
-		AND:      "AND",
-		LBRACK:   "[",
-		RBRACK:   "]",
-		PUT:      "PUT",
-		REMOVE:   "REMOVE",
-		SEMI:     "SEMI",
-		OR:       "OR",
-		DELETE:   "DELETE",
+		SELECT:    "SELECT",
+		WHERE:     "WHERE",
+		KEY:       "KEY",
+		VALUE:     "VALUE",
+		OPERATOR:  "OP",
+		STRING:    "STR",
+		LPAREN:    "(",
+		RPAREN:    ")",
+		NAME:      "NAME",
+		SEP:       "SEP",
+		NUMBER:    "NUM",
+		FLOAT:     "FLOAT",
+		LIMIT:     "LIMIT",
+		ORDER:     "ORDER",
+		BY:        "BY",
+		ASC:       "ASC",
+		DESC:      "DESC",
+		TRUE:      "true",
+		FALSE:     "false",
+		AS:        "AS",
+		GROUP:     "GROUP",
+		IN:        "IN",
+		BETWEEN:   "BETWEEN",
+		AND:       "AND",
+		LBRACK:    "[",
+		RBRACK:    "]",
+		PUT:       "PUT",
+		REMOVE:    "REMOVE",
+		SEMI:      "SEMI",
+		OR:        "OR",
+		DELETE:    "DELETE",
+		WITH:      "WITH",
+		FROM:      "FROM",
+		UPDATE:    "UPDATE",
+		SET:       "SET",
+		IF:        "IF",
+		EXISTS:    "EXISTS",
+		RETURNING: "RETURNING",
+		PARAM:     "PARAM",
+		INVALID:   "INVALID",
 	}
 )
 
@@ -107,7 +127,7 @@ func (t *Token) Precedence() int {
 			return 3
 		case "+", "-":
 			return 4
-		case "*", "/":
+		case "*", "/", "%":
 			return 5
 		}
 	}
@@ -134,6 +154,7 @@ func (l *Lexer) Split() []*Token {
 		ret          []*Token
 		strStart     bool = false
 		strStartChar byte = 0
+		strPrefix    byte = 0
 		tokStart     int  = 0
 		tokLen       int  = 0
 		tokStartPos  int
@@ -145,8 +166,14 @@ func (l *Lexer) Split() []*Token {
 		} else {
 			next = 0
 		}
+		if strStart && strPrefix == 'e' && char == '\\' {
+			// Skip the escaped character, it may be the quote
+			tokLen += 2
+			i++
+			continue
+		}
 		switch char {
-		case ' ':
+		case ' ', '\t', '\n', '\r':
 			if strStart {
 				tokLen++
 				break
@@ -162,7 +189,13 @@ func (l *Lexer) Split() []*Token {
 			if !strStart {
 				strStart = true
 				strStartChar = char
+				strPrefix = 0
 				tokStartPos = i
+				if tokLen == 1 && isStringPrefix(prev) {
+					strPrefix = prev | 0x20
+					tokStartPos = i - 1
+					tokLen = 0
+				}
 				tokStart = i + 1
 			} else if strStartChar == char {
 				strStart = false
@@ -172,6 +205,14 @@ func (l *Lexer) Split() []*Token {
 					Data: curr,
 					Pos:  tokStartPos,
 				}
+				if strPrefix != 0 {
+					data, err := decodeStringLiteral(strPrefix, char, curr)
+					if err != nil {
+						token.Tp = INVALID
+						data = err.Error()
+					}
+					token.Data = data
+				}
 				ret = append(ret, token)
 				tokLen = 0
 			} else {
@@ -196,7 +237,7 @@ func (l *Lexer) Split() []*Token {
 			} else {
 				tokLen++
 			}
-		case '~', '^', '=', '!', '*', '+', '-', '/', '>', '<':
+		case '~', '^', '=', '!', '*', '+', '-', '/', '%', '>', '<':
 			if strStart {
 				tokLen++
 				break
@@ -208,9 +249,22 @@ func (l *Lexer) Split() []*Token {
 			tokLen = 0
 			var token *Token = nil
 
+			if char == '-' && next == '>' {
+				// Arrow of lambda expression
+				ret = append(ret, &Token{
+					Tp:   OPERATOR,
+					Data: "->",
+					Pos:  i,
+				})
+				i++
+				tokStartPos = i + 1
+				tokStart = i + 1
+				break
+			}
+
 			if next != '=' {
 				switch char {
-				case '!', '*', '+', '-', '/':
+				case '!', '*', '+', '-', '/', '%':
 					token = &Token{
 						Tp:   OPERATOR,
 						Data: string(char),
@@ -364,6 +418,69 @@ func (l *Lexer) Split() []*Token {
 	return ret
 }
 
+// isStringPrefix returns true if c is the prefix of hex string literal
+// x'0a0b' or escape string literal e'a\nb'
+func isStringPrefix(c byte) bool {
+	switch c {
+	case 'x', 'X', 'e', 'E':
+		return true
+	}
+	return false
+}
+
+// isPrefixedString returns true if the quote at pos follows a string
+// literal prefix which is a token by itself.
+func isPrefixedString(query string, pos int) bool {
+	if pos < 1 || !isStringPrefix(query[pos-1]) {
+		return false
+	}
+	return pos == 1 || strings.IndexByte(" \t\n\r\"'`~^=!*+-/%><&|()[],;", query[pos-2]) >= 0
+}
+
+// stringLiteralEnd returns the end of string literal starts at pos, the
+// literal can be prefixed by x or e.
+func stringLiteralEnd(query string, pos int) int {
+	escape := false
+	if isStringPrefix(query[pos]) {
+		escape = query[pos]|0x20 == 'e'
+		pos++
+	}
+	for i := pos + 1; i < len(query); i++ {
+		if escape && query[i] == '\\' {
+			i++
+		} else if query[i] == query[pos] {
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+// decodeStringLiteral decodes the data of hex string literal or escape
+// string literal, the escape sequences are the same as Go.
+func decodeStringLiteral(prefix byte, quote byte, data string) (string, error) {
+	if prefix == 'x' {
+		ret, err := hex.DecodeString(data)
+		if err != nil {
+			return "", fmt.Errorf("Invalid hex string literal x'%s'", data)
+		}
+		return string(ret), nil
+	}
+	var ret strings.Builder
+	for s := data; len(s) > 0; {
+		c, multibyte, tail, err := strconv.UnquoteChar(s, quote)
+		if err != nil {
+			return "", fmt.Errorf("Invalid escape sequence in string literal e'%s'", data)
+		}
+		if c < utf8.RuneSelf || !multibyte {
+			ret.WriteByte(byte(c))
+		} else {
+			ret.WriteRune(c)
+		}
+		s = tail
+	}
+	return ret.String(), nil
+}
+
 func isNumber(val string) bool {
 	if _, err := strconv.ParseInt(val, 10, 64); err == nil {
 		return true
@@ -378,6 +495,31 @@ func isFloat(val string) bool {
 	return false
 }
 
+// isParam returns true if val is placeholder of prepared statement:
+// `?`, `$1` or `:name`
+func isParam(val string) bool {
+	if val == "?" {
+		return true
+	}
+	if len(val) < 2 {
+		return false
+	}
+	switch val[0] {
+	case '$':
+		idx, err := strconv.Atoi(val[1:])
+		return err == nil && idx > 0 && val[1] >= '0' && val[1] <= '9'
+	case ':':
+		for i := 1; i < len(val); i++ {
+			c := val[i]
+			if !(c == '_' || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9' && i > 1)) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
 func buildToken(curr string, pos int) *Token {
 	curr = strings.ToLower(strings.TrimSpace(curr))
 	if len(curr) == 0 {
@@ -448,10 +590,34 @@ func buildToken(curr string, pos int) *Token {
 	case "delete":
 		token.Tp = DELETE
 		return token
+	case "with":
+		token.Tp = WITH
+		return token
+	case "from":
+		token.Tp = FROM
+		return token
+	case "update":
+		token.Tp = UPDATE
+		return token
+	case "set":
+		token.Tp = SET
+		return token
+	case "if":
+		token.Tp = IF
+		return token
+	case "exists":
+		token.Tp = EXISTS
+		return token
+	case "returning":
+		token.Tp = RETURNING
+		return token
 	default:
 		if isNumber(curr) {
 			token.Tp = NUMBER
 			return token
+		} else if isParam(curr) {
+			token.Tp = PARAM
+			return token
 		} else if isFloat(curr) {
 			token.Tp = FLOAT
 			return token
@@ -2,6 +2,7 @@ package kvql
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -175,3 +176,71 @@ func TestLexer19(t *testing.T) {
 		fmt.Printf("%s\n", t.String())
 	}
 }
+
+func TestLexerWhitespace(t *testing.T) {
+	query := "select key,\tvalue\nwhere key = 'a\nb';\r\n"
+	toks := NewLexer(query).Split()
+	expect := []string{"select", "key", ",", "value", "where", "key", "=", "a\nb", ";"}
+	if len(toks) != len(expect) {
+		t.Fatal("Unexpected tokens", toks)
+	}
+	for i, tok := range toks {
+		if tok.Data != expect[i] {
+			t.Fatal("Unexpected token", i, tok.String())
+		}
+	}
+}
+
+func TestLexerPrefixedString(t *testing.T) {
+	tcases := []struct {
+		query  string
+		expect []string
+	}{
+		{"key = x'00ff'", []string{"key", "=", "\x00\xff"}},
+		{"key ^= X'6B'", []string{"key", "^=", "k"}},
+		{`value = e'it\'s\n\x00世'`, []string{"value", "=", "it's\n\x00世"}},
+		{`value = E"say \"hi\" \\"`, []string{"value", "=", `say "hi" \`}},
+		{"(x'', e'')", []string{"(", "", ",", "", ")"}},
+		{"index_of(x, 'x')", []string{"index_of", "(", "x", ",", "x", ")"}},
+	}
+	for _, c := range tcases {
+		toks := NewLexer(c.query).Split()
+		if len(toks) != len(c.expect) {
+			t.Fatal("Unexpected tokens", c.query, toks)
+		}
+		for i, tok := range toks {
+			if tok.Data != c.expect[i] {
+				t.Fatalf("%s unexpected token %d %q", c.query, i, tok.Data)
+			}
+		}
+	}
+	for _, query := range []string{"select * where key = x'0g'", "select * where key = x'abc'", `select * where value = e'\q'`} {
+		_, err := NewParser(query).Parse()
+		if err == nil || !strings.Contains(err.Error(), "Invalid") {
+			t.Fatal("Should get invalid literal error", query, err)
+		}
+	}
+}
+
+func TestLexerArrow(t *testing.T) {
+	tcases := []struct {
+		query  string
+		expect []string
+	}{
+		{"x->x*2", []string{"x", "->", "x", "*", "2"}},
+		{"(acc, x) -> acc + x", []string{"(", "acc", ",", "x", ")", "->", "acc", "+", "x"}},
+		{"a - -1 > 0", []string{"a", "-", "-", "1", ">", "0"}},
+		{"'->'", []string{"->"}},
+	}
+	for _, c := range tcases {
+		toks := NewLexer(c.query).Split()
+		if len(toks) != len(c.expect) {
+			t.Fatal("Unexpected tokens", c.query, toks)
+		}
+		for i, tok := range toks {
+			if tok.Data != c.expect[i] {
+				t.Fatalf("%s unexpected token %d %q", c.query, i, tok.Data)
+			}
+		}
+	}
+}
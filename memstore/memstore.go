@@ -0,0 +1,325 @@
+// Package memstore is an in memory sorted key value storage for kvql, it is
+// useful for tests, examples and command line tools.
+package memstore
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/c4pt0r/kvql"
+)
+
+var (
+	_ kvql.Storage       = (*Store)(nil)
+	_ kvql.AtomicStorage = (*Store)(nil)
+	_ kvql.TxnStorage    = (*Store)(nil)
+	_ kvql.Txn           = (*Txn)(nil)
+	_ kvql.AtomicStorage = (*Txn)(nil)
+
+	// ErrConditionFailed is returned by commit if the condition of a
+	// conditional write in transaction does not hold in store any more
+	ErrConditionFailed = errors.New("memstore: condition of conditional write changed before commit")
+)
+
+// Store keeps key value pairs sorted by key, it is safe for concurrent use.
+type Store struct {
+	mu   sync.RWMutex
+	data []kvql.KVPair
+}
+
+func New() *Store {
+	return &Store{}
+}
+
+// NewWithData creates store with the copy of data
+func NewWithData(data []kvql.KVPair) *Store {
+	s := New()
+	s.BatchPut(data)
+	return s
+}
+
+func (s *Store) search(key []byte) (int, bool) {
+	idx := sort.Search(len(s.data), func(i int) bool {
+		return bytes.Compare(s.data[i].Key, key) >= 0
+	})
+	return idx, idx < len(s.data) && bytes.Equal(s.data[idx].Key, key)
+}
+
+func (s *Store) Get(key []byte) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if idx, have := s.search(key); have {
+		return s.data[idx].Value, nil
+	}
+	return nil, nil
+}
+
+func (s *Store) put(key []byte, value []byte) {
+	key = bytes.Clone(key)
+	value = bytes.Clone(value)
+	idx, have := s.search(key)
+	if have {
+		s.data[idx].Value = value
+		return
+	}
+	s.data = append(s.data, kvql.KVPair{})
+	copy(s.data[idx+1:], s.data[idx:])
+	s.data[idx] = kvql.NewKVP(key, value)
+}
+
+func (s *Store) delete(key []byte) {
+	if idx, have := s.search(key); have {
+		s.data = append(s.data[:idx:idx], s.data[idx+1:]...)
+	}
+}
+
+func (s *Store) Put(key []byte, value []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(key, value)
+	return nil
+}
+
+func (s *Store) BatchPut(kvs []kvql.KVPair) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, kvp := range kvs {
+		s.put(kvp.Key, kvp.Value)
+	}
+	return nil
+}
+
+func (s *Store) Delete(key []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.delete(key)
+	return nil
+}
+
+func (s *Store) BatchDelete(keys [][]byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range keys {
+		s.delete(key)
+	}
+	return nil
+}
+
+func (s *Store) PutIfNotExists(key []byte, value []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, have := s.search(key); have {
+		return false, nil
+	}
+	s.put(key, value)
+	return true, nil
+}
+
+func (s *Store) CompareAndSwap(key []byte, oldValue []byte, newValue []byte) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx, have := s.search(key)
+	if !have || !bytes.Equal(s.data[idx].Value, oldValue) {
+		return false, nil
+	}
+	s.put(key, newValue)
+	return true, nil
+}
+
+// Len returns the number of keys
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.data)
+}
+
+func (s *Store) Cursor() (kvql.Cursor, error) {
+	return &cursor{store: s}, nil
+}
+
+// Begin starts a transaction on the snapshot of store, the writes are
+// applied to store when commit. Conflicts of plain writes are not detected,
+// the later committed transaction overwrites the keys, but the conditions of
+// PutIfNotExists and CompareAndSwap are checked again when commit.
+func (s *Store) Begin() (kvql.Txn, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snapshot := &Store{
+		data: make([]kvql.KVPair, len(s.data)),
+	}
+	copy(snapshot.data, s.data)
+	return &Txn{
+		Store:  snapshot,
+		parent: s,
+	}, nil
+}
+
+// cursor reads the latest data of store, so it can see the keys written
+// during iteration.
+type cursor struct {
+	store *Store
+	next  []byte
+	last  []byte
+}
+
+func (c *cursor) Seek(key []byte) error {
+	c.next = bytes.Clone(key)
+	c.last = nil
+	return nil
+}
+
+func (c *cursor) Next() ([]byte, []byte, error) {
+	s := c.store
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	idx := 0
+	if c.last != nil {
+		idx = sort.Search(len(s.data), func(i int) bool {
+			return bytes.Compare(s.data[i].Key, c.last) > 0
+		})
+	} else {
+		idx, _ = s.search(c.next)
+	}
+	if idx >= len(s.data) {
+		return nil, nil, nil
+	}
+	kvp := s.data[idx]
+	c.last = kvp.Key
+	return kvp.Key, kvp.Value, nil
+}
+
+type txnWrite struct {
+	key    []byte
+	value  []byte
+	delete bool
+}
+
+// txnCheck is the condition of conditional write on the snapshot, the key
+// should not exist if exists is false.
+type txnCheck struct {
+	key    []byte
+	value  []byte
+	exists bool
+}
+
+// Txn is the transaction of Store
+type Txn struct {
+	*Store
+	parent *Store
+	mu     sync.Mutex
+	writes []txnWrite
+	checks []txnCheck
+	done   bool
+}
+
+// check records the condition on the key if the key is not written in the
+// transaction before, so the condition was checked on the store snapshot.
+func (t *Txn) check(key []byte, value []byte, exists bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, w := range t.writes {
+		if bytes.Equal(w.key, key) {
+			return
+		}
+	}
+	t.checks = append(t.checks, txnCheck{
+		key:    bytes.Clone(key),
+		value:  bytes.Clone(value),
+		exists: exists,
+	})
+}
+
+func (t *Txn) record(key []byte, value []byte, delete bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.writes = append(t.writes, txnWrite{
+		key:    bytes.Clone(key),
+		value:  bytes.Clone(value),
+		delete: delete,
+	})
+}
+
+func (t *Txn) Put(key []byte, value []byte) error {
+	t.record(key, value, false)
+	return t.Store.Put(key, value)
+}
+
+func (t *Txn) BatchPut(kvs []kvql.KVPair) error {
+	for _, kvp := range kvs {
+		t.record(kvp.Key, kvp.Value, false)
+	}
+	return t.Store.BatchPut(kvs)
+}
+
+func (t *Txn) Delete(key []byte) error {
+	t.record(key, nil, true)
+	return t.Store.Delete(key)
+}
+
+func (t *Txn) BatchDelete(keys [][]byte) error {
+	for _, key := range keys {
+		t.record(key, nil, true)
+	}
+	return t.Store.BatchDelete(keys)
+}
+
+func (t *Txn) PutIfNotExists(key []byte, value []byte) (bool, error) {
+	ok, err := t.Store.PutIfNotExists(key, value)
+	if ok {
+		t.check(key, nil, false)
+		t.record(key, value, false)
+	}
+	return ok, err
+}
+
+func (t *Txn) CompareAndSwap(key []byte, oldValue []byte, newValue []byte) (bool, error) {
+	ok, err := t.Store.CompareAndSwap(key, oldValue, newValue)
+	if ok {
+		t.check(key, oldValue, true)
+		t.record(key, newValue, false)
+	}
+	return ok, err
+}
+
+func (t *Txn) Begin() (kvql.Txn, error) {
+	return nil, kvql.ErrTxnAlreadyBegin
+}
+
+func (t *Txn) Commit() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return kvql.ErrTxnNotBegin
+	}
+	t.done = true
+	p := t.parent
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, c := range t.checks {
+		idx, have := p.search(c.key)
+		if have != c.exists || have && !bytes.Equal(p.data[idx].Value, c.value) {
+			return ErrConditionFailed
+		}
+	}
+	for _, w := range t.writes {
+		if w.delete {
+			p.delete(w.key)
+		} else {
+			p.put(w.key, w.value)
+		}
+	}
+	return nil
+}
+
+func (t *Txn) Rollback() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.done {
+		return kvql.ErrTxnNotBegin
+	}
+	t.done = true
+	t.writes = nil
+	return nil
+}
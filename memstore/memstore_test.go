@@ -0,0 +1,145 @@
+package memstore
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/kvql"
+)
+
+func scanAll(t *testing.T, s kvql.Storage, prefix string) string {
+	c, err := s.Cursor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Seek([]byte(prefix)); err != nil {
+		t.Fatal(err)
+	}
+	ret := []string{}
+	for {
+		key, val, err := c.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key == nil {
+			break
+		}
+		ret = append(ret, fmt.Sprintf("%s=%s", key, val))
+	}
+	return strings.Join(ret, ",")
+}
+
+func TestStore(t *testing.T) {
+	s := NewWithData([]kvql.KVPair{
+		kvql.NewKVPStr("k3", "v3"),
+		kvql.NewKVPStr("k1", "v1"),
+	})
+	s.Put([]byte("k2"), []byte("v2"))
+	s.Put([]byte("k1"), []byte("v1_new"))
+	if ret := scanAll(t, s, ""); ret != "k1=v1_new,k2=v2,k3=v3" {
+		t.Fatal("Unexpected data", ret)
+	}
+	if ret := scanAll(t, s, "k2"); ret != "k2=v2,k3=v3" {
+		t.Fatal("Unexpected data", ret)
+	}
+	s.BatchDelete([][]byte{[]byte("k2"), []byte("k4")})
+	if val, _ := s.Get([]byte("k2")); val != nil {
+		t.Fatal("k2 should be deleted")
+	}
+
+	ok, _ := s.PutIfNotExists([]byte("k1"), []byte("x"))
+	if ok {
+		t.Fatal("k1 exists")
+	}
+	ok, _ = s.CompareAndSwap([]byte("k3"), []byte("v3"), []byte("v3_new"))
+	if !ok {
+		t.Fatal("Compare and swap should success")
+	}
+	if ret := scanAll(t, s, ""); ret != "k1=v1_new,k3=v3_new" {
+		t.Fatal("Unexpected data", ret)
+	}
+
+	// Cursor can see the keys written during iteration
+	c, _ := s.Cursor()
+	c.Seek(nil)
+	key, _, _ := c.Next()
+	s.Put([]byte("k2"), []byte("v2"))
+	key, _, _ = c.Next()
+	if string(key) != "k2" {
+		t.Fatal("Cursor should read k2, got", string(key))
+	}
+}
+
+func TestStoreTxn(t *testing.T) {
+	s := NewWithData([]kvql.KVPair{kvql.NewKVPStr("k1", "v1")})
+	txn, _ := s.Begin()
+	txn.Put([]byte("k2"), []byte("v2"))
+	txn.Delete([]byte("k1"))
+	if ret := scanAll(t, s, ""); ret != "k1=v1" {
+		t.Fatal("Writes in transaction should be invisible", ret)
+	}
+	if ret := scanAll(t, txn, ""); ret != "k2=v2" {
+		t.Fatal("Transaction should read its writes", ret)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if ret := scanAll(t, s, ""); ret != "k2=v2" {
+		t.Fatal("Unexpected data after commit", ret)
+	}
+
+	txn, _ = s.Begin()
+	txn.Put([]byte("k3"), []byte("v3"))
+	txn.Rollback()
+	if ret := scanAll(t, s, ""); ret != "k2=v2" {
+		t.Fatal("Unexpected data after rollback", ret)
+	}
+	if txn.Commit() == nil {
+		t.Fatal("Should not commit finished transaction")
+	}
+}
+
+func TestStoreTxnConditionalWrite(t *testing.T) {
+	s := NewWithData([]kvql.KVPair{kvql.NewKVPStr("k1", "v1")})
+	txn, _ := s.Begin()
+	atxn := txn.(kvql.AtomicStorage)
+	if ok, _ := atxn.PutIfNotExists([]byte("k2"), []byte("v2")); !ok {
+		t.Fatal("Should put not existed key")
+	}
+	// Concurrent writer puts the key before commit
+	s.Put([]byte("k2"), []byte("other"))
+	if err := txn.Commit(); err != ErrConditionFailed {
+		t.Fatal("Commit should fail", err)
+	}
+	if ret := scanAll(t, s, ""); ret != "k1=v1,k2=other" {
+		t.Fatal("Failed commit should not write", ret)
+	}
+
+	txn, _ = s.Begin()
+	atxn = txn.(kvql.AtomicStorage)
+	if ok, _ := atxn.CompareAndSwap([]byte("k1"), []byte("v1"), []byte("v1x")); !ok {
+		t.Fatal("Should swap value")
+	}
+	s.Put([]byte("k1"), []byte("v1y"))
+	if err := txn.Commit(); err != ErrConditionFailed {
+		t.Fatal("Commit should fail", err)
+	}
+
+	// The key written by transaction is not checked in store
+	txn, _ = s.Begin()
+	atxn = txn.(kvql.AtomicStorage)
+	txn.Delete([]byte("k2"))
+	if ok, _ := atxn.PutIfNotExists([]byte("k2"), []byte("v2")); !ok {
+		t.Fatal("Should put deleted key")
+	}
+	if ok, _ := atxn.CompareAndSwap([]byte("k1"), []byte("v1y"), []byte("v1z")); !ok {
+		t.Fatal("Should swap value")
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if ret := scanAll(t, s, ""); ret != "k1=v1z,k2=v2" {
+		t.Fatal("Unexpected data after commit", ret)
+	}
+}
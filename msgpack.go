@@ -0,0 +1,222 @@
+package kvql
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// msgpackTimestampExt is the extension type of msgpack timestamp
+const msgpackTimestampExt = -1
+
+// msgpackReader decodes the msgpack data, integers are int64 or uint64,
+// binary data and extension types except timestamp are converted to string
+// by binaryString.
+type msgpackReader struct {
+	data []byte
+	pos  int
+}
+
+func decodeMsgpack(data []byte, args []any) (any, error) {
+	r := &msgpackReader{data: data}
+	ret, err := r.decode(0)
+	if err != nil {
+		return nil, err
+	}
+	if r.pos != len(data) {
+		return nil, fmt.Errorf("extra data at %d", r.pos)
+	}
+	return ret, nil
+}
+
+func (r *msgpackReader) read(n int) ([]byte, error) {
+	if n < 0 || len(r.data)-r.pos < n {
+		return nil, errInsufficientData
+	}
+	ret := r.data[r.pos : r.pos+n]
+	r.pos += n
+	return ret, nil
+}
+
+// readUint reads big-endian unsigned integer of size bytes
+func (r *msgpackReader) readUint(size int) (uint64, error) {
+	b, err := r.read(size)
+	if err != nil {
+		return 0, err
+	}
+	var ret uint64
+	for _, c := range b {
+		ret = ret<<8 | uint64(c)
+	}
+	return ret, nil
+}
+
+func (r *msgpackReader) decode(depth int) (any, error) {
+	if depth > maxDecodeDepth {
+		return nil, errMaxDecodeDepth
+	}
+	b, err := r.read(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+	switch {
+	case c <= 0x7f:
+		return int64(c), nil
+	case c >= 0xe0:
+		return int64(int8(c)), nil
+	case c&0xf0 == 0x80:
+		return r.decodeMap(int(c&0x0f), depth)
+	case c&0xf0 == 0x90:
+		return r.decodeArray(int(c&0x0f), depth)
+	case c&0xe0 == 0xa0:
+		return r.decodeStr(int(c & 0x1f))
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6:
+		// bin 8, 16, 32
+		n, err := r.readUint(1 << (c - 0xc4))
+		if err != nil {
+			return nil, err
+		}
+		data, err := r.read(int(n))
+		if err != nil {
+			return nil, err
+		}
+		return binaryString(data), nil
+	case 0xc7, 0xc8, 0xc9:
+		// ext 8, 16, 32
+		n, err := r.readUint(1 << (c - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		return r.decodeExt(int(n))
+	case 0xca:
+		v, err := r.readUint(4)
+		return float64(math.Float32frombits(uint32(v))), err
+	case 0xcb:
+		v, err := r.readUint(8)
+		return math.Float64frombits(v), err
+	case 0xcc, 0xcd, 0xce, 0xcf:
+		// uint 8, 16, 32, 64
+		v, err := r.readUint(1 << (c - 0xcc))
+		return uintValue(v), err
+	case 0xd0:
+		v, err := r.readUint(1)
+		return int64(int8(v)), err
+	case 0xd1:
+		v, err := r.readUint(2)
+		return int64(int16(v)), err
+	case 0xd2:
+		v, err := r.readUint(4)
+		return int64(int32(v)), err
+	case 0xd3:
+		v, err := r.readUint(8)
+		return int64(v), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8:
+		// fixext 1, 2, 4, 8, 16
+		return r.decodeExt(1 << (c - 0xd4))
+	case 0xd9, 0xda, 0xdb:
+		// str 8, 16, 32
+		n, err := r.readUint(1 << (c - 0xd9))
+		if err != nil {
+			return nil, err
+		}
+		return r.decodeStr(int(n))
+	case 0xdc, 0xdd:
+		// array 16, 32
+		n, err := r.readUint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return r.decodeArray(int(n), depth)
+	case 0xde, 0xdf:
+		// map 16, 32
+		n, err := r.readUint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return r.decodeMap(int(n), depth)
+	}
+	return nil, fmt.Errorf("invalid type byte 0x%02x at %d", c, r.pos-1)
+}
+
+func (r *msgpackReader) decodeStr(n int) (any, error) {
+	data, err := r.read(n)
+	if err != nil {
+		return nil, err
+	}
+	return binaryString(data), nil
+}
+
+func (r *msgpackReader) decodeArray(n int, depth int) (any, error) {
+	// Each element has at least 1 byte
+	if n > len(r.data)-r.pos {
+		return nil, errInsufficientData
+	}
+	ret := make([]any, n)
+	for i := range ret {
+		item, err := r.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		ret[i] = item
+	}
+	return ret, nil
+}
+
+func (r *msgpackReader) decodeMap(n int, depth int) (any, error) {
+	if n > len(r.data)-r.pos {
+		return nil, errInsufficientData
+	}
+	ret := make(map[string]any, n)
+	for i := 0; i < n; i++ {
+		key, err := r.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		val, err := r.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		ret[mapKeyString(key)] = val
+	}
+	return ret, nil
+}
+
+// decodeExt decodes timestamp extension into time, other extensions are
+// returned as string.
+func (r *msgpackReader) decodeExt(n int) (any, error) {
+	tp, err := r.read(1)
+	if err != nil {
+		return nil, err
+	}
+	data, err := r.read(n)
+	if err != nil {
+		return nil, err
+	}
+	if int8(tp[0]) != msgpackTimestampExt {
+		return binaryString(data), nil
+	}
+	switch n {
+	case 4:
+		return time.Unix(int64(binary.BigEndian.Uint32(data)), 0).UTC(), nil
+	case 8:
+		v := binary.BigEndian.Uint64(data)
+		return time.Unix(int64(v&(1<<34-1)), int64(v>>34)).UTC(), nil
+	case 12:
+		nsec := binary.BigEndian.Uint32(data)
+		sec := int64(binary.BigEndian.Uint64(data[4:]))
+		return time.Unix(sec, int64(nsec)).UTC(), nil
+	}
+	return nil, errors.New("invalid timestamp extension")
+}
@@ -0,0 +1,208 @@
+package mysqlserver
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+)
+
+const (
+	maxPacketSize = 1<<24 - 1
+	// maxHandshakePayload limits the payload read before authentication
+	maxHandshakePayload = 1 << 16
+	// maxAllowedPacket limits the payload of commands, it is reported as
+	// max_allowed_packet
+	maxAllowedPacket = 1 << 26
+)
+
+var (
+	errMalformedPacket = errors.New("mysql: malformed packet")
+	errPacketTooLarge  = errors.New("mysql: packet too large")
+)
+
+// packetIO reads and writes MySQL packets, the error of write is sticky
+// so the result writers need not check every write.
+type packetIO struct {
+	rd  *bufio.Reader
+	wr  *bufio.Writer
+	seq uint8
+	err error
+	// maxPayload limits the total payload of split packets, 0 means no limit
+	maxPayload int
+}
+
+func newPacketIO(c net.Conn) *packetIO {
+	return &packetIO{
+		rd: bufio.NewReader(c),
+		wr: bufio.NewWriter(c),
+	}
+}
+
+func (p *packetIO) readPacket() ([]byte, error) {
+	var ret []byte
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(p.rd, header[:]); err != nil {
+			return nil, err
+		}
+		length := int(uint32(header[0]) | uint32(header[1])<<8 | uint32(header[2])<<16)
+		if header[3] != p.seq {
+			return nil, fmt.Errorf("mysql: invalid packet sequence %d, expect %d", header[3], p.seq)
+		}
+		p.seq++
+		if p.maxPayload > 0 && len(ret)+length > p.maxPayload {
+			return nil, errPacketTooLarge
+		}
+		data := make([]byte, length)
+		if _, err := io.ReadFull(p.rd, data); err != nil {
+			return nil, err
+		}
+		ret = append(ret, data...)
+		if length < maxPacketSize {
+			return ret, nil
+		}
+	}
+}
+
+func (p *packetIO) writePacket(data []byte) {
+	for p.err == nil {
+		length := len(data)
+		if length > maxPacketSize {
+			length = maxPacketSize
+		}
+		header := [4]byte{byte(length), byte(length >> 8), byte(length >> 16), p.seq}
+		p.seq++
+		if _, p.err = p.wr.Write(header[:]); p.err != nil {
+			return
+		}
+		if _, p.err = p.wr.Write(data[:length]); p.err != nil {
+			return
+		}
+		data = data[length:]
+		// Packet with max size should be followed by a packet even empty
+		if length < maxPacketSize {
+			return
+		}
+	}
+}
+
+func (p *packetIO) flush() error {
+	if p.err == nil {
+		p.err = p.wr.Flush()
+	}
+	return p.err
+}
+
+func (p *packetIO) resetSeq() {
+	p.seq = 0
+}
+
+func appendLenEncInt(buf []byte, n uint64) []byte {
+	switch {
+	case n < 251:
+		return append(buf, byte(n))
+	case n < 1<<16:
+		return append(buf, 0xfc, byte(n), byte(n>>8))
+	case n < 1<<24:
+		return append(buf, 0xfd, byte(n), byte(n>>8), byte(n>>16))
+	}
+	buf = append(buf, 0xfe)
+	return binary.LittleEndian.AppendUint64(buf, n)
+}
+
+func appendLenEncString(buf []byte, s string) []byte {
+	buf = appendLenEncInt(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+func appendUint16(buf []byte, n uint16) []byte {
+	return binary.LittleEndian.AppendUint16(buf, n)
+}
+
+func appendUint32(buf []byte, n uint32) []byte {
+	return binary.LittleEndian.AppendUint32(buf, n)
+}
+
+// packetReader reads fields from packet payload
+type packetReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *packetReader) remain() int {
+	return len(r.data) - r.pos
+}
+
+func (r *packetReader) readByte() (byte, error) {
+	if r.remain() < 1 {
+		return 0, errMalformedPacket
+	}
+	r.pos++
+	return r.data[r.pos-1], nil
+}
+
+func (r *packetReader) readBytes(n int) ([]byte, error) {
+	if n < 0 || r.remain() < n {
+		return nil, errMalformedPacket
+	}
+	r.pos += n
+	return r.data[r.pos-n : r.pos], nil
+}
+
+func (r *packetReader) readUint32() (uint32, error) {
+	b, err := r.readBytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+func (r *packetReader) readNullString() (string, error) {
+	for i := r.pos; i < len(r.data); i++ {
+		if r.data[i] == 0 {
+			ret := string(r.data[r.pos:i])
+			r.pos = i + 1
+			return ret, nil
+		}
+	}
+	return "", errMalformedPacket
+}
+
+func (r *packetReader) readLenEncInt() (uint64, error) {
+	b, err := r.readByte()
+	if err != nil {
+		return 0, err
+	}
+	var size int
+	switch b {
+	case 0xfc:
+		size = 2
+	case 0xfd:
+		size = 3
+	case 0xfe:
+		size = 8
+	default:
+		return uint64(b), nil
+	}
+	data, err := r.readBytes(size)
+	if err != nil {
+		return 0, err
+	}
+	var ret uint64
+	for i := size - 1; i >= 0; i-- {
+		ret = ret<<8 | uint64(data[i])
+	}
+	return ret, nil
+}
+
+func (r *packetReader) readLenEncString() (string, error) {
+	n, err := r.readLenEncInt()
+	if err != nil {
+		return "", err
+	}
+	b, err := r.readBytes(int(n))
+	return string(b), err
+}
@@ -0,0 +1,343 @@
+package mysqlserver
+
+import (
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/c4pt0r/kvql"
+)
+
+const (
+	clientLongPassword     uint32 = 0x00000001
+	clientFoundRows        uint32 = 0x00000002
+	clientLongFlag         uint32 = 0x00000004
+	clientConnectWithDB    uint32 = 0x00000008
+	clientProtocol41       uint32 = 0x00000200
+	clientTransactions     uint32 = 0x00002000
+	clientSecureConn       uint32 = 0x00008000
+	clientMultiStatements  uint32 = 0x00010000
+	clientMultiResults     uint32 = 0x00020000
+	clientPluginAuth       uint32 = 0x00080000
+	clientPluginAuthLenEnc uint32 = 0x00200000
+
+	serverCapability = clientLongPassword | clientFoundRows | clientLongFlag |
+		clientConnectWithDB | clientProtocol41 | clientTransactions |
+		clientSecureConn | clientMultiStatements | clientMultiResults |
+		clientPluginAuth | clientPluginAuthLenEnc
+
+	statusInTrans           uint16 = 0x0001
+	statusAutocommit        uint16 = 0x0002
+	statusMoreResultsExists uint16 = 0x0008
+
+	comQuit     byte = 0x01
+	comInitDB   byte = 0x02
+	comQuery    byte = 0x03
+	comPing     byte = 0x0e
+	comResetCon byte = 0x1f
+
+	headerOK  byte = 0x00
+	headerEOF byte = 0xfe
+	headerERR byte = 0xff
+
+	charsetUTF8MB4 byte = 45
+	charsetBinary  byte = 63
+
+	typeTiny       byte = 0x01
+	typeLongLong   byte = 0x08
+	typeNewDecimal byte = 0xf6
+	typeJSON       byte = 0xf5
+	typeVarString  byte = 0xfd
+
+	nativePasswordPlugin = "mysql_native_password"
+)
+
+// MySQL error codes
+const (
+	ErUnknownError            uint16 = 1105
+	ErAccessDenied            uint16 = 1045
+	ErUnknownCommand          uint16 = 1047
+	ErEmptyQuery              uint16 = 1065
+	ErNetPacketTooLarge       uint16 = 1153
+	ErParseError              uint16 = 1064
+	ErOptionPreventsStatement uint16 = 1290
+)
+
+// nearLength is the length of query shown in error message
+const nearLength = 80
+
+func newSalt() ([]byte, error) {
+	salt := make([]byte, 20)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	// Salt should not contain NUL and '$'
+	for i, b := range salt {
+		salt[i] = b&0x7f | 0x01
+		if salt[i] == '$' {
+			salt[i]++
+		}
+	}
+	return salt, nil
+}
+
+func (c *conn) writeHandshake() error {
+	data := []byte{10}
+	data = append(data, c.server.version()...)
+	data = append(data, 0)
+	data = appendUint32(data, c.id)
+	data = append(data, c.salt[:8]...)
+	data = append(data, 0)
+	data = appendUint16(data, uint16(serverCapability&0xffff))
+	data = append(data, charsetUTF8MB4)
+	data = appendUint16(data, statusAutocommit)
+	data = appendUint16(data, uint16(serverCapability>>16))
+	data = append(data, byte(len(c.salt)+1))
+	data = append(data, make([]byte, 10)...)
+	data = append(data, c.salt[8:]...)
+	data = append(data, 0)
+	data = append(data, nativePasswordPlugin...)
+	data = append(data, 0)
+	c.pkt.writePacket(data)
+	return c.pkt.flush()
+}
+
+type handshakeResponse struct {
+	capability uint32
+	user       string
+	authData   []byte
+	db         string
+	plugin     string
+}
+
+func parseHandshakeResponse(data []byte) (*handshakeResponse, error) {
+	r := &packetReader{data: data}
+	capability, err := r.readUint32()
+	if err != nil {
+		return nil, err
+	}
+	if capability&clientProtocol41 == 0 {
+		return nil, errors.New("mysql: client protocol 41 required")
+	}
+	// Max packet size, charset and reserved bytes
+	if _, err = r.readBytes(4 + 1 + 23); err != nil {
+		return nil, err
+	}
+	ret := &handshakeResponse{capability: capability}
+	if ret.user, err = r.readNullString(); err != nil {
+		return nil, err
+	}
+	switch {
+	case capability&clientPluginAuthLenEnc != 0:
+		var auth string
+		auth, err = r.readLenEncString()
+		ret.authData = []byte(auth)
+	case capability&clientSecureConn != 0:
+		var n byte
+		if n, err = r.readByte(); err == nil {
+			ret.authData, err = r.readBytes(int(n))
+		}
+	default:
+		var auth string
+		auth, err = r.readNullString()
+		ret.authData = []byte(auth)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if capability&clientConnectWithDB != 0 && r.remain() > 0 {
+		if ret.db, err = r.readNullString(); err != nil {
+			return nil, err
+		}
+	}
+	if capability&clientPluginAuth != 0 && r.remain() > 0 {
+		if ret.plugin, err = r.readNullString(); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// nativePassword computes the mysql_native_password auth response:
+// SHA1(password) XOR SHA1(salt + SHA1(SHA1(password)))
+func nativePassword(salt []byte, password string) []byte {
+	if password == "" {
+		return nil
+	}
+	stage1 := sha1.Sum([]byte(password))
+	stage2 := sha1.Sum(stage1[:])
+	h := sha1.New()
+	h.Write(salt)
+	h.Write(stage2[:])
+	ret := h.Sum(nil)
+	for i := range ret {
+		ret[i] ^= stage1[i]
+	}
+	return ret
+}
+
+// authenticate checks the handshake response, the client is switched to
+// mysql_native_password if it uses other plugin.
+func (c *conn) authenticate(resp *handshakeResponse) error {
+	s := c.server
+	if s.User == "" {
+		return nil
+	}
+	authData := resp.authData
+	if resp.plugin != "" && resp.plugin != nativePasswordPlugin {
+		data := []byte{headerEOF}
+		data = append(data, nativePasswordPlugin...)
+		data = append(data, 0)
+		data = append(data, c.salt...)
+		data = append(data, 0)
+		c.pkt.writePacket(data)
+		if err := c.pkt.flush(); err != nil {
+			return err
+		}
+		var err error
+		if authData, err = c.pkt.readPacket(); err != nil {
+			return err
+		}
+	}
+	if resp.user != s.User || subtle.ConstantTimeCompare(authData, nativePassword(c.salt, s.Password)) != 1 {
+		c.writeError(ErAccessDenied, "28000", fmt.Sprintf("Access denied for user '%s'", resp.user))
+		c.pkt.flush()
+		return errAccessDenied
+	}
+	return nil
+}
+
+func (c *conn) status(more bool) uint16 {
+	ret := statusAutocommit
+	if c.sess.InTxn() {
+		ret |= statusInTrans
+	}
+	if more {
+		ret |= statusMoreResultsExists
+	}
+	return ret
+}
+
+func (c *conn) writeOK(affected uint64, more bool) {
+	data := []byte{headerOK}
+	data = appendLenEncInt(data, affected)
+	// Last insert id
+	data = appendLenEncInt(data, 0)
+	data = appendUint16(data, c.status(more))
+	// Warnings
+	data = appendUint16(data, uint16(len(c.warnings)))
+	c.pkt.writePacket(data)
+}
+
+func (c *conn) writeEOF(more bool) {
+	data := []byte{headerEOF}
+	data = appendUint16(data, uint16(len(c.warnings)))
+	data = appendUint16(data, c.status(more))
+	c.pkt.writePacket(data)
+}
+
+func (c *conn) writeError(code uint16, state string, msg string) {
+	data := []byte{headerERR}
+	data = appendUint16(data, code)
+	data = append(data, '#')
+	data = append(data, state...)
+	data = append(data, msg...)
+	c.pkt.writePacket(data)
+}
+
+// writeQueryError maps kvql errors to MySQL error, the error position is
+// shown as the query near it and the line number like MySQL does.
+func (c *conn) writeQueryError(query string, err error) {
+	var (
+		serr *kvql.SyntaxError
+		eerr *kvql.ExecuteError
+		perr *kvql.PolicyError
+	)
+	switch {
+	case errors.As(err, &serr):
+		c.writeError(ErParseError, "42000", "Syntax Error: "+serr.Message+nearQuery(query, serr.Pos))
+	case errors.As(err, &eerr):
+		c.writeError(ErUnknownError, "HY000", "Execute Error: "+eerr.Message+nearQuery(query, eerr.Pos))
+	case errors.As(err, &perr):
+		c.writeError(ErOptionPreventsStatement, "HY000", "Policy Error: "+perr.Message+nearQuery(query, perr.Pos))
+	default:
+		c.writeError(ErUnknownError, "HY000", err.Error())
+	}
+}
+
+func nearQuery(query string, pos int) string {
+	if pos < 0 || pos > len(query) {
+		pos = len(query)
+	}
+	line := strings.Count(query[:pos], "\n") + 1
+	near := query[pos:]
+	if len(near) > nearLength {
+		near = near[:nearLength]
+	}
+	return fmt.Sprintf(" near '%s' at line %d", near, line)
+}
+
+type column struct {
+	name    string
+	tp      byte
+	charset byte
+}
+
+func columnOfType(name string, tp kvql.Type) column {
+	switch tp {
+	case kvql.TBOOL:
+		return column{name, typeTiny, charsetBinary}
+	case kvql.TNUMBER:
+		// Number can be integer or float
+		return column{name, typeNewDecimal, charsetBinary}
+	case kvql.TJSON, kvql.TLIST:
+		return column{name, typeJSON, charsetBinary}
+	}
+	return column{name, typeVarString, charsetUTF8MB4}
+}
+
+func (c *conn) writeColumns(cols []column) {
+	c.pkt.writePacket(appendLenEncInt(nil, uint64(len(cols))))
+	for _, col := range cols {
+		data := appendLenEncString(nil, "def")
+		// Schema, table and original table
+		data = appendLenEncString(data, "")
+		data = appendLenEncString(data, "")
+		data = appendLenEncString(data, "")
+		data = appendLenEncString(data, col.name)
+		data = appendLenEncString(data, col.name)
+		// Length of fixed fields
+		data = append(data, 0x0c)
+		data = appendUint16(data, uint16(col.charset))
+		// Column length
+		data = appendUint32(data, 1<<24-1)
+		data = append(data, col.tp)
+		// Flags
+		data = appendUint16(data, 0)
+		// Decimals, 0x1f means not fixed
+		if col.tp == typeNewDecimal {
+			data = append(data, 0x1f)
+		} else {
+			data = append(data, 0)
+		}
+		data = append(data, 0, 0)
+		c.pkt.writePacket(data)
+	}
+	c.writeEOF(false)
+}
+
+// writeRow writes text protocol row, nil value is NULL
+func (c *conn) writeRow(values []*string) {
+	data := []byte{}
+	for _, val := range values {
+		if val == nil {
+			data = append(data, 0xfb)
+		} else {
+			data = appendLenEncString(data, *val)
+		}
+	}
+	c.pkt.writePacket(data)
+}
@@ -0,0 +1,292 @@
+package mysqlserver
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/c4pt0r/kvql"
+)
+
+// errResultAborted means the error is written to client in result set
+var errResultAborted = errors.New("mysql: result set aborted")
+
+var (
+	sysVarRegexp   = regexp.MustCompile(`^@@(?:session\.|global\.)?([a-z_]+)(?:\s+as\s+(\S+))?$`)
+	selectConstExp = regexp.MustCompile(`^select\s+(-?\d+)$`)
+)
+
+func (c *conn) handleQuery(query string) {
+	stmts := kvql.SplitStatements(query)
+	if len(stmts) == 0 {
+		c.writeError(ErEmptyQuery, "42000", "Query was empty")
+		return
+	}
+	for i, stmt := range stmts {
+		more := i < len(stmts)-1
+		err := c.executeStatement(stmt, more)
+		if err != nil {
+			if err != errResultAborted {
+				c.writeQueryError(stmt, err)
+			}
+			// Statements after the failed one are not executed
+			return
+		}
+	}
+}
+
+func (c *conn) executeStatement(stmt string, more bool) error {
+	normalized := strings.ToLower(strings.Join(strings.Fields(stmt), " "))
+	if normalized != "show warnings" {
+		c.warnings = nil
+	}
+	if c.handleCompatStatement(stmt, more) {
+		return nil
+	}
+	if normalized == "start transaction" {
+		stmt = "begin"
+	}
+	hasResult := false
+	err := c.sess.Execute(stmt, func(query string, plan kvql.FinalPlan) error {
+		hasResult = true
+		return c.writePlan(plan, more)
+	})
+	if err != nil {
+		return err
+	}
+	if !hasResult {
+		// begin, commit and rollback
+		c.writeOK(0, more)
+	}
+	return nil
+}
+
+// returnsAffectedRows returns true if the plan is write statement without
+// returning clause, it is responded by OK packet with affected rows.
+func returnsAffectedRows(plan kvql.FinalPlan) bool {
+	switch p := plan.(type) {
+	case *kvql.PutPlan, *kvql.PutSelectPlan:
+		return true
+	case *kvql.RemovePlan:
+		return p.Returning == nil
+	case *kvql.DeletePlan:
+		return p.Returning == nil
+	case *kvql.UpdatePlan:
+		return p.Returning == nil
+	}
+	return false
+}
+
+func (c *conn) writePlan(plan kvql.FinalPlan, more bool) error {
+	ctx := kvql.NewExecuteCtx()
+	if returnsAffectedRows(plan) {
+		var affected uint64
+		for {
+			rows, err := plan.Batch(ctx)
+			if err != nil {
+				return err
+			}
+			if len(rows) == 0 {
+				break
+			}
+			for _, row := range rows {
+				n, _ := row[0].(int)
+				affected += uint64(n)
+			}
+			ctx.Clear()
+		}
+		c.warnings = ctx.Warnings
+		c.writeOK(affected, more)
+		return nil
+	}
+	// Read the first batch before writing columns, so the error can be
+	// returned without result set.
+	rows, err := plan.Batch(ctx)
+	if err != nil {
+		return err
+	}
+	var (
+		names = plan.FieldNameList()
+		types = plan.FieldTypeList()
+		cols  = make([]column, len(names))
+	)
+	for i, name := range names {
+		cols[i] = columnOfType(name, types[i])
+	}
+	c.writeColumns(cols)
+	values := make([]*string, len(cols))
+	for len(rows) > 0 {
+		for _, row := range rows {
+			for i := range values {
+				values[i] = nil
+				if i < len(row) {
+					values[i] = formatValue(row[i])
+				}
+			}
+			c.writeRow(values)
+		}
+		ctx.Clear()
+		rows, err = plan.Batch(ctx)
+		if err != nil {
+			c.writeQueryError("", err)
+			return errResultAborted
+		}
+	}
+	c.warnings = ctx.Warnings
+	c.writeEOF(more)
+	return nil
+}
+
+func formatValue(col kvql.Column) *string {
+	var ret string
+	switch v := col.(type) {
+	case nil:
+		return nil
+	case string:
+		ret = kvql.DisplayString(v)
+	case []byte:
+		ret = kvql.DisplayString(string(v))
+	case bool:
+		ret = "0"
+		if v {
+			ret = "1"
+		}
+	case int:
+		ret = strconv.Itoa(v)
+	case int64:
+		ret = strconv.FormatInt(v, 10)
+	case float64:
+		ret = strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		ret = v.Format(time.RFC3339Nano)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			ret = fmt.Sprint(v)
+		} else {
+			ret = string(data)
+		}
+	}
+	return &ret
+}
+
+// handleCompatStatement responds the statements sent by MySQL clients and
+// tools which are not kvql statements, returns false if stmt is not one of
+// them.
+func (c *conn) handleCompatStatement(stmt string, more bool) bool {
+	lstmt := strings.ToLower(strings.Join(strings.Fields(stmt), " "))
+	switch {
+	case strings.HasPrefix(lstmt, "set "), strings.HasPrefix(lstmt, "use "):
+		c.writeOK(0, more)
+	case lstmt == "select database()", lstmt == "select schema()":
+		c.writeConstResult([]string{stmt[7:]}, []*string{nil}, more)
+	case lstmt == "select version()":
+		version := c.server.version()
+		c.writeConstResult([]string{"version()"}, []*string{&version}, more)
+	case lstmt == "show databases":
+		db := "kvql"
+		c.writeConstResult([]string{"Database"}, []*string{&db}, more)
+	case lstmt == "show tables":
+		c.writeConstResult([]string{"Tables_in_kvql"}, nil, more)
+	case lstmt == "show warnings":
+		c.writeWarnings(more)
+	case selectConstExp.MatchString(lstmt):
+		val := selectConstExp.FindStringSubmatch(lstmt)[1]
+		c.writeConstResult([]string{val}, []*string{&val}, more)
+	case strings.HasPrefix(lstmt, "select @@"):
+		return c.writeSysVars(stmt, more)
+	default:
+		return false
+	}
+	return true
+}
+
+// writeWarnings responds `show warnings` with the warnings of the last
+// statement
+func (c *conn) writeWarnings(more bool) {
+	c.writeColumns([]column{
+		columnOfType("Level", kvql.TSTR),
+		columnOfType("Code", kvql.TNUMBER),
+		columnOfType("Message", kvql.TSTR),
+	})
+	level, code := "Warning", strconv.Itoa(int(ErUnknownError))
+	for _, warning := range c.warnings {
+		msg := warning
+		c.writeRow([]*string{&level, &code, &msg})
+	}
+	c.writeEOF(more)
+}
+
+// writeSysVars responds `select @@var [as name], ...`
+func (c *conn) writeSysVars(stmt string, more bool) bool {
+	fields := strings.TrimSpace(stmt[len("select"):])
+	if idx := strings.LastIndex(strings.ToLower(fields), " limit "); idx >= 0 {
+		fields = fields[:idx]
+	}
+	var (
+		names  []string
+		values []*string
+	)
+	for _, field := range strings.Split(fields, ",") {
+		field = strings.TrimSpace(field)
+		m := sysVarRegexp.FindStringSubmatch(strings.ToLower(field))
+		if m == nil {
+			return false
+		}
+		name := field
+		if m[2] != "" {
+			name = field[len(field)-len(m[2]):]
+		}
+		names = append(names, name)
+		values = append(values, c.sysVar(m[1]))
+	}
+	c.writeConstResult(names, values, more)
+	return true
+}
+
+func (c *conn) sysVar(name string) *string {
+	var ret string
+	switch name {
+	case "version":
+		ret = c.server.version()
+	case "version_comment":
+		ret = "kvql"
+	case "autocommit":
+		ret = "1"
+	case "max_allowed_packet":
+		ret = strconv.Itoa(maxAllowedPacket)
+	case "character_set_client", "character_set_connection", "character_set_results", "character_set_server":
+		ret = "utf8mb4"
+	case "collation_connection", "collation_server":
+		ret = "utf8mb4_general_ci"
+	case "transaction_isolation", "tx_isolation":
+		ret = "REPEATABLE-READ"
+	case "sql_mode":
+		ret = ""
+	case "time_zone", "system_time_zone":
+		ret = "SYSTEM"
+	case "lower_case_table_names", "transaction_read_only", "tx_read_only":
+		ret = "0"
+	case "wait_timeout", "interactive_timeout":
+		ret = "28800"
+	default:
+		return nil
+	}
+	return &ret
+}
+
+func (c *conn) writeConstResult(names []string, row []*string, more bool) {
+	cols := make([]column, len(names))
+	for i, name := range names {
+		cols[i] = columnOfType(name, kvql.TSTR)
+	}
+	c.writeColumns(cols)
+	if row != nil {
+		c.writeRow(row)
+	}
+	c.writeEOF(more)
+}
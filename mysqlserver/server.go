@@ -0,0 +1,254 @@
+// Package mysqlserver serves kvql over the MySQL text protocol, so MySQL
+// clients and tools can query the storage:
+//
+//	srv := mysqlserver.NewServer(storage)
+//	err := srv.ListenAndServe("127.0.0.1:3306")
+//
+//	$ mysql -h 127.0.0.1 -P 3306 -e "select key, value where key ^= 'k'"
+//
+// It supports handshake with mysql_native_password, COM_QUERY, COM_PING,
+// COM_INIT_DB and COM_QUIT. The common statements sent by clients when
+// connecting, such as `set names utf8mb4` and `select @@version_comment`,
+// are answered by server directly.
+package mysqlserver
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/c4pt0r/kvql"
+)
+
+const DefaultServerVersion = "8.0.11-kvql"
+
+var (
+	ErrServerClosed = errors.New("mysql: server closed")
+
+	errAccessDenied = errors.New("mysql: access denied")
+)
+
+type Server struct {
+	Storage kvql.Storage
+	// Policy restricts the write statements, nil means no restriction
+	Policy *kvql.ExecutePolicy
+	// PlanCache caches the statements if it is not nil
+	PlanCache *kvql.PlanCache
+	// User and Password are checked in handshake, empty User means
+	// no authentication.
+	User     string
+	Password string
+	// Version is the server version sent to clients
+	Version string
+	// ErrorLog logs the connection errors if it is not nil
+	ErrorLog func(err error)
+
+	mu       sync.Mutex
+	listener net.Listener
+	conns    map[*conn]struct{}
+	closed   bool
+	connID   atomic.Uint32
+}
+
+func NewServer(s kvql.Storage) *Server {
+	return &Server{
+		Storage: s,
+	}
+}
+
+func (s *Server) version() string {
+	if s.Version != "" {
+		return s.Version
+	}
+	return DefaultServerVersion
+}
+
+func (s *Server) ListenAndServe(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(l)
+}
+
+// Serve accepts connections on listener until Close is called, it
+// always returns non-nil error.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		l.Close()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+	for {
+		nc, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closed := s.closed
+			s.mu.Unlock()
+			if closed {
+				return ErrServerClosed
+			}
+			return err
+		}
+		c := s.newConn(nc)
+		if c == nil {
+			return ErrServerClosed
+		}
+		go c.serve()
+	}
+}
+
+// Close stops the listener and closes all connections
+func (s *Server) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	var err error
+	if s.listener != nil {
+		err = s.listener.Close()
+	}
+	for c := range s.conns {
+		c.netConn.Close()
+	}
+	return err
+}
+
+func (s *Server) newConn(nc net.Conn) *conn {
+	sess := kvql.NewSession(s.Storage)
+	sess.Policy = s.Policy
+	sess.PlanCache = s.PlanCache
+	c := &conn{
+		server:  s,
+		netConn: nc,
+		pkt:     newPacketIO(nc),
+		id:      s.connID.Add(1),
+		sess:    sess,
+	}
+	c.pkt.maxPayload = maxHandshakePayload
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		nc.Close()
+		return nil
+	}
+	if s.conns == nil {
+		s.conns = make(map[*conn]struct{})
+	}
+	s.conns[c] = struct{}{}
+	return c
+}
+
+func (s *Server) removeConn(c *conn) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.conns, c)
+}
+
+func (s *Server) logError(err error) {
+	if s.ErrorLog != nil && err != nil {
+		s.ErrorLog(err)
+	}
+}
+
+type conn struct {
+	server  *Server
+	netConn net.Conn
+	pkt     *packetIO
+	id      uint32
+	salt    []byte
+	sess    *kvql.Session
+	// warnings of the last statement, they are counted in OK and EOF
+	// packets and responded by `show warnings`
+	warnings []string
+}
+
+func (c *conn) serve() {
+	defer func() {
+		if c.sess.InTxn() {
+			c.sess.Rollback()
+		}
+		c.netConn.Close()
+		c.server.removeConn(c)
+	}()
+	if err := c.handshake(); err != nil {
+		if err != errAccessDenied {
+			c.server.logError(err)
+		}
+		return
+	}
+	for {
+		c.pkt.resetSeq()
+		data, err := c.pkt.readPacket()
+		if err != nil {
+			if err == errPacketTooLarge {
+				c.writeError(ErNetPacketTooLarge, "08S01", "Got a packet bigger than 'max_allowed_packet' bytes")
+				c.pkt.flush()
+			}
+			if err != io.EOF {
+				c.server.logError(err)
+			}
+			return
+		}
+		if len(data) == 0 {
+			return
+		}
+		if quit := c.dispatch(data[0], data[1:]); quit {
+			return
+		}
+		if err = c.pkt.flush(); err != nil {
+			c.server.logError(err)
+			return
+		}
+	}
+}
+
+func (c *conn) handshake() error {
+	var err error
+	if c.salt, err = newSalt(); err != nil {
+		return err
+	}
+	if err = c.writeHandshake(); err != nil {
+		return err
+	}
+	data, err := c.pkt.readPacket()
+	if err != nil {
+		return err
+	}
+	resp, err := parseHandshakeResponse(data)
+	if err != nil {
+		return err
+	}
+	if err = c.authenticate(resp); err != nil {
+		return err
+	}
+	c.pkt.maxPayload = maxAllowedPacket
+	c.writeOK(0, false)
+	return c.pkt.flush()
+}
+
+func (c *conn) dispatch(cmd byte, data []byte) bool {
+	switch cmd {
+	case comQuit:
+		return true
+	case comPing, comInitDB:
+		c.writeOK(0, false)
+	case comResetCon:
+		if c.sess.InTxn() {
+			c.sess.Rollback()
+		}
+		c.writeOK(0, false)
+	case comQuery:
+		c.handleQuery(string(data))
+	default:
+		c.writeError(ErUnknownCommand, "08S01", "Command not supported")
+	}
+	return false
+}
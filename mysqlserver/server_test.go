@@ -0,0 +1,395 @@
+package mysqlserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/c4pt0r/kvql"
+	"github.com/c4pt0r/kvql/memstore"
+)
+
+// testClient is a minimal MySQL text protocol client
+type testClient struct {
+	t   *testing.T
+	nc  net.Conn
+	pkt *packetIO
+}
+
+type testResult struct {
+	columns  []string
+	types    []byte
+	rows     [][]*string
+	affected uint64
+	status   uint16
+	warnings uint16
+}
+
+type testError struct {
+	code uint16
+	msg  string
+}
+
+func (e *testError) Error() string {
+	return fmt.Sprintf("%d: %s", e.code, e.msg)
+}
+
+func startTestServer(t *testing.T, setup func(s *Server)) string {
+	s := memstore.New()
+	for i := 0; i < 10; i++ {
+		s.Put([]byte(fmt.Sprintf("k_%02d", i)), []byte(fmt.Sprintf(`{"id": %d}`, i)))
+	}
+	srv := NewServer(s)
+	if setup != nil {
+		setup(srv)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		done <- srv.Serve(l)
+	}()
+	t.Cleanup(func() {
+		srv.Close()
+		if err := <-done; err != ErrServerClosed {
+			t.Error("Unexpected serve error", err)
+		}
+	})
+	return l.Addr().String()
+}
+
+func dialTestServer(t *testing.T, addr string, user string, password string) (*testClient, error) {
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		nc.Close()
+	})
+	c := &testClient{t: t, nc: nc, pkt: newPacketIO(nc)}
+	data, err := c.pkt.readPacket()
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &packetReader{data: data}
+	r.readByte()
+	r.readNullString()
+	r.readUint32()
+	salt, _ := r.readBytes(8)
+	r.readBytes(1 + 2 + 1 + 2 + 2 + 1 + 10)
+	rest, _ := r.readNullString()
+	salt = append(salt, rest...)
+
+	capability := clientProtocol41 | clientSecureConn | clientPluginAuth | clientMultiStatements | clientMultiResults
+	resp := appendUint32(nil, capability)
+	resp = appendUint32(resp, maxPacketSize)
+	resp = append(resp, charsetUTF8MB4)
+	resp = append(resp, make([]byte, 23)...)
+	resp = append(resp, user...)
+	resp = append(resp, 0)
+	auth := nativePassword(salt, password)
+	resp = append(resp, byte(len(auth)))
+	resp = append(resp, auth...)
+	resp = append(resp, nativePasswordPlugin...)
+	resp = append(resp, 0)
+	c.pkt.writePacket(resp)
+	if err = c.pkt.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.readResult(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *testClient) command(cmd byte, arg string) {
+	c.pkt.resetSeq()
+	c.pkt.writePacket(append([]byte{cmd}, arg...))
+	if err := c.pkt.flush(); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) readPacket() []byte {
+	data, err := c.pkt.readPacket()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return data
+}
+
+// readResult reads OK, error or result set
+func (c *testClient) readResult() (*testResult, error) {
+	data := c.readPacket()
+	r := &packetReader{data: data[1:]}
+	switch data[0] {
+	case headerOK:
+		ret := &testResult{}
+		ret.affected, _ = r.readLenEncInt()
+		r.readLenEncInt()
+		b, _ := r.readBytes(4)
+		ret.status = uint16(b[0]) | uint16(b[1])<<8
+		ret.warnings = uint16(b[2]) | uint16(b[3])<<8
+		return ret, nil
+	case headerERR:
+		return nil, c.parseError(data)
+	}
+	r = &packetReader{data: data}
+	ncols, _ := r.readLenEncInt()
+	ret := &testResult{}
+	for i := 0; i < int(ncols); i++ {
+		r := &packetReader{data: c.readPacket()}
+		for j := 0; j < 4; j++ {
+			r.readLenEncString()
+		}
+		name, _ := r.readLenEncString()
+		r.readLenEncString()
+		r.readBytes(1 + 2 + 4)
+		tp, _ := r.readByte()
+		ret.columns = append(ret.columns, name)
+		ret.types = append(ret.types, tp)
+	}
+	if data := c.readPacket(); data[0] != headerEOF {
+		c.t.Fatal("Expect EOF after columns", data)
+	}
+	for {
+		data := c.readPacket()
+		switch {
+		case data[0] == headerEOF && len(data) < 9:
+			ret.warnings = uint16(data[1]) | uint16(data[2])<<8
+			ret.status = uint16(data[3]) | uint16(data[4])<<8
+			return ret, nil
+		case data[0] == headerERR:
+			return nil, c.parseError(data)
+		}
+		r := &packetReader{data: data}
+		row := make([]*string, len(ret.columns))
+		for i := range row {
+			if r.data[r.pos] == 0xfb {
+				r.pos++
+				continue
+			}
+			val, err := r.readLenEncString()
+			if err != nil {
+				c.t.Fatal(err)
+			}
+			row[i] = &val
+		}
+		ret.rows = append(ret.rows, row)
+	}
+}
+
+func (c *testClient) parseError(data []byte) error {
+	code := uint16(data[1]) | uint16(data[2])<<8
+	return &testError{code: code, msg: string(data[9:])}
+}
+
+func (c *testClient) query(q string) (*testResult, error) {
+	c.command(comQuery, q)
+	return c.readResult()
+}
+
+func (r *testResult) String() string {
+	rows := []string{}
+	for _, row := range r.rows {
+		vals := []string{}
+		for _, v := range row {
+			if v == nil {
+				vals = append(vals, "NULL")
+			} else {
+				vals = append(vals, *v)
+			}
+		}
+		rows = append(rows, strings.Join(vals, ","))
+	}
+	return strings.Join(rows, "|")
+}
+
+func TestQuery(t *testing.T) {
+	addr := startTestServer(t, nil)
+	c, err := dialTestServer(t, addr, "root", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, err := c.query("select key, int(json(value)['id']) * 2 as v, json(value), key = 'k_07' as b where key ^= 'k_0' & int(json(value)['id']) >= 7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(ret.columns, ",") != "KEY,v,json(VALUE),b" {
+		t.Fatal("Unexpected columns", ret.columns)
+	}
+	if string(ret.types) != string([]byte{typeVarString, typeNewDecimal, typeJSON, typeTiny}) {
+		t.Fatal("Unexpected column types", ret.types)
+	}
+	if ret.String() != `k_07,14,{"id":7},1|k_08,16,{"id":8},0|k_09,18,{"id":9},0` {
+		t.Fatal("Unexpected result", ret)
+	}
+
+	ret, err = c.query("select key where key = 'not_exists'")
+	if err != nil || len(ret.rows) != 0 {
+		t.Fatal("Unexpected empty result", ret, err)
+	}
+
+	c.command(comPing, "")
+	if _, err = c.readResult(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	addr := startTestServer(t, nil)
+	c, err := dialTestServer(t, addr, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, err := c.query("put ('a', '1'), ('b', '2')")
+	if err != nil || ret.affected != 2 {
+		t.Fatal("Unexpected put", ret, err)
+	}
+	ret, err = c.query("delete where key ^= 'k_0' & int(json(value)['id']) < 3")
+	if err != nil || ret.affected != 3 {
+		t.Fatal("Unexpected delete", ret, err)
+	}
+	ret, err = c.query("select count(1) where key >= ''")
+	if err != nil || ret.String() != "9" {
+		t.Fatal("Unexpected count", ret, err)
+	}
+
+	// Multiple statements in transaction
+	c.command(comQuery, "start transaction; put ('c', '3'); select count(1) where key >= ''")
+	for i, expect := range []string{"", "", "10"} {
+		ret, err = c.readResult()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ret.status&statusInTrans == 0 {
+			t.Fatal("Expect in transaction")
+		}
+		if i < 2 && ret.status&statusMoreResultsExists == 0 {
+			t.Fatal("Expect more results", i)
+		}
+		if ret.String() != expect {
+			t.Fatal("Unexpected result", i, ret)
+		}
+	}
+	if ret.status&statusMoreResultsExists != 0 {
+		t.Fatal("Unexpected more results")
+	}
+	if _, err = c.query("rollback"); err != nil {
+		t.Fatal(err)
+	}
+	ret, err = c.query("select count(1) where key >= ''")
+	if err != nil || ret.String() != "9" || ret.status&statusInTrans != 0 {
+		t.Fatal("Unexpected count after rollback", ret, err)
+	}
+}
+
+// nonAtomicStorage hides the atomic writes of memstore
+type nonAtomicStorage struct {
+	kvql.Storage
+}
+
+func TestWarnings(t *testing.T) {
+	addr := startTestServer(t, func(s *Server) {
+		s.Storage = nonAtomicStorage{s.Storage}
+	})
+	c, err := dialTestServer(t, addr, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, err := c.query("put if not exists ('a', '1'), ('k_01', '2')")
+	if err != nil || ret.affected != 1 || ret.warnings != 1 {
+		t.Fatal("Unexpected put", ret, err)
+	}
+	ret, err = c.query("show warnings")
+	if err != nil || len(ret.rows) != 1 || *ret.rows[0][0] != "Warning" || !strings.Contains(*ret.rows[0][2], "not atomic") {
+		t.Fatal("Unexpected warnings", ret, err)
+	}
+	ret, err = c.query("select count(1) where key >= ''")
+	if err != nil || ret.warnings != 0 {
+		t.Fatal("Unexpected select", ret, err)
+	}
+	ret, err = c.query("show warnings")
+	if err != nil || len(ret.rows) != 0 {
+		t.Fatal("Warnings should be cleared", ret, err)
+	}
+}
+
+func TestQueryError(t *testing.T) {
+	addr := startTestServer(t, func(s *Server) {
+		s.Policy = &kvql.ExecutePolicy{ReadOnly: true}
+	})
+	c, err := dialTestServer(t, addr, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.query("select *\nwhere key ^=")
+	var terr *testError
+	if !errors.As(err, &terr) || terr.code != ErParseError || !strings.HasSuffix(terr.msg, "at line 2") {
+		t.Fatal("Unexpected syntax error", err)
+	}
+	_, err = c.query("select 10 / (int(json(value)['id']) - 1) where key ^= 'k_0'")
+	if !errors.As(err, &terr) || terr.code != ErUnknownError || !strings.HasPrefix(terr.msg, "Execute Error:") {
+		t.Fatal("Unexpected execute error", err)
+	}
+	_, err = c.query("put ('a', '1')")
+	if !errors.As(err, &terr) || terr.code != ErOptionPreventsStatement {
+		t.Fatal("Unexpected policy error", err)
+	}
+	_, err = c.query("")
+	if !errors.As(err, &terr) || terr.code != ErEmptyQuery {
+		t.Fatal("Unexpected empty query error", err)
+	}
+	// Connection is still usable after errors
+	ret, err := c.query("select @@version_comment limit 1")
+	if err != nil || ret.String() != "kvql" {
+		t.Fatal("Unexpected version comment", ret, err)
+	}
+	ret, err = c.query("select @@session.autocommit as ac, @@max_allowed_packet")
+	if err != nil || strings.Join(ret.columns, ",") != "ac,@@max_allowed_packet" || ret.String() != "1,67108864" {
+		t.Fatal("Unexpected system variables", ret, err)
+	}
+	if _, err = c.query("set names utf8mb4"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	addr := startTestServer(t, func(s *Server) {
+		s.User = "kvql"
+		s.Password = "secret"
+	})
+	if _, err := dialTestServer(t, addr, "kvql", "secret"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := dialTestServer(t, addr, "kvql", "wrong")
+	var terr *testError
+	if !errors.As(err, &terr) || terr.code != ErAccessDenied {
+		t.Fatal("Unexpected auth error", err)
+	}
+}
+
+func TestHandshakePacketTooLarge(t *testing.T) {
+	addr := startTestServer(t, nil)
+	nc, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	pkt := newPacketIO(nc)
+	if _, err = pkt.readPacket(); err != nil {
+		t.Fatal(err)
+	}
+	// Server should close the connection without reading the payload
+	header := []byte{0xff, 0xff, 0xff, pkt.seq}
+	if _, err = nc.Write(header); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = pkt.readPacket(); err == nil {
+		t.Fatal("Should close the connection")
+	}
+}
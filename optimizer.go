@@ -6,6 +6,11 @@ type Optimizer struct {
 	Query  string
 	stmt   Statement
 	filter *FilterExec
+	// skipKeys is not nil means storage scan plan should skip the keys
+	skipKeys *writtenKeys
+	policy   *ExecutePolicy
+	// numSlots is the number of common sub-expression slots
+	numSlots int
 }
 
 func NewOptimizer(query string) *Optimizer {
@@ -20,20 +25,74 @@ func (o *Optimizer) init() error {
 	if err != nil {
 		return err
 	}
-	o.stmt = stmt
+	if len(p.params) > 0 {
+		return NewSyntaxError(p.params[0].Pos, "Query has parameters, should use Prepare and Bind")
+	}
+	o.prepare(stmt)
+	return nil
+}
+
+// prepare optimizes the expressions of statement, it will not be called
+// again when binding parameters of prepared statement.
+func (o *Optimizer) prepare(stmt Statement) {
 	switch vstmt := stmt.(type) {
 	case *SelectStmt:
-		o.optimizeSelectExpressions(vstmt)
-		o.filter = &FilterExec{
-			Ast: vstmt.Where,
+		if vstmt.With != nil {
+			for _, cte := range vstmt.With.CTEs {
+				if cte.Materialized {
+					o.optimizeSelectExpressions(cte.Select)
+					o.eliminateCommonExprs(selectStmtExprs(cte.Select)...)
+				}
+			}
 		}
+		o.optimizeSelectExpressions(vstmt)
+		o.eliminateCommonExprs(selectStmtExprs(vstmt)...)
 	case *DeleteStmt:
 		o.optimizeDeleteExpressions(vstmt)
-		o.filter = &FilterExec{
-			Ast: vstmt.Where,
+		o.optimizeReturningExpressions(vstmt.Returning)
+		o.eliminateCommonExprs(append([]Expression{vstmt.Where.Expr}, returningExprs(vstmt.Returning)...)...)
+	case *RemoveStmt:
+		o.optimizeReturningExpressions(vstmt.Returning)
+		o.eliminateCommonExprs(returningExprs(vstmt.Returning)...)
+	case *PutStmt:
+		if vstmt.Select != nil {
+			o.optimizeSelectExpressions(vstmt.Select)
+			o.eliminateCommonExprs(selectStmtExprs(vstmt.Select)...)
+		}
+	case *UpdateStmt:
+		o.optimizeUpdateExpressions(vstmt)
+		o.optimizeReturningExpressions(vstmt.Returning)
+		o.eliminateCommonExprs(append([]Expression{vstmt.Where.Expr, vstmt.Value}, returningExprs(vstmt.Returning)...)...)
+	}
+	o.setStatement(stmt)
+}
+
+// eliminateCommonExprs assigns slots to the common sub-expressions of the
+// expressions executed on same rows.
+func (o *Optimizer) eliminateCommonExprs(exprs ...Expression) {
+	ceo := CommonExprOptimizer{
+		Roots:    exprs,
+		NumSlots: o.numSlots,
+	}
+	o.numSlots = ceo.Optimize()
+}
+
+// setStatement sets the optimized statement and the filter of it
+func (o *Optimizer) setStatement(stmt Statement) {
+	o.stmt = stmt
+	o.filter = nil
+	switch vstmt := stmt.(type) {
+	case *SelectStmt:
+		o.filter = &FilterExec{Ast: vstmt.Where}
+	case *DeleteStmt:
+		o.filter = &FilterExec{Ast: vstmt.Where}
+	case *UpdateStmt:
+		o.filter = &FilterExec{Ast: vstmt.Where}
+	case *PutStmt:
+		if vstmt.Select != nil {
+			o.filter = &FilterExec{Ast: vstmt.Select.Where}
 		}
 	}
-	return nil
 }
 
 func (o *Optimizer) optimizeDeleteExpressions(stmt *DeleteStmt) {
@@ -43,6 +102,26 @@ func (o *Optimizer) optimizeDeleteExpressions(stmt *DeleteStmt) {
 	stmt.Where.Expr = eo.Optimize()
 }
 
+func (o *Optimizer) optimizeUpdateExpressions(stmt *UpdateStmt) {
+	eo := ExpressionOptimizer{
+		Root: stmt.Where.Expr,
+	}
+	stmt.Where.Expr = eo.Optimize()
+	eo.Root = stmt.Value
+	stmt.Value = eo.Optimize()
+}
+
+func (o *Optimizer) optimizeReturningExpressions(stmt *ReturningStmt) {
+	if stmt == nil {
+		return
+	}
+	eo := ExpressionOptimizer{}
+	for i, field := range stmt.Fields {
+		eo.Root = field
+		stmt.Fields[i] = eo.Optimize()
+	}
+}
+
 func (o *Optimizer) optimizeSelectExpressions(stmt *SelectStmt) {
 	eo := ExpressionOptimizer{
 		Root: stmt.Where.Expr,
@@ -182,6 +261,14 @@ func (o *Optimizer) buildPlan(s Storage) (FinalPlan, error) {
 	if err != nil {
 		return nil, err
 	}
+	return o.buildStmtPlan(s)
+}
+
+func (o *Optimizer) buildStmtPlan(s Storage) (FinalPlan, error) {
+	err := o.policy.checkStatement(o.stmt)
+	if err != nil {
+		return nil, err
+	}
 	switch stmt := o.stmt.(type) {
 	case *SelectStmt:
 		return o.buildSelectPlan(s, stmt)
@@ -191,15 +278,23 @@ func (o *Optimizer) buildPlan(s Storage) (FinalPlan, error) {
 		return o.buildRemovePlan(s, stmt)
 	case *DeleteStmt:
 		return o.buildDeletePlan(s, stmt)
+	case *UpdateStmt:
+		return o.buildUpdatePlan(s, stmt)
 	default:
 		return nil, fmt.Errorf("Cannot build query plan without a select statement")
 	}
 }
 
 func (o *Optimizer) buildPutPlan(s Storage, stmt *PutStmt) (FinalPlan, error) {
+	if stmt.Select != nil {
+		return o.buildPutSelectPlan(s, stmt)
+	}
 	plan := &PutPlan{
-		Storage: s,
-		KVPairs: stmt.KVPairs,
+		Storage:     s,
+		KVPairs:     stmt.KVPairs,
+		IfNotExists: stmt.IfNotExists,
+		OldValue:    stmt.OldValue,
+		RowsLimit:   o.policy.rowsLimit(stmt.Pos, false),
 	}
 	err := plan.Init()
 	if err != nil {
@@ -208,10 +303,34 @@ func (o *Optimizer) buildPutPlan(s Storage, stmt *PutStmt) (FinalPlan, error) {
 	return plan, nil
 }
 
+func (o *Optimizer) buildPutSelectPlan(s Storage, stmt *PutStmt) (FinalPlan, error) {
+	// Written keys should not be read again if put target overlaps
+	// the select scan range.
+	o.skipKeys = newWrittenKeys()
+	sfp, err := o.buildSelectPlan(s, stmt.Select)
+	if err != nil {
+		return nil, err
+	}
+	plan := &PutSelectPlan{
+		Storage:   s,
+		ChildPlan: sfp,
+		Written:   o.skipKeys,
+		RowsLimit: o.policy.rowsLimit(stmt.Pos, false),
+	}
+	err = plan.Init()
+	if err != nil {
+		return nil, err
+	}
+	return plan, nil
+}
+
 func (o *Optimizer) buildRemovePlan(s Storage, stmt *RemoveStmt) (FinalPlan, error) {
 	plan := &RemovePlan{
-		Storage: s,
-		Keys:    stmt.Keys,
+		Storage:   s,
+		Keys:      stmt.Keys,
+		Returning: stmt.Returning,
+		DryRun:    stmt.DryRun,
+		RowsLimit: o.policy.rowsLimit(stmt.Pos, stmt.DryRun),
 	}
 	err := plan.Init()
 	if err != nil {
@@ -220,7 +339,7 @@ func (o *Optimizer) buildRemovePlan(s Storage, stmt *RemoveStmt) (FinalPlan, err
 	return plan, nil
 }
 
-func (o *Optimizer) optimizeDeletePlanToRemovePlan(s Storage, mgPlan *MultiGetPlan) (FinalPlan, error) {
+func (o *Optimizer) optimizeDeletePlanToRemovePlan(s Storage, stmt *DeleteStmt, mgPlan *MultiGetPlan) (FinalPlan, error) {
 	keys := make([]Expression, len(mgPlan.Keys))
 	for i, key := range mgPlan.Keys {
 		kexpr := &StringExpr{
@@ -231,8 +350,11 @@ func (o *Optimizer) optimizeDeletePlanToRemovePlan(s Storage, mgPlan *MultiGetPl
 	}
 
 	removePlan := &RemovePlan{
-		Storage: s,
-		Keys:    keys,
+		Storage:   s,
+		Keys:      keys,
+		Returning: stmt.Returning,
+		DryRun:    stmt.DryRun,
+		RowsLimit: o.policy.rowsLimit(stmt.Pos, stmt.DryRun),
 	}
 	err := removePlan.Init()
 	return removePlan, err
@@ -265,6 +387,10 @@ func (o *Optimizer) buildDeletePlan(s Storage, stmt *DeleteStmt) (FinalPlan, err
 	var err error
 	// Build Scan
 	fp := o.buildScanPlan(s)
+	err = o.policy.checkScanPlan(fp, stmt.Where, stmt.DryRun)
+	if err != nil {
+		return nil, err
+	}
 
 	// Just build an empyt result plan so we can
 	// ignore limit plan just return the delete plan
@@ -273,6 +399,8 @@ func (o *Optimizer) buildDeletePlan(s Storage, stmt *DeleteStmt) (FinalPlan, err
 		delPlan := &DeletePlan{
 			Storage:   s,
 			ChildPlan: fp,
+			Returning: stmt.Returning,
+			DryRun:    stmt.DryRun,
 		}
 		err = delPlan.Init()
 		if err != nil {
@@ -284,13 +412,16 @@ func (o *Optimizer) buildDeletePlan(s Storage, stmt *DeleteStmt) (FinalPlan, err
 	if mgPlan, ok := fp.(*MultiGetPlan); ok && stmt.Limit == nil {
 		// Only multi get plan and no limit statement can be optimize to remove plan
 		if o.canOptimizeDeletePlanToRemovePlan(mgPlan) {
-			return o.optimizeDeletePlanToRemovePlan(s, mgPlan)
+			return o.optimizeDeletePlanToRemovePlan(s, stmt, mgPlan)
 		}
 	}
 
 	delPlan := &DeletePlan{
 		Storage:   s,
 		ChildPlan: fp,
+		Returning: stmt.Returning,
+		DryRun:    stmt.DryRun,
+		RowsLimit: o.policy.rowsLimit(stmt.Pos, stmt.DryRun),
 	}
 
 	if stmt.Limit != nil {
@@ -309,9 +440,48 @@ func (o *Optimizer) buildDeletePlan(s Storage, stmt *DeleteStmt) (FinalPlan, err
 	return delPlan, nil
 }
 
-func (o *Optimizer) buildSelectPlan(s Storage, stmt *SelectStmt) (FinalPlan, error) {
+func (o *Optimizer) buildUpdatePlan(s Storage, stmt *UpdateStmt) (FinalPlan, error) {
 	// Build Scan
 	fp := o.buildScanPlan(s)
+	err := o.policy.checkScanPlan(fp, stmt.Where, stmt.DryRun)
+	if err != nil {
+		return nil, err
+	}
+	updatePlan := &UpdatePlan{
+		Storage:   s,
+		Value:     stmt.Value,
+		ChildPlan: fp,
+		Returning: stmt.Returning,
+		DryRun:    stmt.DryRun,
+		RowsLimit: o.policy.rowsLimit(stmt.Pos, stmt.DryRun),
+	}
+
+	// Empty result plan do not need limit plan
+	if _, ok := fp.(*EmptyResultPlan); !ok && stmt.Limit != nil {
+		updatePlan.ChildPlan = &LimitPlan{
+			Storage:   s,
+			Start:     stmt.Limit.Start,
+			Count:     stmt.Limit.Count,
+			ChildPlan: fp,
+		}
+	}
+	err = updatePlan.Init()
+	if err != nil {
+		return nil, err
+	}
+	return updatePlan, nil
+}
+
+func (o *Optimizer) buildSelectPlan(s Storage, stmt *SelectStmt) (FinalPlan, error) {
+	return o.buildSelectPlanWithFilter(s, stmt, o.filter)
+}
+
+func (o *Optimizer) buildSelectPlanWithFilter(s Storage, stmt *SelectStmt, filter *FilterExec) (FinalPlan, error) {
+	// Build Scan
+	fp, err := o.buildSelectScanPlan(s, stmt, filter)
+	if err != nil {
+		return nil, err
+	}
 
 	// Just build an empty result plan so we can
 	// ignore order and limit plan just return
@@ -339,7 +509,41 @@ func (o *Optimizer) buildSelectPlan(s Storage, stmt *SelectStmt) (FinalPlan, err
 	return ret, nil
 }
 
-func (o *Optimizer) BuildPlan(s Storage) (FinalPlan, error) {
+func (o *Optimizer) buildSelectScanPlan(s Storage, stmt *SelectStmt, filter *FilterExec) (Plan, error) {
+	if stmt.From == nil || stmt.From.Scan == nil {
+		fopt := NewFilterOptimizer(filter.Ast, s, filter)
+		fp := fopt.Optimize()
+		if _, ok := fp.(*EmptyResultPlan); !ok && o.skipKeys != nil {
+			o.skipKeys.scan = fp
+			fp = &SkipKeysPlan{
+				ChildPlan: fp,
+				Keys:      o.skipKeys,
+			}
+		}
+		return fp, nil
+	}
+	cte := stmt.From.Scan
+	if cte.result == nil {
+		// Materialized CTE will be shared by all the references, it is
+		// loaded before any write so the written keys are not skipped.
+		skipKeys := o.skipKeys
+		o.skipKeys = nil
+		plan, err := o.buildSelectPlanWithFilter(s, cte.Select, &FilterExec{Ast: cte.Select.Where})
+		o.skipKeys = skipKeys
+		if err != nil {
+			return nil, err
+		}
+		cte.result = &cteResult{plan: plan}
+	}
+	return NewCTEScanPlan(s, filter, cte), nil
+}
+
+// BuildPlan builds the query plan on storage, the write statements will be
+// restricted by policy if it is given.
+func (o *Optimizer) BuildPlan(s Storage, policy ...*ExecutePolicy) (FinalPlan, error) {
+	if len(policy) > 0 {
+		o.policy = policy[0]
+	}
 	ret, err := o.buildPlan(s)
 	if err != nil {
 		return nil, err
@@ -1,6 +1,10 @@
 package kvql
 
-import "testing"
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
 
 type builderTest struct {
 	query     string
@@ -81,3 +85,329 @@ func TestOptimizeDelete2(t *testing.T) {
 		}
 	}
 }
+
+func TestOptimizeUpdate(t *testing.T) {
+	queries := []string{
+		"update set value = upper(value) where key ^= 'name_'",
+		"update set value = 'v' where key in ('k1', 'k2') limit 1",
+	}
+	for _, query := range queries {
+		plan, err := buildPlan(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p, ok := plan.(*UpdatePlan)
+		if !ok {
+			t.Fatal("Should build update plan")
+		}
+		switch cp := p.ChildPlan.(type) {
+		case *PrefixScanPlan:
+		case *LimitPlan:
+			if _, ok := cp.ChildPlan.(*MultiGetPlan); !ok {
+				t.Fatal("Should optimize as multi get plan")
+			}
+		default:
+			t.Fatal("Should optimize scan plan")
+		}
+	}
+}
+
+func TestUpdatePlan(t *testing.T) {
+	data := []KVPair{}
+	for i := 0; i < 100; i++ {
+		data = append(data, NewKVPStr(fmt.Sprintf("name_%02d", i), fmt.Sprintf("v%d", i)))
+		data = append(data, NewKVPStr(fmt.Sprintf("other_%02d", i), "v"))
+	}
+	txn := newMockQueryStorage(data)
+	rows := collectRows(t, txn, "update set value = upper(value) + '_' + key where key ^= 'name_' & key < 'name_50'", true)
+	if len(rows) != 1 || rows[0] != "50" {
+		t.Fatal("Should update 50 rows, got", rows)
+	}
+	rows = collectRows(t, txn, "update set value = 'x' where key ^= 'other_' limit 5", false)
+	if len(rows) != 1 || rows[0] != "5" {
+		t.Fatal("Should update 5 rows, got", rows)
+	}
+	rows = collectRows(t, txn, "select key, value where key in ('name_01', 'name_60', 'other_00', 'other_05')", true)
+	expected := "name_01,V1_name_01|name_60,v60|other_00,x|other_05,v"
+	if strings.Join(rows, "|") != expected {
+		t.Fatal("Unexpected result", rows)
+	}
+}
+
+func TestPutSelectPlan(t *testing.T) {
+	data := []KVPair{}
+	for i := 0; i < 100; i++ {
+		data = append(data, NewKVPStr(fmt.Sprintf("v1:user:%02d", i), fmt.Sprintf("name%d", i)))
+	}
+	txn := newMockQueryStorage(data)
+	rows := collectRows(t, txn, "put select 'v2:' + key, upper(value) where key ^= 'v1:user:'", true)
+	if len(rows) != 1 || rows[0] != "100" {
+		t.Fatal("Should put 100 rows, got", rows)
+	}
+	rows = collectRows(t, txn, "select * where key in ('v1:user:05', 'v2:v1:user:05')", true)
+	if strings.Join(rows, "|") != "v1:user:05,name5|v2:v1:user:05,NAME5" {
+		t.Fatal("Unexpected result", rows)
+	}
+
+	// Target keys overlap the source range should not be read again
+	for _, batch := range []bool{true, false} {
+		rows = collectRows(t, txn, "put select key + '_x', value where key ^= 'v2:' limit 1000", batch)
+		if len(rows) != 1 || rows[0] != "100" {
+			t.Fatal("Should put 100 rows, got", rows)
+		}
+		rows = collectRows(t, txn, "delete where key ^= 'v2:' & key ~= '_x$'", batch)
+		if len(rows) != 1 || rows[0] != "100" {
+			t.Fatal("Should delete 100 rows, got", rows)
+		}
+	}
+
+	// Only the keys which may be scanned again are kept
+	for _, query := range []string{
+		"put select 'v3:' + key, value where key ^= 'v1:user:'",
+		"put select key + '_x', value where key ^= 'v2:'",
+	} {
+		plan, err := NewOptimizer(query).BuildPlan(txn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		written := plan.(*PutSelectPlan).Written
+		if rows = collectPlanRows(t, plan, true); rows[0] != "100" {
+			t.Fatal("Should put 100 rows, got", rows)
+		}
+		for key := range written.keys {
+			if !strings.HasPrefix(key, "v2:") || key <= string(written.last) {
+				t.Fatal(query, "should not keep written key", key)
+			}
+		}
+	}
+	written := &writtenKeys{scan: &PrefixScanPlan{Prefix: "v2:"}, keys: make(map[string]struct{})}
+	written.visit([]byte("v2:05"))
+	for _, key := range []string{"v1:01", "v2:01", "v2:05", "v2:06", "v3:01"} {
+		written.add(key)
+	}
+	if len(written.keys) != 1 || !written.has([]byte("v2:06")) {
+		t.Fatal("Unexpected written keys", written.keys)
+	}
+}
+
+func TestPutSelectPlanError(t *testing.T) {
+	queries := []string{
+		"put select key where key ^= 'k'",
+		"put select key, value, value where key ^= 'k'",
+		"put select key, key = 'k' where key ^= 'k'",
+		"put select key, value",
+	}
+	for _, query := range queries {
+		if _, err := buildPlan(query); err == nil {
+			t.Fatal("Should get error:", query)
+		}
+	}
+}
+
+type mockAtomicStorage struct {
+	*mockQueryStorage
+	calls int
+}
+
+func (t *mockAtomicStorage) PutIfNotExists(key []byte, value []byte) (bool, error) {
+	t.calls++
+	if val, _ := t.Get(key); val != nil {
+		return false, nil
+	}
+	return true, t.Put(key, value)
+}
+
+func (t *mockAtomicStorage) CompareAndSwap(key []byte, oldValue []byte, newValue []byte) (bool, error) {
+	t.calls++
+	if val, _ := t.Get(key); val == nil || string(val) != string(oldValue) {
+		return false, nil
+	}
+	return true, t.Put(key, newValue)
+}
+
+func TestConditionalPut(t *testing.T) {
+	newStorages := func() []Storage {
+		data := []KVPair{NewKVPStr("k1", "v1"), NewKVPStr("k2", "v2")}
+		data2 := []KVPair{NewKVPStr("k1", "v1"), NewKVPStr("k2", "v2")}
+		return []Storage{
+			newMockQueryStorage(data),
+			&mockAtomicStorage{mockQueryStorage: newMockQueryStorage(data2)},
+		}
+	}
+	for _, txn := range newStorages() {
+		_, atomic := txn.(*mockAtomicStorage)
+		opt := NewOptimizer("put if not exists ('k1', 'x1'), ('k3', 'x3')")
+		plan, err := opt.BuildPlan(txn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(plan.FieldNameList()) != 2 {
+			t.Fatal("Conditional put should return rows and skipped columns")
+		}
+		ctx := NewExecuteCtx()
+		row, err := plan.Next(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if row[0] != 1 || row[1] != 1 {
+			t.Fatal("Should write 1 row and skip 1 row, got", row)
+		}
+		if atomic == (len(ctx.Warnings) > 0) {
+			t.Fatal("Only non-atomic storage should get warning", ctx.Warnings)
+		}
+
+		rows := collectRows(t, txn, "put ('k2', 'new'), ('k1', 'new'), ('k4', 'new') if value = 'v' + '2'", true)
+		if len(rows) != 1 || rows[0] != "1,2" {
+			t.Fatal("Should write 1 row and skip 2 rows, got", rows)
+		}
+		rows = collectRows(t, txn, "select * where key in ('k1', 'k2', 'k3', 'k4')", true)
+		if strings.Join(rows, "|") != "k1,v1|k2,new|k3,x3" {
+			t.Fatal("Unexpected result", rows)
+		}
+		if atomic && txn.(*mockAtomicStorage).calls != 5 {
+			t.Fatal("Should use atomic storage interface")
+		}
+	}
+}
+
+func TestReturningPlan(t *testing.T) {
+	for _, batch := range []bool{true, false} {
+		data := []KVPair{}
+		for i := 0; i < 300; i++ {
+			data = append(data, NewKVPStr(fmt.Sprintf("k_%03d", i), fmt.Sprintf("v%d", i)))
+		}
+		txn := newMockQueryStorage(data)
+		rows := collectRows(t, txn, "update set value = upper(value) where key ^= 'k_' & key < 'k_003' returning key, value as v", batch)
+		if strings.Join(rows, "|") != "k_000,V0|k_001,V1|k_002,V2" {
+			t.Fatal("Unexpected update returning", rows)
+		}
+		// Delete streams affected rows over multiple batches
+		rows = collectRows(t, txn, "delete where key ^= 'k_' & key >= 'k_100' returning key", batch)
+		if len(rows) != 200 || rows[0] != "k_100" || rows[199] != "k_299" {
+			t.Fatal("Unexpected delete returning", len(rows))
+		}
+		// Not exists keys should not be returned
+		rows = collectRows(t, txn, "remove 'k_001', 'k_200' returning key, value", batch)
+		if strings.Join(rows, "|") != "k_001,V1" {
+			t.Fatal("Unexpected remove returning", rows)
+		}
+		rows = collectRows(t, txn, "delete where key in ('k_002', 'k_999') returning *", batch)
+		if strings.Join(rows, "|") != "k_002,V2" {
+			t.Fatal("Unexpected delete returning", rows)
+		}
+		rows = collectRows(t, txn, "select count(1) where key ^= 'k_'", batch)
+		if len(rows) != 1 || rows[0] != "98" {
+			t.Fatal("Unexpected remaining rows", rows)
+		}
+	}
+}
+
+func TestDryRunPlan(t *testing.T) {
+	data := []KVPair{}
+	for i := 0; i < 10; i++ {
+		data = append(data, NewKVPStr(fmt.Sprintf("k_%d", i), fmt.Sprintf("v%d", i)))
+	}
+	txn := newMockQueryStorage(data)
+	queries := map[string]string{
+		"dry run delete where key ^= 'k_'":                                 "10",
+		"dry-run delete where key ^= 'k_' limit 3 returning key":           "k_0|k_1|k_2",
+		"dry run update set value = key where key = 'k_1' returning value": "k_1",
+		"dry run remove 'k_1', 'k_x'":                                      "1",
+		"dry run delete where key in ('k_1', 'k_x') returning key":         "k_1",
+	}
+	for query, expected := range queries {
+		rows := collectRows(t, txn, query, true)
+		if strings.Join(rows, "|") != expected {
+			t.Fatal("Unexpected result", query, rows)
+		}
+	}
+	rows := collectRows(t, txn, "select count(1) where value ^= 'v'", true)
+	if len(rows) != 1 || rows[0] != "10" {
+		t.Fatal("Dry run should not modify data", rows)
+	}
+
+	plan, err := NewOptimizer("dry run delete where key ^= 'k_' returning key").BuildPlan(txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(plan.Explain()[0], "DryRun = true") || !strings.Contains(plan.Explain()[0], "Returning = <KEY>") {
+		t.Fatal("Unexpected explain", plan.Explain())
+	}
+}
+
+func TestCommonExprElimination(t *testing.T) {
+	calls := 0
+	AddScalarFunction(&Function{"cse_test_json", 1, false, TJSON,
+		func(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+			calls++
+			return funcJson(kv, args, ctx)
+		},
+		func(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+			calls += len(chunk)
+			return funcJsonVec(chunk, args, ctx)
+		},
+	})
+	data := []KVPair{}
+	for i := 0; i < 100; i++ {
+		data = append(data, NewKVPStr(fmt.Sprintf("k%03d", i), fmt.Sprintf(`{"a": %d, "b": "g%d"}`, i, i%3)))
+	}
+	s := newMockQueryStorage(data)
+	tcases := []struct {
+		query  string
+		expect string
+		calls  int
+	}{
+		{"select key, cse_test_json(value)['a'] where int(cse_test_json(value)['a']) > 97", "k098,98|k099,99", 100},
+		{"select key, int(cse_test_json(value)['a']) as a where a < 2 & a >= 0", "k000,0|k001,1", 100},
+		{"select cse_test_json(value)['b'], count(1) where key < 'k010' group by cse_test_json(value)['b']", "g0,4|g1,3|g2,3", 10},
+		{"select key, strlen(value) as n where n = 19 & key > 'k005'", "k006,19|k007,19|k008,19|k009,19", 0},
+	}
+	for _, c := range tcases {
+		for _, batch := range []bool{false, true} {
+			calls = 0
+			rows := collectRows(t, s, c.query, batch)
+			if strings.Join(rows, "|") != c.expect {
+				t.Fatal(c.query, "unexpected result", rows)
+			}
+			if EnableFieldCache && calls != c.calls {
+				t.Fatal(c.query, "unexpected calls", calls, "batch", batch)
+			}
+		}
+	}
+
+	opt := NewOptimizer("select key, json(value)['a'] where json(value)['a'] > 1 & now() > now()")
+	if err := opt.init(); err != nil {
+		t.Fatal(err)
+	}
+	stmt := opt.stmt.(*SelectStmt)
+	field := stmt.Fields[1].(*FieldAccessExpr)
+	if field.Slot == 0 || field.Left.(*FunctionCallExpr).Slot == 0 {
+		t.Fatal("common expression should have slot")
+	}
+	stmt.Where.Expr.Walk(func(e Expression) bool {
+		if fc, ok := e.(*FunctionCallExpr); ok && IsAggrFuncExpr(fc) == false {
+			if name, _ := GetFuncNameFromExpr(fc); name == "now" && fc.Slot != 0 {
+				t.Fatal("non-deterministic function should not have slot")
+			}
+		}
+		return true
+	})
+
+	// Lambda body is executed for each element, only the whole call is shared
+	opt = NewOptimizer("select list_map(split(value, ','), x -> int(x)) as l where list_sum(l) > 1 & list_any(split(key, ','), x -> int(x) > 0)")
+	if err := opt.init(); err != nil {
+		t.Fatal(err)
+	}
+	stmt = opt.stmt.(*SelectStmt)
+	if stmt.Fields[0].(*FunctionCallExpr).Slot == 0 {
+		t.Fatal("common expression with lambda should have slot")
+	}
+	for _, root := range selectStmtExprs(stmt) {
+		root.Walk(func(e Expression) bool {
+			if fc, ok := e.(*FunctionCallExpr); ok && fc.String() == "int(x)" && fc.Slot != 0 {
+				t.Fatal("lambda body should not have slot", fc)
+			}
+			return true
+		})
+	}
+}
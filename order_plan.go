@@ -184,12 +184,14 @@ func (l *orderColumnsRow) Less(r *orderColumnsRow) bool {
 
 func (l *orderColumnsRow) compare(tp Type, lval, rval Column, reverse bool) int {
 	switch tp {
-	case TSTR:
+	case TSTR, TBYTES:
 		return l.compareBytes(lval, rval, reverse)
 	case TNUMBER:
 		return l.compareNumber(lval, rval, reverse)
 	case TBOOL:
 		return l.compareBool(lval, rval, reverse)
+	case TTIME:
+		return l.compareTime(lval, rval, reverse)
 	default:
 		return 0
 	}
@@ -216,6 +218,19 @@ func (l *orderColumnsRow) compareBytes(lval, rval Column, reverse bool) int {
 	return bytes.Compare(lbval, rbval)
 }
 
+func (l *orderColumnsRow) compareTime(lval, rval Column, reverse bool) int {
+	ltime, lok := toTime(lval)
+	rtime, rok := toTime(rval)
+	if !lok || !rok {
+		return 0
+	}
+	ret := ltime.Compare(rtime)
+	if reverse {
+		return 0 - ret
+	}
+	return ret
+}
+
 func (l *orderColumnsRow) compareBool(lval, rval Column, reverse bool) int {
 	var (
 		lbool bool
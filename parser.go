@@ -15,6 +15,11 @@ type Parser struct {
 	numToks int
 	nestLev int
 	exprLev int
+	ctes    map[string]*CTEStmt
+	// params are the placeholders of prepared statement
+	params []*ParamExpr
+	// lambdaParams are the parameters of enclosing lambda expressions
+	lambdaParams []string
 }
 
 func NewParser(query string) *Parser {
@@ -181,7 +186,15 @@ func (p *Parser) parseFuncCall(fun Expression) (Expression, error) {
 	p.exprLev++
 	var list []Expression
 	for p.tok != nil && p.tok.Tp != RPAREN {
-		arg, err := p.parseExpr()
+		var (
+			arg Expression
+			err error
+		)
+		if params, ntoks := p.peekLambdaParams(); ntoks > 0 {
+			arg, err = p.parseLambda(params, ntoks)
+		} else {
+			arg, err = p.parseExpr()
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -205,6 +218,76 @@ func (p *Parser) parseFuncCall(fun Expression) (Expression, error) {
 	return &FunctionCallExpr{Pos: fun.GetPos(), Name: fun, Args: list}, nil
 }
 
+// peekLambdaParams returns the parameters and the number of tokens before
+// the body if the current token starts a lambda expression, such as
+// x -> ... or (acc, x) -> ...
+func (p *Parser) peekLambdaParams() ([]string, int) {
+	isArrow := func(idx int) bool {
+		return idx < p.numToks && p.toks[idx].Tp == OPERATOR && p.toks[idx].Data == "->"
+	}
+	switch p.tok.Tp {
+	case NAME:
+		if isArrow(p.pos) {
+			return []string{p.tok.Data}, 2
+		}
+	case LPAREN:
+		var params []string
+		for idx := p.pos; idx < p.numToks; idx += 2 {
+			if p.toks[idx].Tp != NAME || idx+1 >= p.numToks {
+				return nil, 0
+			}
+			params = append(params, p.toks[idx].Data)
+			switch sep := p.toks[idx+1]; {
+			case sep.Tp == RPAREN:
+				if isArrow(idx + 2) {
+					return params, idx + 4 - p.pos
+				}
+				return nil, 0
+			case sep.Tp == SEP && sep.Data == ",":
+			default:
+				return nil, 0
+			}
+		}
+	}
+	return nil, 0
+}
+
+func (p *Parser) parseLambda(params []string, ntoks int) (Expression, error) {
+	pos := p.tok.Pos
+	for i, param := range params {
+		for _, prev := range params[:i] {
+			if prev == param {
+				return nil, NewSyntaxError(pos, "Duplicate lambda parameter %s", param)
+			}
+		}
+	}
+	for i := 0; i < ntoks; i++ {
+		p.next()
+	}
+	if p.tok == nil {
+		return nil, NewSyntaxError(-1, "Lambda expression require body")
+	}
+	base := len(p.lambdaParams)
+	p.lambdaParams = append(p.lambdaParams, params...)
+	body, err := p.parseExpr()
+	p.lambdaParams = p.lambdaParams[:base]
+	if err != nil {
+		return nil, err
+	}
+	return &LambdaExpr{Pos: pos, Params: params, Index: base, Body: body}, nil
+}
+
+// lambdaArg returns the parameter of the innermost lambda expression which
+// has the name.
+func (p *Parser) lambdaArg(pos int, name string) (*LambdaArgExpr, bool) {
+	for i := len(p.lambdaParams) - 1; i >= 0; i-- {
+		if p.lambdaParams[i] == name {
+			return &LambdaArgExpr{Pos: pos, Name: name, Index: i}, true
+		}
+	}
+	return nil, false
+}
+
 func (p *Parser) parseFieldAccess(pos int, left Expression) (Expression, error) {
 	err := p.expect(&Token{Tp: LBRACK, Data: "["})
 	if err != nil {
@@ -341,6 +424,11 @@ func (p *Parser) parseOperand() (Expression, error) {
 		}
 		return x, nil
 	case NAME:
+		isCall := p.pos < p.numToks && p.toks[p.pos].Tp == LPAREN
+		if arg, ok := p.lambdaArg(p.tok.Pos, p.tok.Data); ok && !isCall {
+			p.next()
+			return arg, nil
+		}
 		x := &NameExpr{Pos: p.tok.Pos, Data: p.tok.Data}
 		p.next()
 		return x, nil
@@ -360,6 +448,13 @@ func (p *Parser) parseOperand() (Expression, error) {
 		x := &BoolExpr{Pos: p.tok.Pos, Data: p.tok.Data, Bool: false}
 		p.next()
 		return x, nil
+	case PARAM:
+		x := &ParamExpr{Pos: p.tok.Pos, Name: p.tok.Data, Index: -1}
+		p.params = append(p.params, x)
+		p.next()
+		return x, nil
+	case INVALID:
+		return nil, NewSyntaxError(p.tok.Pos, "%s", p.tok.Data)
 	}
 	return nil, NewSyntaxError(p.tok.Pos, "Bad Expression")
 }
@@ -378,11 +473,11 @@ func (p *Parser) parseSelect() (*SelectStmt, error) {
 		return nil, err
 	}
 	p.exprLev++
-	for p.tok != nil && p.tok.Tp != WHERE {
+	for p.tok != nil && p.tok.Tp != WHERE && p.tok.Tp != FROM {
 		if p.tok.Tp == OPERATOR && p.tok.Data == "*" {
 			allFields = true
 			p.next()
-			if p.tok != nil && p.tok.Tp != WHERE {
+			if p.tok != nil && p.tok.Tp != WHERE && p.tok.Tp != FROM {
 				return nil, NewSyntaxError(p.tok.Pos, "Invalid field expression")
 			}
 			if len(fields) > 0 {
@@ -410,7 +505,7 @@ func (p *Parser) parseSelect() (*SelectStmt, error) {
 				p.next()
 			} else if p.tok.Tp == SEP && p.tok.Data == "," {
 				// Correct do nothing
-			} else if p.tok.Tp == WHERE {
+			} else if p.tok.Tp == WHERE || p.tok.Tp == FROM {
 				// Correct do nothing
 			} else {
 				return nil, NewSyntaxError(p.tok.Pos, "Expect `as` or `,` but got %s", p.tok.Data)
@@ -419,7 +514,7 @@ func (p *Parser) parseSelect() (*SelectStmt, error) {
 		fields = append(fields, field)
 		fieldNames = append(fieldNames, fieldName)
 		fieldTypes = append(fieldTypes, field.ReturnType())
-		if p.tok != nil && p.tok.Tp == WHERE {
+		if p.tok != nil && (p.tok.Tp == WHERE || p.tok.Tp == FROM) {
 			break
 		}
 		p.next()
@@ -506,7 +601,7 @@ func (p *Parser) findFieldInSelect(selStmt *SelectStmt, fieldName string, pos in
 	}
 	fexpr := selStmt.Fields[foundIdx]
 	switch fexpr.ReturnType() {
-	case TSTR, TNUMBER, TBOOL:
+	case TSTR, TBYTES, TNUMBER, TBOOL, TTIME:
 		break
 	default:
 		return nil, NewSyntaxError(fexpr.GetPos(), "Field %s return wrong type", fieldName)
@@ -543,7 +638,13 @@ func (p *Parser) parseGroupBy(selStmt *SelectStmt, ctx *CheckCtx) (*GroupByStmt,
 			}
 			fields = append(fields, GroupByField{e.Data, fexpr})
 		case *FieldExpr:
-			fields = append(fields, GroupByField{field.String(), field})
+			if selStmt.From != nil {
+				field, err = p.resolveCTEExpr(selStmt.From.CTE, field)
+				if err != nil {
+					return nil, err
+				}
+			}
+			fields = append(fields, GroupByField{e.String(), field})
 		case *FunctionCallExpr:
 			fexpr, err := p.findFieldInSelect(selStmt, field.String(), e.GetPos())
 			if err != nil {
@@ -694,14 +795,37 @@ func (p *Parser) parsePutKVPair() (*PutKVPair, error) {
 
 func (p *Parser) parsePut() (*PutStmt, error) {
 	var (
-		pos     = p.tok.Pos
-		kvpairs = []*PutKVPair{}
-		err     error
+		pos         = p.tok.Pos
+		kvpairs     = []*PutKVPair{}
+		err         error
+		ifNotExists = false
+		oldValue    Expression
 	)
 	err = p.expect(&Token{Tp: PUT, Data: "put"})
 	if err != nil {
 		return nil, err
 	}
+	if p.tok != nil && p.tok.Tp == IF {
+		err = p.parseIfNotExists()
+		if err != nil {
+			return nil, err
+		}
+		ifNotExists = true
+	}
+	if p.tok != nil && p.tok.Tp == SELECT {
+		if ifNotExists {
+			return nil, NewSyntaxError(p.tok.Pos, "Conditional put not support select statement")
+		}
+		selectStmt, err := p.parseSelectStmt()
+		if err != nil {
+			return nil, err
+		}
+		stmt := &PutStmt{
+			Pos:    pos,
+			Select: selectStmt,
+		}
+		return stmt, stmt.Validate(&CheckCtx{})
+	}
 	for p.tok != nil {
 		kvp, err := p.parsePutKVPair()
 		if err != nil {
@@ -711,14 +835,29 @@ func (p *Parser) parsePut() (*PutStmt, error) {
 		if p.tok == nil {
 			break
 		}
+		if p.tok.Tp == IF {
+			if ifNotExists {
+				return nil, NewSyntaxError(p.tok.Pos, "Duplicate put condition")
+			}
+			oldValue, err = p.parsePutCompareValue()
+			if err != nil {
+				return nil, err
+			}
+			if p.tok != nil {
+				return nil, NewSyntaxError(p.tok.Pos, "Has more expression")
+			}
+			break
+		}
 		err = p.expect(&Token{Tp: SEP, Data: ","})
 		if err != nil {
 			return nil, err
 		}
 	}
 	stmt := &PutStmt{
-		Pos:     pos,
-		KVPairs: kvpairs,
+		Pos:         pos,
+		KVPairs:     kvpairs,
+		IfNotExists: ifNotExists,
+		OldValue:    oldValue,
 	}
 	checkCtx := &CheckCtx{
 		NotAllowValue: true,
@@ -727,23 +866,61 @@ func (p *Parser) parsePut() (*PutStmt, error) {
 	return stmt, err
 }
 
+// parseIfNotExists parses `if not exists`
+func (p *Parser) parseIfNotExists() error {
+	err := p.expect(&Token{Tp: IF, Data: "if"})
+	if err != nil {
+		return err
+	}
+	if p.tok == nil {
+		return NewSyntaxError(-1, "Expect token not but got EOF")
+	} else if p.tok.Tp != NAME || p.tok.Data != "not" {
+		return NewSyntaxError(p.tok.Pos, "Expect token not bug got %s", p.tok.Data)
+	}
+	p.next()
+	return p.expect(&Token{Tp: EXISTS, Data: "exists"})
+}
+
+// parsePutCompareValue parses `if value = <expr>`
+func (p *Parser) parsePutCompareValue() (Expression, error) {
+	err := p.expect(&Token{Tp: IF, Data: "if"})
+	if err != nil {
+		return nil, err
+	}
+	err = p.expect(&Token{Tp: VALUE, Data: "value"})
+	if err != nil {
+		return nil, err
+	}
+	if p.tok == nil {
+		return nil, NewSyntaxError(-1, "Expect token = but got EOF")
+	} else if p.tok.Tp != OPERATOR || p.tok.Data != "=" {
+		return nil, NewSyntaxError(p.tok.Pos, "Expect token = bug got %s", p.tok.Data)
+	}
+	p.next()
+	if p.tok == nil {
+		return nil, NewSyntaxError(-1, "Require value expression")
+	}
+	return p.parseExpr()
+}
+
 func (p *Parser) parseRemove() (Statement, error) {
 	var (
-		pos  = p.tok.Pos
-		keys = []Expression{}
-		err  error
+		pos       = p.tok.Pos
+		keys      = []Expression{}
+		err       error
+		returning *ReturningStmt
 	)
 	err = p.expect(&Token{Tp: REMOVE, Data: "remove"})
 	if err != nil {
 		return nil, err
 	}
-	for p.tok != nil {
+	for p.tok != nil && p.tok.Tp != RETURNING {
 		kexpr, err := p.parseExpr()
 		if err != nil {
 			return nil, err
 		}
 		keys = append(keys, kexpr)
-		if p.tok == nil {
+		if p.tok == nil || p.tok.Tp == RETURNING {
 			break
 		}
 		err = p.expect(&Token{Tp: SEP, Data: ","})
@@ -751,9 +928,16 @@ func (p *Parser) parseRemove() (Statement, error) {
 			return nil, err
 		}
 	}
+	if p.tok != nil && p.tok.Tp == RETURNING {
+		returning, err = p.parseReturning()
+		if err != nil {
+			return nil, err
+		}
+	}
 	stmt := &RemoveStmt{
-		Pos:  pos,
-		Keys: keys,
+		Pos:       pos,
+		Keys:      keys,
+		Returning: returning,
 	}
 	checkCtx := &CheckCtx{
 		NotAllowKey:   true,
@@ -765,9 +949,8 @@ func (p *Parser) parseRemove() (Statement, error) {
 
 func (p *Parser) parseDelete() (Statement, error) {
 	var (
-		pos       = p.tok.Pos
-		err       error
-		limitStmt *LimitStmt = nil
+		pos = p.tok.Pos
+		err error
 	)
 	err = p.expect(&Token{Tp: DELETE, Data: "delete"})
 	if err != nil {
@@ -788,31 +971,114 @@ func (p *Parser) parseDelete() (Statement, error) {
 		return nil, err
 	}
 	whereStmt.Expr = wexpr
-	if p.tok != nil {
+	limitStmt, returning, err := p.parseWriteTail()
+	if err != nil {
+		return nil, err
+	}
+	deleteStmt := &DeleteStmt{
+		Pos:       pos,
+		Where:     whereStmt,
+		Limit:     limitStmt,
+		Returning: returning,
+	}
+	checkCtx := &CheckCtx{}
+	err = deleteStmt.Validate(checkCtx)
+	return deleteStmt, err
+}
+
+func (p *Parser) parseUpdate() (Statement, error) {
+	var (
+		pos = p.tok.Pos
+		err error
+	)
+	err = p.expect(&Token{Tp: UPDATE, Data: "update"})
+	if err != nil {
+		return nil, err
+	}
+	err = p.expect(&Token{Tp: SET, Data: "set"})
+	if err != nil {
+		return nil, err
+	}
+	err = p.expect(&Token{Tp: VALUE, Data: "value"})
+	if err != nil {
+		return nil, err
+	}
+	if p.tok == nil {
+		return nil, NewSyntaxError(-1, "Expect token = but got EOF")
+	} else if p.tok.Tp != OPERATOR || p.tok.Data != "=" {
+		return nil, NewSyntaxError(p.tok.Pos, "Expect token = bug got %s", p.tok.Data)
+	}
+	p.next()
+	if p.tok == nil {
+		return nil, NewSyntaxError(-1, "Require value expression")
+	}
+	vexpr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+
+	whereTok := p.tok
+	err = p.expect(&Token{Tp: WHERE, Data: "where"})
+	if err != nil {
+		return nil, err
+	}
+	whereStmt := &WhereStmt{
+		Pos:  whereTok.Pos,
+		Expr: nil,
+	}
+	wexpr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	whereStmt.Expr = wexpr
+	limitStmt, returning, err := p.parseWriteTail()
+	if err != nil {
+		return nil, err
+	}
+	updateStmt := &UpdateStmt{
+		Pos:       pos,
+		Value:     vexpr,
+		Where:     whereStmt,
+		Limit:     limitStmt,
+		Returning: returning,
+	}
+	checkCtx := &CheckCtx{}
+	err = updateStmt.Validate(checkCtx)
+	return updateStmt, err
+}
+
+// parseWriteTail parses the optional limit and returning statements
+// after where statement of delete and update.
+func (p *Parser) parseWriteTail() (*LimitStmt, *ReturningStmt, error) {
+	var (
+		limitStmt *LimitStmt
+		returning *ReturningStmt
+		err       error
+	)
+	for p.tok != nil {
 		switch p.tok.Tp {
 		case LIMIT:
 			if limitStmt != nil {
-				return nil, NewSyntaxError(p.tok.Pos, "Duplicate limit expression")
+				return nil, nil, NewSyntaxError(p.tok.Pos, "Duplicate limit expression")
+			}
+			if returning != nil {
+				return nil, nil, NewSyntaxError(p.tok.Pos, "Limit should before returning statement")
 			}
 			limitStmt, err = p.parseLimit()
 			if err != nil {
-				return nil, err
+				return nil, nil, err
+			}
+		case RETURNING:
+			// Returning statement consumes all the remaining tokens
+			returning, err = p.parseReturning()
+			if err != nil {
+				return nil, nil, err
 			}
 		default:
-			return nil, NewSyntaxError(p.tok.Pos, "Missing operator")
+			return nil, nil, NewSyntaxError(p.tok.Pos, "Missing operator")
 		}
 	}
-	if p.tok != nil {
-		return nil, NewSyntaxError(p.tok.Pos, "Has more expression")
-	}
-	deleteStmt := &DeleteStmt{
-		Pos:   pos,
-		Where: whereStmt,
-		Limit: limitStmt,
-	}
-	checkCtx := &CheckCtx{}
-	err = deleteStmt.Validate(checkCtx)
-	return deleteStmt, err
+	return limitStmt, returning, nil
 }
 
 func (p *Parser) trimEndSemis() {
@@ -833,19 +1099,51 @@ func (p *Parser) trimEndSemis() {
 func (p *Parser) Parse() (Statement, error) {
 	p.trimEndSemis()
 	if p.numToks == 0 {
-		return nil, NewSyntaxError(-1, "Expect put, delete, select or where keyword")
+		return nil, NewSyntaxError(-1, "Expect put, delete, update, select or where keyword")
 	}
 	p.next()
+	dryRun, err := p.parseDryRun()
+	if err != nil {
+		return nil, err
+	}
 	if p.tok == nil {
-		return nil, NewSyntaxError(-1, "Expect put, delete, select or where keyword")
+		return nil, NewSyntaxError(-1, "Expect put, delete, update, select or where keyword")
 	} else {
 		switch p.tok.Tp {
-		case WHERE, SELECT, PUT, REMOVE, DELETE:
+		case WHERE, SELECT, PUT, REMOVE, DELETE, UPDATE, WITH:
 			break
 		default:
-			return nil, NewSyntaxError(p.tok.Pos, "Expect put, delete, select or where keyword")
+			return nil, NewSyntaxError(p.tok.Pos, "Expect put, delete, update, select or where keyword")
+		}
+	}
+	switch p.tok.Tp {
+	case PUT:
+		return p.parsePut()
+	case REMOVE:
+		stmt, err := p.parseRemove()
+		if err == nil {
+			stmt.(*RemoveStmt).DryRun = dryRun
+		}
+		return stmt, err
+	case DELETE:
+		stmt, err := p.parseDelete()
+		if err == nil {
+			stmt.(*DeleteStmt).DryRun = dryRun
 		}
+		return stmt, err
+	case UPDATE:
+		stmt, err := p.parseUpdate()
+		if err == nil {
+			stmt.(*UpdateStmt).DryRun = dryRun
+		}
+		return stmt, err
+	case WITH:
+		return p.parseWith()
 	}
+	return p.parseSelectStmt()
+}
+
+func (p *Parser) parseSelectStmt() (*SelectStmt, error) {
 	var (
 		selectStmt  *SelectStmt  = nil
 		limitStmt   *LimitStmt   = nil
@@ -853,25 +1151,32 @@ func (p *Parser) Parse() (Statement, error) {
 		groupByStmt *GroupByStmt = nil
 		err         error
 		wherePos    int
+		hasWhere    = true
+		expr        Expression
 	)
 
-	if p.tok.Tp == PUT {
-		return p.parsePut()
-	} else if p.tok.Tp == REMOVE {
-		return p.parseRemove()
-	} else if p.tok.Tp == DELETE {
-		return p.parseDelete()
-	} else if p.tok.Tp == SELECT {
+	if p.tok.Tp == SELECT {
 		selectStmt, err = p.parseSelect()
 		if err != nil {
 			return nil, err
 		}
-		if p.tok != nil {
+		if p.tok != nil && p.tok.Tp == FROM {
+			err = p.parseFrom(selectStmt)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if p.tok != nil && p.tok.Tp == WHERE {
 			wherePos = p.tok.Pos
+			p.next()
+		} else if selectStmt.From != nil {
+			// From CTE statement can ignore where statement
+			hasWhere = false
+		} else if p.tok != nil {
+			return nil, NewSyntaxError(p.tok.Pos, "Expect where keyword")
 		} else {
 			return nil, NewSyntaxError(-1, "Expect where keyword")
 		}
-		p.next()
 	} else {
 		if p.tok.Tp != WHERE {
 			return nil, NewSyntaxError(p.tok.Pos, "Expect where keyword")
@@ -882,13 +1187,24 @@ func (p *Parser) Parse() (Statement, error) {
 		p.next()
 	}
 
-	if p.tok == nil {
-		return nil, NewSyntaxError(-1, "Expect where statement")
+	if hasWhere {
+		if p.tok == nil {
+			return nil, NewSyntaxError(-1, "Expect where statement")
+		}
+		expr, err = p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		wherePos = selectStmt.From.Pos
+		expr = &BoolExpr{Pos: wherePos, Data: "true", Bool: true}
 	}
 
-	expr, err := p.parseExpr()
-	if err != nil {
-		return nil, err
+	if selectStmt != nil && selectStmt.From != nil {
+		expr, err = p.resolveCTEExpr(selectStmt.From.CTE, expr)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	if selectStmt == nil {
@@ -954,6 +1270,15 @@ func (p *Parser) Parse() (Statement, error) {
 	if expr.ReturnType() != TBOOL {
 		return nil, NewSyntaxError(expr.GetPos(), "where statement result type should be boolean")
 	}
+	if selectStmt.From != nil && !selectStmt.From.CTE.Materialized {
+		// Inline CTE, merge the CTE where expression
+		expr = &BinaryOpExpr{
+			Pos:   wherePos,
+			Op:    And,
+			Left:  selectStmt.From.CTE.Select.Where.Expr,
+			Right: expr,
+		}
+	}
 	whereStmt := &WhereStmt{
 		Pos:  wherePos,
 		Expr: expr,
@@ -50,6 +50,10 @@ func FuzzSQLParser(f *testing.F) {
 		"delete where key ^='prefix' and value = 'v2'",
 		"delete where key in ('k1', 'k2')",
 		"delete where (key = 'k1' | key = 'k2') and key ^= 'k'",
+		"update set value = upper(value) where key ^= 'k' limit 10",
+		"put select 'v2:' + key, upper(value) where key ^= 'v1:'",
+		"dry run delete where key ^= 'k' limit 10 returning key, value as v",
+		"select * where key ^= ? & value in ($1, :name)",
 	}
 
 	for _, t := range tests {
@@ -2,6 +2,7 @@ package kvql
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 )
 
@@ -41,6 +42,15 @@ func parseDeleteQuery(query string) (*DeleteStmt, error) {
 	return nil, err
 }
 
+func parseUpdateQuery(query string) (*UpdateStmt, error) {
+	p := NewParser(query)
+	expr, err := p.Parse()
+	if expr != nil {
+		return expr.(*UpdateStmt), err
+	}
+	return nil, err
+}
+
 func TestParser1(t *testing.T) {
 	query := "where key = 'test' & value = 'value'"
 	expr, err := parseQuery(query)
@@ -449,3 +459,160 @@ func TestParser40(t *testing.T) {
 	}
 	fmt.Printf("%+v\n", expr.Where.Expr.String())
 }
+
+func TestParser41(t *testing.T) {
+	query := "update set value = upper(value) + key where key ^= 'name_' limit 10"
+	expr, err := parseUpdateQuery(query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expr.Limit == nil || expr.Limit.Count != 10 {
+		t.Fatal("Should have limit 10")
+	}
+	fmt.Printf("%+v %+v\n", expr.Value.String(), expr.Where.Expr.String())
+}
+
+func TestParserUpdateError(t *testing.T) {
+	queries := []string{
+		"update value = 'v' where key = 'k'",
+		"update set key = 'v' where key = 'k'",
+		"update set value != 'v' where key = 'k'",
+		"update set value = 'v'",
+		"update set value = key ^= 'k' where key = 'k'",
+		"update set value = 'v' where key = 'k' order by key",
+	}
+	for _, query := range queries {
+		_, err := parseUpdateQuery(query)
+		if err == nil {
+			t.Fatal("Should get syntax error:", query)
+		}
+	}
+}
+
+func TestParserConditionalPut(t *testing.T) {
+	stmt, err := parsePutQuery("put if not exists ('k1', 'v1'), ('k2', 'v2')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !stmt.IfNotExists || len(stmt.KVPairs) != 2 {
+		t.Fatal("Should parse put if not exists")
+	}
+	stmt, err = parsePutQuery("put ('k1', 'new') if value = 'old'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stmt.OldValue == nil || stmt.OldValue.String() != "'old'" {
+		t.Fatal("Should parse compare and set put")
+	}
+
+	queries := []string{
+		"put if exists ('k1', 'v1')",
+		"put if not ('k1', 'v1')",
+		"put ('k1', 'v1') if key = 'k1'",
+		"put ('k1', 'v1') if value != 'v'",
+		"put ('k1', 'v1') if value = value",
+		"put ('k1', 'v1') if value = 'v', ('k2', 'v2')",
+		"put if not exists ('k1', 'v1') if value = 'v'",
+		"put if not exists select key, value where key ^= 'k'",
+	}
+	for _, query := range queries {
+		_, err := parsePutQuery(query)
+		if err == nil {
+			t.Fatal("Should get syntax error:", query)
+		}
+	}
+}
+
+func TestParserReturning(t *testing.T) {
+	dstmt, err := parseDeleteQuery("dry run delete where key ^= 'k' limit 10 returning key, upper(value) as v")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !dstmt.DryRun || dstmt.Limit == nil || dstmt.Returning == nil {
+		t.Fatal("Should parse dry run delete with limit and returning")
+	}
+	if strings.Join(dstmt.Returning.FieldNames, ",") != "KEY,v" {
+		t.Fatal("Unexpected returning field names", dstmt.Returning.FieldNames)
+	}
+	rstmt, err := parseRemoveQuery("dry-run remove 'k1', 'k2' returning *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rstmt.DryRun || len(rstmt.Keys) != 2 || len(rstmt.Returning.Fields) != 2 {
+		t.Fatal("Should parse dry run remove with returning")
+	}
+	ustmt, err := parseUpdateQuery("explain dry-run update set value = 'v' where key = 'k' returning value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ustmt.DryRun || len(ustmt.Returning.Fields) != 1 {
+		t.Fatal("Should parse dry run update with returning")
+	}
+
+	queries := []string{
+		"delete where key = 'k' returning",
+		"delete where key = 'k' returning key,",
+		"delete where key = 'k' returning count(1)",
+		"delete where key = 'k' returning key limit 10",
+		"delete where key = 'k' returning key returning value",
+		"remove 'k1' returning key where key = 'k'",
+		"dry run select * where key = 'k'",
+		"dry run put ('k', 'v')",
+		"dry walk delete where key = 'k'",
+		"explain delete where key = 'k'",
+		"dry run",
+	}
+	for _, query := range queries {
+		_, err := NewParser(query).Parse()
+		if err == nil {
+			t.Fatal("Should get syntax error:", query)
+		}
+	}
+}
+
+func TestParserLambda(t *testing.T) {
+	stmt, err := NewParser("select list_map(split(value, ','), x -> list_any(list(1, 2), y -> y = int(x))) where list_reduce(split(key, ','), 0, (acc, x) -> acc + int(x)) > 1").Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sstmt := stmt.(*SelectStmt)
+	outer, ok := sstmt.Fields[0].(*FunctionCallExpr).Args[1].(*LambdaExpr)
+	if !ok || outer.Index != 0 || len(outer.Params) != 1 {
+		t.Fatal("Should parse lambda argument", sstmt.Fields[0])
+	}
+	inner := outer.Body.(*FunctionCallExpr).Args[1].(*LambdaExpr)
+	if inner.Index != 1 {
+		t.Fatal("Nested lambda parameters should follow the enclosing ones", inner.Index)
+	}
+	args := map[string]*LambdaArgExpr{}
+	inner.Walk(func(e Expression) bool {
+		if arg, ok := e.(*LambdaArgExpr); ok {
+			args[arg.Name] = arg
+		}
+		return true
+	})
+	if args["x"] == nil || args["x"].Index != 0 || args["x"].Type != TSTR || args["y"] == nil || args["y"].Index != 1 || args["y"].Type != TNUMBER {
+		t.Fatal("Unexpected lambda arguments", args)
+	}
+	reduce := sstmt.Where.Expr.(*BinaryOpExpr).Left.(*FunctionCallExpr)
+	if lambda := reduce.Args[2].(*LambdaExpr); len(lambda.Params) != 2 || reduce.ReturnType() != TNUMBER {
+		t.Fatal("Should parse reduce lambda", reduce)
+	}
+
+	queries := []string{
+		"select * where list_any(split(value, ','), x -> x + 1)",
+		"select * where list_any(split(value, ','), x -> x > 1)",
+		"select * where list_any(split(value, ','), (x, x) -> true)",
+		"select * where list_filter(split(value, ','), (a, b) -> true) = 1",
+		"select * where list_map(split(value, ','), 1) = 1",
+		"select * where upper(x -> x) = 'a'",
+		"select * where list_any(split(value, ','), x ->)",
+		"select * where x -> true",
+	}
+	for _, query := range queries {
+		_, err := NewParser(query).Parse()
+		if err == nil {
+			t.Fatal("Should get syntax error:", query)
+		}
+	}
+}
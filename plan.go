@@ -1,7 +1,7 @@
 package kvql
 
 import (
-	"fmt"
+	"bytes"
 	"os"
 )
 
@@ -17,116 +17,203 @@ func init() {
 }
 
 type ExecuteCtx struct {
-	Hit                 int
-	EnableCache         bool
-	FieldCaches         map[string]any
-	FieldChunkKeyCaches map[string][]any
-	FieldChunkCaches    map[string][]any
+	Hit         int
+	EnableCache bool
+	// Warnings generated by plans during execution
+	Warnings []string
+	// slots keeps the results of common sub-expressions, indexed by the
+	// slot assigned by optimizer
+	slots []exprSlot
+	// lambdaArgs keeps the arguments of the lambda expressions being
+	// called, indexed by LambdaArgExpr.Index
+	lambdaArgs []any
+}
+
+// exprSlot keeps the result of expression for the last executed row and
+// the recent chunks, rows are matched by key and the underlying value bytes,
+// so the stale result of other row will never be returned.
+type exprSlot struct {
+	row   KVPair
+	value any
+	valid bool
+	// rows and values are the results of chunks, the rows of last chunk
+	// start from chunkPos
+	rows     []KVPair
+	values   []any
+	chunkPos int
+	pos      int
 }
 
 func NewExecuteCtx() *ExecuteCtx {
 	return &ExecuteCtx{
-		Hit:                 0,
-		EnableCache:         EnableFieldCache,
-		FieldCaches:         make(map[string]any),
-		FieldChunkKeyCaches: make(map[string][]any),
-		FieldChunkCaches:    make(map[string][]any),
+		Hit:         0,
+		EnableCache: EnableFieldCache,
 	}
 }
 
-func (c *ExecuteCtx) GetFieldResult(name string) (any, bool) {
-	if !c.EnableCache {
-		return nil, false
-	}
-	if val, have := c.FieldCaches[name]; have {
-		return val, true
+func (c *ExecuteCtx) AddWarning(msg string) {
+	for _, w := range c.Warnings {
+		if w == msg {
+			return
+		}
 	}
-	return nil, false
+	c.Warnings = append(c.Warnings, msg)
+}
+
+func (c *ExecuteCtx) UpdateHit() {
+	c.Hit++
 }
 
-func (c *ExecuteCtx) SetFieldResult(name string, value any) {
+func (c *ExecuteCtx) Clear() {
 	if !c.EnableCache {
 		return
 	}
-	c.FieldCaches[name] = value
+	clear(c.slots)
 }
 
-func (c *ExecuteCtx) GetChunkFieldResult(name string, key []byte) ([]any, bool) {
-	if !c.EnableCache {
-		return nil, false
-	}
-	ckey := fmt.Sprintf("%s-%s", name, string(key))
-	if chunk, have := c.FieldChunkKeyCaches[ckey]; have {
-		return chunk, true
+func (c *ExecuteCtx) setLambdaArg(idx int, val any) {
+	if idx >= len(c.lambdaArgs) {
+		c.lambdaArgs = append(c.lambdaArgs, make([]any, idx+1-len(c.lambdaArgs))...)
 	}
-	return nil, false
+	c.lambdaArgs[idx] = val
 }
 
-func (c *ExecuteCtx) AppendChunkFieldResult(name string, chunk []any) {
-	if !c.EnableCache {
-		return
-	}
-	cdata, have := c.FieldChunkCaches[name]
-	if have {
-		cdata = append(cdata, chunk...)
-		c.FieldChunkCaches[name] = cdata
-	} else {
-		cchunk := make([]any, len(chunk))
-		copy(cchunk, chunk)
-		c.FieldChunkCaches[name] = cchunk
+func (c *ExecuteCtx) slot(idx int) *exprSlot {
+	if idx >= len(c.slots) {
+		c.slots = append(c.slots, make([]exprSlot, idx+1-len(c.slots))...)
 	}
+	return &c.slots[idx]
 }
 
-func (c *ExecuteCtx) SetChunkFieldResult(name string, key []byte, chunk []any) {
-	if !c.EnableCache {
-		return
+// execSlot returns the result of expression in slot for the row, exec is
+// called only if the row is not executed yet.
+func (c *ExecuteCtx) execSlot(idx int, kv KVPair, exec func(KVPair, *ExecuteCtx) (any, error)) (any, error) {
+	if c == nil || !c.EnableCache || idx <= 0 {
+		return exec(kv, c)
 	}
-	ckey := fmt.Sprintf("%s-%s", name, string(key))
-	if _, have := c.FieldChunkKeyCaches[ckey]; have {
-		return
+	s := c.slot(idx)
+	if s.valid && sameRow(s.row, kv) {
+		c.UpdateHit()
+		return s.value, nil
 	}
-	c.FieldChunkKeyCaches[ckey] = chunk
-	c.AppendChunkFieldResult(name, chunk)
-}
-
-func (c *ExecuteCtx) GetChunkFieldFinalResult(name string) ([]any, bool) {
-	if !c.EnableCache {
-		return nil, false
+	// Row executed by batch, rows are usually visited in the chunk order
+	for i := 0; i < len(s.rows); i++ {
+		pos := (s.pos + i) % len(s.rows)
+		if sameRow(s.rows[pos], kv) {
+			s.pos = pos + 1
+			c.UpdateHit()
+			return s.values[pos], nil
+		}
 	}
-	val, have := c.FieldChunkCaches[name]
-	return val, have
-}
-
-func (c *ExecuteCtx) UpdateHit() {
-	c.Hit++
+	ret, err := exec(kv, c)
+	if err != nil {
+		return nil, err
+	}
+	// The slots may be grown by exec
+	s = c.slot(idx)
+	s.row, s.value, s.valid = kv, ret, true
+	return ret, nil
 }
 
-func (c *ExecuteCtx) Clear() {
-	if !c.EnableCache {
-		return
+// execSlotBatch returns the results of expression in slot for the chunk,
+// exec is called with the rows not executed yet.
+func (c *ExecuteCtx) execSlotBatch(idx int, chunk []KVPair, exec func([]KVPair, *ExecuteCtx) ([]any, error)) ([]any, error) {
+	if c == nil || !c.EnableCache || idx <= 0 {
+		return exec(chunk, c)
 	}
-	clear(c.FieldCaches)
-	clear(c.FieldChunkCaches)
-	clear(c.FieldChunkKeyCaches)
+	var (
+		s       = c.slot(idx)
+		ret     = make([]any, len(chunk))
+		missing []int
+		pos     = 0
+	)
+	// Filtered chunk is the subsequence of executed rows
+	for i, kv := range chunk {
+		j := pos
+		for j < len(s.rows) && !sameRow(s.rows[j], kv) {
+			j++
+		}
+		if j < len(s.rows) {
+			ret[i] = s.values[j]
+			pos = j + 1
+		} else {
+			missing = append(missing, i)
+		}
+	}
+	if len(missing) < len(chunk) {
+		c.UpdateHit()
+	}
+	if len(missing) == 0 {
+		return ret, nil
+	}
+	rows := chunk
+	if len(missing) < len(chunk) {
+		rows = make([]KVPair, len(missing))
+		for i, m := range missing {
+			rows[i] = chunk[m]
+		}
+	}
+	vals, err := exec(rows, c)
+	if err != nil {
+		return nil, err
+	}
+	for i, m := range missing {
+		ret[m] = vals[i]
+	}
+	// Keep the results of recent rows only, the rows returned by a batch
+	// of scan plan are less than 2 * PlanBatchSize.
+	s = c.slot(idx)
+	if over := len(s.rows) - 2*PlanBatchSize; over > 0 {
+		n := copy(s.rows, s.rows[over:])
+		copy(s.values, s.values[over:])
+		clear(s.values[n:])
+		s.rows, s.values = s.rows[:n], s.values[:n]
+	}
+	// Copy the rows and results, because the caller may reuse the chunk
+	// and overwrite the results.
+	s.chunkPos = len(s.rows)
+	s.rows = append(s.rows, rows...)
+	s.values = append(s.values, vals...)
+	s.pos = 0
+	return ret, nil
 }
 
-func (c *ExecuteCtx) AdjustChunkCache(chooseIdxes []int) {
-	if !c.EnableCache {
+// filterSlots removes the results of rows not matched by filter in the last
+// executed chunks, so the results of matched rows in previous chunks are
+// kept for the plans reading the filtered rows.
+func (c *ExecuteCtx) filterSlots(chunk []KVPair, matchs []bool) {
+	if c == nil || !c.EnableCache {
 		return
 	}
-	cidxes := make(map[int]struct{})
-	for _, idx := range chooseIdxes {
-		cidxes[idx] = struct{}{}
-	}
-	for k, v := range c.FieldChunkCaches {
-		nv := make([]any, 0, len(chooseIdxes))
-		for i, item := range v {
-			if _, have := cidxes[i]; have {
-				nv = append(nv, item)
+	for i := range c.slots {
+		s := &c.slots[i]
+		n, j := s.chunkPos, 0
+		for k := s.chunkPos; k < len(s.rows); k++ {
+			for j < len(chunk) && !sameRow(chunk[j], s.rows[k]) {
+				j++
 			}
+			if j < len(chunk) && !matchs[j] {
+				continue
+			}
+			s.rows[n], s.values[n] = s.rows[k], s.values[k]
+			n++
 		}
-		c.FieldChunkCaches[k] = nv
+		clear(s.values[n:])
+		s.rows, s.values = s.rows[:n], s.values[:n]
+		s.chunkPos = n
+	}
+}
+
+// sameRow reports whether l and r are the same row, the value is compared
+// by the underlying bytes because it may be rewritten by update.
+func sameRow(l, r KVPair) bool {
+	if len(l.Value) != len(r.Value) {
+		return false
+	}
+	if len(l.Value) > 0 && &l.Value[0] != &r.Value[0] {
+		return false
 	}
+	return bytes.Equal(l.Key, r.Key)
 }
 
 type FinalPlan interface {
@@ -154,12 +241,17 @@ var (
 	_ Plan = (*PrefixScanPlan)(nil)
 	_ Plan = (*MultiGetPlan)(nil)
 	_ Plan = (*LimitPlan)(nil)
+	_ Plan = (*CTEScanPlan)(nil)
+	_ Plan = (*SkipKeysPlan)(nil)
 
 	_ FinalPlan = (*ProjectionPlan)(nil)
 	_ FinalPlan = (*AggregatePlan)(nil)
 	_ FinalPlan = (*FinalOrderPlan)(nil)
 	_ FinalPlan = (*FinalLimitPlan)(nil)
 	_ FinalPlan = (*PutPlan)(nil)
+	_ FinalPlan = (*DeletePlan)(nil)
+	_ FinalPlan = (*UpdatePlan)(nil)
+	_ FinalPlan = (*PutSelectPlan)(nil)
 )
 
 type Column any
@@ -0,0 +1,246 @@
+package kvql
+
+import (
+	"container/list"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const DefaultPlanCacheSize = 1024
+
+// PlanCache is a LRU cache of the parsed and optimized statements. The
+// literals in where conditions and the written keys and values are
+// replaced by parameters, so the queries with same shape share one cache
+// entry:
+//
+//	select * where key ^= 'user_1' & value = 'a'
+//	select * where key ^= 'user_2' & value = 'b'
+//
+// The cached statement is copied and bound with the literals of query to
+// build the plan, so the plans built by cache can be executed concurrently.
+// Cache entries are invalidated when functions are registered by
+// AddScalarFunction or AddAggrFunction.
+type PlanCache struct {
+	capacity int
+	mu       sync.Mutex
+	lru      *list.List
+	entries  map[string]*list.Element
+	stats    PlanCacheStats
+}
+
+type PlanCacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Size      int
+}
+
+type planCacheEntry struct {
+	key string
+	// stmt is nil means the query shape cannot be parameterized, the plan
+	// should be built from query directly.
+	stmt    *PreparedStmt
+	toks    []*Token
+	version uint64
+}
+
+func NewPlanCache(capacity int) *PlanCache {
+	if capacity <= 0 {
+		capacity = DefaultPlanCacheSize
+	}
+	return &PlanCache{
+		capacity: capacity,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// BuildPlan builds plan of query as Optimizer.BuildPlan does, the
+// statement is taken from cache if query shape is cached.
+func (c *PlanCache) BuildPlan(query string, s Storage, policy ...*ExecutePolicy) (FinalPlan, error) {
+	key, toks, lits, ok := normalizeQuery(query)
+	if !ok {
+		c.mu.Lock()
+		c.stats.Misses++
+		c.mu.Unlock()
+		return NewOptimizer(query).BuildPlan(s, policy...)
+	}
+	entry := c.get(key)
+	if entry == nil {
+		stmt, err := prepareWithParser(&Parser{
+			Query:   query,
+			toks:    toks,
+			numToks: len(toks),
+		})
+		if err != nil {
+			// Use the original query to report error or build the plan
+			plan, err := NewOptimizer(query).BuildPlan(s, policy...)
+			if err == nil {
+				c.put(&planCacheEntry{key: key, version: funcVersion.Load()})
+			}
+			return plan, err
+		}
+		entry = &planCacheEntry{
+			key:     key,
+			stmt:    stmt,
+			toks:    toks,
+			version: funcVersion.Load(),
+		}
+		c.put(entry)
+	}
+	if entry.stmt == nil {
+		return NewOptimizer(query).BuildPlan(s, policy...)
+	}
+	stmt := entry.stmt.clone(query, mapTokenPos(entry.toks, toks))
+	values := make([]Expression, len(stmt.params))
+	for i, param := range stmt.params {
+		values[i] = literalExpr(lits[param.Index])
+	}
+	err := stmt.bindValues(values)
+	if err != nil {
+		// Literal type is not match the cached shape, build the plan from
+		// query to get the same error or plan as uncached.
+		return NewOptimizer(query).BuildPlan(s, policy...)
+	}
+	return stmt.BuildPlan(s, policy...)
+}
+
+func (c *PlanCache) get(key string) *planCacheEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	elem, have := c.entries[key]
+	if !have {
+		c.stats.Misses++
+		return nil
+	}
+	entry := elem.Value.(*planCacheEntry)
+	if entry.version != funcVersion.Load() {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		c.stats.Misses++
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	c.stats.Hits++
+	return entry
+}
+
+func (c *PlanCache) put(entry *planCacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, have := c.entries[entry.key]; have {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.capacity {
+		elem := c.lru.Back()
+		c.lru.Remove(elem)
+		delete(c.entries, elem.Value.(*planCacheEntry).key)
+		c.stats.Evictions++
+	}
+}
+
+// Purge removes all cached statements
+func (c *PlanCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+}
+
+func (c *PlanCache) Stats() PlanCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ret := c.stats
+	ret.Size = c.lru.Len()
+	return ret
+}
+
+// normalizeQuery replaces the literals in where conditions, put key value
+// pairs, update value and remove keys with `?` parameters. It returns the
+// cache key, the tokens for parser and the replaced literal tokens. The
+// literals in fields, order by, group by and limit are kept, because they
+// decide the field names and the plan.
+func normalizeQuery(query string) (string, []*Token, []*Token, bool) {
+	var (
+		toks     = NewLexer(query).Split()
+		ret      = make([]*Token, len(toks))
+		lits     = []*Token{}
+		key      strings.Builder
+		depth    = 0
+		inRegion = false
+		regDepth = 0
+	)
+	for i, tok := range toks {
+		ret[i] = tok
+		switch tok.Tp {
+		case PARAM:
+			return "", nil, nil, false
+		case LPAREN:
+			depth++
+		case RPAREN:
+			depth--
+			if depth < regDepth {
+				inRegion = false
+			}
+		case WHERE:
+			inRegion = true
+			regDepth = depth
+		case PUT:
+			inRegion = i+1 < len(toks) && toks[i+1].Tp != SELECT
+			regDepth = depth
+		case SET, REMOVE:
+			inRegion = true
+			regDepth = depth
+		case ORDER, GROUP, LIMIT, RETURNING, SEMI:
+			if depth <= regDepth {
+				inRegion = false
+			}
+		case STRING, NUMBER, FLOAT:
+			if inRegion {
+				ret[i] = &Token{Tp: PARAM, Data: "?", Pos: tok.Pos}
+				lits = append(lits, tok)
+			}
+		}
+		if i > 0 {
+			key.WriteByte(' ')
+		}
+		switch ret[i].Tp {
+		case STRING:
+			key.WriteString(strconv.Quote(tok.Data))
+		case NAME:
+			key.WriteString("`" + tok.Data + "`")
+		default:
+			key.WriteString(ret[i].Data)
+		}
+	}
+	return key.String(), ret, lits, true
+}
+
+// mapTokenPos maps the token positions of cached query to the query
+// with same shape.
+func mapTokenPos(from []*Token, to []*Token) func(int) int {
+	return func(pos int) int {
+		idx := sort.Search(len(from), func(i int) bool {
+			return from[i].Pos >= pos
+		})
+		if idx < len(from) && from[idx].Pos == pos {
+			return to[idx].Pos
+		}
+		return pos
+	}
+}
+
+func literalExpr(tok *Token) Expression {
+	switch tok.Tp {
+	case NUMBER:
+		return newNumberExpr(tok.Pos, tok.Data)
+	case FLOAT:
+		return newFloatExpr(tok.Pos, tok.Data)
+	}
+	return &StringExpr{Pos: tok.Pos, Data: tok.Data}
+}
@@ -0,0 +1,221 @@
+package kvql
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newPlanCacheTestStorage() Storage {
+	data := []KVPair{}
+	for i := 0; i < 20; i++ {
+		data = append(data, NewKVPStr(fmt.Sprintf("k_%02d", i), fmt.Sprintf(`{"id": %d, "tag": "t%d"}`, i, i%3)))
+	}
+	return newMockQueryStorage(data)
+}
+
+func TestNormalizeQuery(t *testing.T) {
+	tdata := []struct {
+		query string
+		key   string
+		lits  int
+	}{
+		{"select key, 'a' where key ^= 'k_0' & value = 1.5 limit 2", "select key , \"a\" where key ^= ? & value = ? limit 2", 2},
+		{"select * where key in ('a', 'b') order by key", "select * where key in ( ? , ? ) order by key", 2},
+		{"with t as (select key, 'x' as x where key = 'a') select x from t where x = 'y'",
+			"with `t` as ( select key , \"x\" as `x` where key = ? ) select `x` from `t` where `x` = ?", 2},
+		{"put ('k', 'v') if value = 'old'", "put ( ? , ? ) if value = ?", 3},
+		{"put select 'p_' + key, value where key ^= 'k'", "put select \"p_\" + key , value where key ^= ?", 1},
+		{"update set value = 'v' where key = 'k' returning key, 'x'", "update set value = ? where key = ? returning key , \"x\"", 2},
+		{"remove 'k1', 'k2'", "remove ? , ?", 2},
+	}
+	for _, item := range tdata {
+		key, _, lits, ok := normalizeQuery(item.query)
+		if !ok || key != item.key || len(lits) != item.lits {
+			t.Fatalf("Normalize %s got %s, %d literals", item.query, key, len(lits))
+		}
+	}
+	if _, _, _, ok := normalizeQuery("select * where key = ?"); ok {
+		t.Fatal("Query with parameters should not be normalized")
+	}
+}
+
+func TestPlanCacheResult(t *testing.T) {
+	queries := []string{
+		"select key, json(value)['id'] as id where key ^= 'k_0' & int(id) > 5",
+		"select key, json(value)['id'] as id where key ^= 'k_1' & int(id) > 15",
+		"select * where key in ('k_01', 'k_03') | key = 'k_05'",
+		"select * where key in ('k_02', 'k_04') | key = 'k_06'",
+		"select key where key between 'k_03' and 'k_05' & json(value)['tag'] = 't1'",
+		"select json(value)['tag'] as tag, count(1) where key > 'k_10' group by tag order by tag",
+		"select json(value)['tag'] as tag, count(1) where key > 'k_15' group by tag order by tag",
+		"with t as (select key, json(value)['id'] as id where key ^= 'k_1') select key from t where int(id) < 12",
+		"with t as (select key, json(value)['id'] as id where key ^= 'k_0') select key from t where int(id) < 3",
+		"select key, int(json(value)['id']) * 2 + 1 as v where key < 'k_02' order by v desc limit 1",
+	}
+	s := newPlanCacheTestStorage()
+	cache := NewPlanCache(100)
+	for i := 0; i < 2; i++ {
+		for _, query := range queries {
+			expected := collectRows(t, s, query, true)
+			plan, err := cache.BuildPlan(query, s)
+			if err != nil {
+				t.Fatal(query, err)
+			}
+			ret := collectPlanRows(t, plan, true)
+			if strings.Join(ret, "|") != strings.Join(expected, "|") {
+				t.Fatalf("%s expect %v but got %v", query, expected, ret)
+			}
+			opt := NewOptimizer(query)
+			direct, _ := opt.BuildPlan(s)
+			if strings.Join(plan.FieldNameList(), ",") != strings.Join(direct.FieldNameList(), ",") {
+				t.Fatalf("%s field names %v not match %v", query, plan.FieldNameList(), direct.FieldNameList())
+			}
+		}
+	}
+	stats := cache.Stats()
+	if stats.Size != 6 || stats.Misses != 6 || stats.Hits != 14 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+
+	plan, err := cache.BuildPlan("select * where key in ('k_01', 'k_03')", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := plan.(*ProjectionPlan).ChildPlan.(*MultiGetPlan); !ok {
+		t.Fatal("Cached plan should use multi get", plan.Explain())
+	}
+}
+
+func TestPlanCacheWrite(t *testing.T) {
+	s := newMockQueryStorage(nil)
+	cache := NewPlanCache(100)
+	for i := 0; i < 3; i++ {
+		plan, err := cache.BuildPlan(fmt.Sprintf("put ('k%d', 'v%d')", i, i), s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		collectPlanRows(t, plan, true)
+	}
+	plan, err := cache.BuildPlan("update set value = value + '_x' where key = 'k1'", s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	collectPlanRows(t, plan, true)
+	ret := collectRows(t, s, "select * where true", true)
+	if strings.Join(ret, "|") != "k0,v0|k1,v1_x|k2,v2" {
+		t.Fatal("Unexpected result", ret)
+	}
+	if stats := cache.Stats(); stats.Hits != 2 || stats.Misses != 2 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+}
+
+func TestPlanCacheError(t *testing.T) {
+	s := newPlanCacheTestStorage()
+	cache := NewPlanCache(100)
+	queries := []string{
+		"select * where key ^= 'k' & value > 'a'",
+		// Type not match the cached shape
+		"select * where key ^= 1 & value > 'a'",
+		"select * where key ^= 'long_prefix' & int(value) / 0 > 1",
+		// Position is mapped to the query
+		"select * where key ^= 'k' & int(value) / 0 > 1",
+		"select * where key ^= 'k' & value ^= 'a' + 1",
+		"select * where key ^= 'kkkk' & value ^= 'a' + 1",
+	}
+	for _, query := range queries {
+		_, expected := NewOptimizer(query).BuildPlan(s)
+		_, err := cache.BuildPlan(query, s)
+		if (expected == nil) != (err == nil) {
+			t.Fatalf("%s expect error %v but got %v", query, expected, err)
+		}
+		if err == nil {
+			continue
+		}
+		expected.(QueryBinder).BindQuery(query)
+		err.(QueryBinder).BindQuery(query)
+		if err.Error() != expected.Error() {
+			t.Fatalf("%s expect error\n%s\nbut got\n%s", query, expected, err)
+		}
+	}
+}
+
+func TestPlanCacheEvictAndInvalidate(t *testing.T) {
+	s := newPlanCacheTestStorage()
+	cache := NewPlanCache(2)
+	queries := []string{
+		"select key where key = 'k_01'",
+		"select value where key = 'k_01'",
+		"select key where key = 'k_02'",
+		"select key, value where key = 'k_01'",
+	}
+	for _, query := range queries {
+		if _, err := cache.BuildPlan(query, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats := cache.Stats()
+	if stats.Size != 2 || stats.Evictions != 1 || stats.Hits != 1 || stats.Misses != 3 {
+		t.Fatalf("Unexpected stats %+v", stats)
+	}
+
+	AddScalarFunction(&Function{"plan_cache_test", 1, false, TSTR, funcToString, funcToStringVec})
+	if _, err := cache.BuildPlan("select key where key = 'k_03'", s); err != nil {
+		t.Fatal(err)
+	}
+	if stats = cache.Stats(); stats.Misses != 4 || stats.Size != 2 {
+		t.Fatalf("Cache should be invalidated %+v", stats)
+	}
+	cache.Purge()
+	if stats = cache.Stats(); stats.Size != 0 {
+		t.Fatalf("Cache should be purged %+v", stats)
+	}
+}
+
+func TestPlanCacheConcurrent(t *testing.T) {
+	s := newPlanCacheTestStorage()
+	cache := NewPlanCache(10)
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				key := fmt.Sprintf("k_%02d", (i+j)%20)
+				plan, err := cache.BuildPlan(fmt.Sprintf("select json(value)['tag'] as t, count(1) where key <= '%s' group by t", key), s)
+				if err != nil {
+					errs <- err
+					return
+				}
+				ctx := NewExecuteCtx()
+				total := 0
+				for {
+					rows, err := plan.Batch(ctx)
+					if err != nil {
+						errs <- err
+						return
+					}
+					if len(rows) == 0 {
+						break
+					}
+					for _, row := range rows {
+						total += int(row[1].(int64))
+					}
+					ctx.Clear()
+				}
+				if total != (i+j)%20+1 {
+					errs <- fmt.Errorf("%s expect %d rows but got %d", key, (i+j)%20+1, total)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
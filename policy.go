@@ -0,0 +1,154 @@
+package kvql
+
+import "fmt"
+
+var (
+	_ error       = (*PolicyError)(nil)
+	_ QueryBinder = (*PolicyError)(nil)
+)
+
+// ExecutePolicy is the safeguards of write statements, it is checked by
+// Optimizer.BuildPlan and the write plans. Dry run statements are not
+// restricted by the policy because they do not write storage.
+type ExecutePolicy struct {
+	// ReadOnly rejects put, remove, delete and update statements
+	ReadOnly bool
+	// MaxAffectedRows aborts the statement before writing rows beyond it,
+	// zero means no limit. The rows are counted batch by batch while they
+	// are written, so the batches before the limit is exceeded are already
+	// written when the statement fails. Execute the statement in
+	// transaction, which Session rolls back on error, to avoid it.
+	MaxAffectedRows int
+	// DenyFullScanWrite rejects delete and update statements which
+	// filter cannot be optimized and reduce to full scan.
+	DenyFullScanWrite bool
+}
+
+type PolicyRule int
+
+const (
+	PolicyReadOnly PolicyRule = iota + 1
+	PolicyMaxAffectedRows
+	PolicyFullScanWrite
+)
+
+func (r PolicyRule) String() string {
+	switch r {
+	case PolicyReadOnly:
+		return "read only"
+	case PolicyMaxAffectedRows:
+		return "max affected rows"
+	case PolicyFullScanWrite:
+		return "deny full scan write"
+	}
+	return "unknown"
+}
+
+type PolicyError struct {
+	Query   string
+	Rule    PolicyRule
+	Message string
+	Pos     int
+	Padding int
+}
+
+func NewPolicyError(rule PolicyRule, pos int, msg string, args ...any) error {
+	return &PolicyError{
+		Rule:    rule,
+		Pos:     pos,
+		Message: fmt.Sprintf(msg, args...),
+		Padding: DefaultErrorPadding,
+	}
+}
+
+func (e *PolicyError) BindQuery(query string) {
+	e.Query = query
+}
+
+func (e *PolicyError) SetPadding(pad int) {
+	e.Padding = pad
+}
+
+func (e *PolicyError) Error() string {
+	if e.Query == "" {
+		return fmt.Sprintf("Policy Error: %s at %d", e.Message, e.Pos)
+	}
+	ret := outputQueryAndErrPos(e.Query, e.Pos, e.Padding)
+	pad := generatePads(e.Padding)
+	ret += fmt.Sprintf("%sPolicy Error: %s", pad, e.Message)
+	return ret
+}
+
+// checkStatement checks the rules which can be decided by statement
+func (p *ExecutePolicy) checkStatement(stmt Statement) error {
+	if p == nil || !p.ReadOnly {
+		return nil
+	}
+	switch s := stmt.(type) {
+	case *PutStmt:
+		return NewPolicyError(PolicyReadOnly, s.Pos, "Cannot execute put statement in read only mode")
+	case *RemoveStmt:
+		if !s.DryRun {
+			return NewPolicyError(PolicyReadOnly, s.Pos, "Cannot execute remove statement in read only mode")
+		}
+	case *DeleteStmt:
+		if !s.DryRun {
+			return NewPolicyError(PolicyReadOnly, s.Pos, "Cannot execute delete statement in read only mode")
+		}
+	case *UpdateStmt:
+		if !s.DryRun {
+			return NewPolicyError(PolicyReadOnly, s.Pos, "Cannot execute update statement in read only mode")
+		}
+	}
+	return nil
+}
+
+// checkScanPlan checks the scan plan of delete and update statement
+func (p *ExecutePolicy) checkScanPlan(plan Plan, where *WhereStmt, dryRun bool) error {
+	if p == nil || !p.DenyFullScanWrite || dryRun {
+		return nil
+	}
+	if isFullScan(plan) {
+		return NewPolicyError(PolicyFullScanWrite, where.Pos, "Filter reduce to full scan, require key condition")
+	}
+	return nil
+}
+
+// isFullScan returns true if the plan scans all keys, the prefix scan of
+// empty prefix and the range scan without bounds are also full scan.
+func isFullScan(plan Plan) bool {
+	switch p := plan.(type) {
+	case *FullScanPlan:
+		return true
+	case *PrefixScanPlan:
+		return p.Prefix == ""
+	case *RangeScanPlan:
+		return len(p.Start) == 0 && p.End == nil
+	}
+	return false
+}
+
+func (p *ExecutePolicy) rowsLimit(pos int, dryRun bool) *RowsLimit {
+	if p == nil || p.MaxAffectedRows <= 0 || dryRun {
+		return nil
+	}
+	return &RowsLimit{
+		Max: p.MaxAffectedRows,
+		Pos: pos,
+	}
+}
+
+// RowsLimit is checked by write plans before writing a batch of rows
+type RowsLimit struct {
+	Max int
+	Pos int
+}
+
+// Check returns error if write n rows when affected rows are written
+// exceed the limit.
+func (l *RowsLimit) Check(affected int, n int) error {
+	if l == nil || affected+n <= l.Max {
+		return nil
+	}
+	return NewPolicyError(PolicyMaxAffectedRows, l.Pos, "Affected rows exceed limit %d", l.Max)
+}
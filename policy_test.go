@@ -0,0 +1,160 @@
+package kvql
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func newPolicyTestStorage() *mockQueryStorage {
+	data := []KVPair{}
+	for i := 0; i < 300; i++ {
+		data = append(data, NewKVPStr(fmt.Sprintf("k_%03d", i), fmt.Sprintf("v%d", i)))
+	}
+	return newMockQueryStorage(data)
+}
+
+func expectPolicyError(t *testing.T, err error, rule PolicyRule, pos int) {
+	perr, ok := err.(*PolicyError)
+	if !ok {
+		t.Fatal("Should get policy error", err)
+	}
+	if perr.Rule != rule || perr.Pos != pos {
+		t.Fatal("Unexpected policy error", perr.Rule, perr.Pos, perr)
+	}
+}
+
+func TestPolicyReadOnly(t *testing.T) {
+	txn := newPolicyTestStorage()
+	policy := &ExecutePolicy{ReadOnly: true}
+	queries := []string{
+		"put ('k', 'v')",
+		"remove 'k_001'",
+		"delete where key = 'k_001'",
+		"  update set value = 'v' where key = 'k_001'",
+	}
+	for _, query := range queries {
+		_, err := NewOptimizer(query).BuildPlan(txn, policy)
+		expectPolicyError(t, err, PolicyReadOnly, len(query)-len(strings.TrimLeft(query, " ")))
+	}
+
+	queries = []string{
+		"select * where key = 'k_001'",
+		"dry run delete where key ^= 'k_'",
+	}
+	for _, query := range queries {
+		_, err := NewOptimizer(query).BuildPlan(txn, policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPolicyFullScanWrite(t *testing.T) {
+	txn := newPolicyTestStorage()
+	policy := &ExecutePolicy{DenyFullScanWrite: true}
+	queries := []string{
+		"delete where true",
+		"delete where value = 'v1'",
+		"update set value = 'v' where key > '' | value = 'v1'",
+		"delete where key ^= ''",
+		"update set value = 'v' where key ^= ''",
+		"delete where key >= ''",
+	}
+	for _, query := range queries {
+		_, err := NewOptimizer(query).BuildPlan(txn, policy)
+		expectPolicyError(t, err, PolicyFullScanWrite, strings.Index(query, "where"))
+	}
+
+	if !isFullScan(&RangeScanPlan{Start: []byte{}}) || isFullScan(&RangeScanPlan{End: []byte("k_1")}) {
+		t.Fatal("Unbounded range scan should be full scan")
+	}
+
+	queries = []string{
+		"delete where key ^= 'k_1'",
+		"delete where key >= '' & key < 'k_1'",
+		"update set value = 'v' where key in ('k_001', 'k_002')",
+		"dry run delete where true",
+	}
+	for _, query := range queries {
+		_, err := NewOptimizer(query).BuildPlan(txn, policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestPolicyMaxAffectedRows(t *testing.T) {
+	txn := newPolicyTestStorage()
+	policy := &ExecutePolicy{MaxAffectedRows: 150}
+	execute := func(query string) error {
+		plan, err := NewOptimizer(query).BuildPlan(txn, policy)
+		if err != nil {
+			return err
+		}
+		ctx := NewExecuteCtx()
+		for {
+			rows, err := plan.Batch(ctx)
+			if err != nil || len(rows) == 0 {
+				return err
+			}
+		}
+	}
+
+	err := execute("put ('a', '1'), ('b', '2')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = execute("update set value = 'x' where key ^= 'k_'")
+	expectPolicyError(t, err, PolicyMaxAffectedRows, 0)
+	// Should abort before writing the batch beyond the limit
+	written := 150 / PlanBatchSize * PlanBatchSize
+	rows := collectRows(t, txn, "select count(1) where value = 'x'", true)
+	if len(rows) != 1 || rows[0] != fmt.Sprint(written) {
+		t.Fatal("Unexpected updated rows", rows)
+	}
+	err = execute("delete where key ^= 'k_'")
+	expectPolicyError(t, err, PolicyMaxAffectedRows, 0)
+	rows = collectRows(t, txn, "select count(1) where key ^= 'k_'", true)
+	if len(rows) != 1 || rows[0] != fmt.Sprint(300-written) {
+		t.Fatal("Unexpected deleted rows", rows)
+	}
+
+	err = execute("delete where key ^= 'k_' limit 150")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = execute("dry run delete where key ^= 'k_'")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sess := NewSession(txn)
+	sess.Policy = &ExecutePolicy{MaxAffectedRows: 1}
+	err = sess.Execute("put ('a', '1'), ('b', '2')", nil)
+	expectPolicyError(t, err, PolicyMaxAffectedRows, 0)
+
+	// The batches before exceeding limit are written, and they are rolled
+	// back with the transaction of session
+	ts := &mockTxnStorage{newPolicyTestStorage()}
+	sess = NewSession(ts)
+	sess.Policy = &ExecutePolicy{MaxAffectedRows: 50}
+	err = sess.Execute("delete where key ^= 'k_' & key < 'k_100'", nil)
+	expectPolicyError(t, err, PolicyMaxAffectedRows, 0)
+	rows = collectRows(t, ts, "select count(1) where key ^= 'k_'", true)
+	if len(rows) != 1 || rows[0] != fmt.Sprint(300-50/PlanBatchSize*PlanBatchSize) {
+		t.Fatal("Unexpected rows after partial delete", rows)
+	}
+	if err = sess.Execute("begin", nil); err != nil {
+		t.Fatal(err)
+	}
+	err = sess.Execute("delete where key ^= 'k_'", nil)
+	expectPolicyError(t, err, PolicyMaxAffectedRows, 0)
+	if sess.InTxn() {
+		t.Fatal("Transaction should be rolled back")
+	}
+	rows2 := collectRows(t, ts, "select count(1) where key ^= 'k_'", true)
+	if len(rows2) != 1 || rows2[0] != rows[0] {
+		t.Fatal("Partial delete should be rolled back", rows2)
+	}
+}
@@ -0,0 +1,323 @@
+package kvql
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NamedArg binds value to the `:name` parameter
+type NamedArg struct {
+	Name  string
+	Value any
+}
+
+func Named(name string, value any) NamedArg {
+	return NamedArg{
+		Name:  name,
+		Value: value,
+	}
+}
+
+// PreparedStmt is the parsed statement with parameters, parameters can be
+// `?`, `$1` or `:name` and they cannot be mixed in one statement:
+//
+//	stmt, err := Prepare("select * where key ^= ? & value = ?")
+//	err = stmt.Bind("prefix_", "value")
+//	plan, err := stmt.BuildPlan(storage)
+//
+// The statement is parsed and the parameter independent expressions are
+// optimized once by Prepare. Bind only checks the types of bound values and
+// BuildPlan only recomputes the scan plan. PreparedStmt is not safe for
+// concurrent use.
+type PreparedStmt struct {
+	Query     string
+	opt       *Optimizer
+	params    []*ParamExpr
+	names     []string
+	numParams int
+	bound     bool
+}
+
+func Prepare(query string) (*PreparedStmt, error) {
+	return prepareWithParser(NewParser(query))
+}
+
+func prepareWithParser(p *Parser) (*PreparedStmt, error) {
+	query := p.Query
+	stmt, err := p.Parse()
+	if err != nil {
+		return nil, err
+	}
+	ret := &PreparedStmt{
+		Query:  query,
+		opt:    NewOptimizer(query),
+		params: p.params,
+	}
+	err = ret.indexParams()
+	if err != nil {
+		return nil, err
+	}
+	ret.opt.prepare(stmt)
+	return ret, nil
+}
+
+// indexParams assigns the argument index of parameters
+func (s *PreparedStmt) indexParams() error {
+	params := append([]*ParamExpr{}, s.params...)
+	sort.SliceStable(params, func(i, j int) bool {
+		return params[i].Pos < params[j].Pos
+	})
+	var (
+		style     byte
+		nextIndex = 0
+		nameIdxes = map[string]int{}
+	)
+	for _, param := range params {
+		if style == 0 {
+			style = param.Name[0]
+		} else if style != param.Name[0] {
+			return NewSyntaxError(param.Pos, "Cannot mix parameter styles")
+		}
+		switch style {
+		case '?':
+			param.Index = nextIndex
+			nextIndex++
+		case '$':
+			idx, err := strconv.Atoi(param.Name[1:])
+			if err != nil {
+				return NewSyntaxError(param.Pos, "Invalid parameter %s", param.Name)
+			}
+			param.Index = idx - 1
+		case ':':
+			name := param.Name[1:]
+			idx, have := nameIdxes[name]
+			if !have {
+				idx = len(s.names)
+				nameIdxes[name] = idx
+				s.names = append(s.names, name)
+			}
+			param.Index = idx
+		}
+		if param.Index+1 > s.numParams {
+			s.numParams = param.Index + 1
+		}
+	}
+	return nil
+}
+
+// NumParams returns the number of arguments required by Bind
+func (s *PreparedStmt) NumParams() int {
+	return s.numParams
+}
+
+// ParamNames returns the names of `:name` parameters ordered by index
+func (s *PreparedStmt) ParamNames() []string {
+	return s.names
+}
+
+func (s *PreparedStmt) Statement() Statement {
+	return s.opt.stmt
+}
+
+// Bind binds arguments to the parameters. Arguments can be string, []byte,
+// integer, float, bool or NamedArg for `:name` parameter.
+func (s *PreparedStmt) Bind(args ...any) error {
+	values := make([]any, s.numParams)
+	bound := make([]bool, s.numParams)
+	for i, arg := range args {
+		idx := i
+		if narg, ok := arg.(NamedArg); ok {
+			idx = s.nameIndex(narg.Name)
+			if idx < 0 {
+				return fmt.Errorf("Cannot find parameter :%s", narg.Name)
+			}
+			arg = narg.Value
+		}
+		if idx >= s.numParams {
+			return fmt.Errorf("Too many arguments, require %d but got %d", s.numParams, len(args))
+		}
+		values[idx] = arg
+		bound[idx] = true
+	}
+	for idx, have := range bound {
+		if !have {
+			return fmt.Errorf("Parameter %d not bound", idx+1)
+		}
+	}
+	exprs := make([]Expression, len(s.params))
+	for i, param := range s.params {
+		value, err := newParamValue(param.Pos, values[param.Index])
+		if err != nil {
+			return err
+		}
+		exprs[i] = value
+	}
+	return s.bindValues(exprs)
+}
+
+// bindValues binds the literal expressions to parameters, values are
+// ordered as s.params.
+func (s *PreparedStmt) bindValues(values []Expression) error {
+	s.bound = false
+	for i, param := range s.params {
+		param.Value = values[i]
+	}
+	err := checkBoundStatement(s.opt.stmt)
+	if err != nil {
+		return err
+	}
+	s.bound = true
+	return nil
+}
+
+// clone returns an unbound copy of the statement for query with same
+// shape, positions of the copy are mapped by pos.
+func (s *PreparedStmt) clone(query string, pos func(int) int) *PreparedStmt {
+	c := newStmtCloner(pos)
+	opt := NewOptimizer(query)
+	opt.numSlots = s.opt.numSlots
+	opt.setStatement(c.statement(s.opt.stmt))
+	params := make([]*ParamExpr, len(s.params))
+	for i, param := range s.params {
+		params[i] = c.expr(param).(*ParamExpr)
+		params[i].Value = nil
+	}
+	return &PreparedStmt{
+		Query:     query,
+		opt:       opt,
+		params:    params,
+		names:     s.names,
+		numParams: s.numParams,
+	}
+}
+
+func (s *PreparedStmt) nameIndex(name string) int {
+	// Lexer lowercases the parameter names
+	name = strings.ToLower(strings.TrimPrefix(name, ":"))
+	for i, n := range s.names {
+		if n == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// BuildPlan builds query plan with bound arguments
+func (s *PreparedStmt) BuildPlan(storage Storage, policy ...*ExecutePolicy) (FinalPlan, error) {
+	if !s.bound && s.numParams > 0 {
+		return nil, fmt.Errorf("Prepared statement parameters not bound")
+	}
+	o := s.opt
+	if s.numParams > 0 {
+		// Plans hold the parameter nodes, so clone the statement to keep
+		// the bound values of this plan when arguments are bound again.
+		o = &Optimizer{Query: s.Query, numSlots: s.opt.numSlots}
+		o.setStatement(newStmtCloner(nil).statement(s.opt.stmt))
+	}
+	o.skipKeys = nil
+	o.policy = nil
+	if len(policy) > 0 {
+		o.policy = policy[0]
+	}
+	// Materialized CTE result should be loaded again
+	if sstmt, ok := o.stmt.(*SelectStmt); ok && sstmt.With != nil {
+		for _, cte := range sstmt.With.CTEs {
+			cte.result = nil
+		}
+	}
+	ret, err := o.buildStmtPlan(storage)
+	if err != nil {
+		return nil, err
+	}
+	err = ret.Init()
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func newParamValue(pos int, arg any) (Expression, error) {
+	switch v := arg.(type) {
+	case string:
+		return &StringExpr{Pos: pos, Data: v}, nil
+	case []byte:
+		return &StringExpr{Pos: pos, Data: string(v)}, nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32:
+		return newNumberExpr(pos, fmt.Sprint(v)), nil
+	case uint64:
+		if v > math.MaxInt64 {
+			return nil, NewSyntaxError(pos, "Parameter value %d overflow", v)
+		}
+		return newNumberExpr(pos, strconv.FormatUint(v, 10)), nil
+	case float32:
+		return newFloatExpr(pos, strconv.FormatFloat(float64(v), 'f', -1, 32)), nil
+	case float64:
+		return newFloatExpr(pos, strconv.FormatFloat(v, 'f', -1, 64)), nil
+	case bool:
+		return &BoolExpr{Pos: pos, Data: strconv.FormatBool(v), Bool: v}, nil
+	}
+	return nil, NewSyntaxError(pos, "Parameter type %T not support", arg)
+}
+
+// checkBoundStatement checks the statement again after parameters bound
+func checkBoundStatement(stmt Statement) error {
+	switch s := stmt.(type) {
+	case *SelectStmt:
+		return checkBoundSelect(s)
+	case *PutStmt:
+		if s.Select != nil {
+			if err := checkBoundSelect(s.Select); err != nil {
+				return err
+			}
+			return s.validateSelect()
+		}
+		return s.Validate(&CheckCtx{NotAllowValue: true})
+	case *RemoveStmt:
+		err := s.Validate(&CheckCtx{NotAllowKey: true, NotAllowValue: true})
+		if err != nil || s.Returning == nil {
+			return err
+		}
+		return s.Returning.Validate(&CheckCtx{})
+	case *DeleteStmt:
+		err := s.Validate(&CheckCtx{})
+		if err != nil || s.Returning == nil {
+			return err
+		}
+		return s.Returning.Validate(&CheckCtx{})
+	case *UpdateStmt:
+		err := s.Validate(&CheckCtx{})
+		if err != nil || s.Returning == nil {
+			return err
+		}
+		return s.Returning.Validate(&CheckCtx{})
+	}
+	return nil
+}
+
+func checkBoundSelect(s *SelectStmt) error {
+	if s.With != nil {
+		for _, cte := range s.With.CTEs {
+			if err := checkBoundSelect(cte.Select); err != nil {
+				return err
+			}
+		}
+	}
+	if !s.AllFields {
+		for i, f := range s.Fields {
+			s.FieldTypes[i] = f.ReturnType()
+		}
+	}
+	ctx := &CheckCtx{
+		Fields:     s.Fields,
+		FieldNames: s.FieldNames,
+		FieldTypes: s.FieldTypes,
+	}
+	err := s.ValidateFields(ctx)
+	if err != nil {
+		return err
+	}
+	return s.Where.Expr.Check(ctx)
+}
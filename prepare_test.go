@@ -0,0 +1,174 @@
+package kvql
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func collectPreparedRows(t *testing.T, stmt *PreparedStmt, s Storage, args ...any) []string {
+	err := stmt.Bind(args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plan, err := stmt.BuildPlan(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return collectPlanRows(t, plan, true)
+}
+
+func TestLexerParams(t *testing.T) {
+	toks := NewLexer("key = ? & value in ($1, :Name) & key = $0 & key = :1a").Split()
+	params := []string{}
+	for _, tok := range toks {
+		if tok.Tp == PARAM {
+			params = append(params, tok.Data)
+		}
+	}
+	if strings.Join(params, " ") != "? $1 :name" {
+		t.Fatal("Unexpected params", params)
+	}
+}
+
+func TestPreparedSelect(t *testing.T) {
+	data := []KVPair{}
+	for i := 0; i < 20; i++ {
+		data = append(data, NewKVPStr(fmt.Sprintf("k_%02d", i), fmt.Sprintf("%d", i)))
+	}
+	txn := newMockQueryStorage(data)
+	stmt, err := Prepare("select key, int(value) + ? as v where key ^= ? & int(value) > ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stmt.NumParams() != 3 {
+		t.Fatal("Should have 3 parameters")
+	}
+	rows := collectPreparedRows(t, stmt, txn, 100, "k_1", 17)
+	if strings.Join(rows, "|") != "k_18,118|k_19,119" {
+		t.Fatal("Unexpected result", rows)
+	}
+	// Bind again recomputes the scan plan
+	rows = collectPreparedRows(t, stmt, txn, 0, "k_0", 7)
+	if strings.Join(rows, "|") != "k_08,8|k_09,9" {
+		t.Fatal("Unexpected result", rows)
+	}
+	// Plans built before binding again keep their own arguments
+	if err = stmt.Bind(100, "k_1", 17); err != nil {
+		t.Fatal(err)
+	}
+	plan1, err := stmt.BuildPlan(txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = stmt.Bind(0, "k_0", 7); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := stmt.BuildPlan(txn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows = collectPlanRows(t, plan1, false)
+	if strings.Join(rows, "|") != "k_18,118|k_19,119" {
+		t.Fatal("Unexpected result", rows)
+	}
+	rows = collectPlanRows(t, plan, false)
+	if strings.Join(rows, "|") != "k_08,8|k_09,9" {
+		t.Fatal("Unexpected result", rows)
+	}
+	explain := strings.Join(plan.Explain(), "\n")
+	if !strings.Contains(explain, "PrefixScanPlan{Prefix = 'k_0'") {
+		t.Fatal("Should use prefix scan", explain)
+	}
+
+	stmt, err = Prepare("select * where key in ($1, $2) | key = $1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows = collectPreparedRows(t, stmt, txn, "k_01", "k_03")
+	if strings.Join(rows, "|") != "k_01,1|k_03,3" {
+		t.Fatal("Unexpected result", rows)
+	}
+	plan, _ = stmt.BuildPlan(txn)
+	if _, ok := plan.(*ProjectionPlan).ChildPlan.(*MultiGetPlan); !ok {
+		t.Fatal("Should use multi get plan", plan.Explain())
+	}
+
+	stmt, err = Prepare("select key where key between :start and :end & value != :start")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows = collectPreparedRows(t, stmt, txn, Named("end", "k_03"), Named(":Start", "k_01"))
+	if strings.Join(rows, "|") != "k_01|k_02|k_03" {
+		t.Fatal("Unexpected result", rows)
+	}
+}
+
+func TestPreparedWrite(t *testing.T) {
+	txn := newMockQueryStorage(nil)
+	stmt, err := Prepare("put (?, ?)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		collectPreparedRows(t, stmt, txn, fmt.Sprintf("k%d", i), i)
+	}
+	stmt, err = Prepare("update set value = value + ? where key = ? returning key, value")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := collectPreparedRows(t, stmt, txn, "_x", "k1")
+	if strings.Join(rows, "|") != "k1,1_x" {
+		t.Fatal("Unexpected result", rows)
+	}
+	stmt, err = Prepare("delete where key = ? | key = ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows = collectPreparedRows(t, stmt, txn, "k0", "k2")
+	if strings.Join(rows, "|") != "2" {
+		t.Fatal("Unexpected result", rows)
+	}
+	rows = collectRows(t, txn, "select * where true", true)
+	if strings.Join(rows, "|") != "k1,1_x" {
+		t.Fatal("Unexpected result", rows)
+	}
+}
+
+func TestPreparedError(t *testing.T) {
+	queries := []string{
+		"select * where key = ? & value = $1",
+		"select * where key = ? ^= 'k'",
+	}
+	for _, query := range queries {
+		if _, err := Prepare(query); err == nil {
+			t.Fatal("Should get syntax error", query)
+		}
+	}
+
+	_, err := NewOptimizer("select * where key = ?").BuildPlan(newMockQueryStorage(nil))
+	if _, ok := err.(*SyntaxError); !ok {
+		t.Fatal("Should get syntax error without bind", err)
+	}
+
+	stmt, err := Prepare("select * where key = ? & int(value) > ?")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = stmt.BuildPlan(newMockQueryStorage(nil)); err == nil {
+		t.Fatal("Should not build plan before bind")
+	}
+	binds := [][]any{
+		{"k"},
+		{"k", 1, 2},
+		{1, 1},
+		{"k", "1"},
+		{"k", []int{1}},
+		{Named("x", "k"), 1},
+	}
+	for _, args := range binds {
+		if err = stmt.Bind(args...); err == nil {
+			t.Fatal("Should get bind error", args)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package kvql
 import (
 	"fmt"
 	"strings"
+	"time"
 )
 
 type ProjectionPlan struct {
@@ -72,19 +73,9 @@ func (p *ProjectionPlan) processProjectionBatch(chunk []KVPair, ctx *ExecuteCtx)
 		ret     = make([][]Column, len(chunk))
 		cols    = make([][]any, nFields)
 		err     error
-		have    bool
 	)
 	for i := 0; i < nFields; i++ {
-		have = false
-		if ctx != nil {
-			fname := p.FieldNames[i]
-			cols[i], have = ctx.GetChunkFieldFinalResult(fname)
-		}
-		if !have {
-			cols[i], err = p.Fields[i].ExecuteBatch(chunk, ctx)
-		} else {
-			ctx.UpdateHit()
-		}
+		cols[i], err = p.Fields[i].ExecuteBatch(chunk, ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -107,16 +98,7 @@ func (p *ProjectionPlan) processProjection(kvp KVPair, ctx *ExecuteCtx) ([]Colum
 		err    error
 	)
 	for i := 0; i < nFields; i++ {
-		have := false
-		if ctx != nil {
-			fname := p.FieldNames[i]
-			result, have = ctx.GetFieldResult(fname)
-		}
-		if !have {
-			result, err = p.Fields[i].Execute(kvp, ctx)
-		} else {
-			ctx.UpdateHit()
-		}
+		result, err = p.Fields[i].Execute(kvp, ctx)
 		if err != nil {
 			return nil, err
 		}
@@ -124,7 +106,7 @@ func (p *ProjectionPlan) processProjection(kvp KVPair, ctx *ExecuteCtx) ([]Colum
 		case bool, []byte, string,
 			int, int8, int16, int32, int64,
 			uint, uint8, uint16, uint32, uint64,
-			float32, float64,
+			float32, float64, time.Time,
 			JSON, map[string]any, []any:
 			ret[i] = value
 		default:
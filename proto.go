@@ -0,0 +1,129 @@
+package kvql
+
+import (
+	"fmt"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var (
+	protoFilesMu sync.RWMutex
+	protoFiles   []*protoregistry.Files
+)
+
+// AddProtoDescriptorSet registers the serialized FileDescriptorSet, which
+// can be generated by `protoc --include_imports --descriptor_set_out`, the
+// messages in it can be decoded by proto(value, "pkg.Message") function.
+// The message registered later takes precedence over the earlier one with
+// the same name, the messages linked into binary are also available.
+func AddProtoDescriptorSet(data []byte) error {
+	fds := &descriptorpb.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, fds); err != nil {
+		return err
+	}
+	files, err := protodesc.NewFiles(fds)
+	if err != nil {
+		return err
+	}
+	protoFilesMu.Lock()
+	protoFiles = append(protoFiles, files)
+	protoFilesMu.Unlock()
+	return nil
+}
+
+func findProtoMessage(name string) (protoreflect.MessageDescriptor, error) {
+	fullName := protoreflect.FullName(name)
+	if !fullName.IsValid() {
+		return nil, fmt.Errorf("invalid message name %q", name)
+	}
+	protoFilesMu.RLock()
+	defer protoFilesMu.RUnlock()
+	for i := len(protoFiles) - 1; i >= 0; i-- {
+		if desc, err := protoFiles[i].FindDescriptorByName(fullName); err == nil {
+			if md, ok := desc.(protoreflect.MessageDescriptor); ok {
+				return md, nil
+			}
+		}
+	}
+	if desc, err := protoregistry.GlobalFiles.FindDescriptorByName(fullName); err == nil {
+		if md, ok := desc.(protoreflect.MessageDescriptor); ok {
+			return md, nil
+		}
+	}
+	return nil, fmt.Errorf("message %s not found", name)
+}
+
+// decodeProto decodes the protobuf message, the argument is the full name
+// of message type. Fields are keyed by field name, enums are converted to
+// enum value name and the fields not set are omitted.
+func decodeProto(data []byte, args []any) (any, error) {
+	md, err := findProtoMessage(toString(args[0]))
+	if err != nil {
+		return nil, err
+	}
+	msg := dynamicpb.NewMessage(md)
+	opts := proto.UnmarshalOptions{RecursionLimit: maxDecodeDepth}
+	if err := opts.Unmarshal(data, msg); err != nil {
+		return nil, err
+	}
+	return protoMessageValue(msg), nil
+}
+
+func protoMessageValue(msg protoreflect.Message) map[string]any {
+	ret := make(map[string]any)
+	msg.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		switch {
+		case fd.IsList():
+			list := v.List()
+			items := make([]any, list.Len())
+			for i := range items {
+				items[i] = protoFieldValue(fd, list.Get(i))
+			}
+			ret[string(fd.Name())] = items
+		case fd.IsMap():
+			items := make(map[string]any)
+			v.Map().Range(func(k protoreflect.MapKey, v protoreflect.Value) bool {
+				items[mapKeyString(protoFieldValue(fd.MapKey(), k.Value()))] = protoFieldValue(fd.MapValue(), v)
+				return true
+			})
+			ret[string(fd.Name())] = items
+		default:
+			ret[string(fd.Name())] = protoFieldValue(fd, v)
+		}
+		return true
+	})
+	return ret
+}
+
+func protoFieldValue(fd protoreflect.FieldDescriptor, v protoreflect.Value) any {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		return v.Bool()
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name())
+		}
+		return int64(v.Enum())
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return v.Int()
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return uintValue(v.Uint())
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return v.Float()
+	case protoreflect.StringKind:
+		return v.String()
+	case protoreflect.BytesKind:
+		return binaryString(v.Bytes())
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return protoMessageValue(v.Message())
+	}
+	return nil
+}
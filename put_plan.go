@@ -1,16 +1,22 @@
 package kvql
 
 import (
+	"bytes"
 	"fmt"
 	"strings"
 )
 
 type PutPlan struct {
-	Storage  Storage
-	KVPairs  []*PutKVPair
-	executed bool
+	Storage     Storage
+	KVPairs     []*PutKVPair
+	IfNotExists bool
+	OldValue    Expression
+	RowsLimit   *RowsLimit
+	executed    bool
 }
 
+const nonAtomicPutWarning = "Storage not support atomic conditional write, put is executed by get then put which is not atomic"
+
 func (p *PutPlan) Init() error {
 	p.executed = false
 	return nil
@@ -25,36 +31,64 @@ func (p *PutPlan) String() string {
 	for i, kvp := range p.KVPairs {
 		kvps[i] = kvp.String()
 	}
+	if p.IfNotExists {
+		return fmt.Sprintf("PutPlan{KVPairs = [%s], IfNotExists = true, Atomic = %v}", strings.Join(kvps, ", "), p.atomic())
+	} else if p.OldValue != nil {
+		return fmt.Sprintf("PutPlan{KVPairs = [%s], OldValue = %s, Atomic = %v}", strings.Join(kvps, ", "), p.OldValue.String(), p.atomic())
+	}
 	return fmt.Sprintf("PutPlan{KVPairs = [%s]}", strings.Join(kvps, ", "))
 }
 
 func (p *PutPlan) Next(ctx *ExecuteCtx) ([]Column, error) {
 	if !p.executed {
-		n, err := p.execute(ctx)
+		row, err := p.executeRow(ctx)
 		p.executed = true
-		return []Column{n}, err
+		return row, err
 	}
 	return nil, nil
 }
 
 func (p *PutPlan) Batch(ctx *ExecuteCtx) ([][]Column, error) {
 	if !p.executed {
-		n, err := p.execute(ctx)
+		row, err := p.executeRow(ctx)
 		p.executed = true
-		row := []Column{n}
 		return [][]Column{row}, err
 	}
 	return nil, nil
 }
 
+func (p *PutPlan) conditional() bool {
+	return p.IfNotExists || p.OldValue != nil
+}
+
+func (p *PutPlan) atomic() bool {
+	_, ok := p.Storage.(AtomicStorage)
+	return ok
+}
+
 func (p *PutPlan) FieldNameList() []string {
+	if p.conditional() {
+		return []string{"Rows", "Skipped"}
+	}
 	return []string{"Rows"}
 }
 
 func (p *PutPlan) FieldTypeList() []Type {
+	if p.conditional() {
+		return []Type{TNUMBER, TNUMBER}
+	}
 	return []Type{TNUMBER}
 }
 
+func (p *PutPlan) executeRow(ctx *ExecuteCtx) ([]Column, error) {
+	if !p.conditional() {
+		n, err := p.execute(ctx)
+		return []Column{n}, err
+	}
+	n, skipped, err := p.executeConditional(ctx)
+	return []Column{n, skipped}, err
+}
+
 func (p *PutPlan) processKVPair(ctx *ExecuteCtx, kvp *PutKVPair) ([]byte, []byte, error) {
 	ekvp := NewKVPStr("", "")
 	rkey, err := kvp.Key.Execute(ekvp, ctx)
@@ -81,6 +115,10 @@ func (p *PutPlan) execute(ctx *ExecuteCtx) (int, error) {
 		}
 		kvps[i] = NewKVP(key, value)
 	}
+	err := p.RowsLimit.Check(0, nkvps)
+	if err != nil {
+		return 0, err
+	}
 
 	if nkvps == 0 {
 		return 0, nil
@@ -98,3 +136,85 @@ func (p *PutPlan) execute(ctx *ExecuteCtx) (int, error) {
 		return nkvps, nil
 	}
 }
+
+func (p *PutPlan) executeConditional(ctx *ExecuteCtx) (int, int, error) {
+	var (
+		nkvps   = len(p.KVPairs)
+		kvps    = make([]KVPair, nkvps)
+		olds    = make([][]byte, nkvps)
+		written = 0
+	)
+	for i, kvp := range p.KVPairs {
+		key, value, err := p.processKVPair(ctx, kvp)
+		if err != nil {
+			return 0, 0, err
+		}
+		kvps[i] = NewKVP(key, value)
+		if p.OldValue != nil {
+			rold, err := p.OldValue.Execute(NewKVP(key, []byte{}), ctx)
+			if err != nil {
+				return 0, 0, err
+			}
+			olds[i] = []byte(toString(rold))
+		}
+	}
+
+	if as, ok := p.Storage.(AtomicStorage); ok {
+		// Cannot know how many keys will be written before put
+		if err := p.RowsLimit.Check(0, nkvps); err != nil {
+			return 0, 0, err
+		}
+		for i, kvp := range kvps {
+			var (
+				done bool
+				err  error
+			)
+			if p.IfNotExists {
+				done, err = as.PutIfNotExists(kvp.Key, kvp.Value)
+			} else {
+				done, err = as.CompareAndSwap(kvp.Key, olds[i], kvp.Value)
+			}
+			if err != nil {
+				return written, i - written, err
+			}
+			if done {
+				written++
+			}
+		}
+		return written, nkvps - written, nil
+	}
+
+	// Fallback to get then put
+	if ctx != nil {
+		ctx.AddWarning(nonAtomicPutWarning)
+	}
+	writes := make([]KVPair, 0, nkvps)
+	for i, kvp := range kvps {
+		current, err := p.Storage.Get(kvp.Key)
+		if err != nil {
+			return 0, 0, err
+		}
+		if p.IfNotExists && current != nil {
+			continue
+		}
+		if p.OldValue != nil && (current == nil || !bytes.Equal(current, olds[i])) {
+			continue
+		}
+		writes = append(writes, kvp)
+	}
+	if err := p.RowsLimit.Check(0, len(writes)); err != nil {
+		return 0, 0, err
+	}
+	switch len(writes) {
+	case 0:
+	case 1:
+		if err := p.Storage.Put(writes[0].Key, writes[0].Value); err != nil {
+			return 0, 0, err
+		}
+	default:
+		if err := p.Storage.BatchPut(writes); err != nil {
+			return 0, 0, err
+		}
+	}
+	return len(writes), nkvps - len(writes), nil
+}
@@ -0,0 +1,214 @@
+package kvql
+
+import (
+	"bytes"
+	"sort"
+)
+
+// PutSelectPlan writes the key value pairs returned by select plan:
+//
+//	put select 'v2:' + key, upper(value) where key ^= 'v1:'
+type PutSelectPlan struct {
+	Storage   Storage
+	ChildPlan FinalPlan
+	// Written keys will be skipped by the scan plan of select statement,
+	// so the statement will not read the keys it just wrote.
+	Written   *writtenKeys
+	RowsLimit *RowsLimit
+	executed  bool
+}
+
+func (p *PutSelectPlan) Init() error {
+	p.executed = false
+	p.Written.reset()
+	return p.ChildPlan.Init()
+}
+
+func (p *PutSelectPlan) String() string {
+	return "PutSelectPlan{}"
+}
+
+func (p *PutSelectPlan) Explain() []string {
+	ret := []string{p.String()}
+	for _, plan := range p.ChildPlan.Explain() {
+		ret = append(ret, plan)
+	}
+	return ret
+}
+
+func (p *PutSelectPlan) FieldNameList() []string {
+	return []string{"Rows"}
+}
+
+func (p *PutSelectPlan) FieldTypeList() []Type {
+	return []Type{TNUMBER}
+}
+
+func (p *PutSelectPlan) Next(ctx *ExecuteCtx) ([]Column, error) {
+	if !p.executed {
+		n, err := p.execute(ctx)
+		p.executed = true
+		return []Column{n}, err
+	}
+	return nil, nil
+}
+
+func (p *PutSelectPlan) Batch(ctx *ExecuteCtx) ([][]Column, error) {
+	if !p.executed {
+		n, err := p.execute(ctx)
+		p.executed = true
+		row := []Column{n}
+		return [][]Column{row}, err
+	}
+	return nil, nil
+}
+
+func (p *PutSelectPlan) execute(ctx *ExecuteCtx) (int, error) {
+	count := 0
+	for {
+		rows, err := p.ChildPlan.Batch(ctx)
+		if err != nil {
+			return count, err
+		}
+		nrows := len(rows)
+		if nrows == 0 {
+			return count, nil
+		}
+		err = p.RowsLimit.Check(count, nrows)
+		if err != nil {
+			return count, err
+		}
+		kvps := make([]KVPair, nrows)
+		for i, row := range rows {
+			key := toString(row[0])
+			kvps[i] = NewKVP([]byte(key), []byte(toString(row[1])))
+			p.Written.add(key)
+		}
+		err = p.Storage.BatchPut(kvps)
+		if err != nil {
+			return count, err
+		}
+		count += nrows
+	}
+}
+
+// writtenKeys keeps the keys written by put select statement which may be
+// read again by the scan plan. Scan returns keys in ascending order, so only
+// the keys in scan range and after the last scanned key are kept, memory is
+// not growing with the number of rows written to other ranges.
+type writtenKeys struct {
+	scan Plan
+	keys map[string]struct{}
+	last []byte
+}
+
+func newWrittenKeys() *writtenKeys {
+	return &writtenKeys{keys: make(map[string]struct{})}
+}
+
+func (w *writtenKeys) reset() {
+	if w == nil {
+		return
+	}
+	clear(w.keys)
+	w.last = nil
+}
+
+func (w *writtenKeys) add(key string) {
+	if w == nil || (w.last != nil && key <= string(w.last)) || !w.inScanRange(key) {
+		return
+	}
+	w.keys[key] = struct{}{}
+}
+
+func (w *writtenKeys) has(key []byte) bool {
+	_, have := w.keys[string(key)]
+	return have
+}
+
+// visit moves the scan position to key, the kept keys before it will never
+// be read again.
+func (w *writtenKeys) visit(key []byte) {
+	w.last = append(w.last[:0], key...)
+	for k := range w.keys {
+		if k <= string(w.last) {
+			delete(w.keys, k)
+		}
+	}
+}
+
+func (w *writtenKeys) inScanRange(key string) bool {
+	switch p := w.scan.(type) {
+	case nil, *EmptyResultPlan:
+		return false
+	case *PrefixScanPlan:
+		return len(key) >= len(p.Prefix) && key[:len(p.Prefix)] == p.Prefix
+	case *RangeScanPlan:
+		if p.Start != nil && bytes.Compare([]byte(key), p.Start) < 0 {
+			return false
+		}
+		return p.End == nil || bytes.Compare([]byte(key), p.End) <= 0
+	case *MultiGetPlan:
+		idx := sort.SearchStrings(p.Keys, key)
+		return idx < len(p.Keys) && p.Keys[idx] == key
+	}
+	return true
+}
+
+// SkipKeysPlan filters out the key value pairs which key in Keys
+type SkipKeysPlan struct {
+	ChildPlan Plan
+	Keys      *writtenKeys
+}
+
+func (p *SkipKeysPlan) Init() error {
+	return p.ChildPlan.Init()
+}
+
+func (p *SkipKeysPlan) String() string {
+	return "SkipKeysPlan{}"
+}
+
+func (p *SkipKeysPlan) Explain() []string {
+	ret := []string{p.String()}
+	for _, plan := range p.ChildPlan.Explain() {
+		ret = append(ret, plan)
+	}
+	return ret
+}
+
+func (p *SkipKeysPlan) Next(ctx *ExecuteCtx) ([]byte, []byte, error) {
+	for {
+		key, val, err := p.ChildPlan.Next(ctx)
+		if err != nil || key == nil {
+			return key, val, err
+		}
+		skip := p.Keys.has(key)
+		p.Keys.visit(key)
+		if !skip {
+			return key, val, nil
+		}
+		if ctx != nil {
+			ctx.Clear()
+		}
+	}
+}
+
+func (p *SkipKeysPlan) Batch(ctx *ExecuteCtx) ([]KVPair, error) {
+	for {
+		rows, err := p.ChildPlan.Batch(ctx)
+		if err != nil || len(rows) == 0 {
+			return rows, err
+		}
+		ret := make([]KVPair, 0, len(rows))
+		for _, row := range rows {
+			if !p.Keys.has(row.Key) {
+				ret = append(ret, row)
+			}
+		}
+		p.Keys.visit(rows[len(rows)-1].Key)
+		if len(ret) > 0 {
+			return ret, nil
+		}
+	}
+}
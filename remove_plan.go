@@ -6,13 +6,18 @@ import (
 )
 
 type RemovePlan struct {
-	Storage  Storage
-	Keys     []Expression
-	executed bool
+	Storage   Storage
+	Keys      []Expression
+	Returning *ReturningStmt
+	DryRun    bool
+	RowsLimit *RowsLimit
+	executed  bool
+	buffer    [][]Column
 }
 
 func (p *RemovePlan) Init() error {
 	p.executed = false
+	p.buffer = nil
 	return nil
 }
 
@@ -25,10 +30,14 @@ func (p *RemovePlan) String() string {
 	for i, k := range p.Keys {
 		keys[i] = k.String()
 	}
-	return fmt.Sprintf("RemovePlan{Keys = [%s]}", strings.Join(keys, ", "))
+	opts := append([]string{fmt.Sprintf("Keys = [%s]", strings.Join(keys, ", "))}, writePlanOptions(p.Returning, p.DryRun)...)
+	return fmt.Sprintf("RemovePlan{%s}", strings.Join(opts, ", "))
 }
 
 func (p *RemovePlan) Next(ctx *ExecuteCtx) ([]Column, error) {
+	if p.Returning != nil {
+		return nextReturningRow(&p.buffer, ctx, p.executeReturning)
+	}
 	if !p.executed {
 		n, err := p.execute(ctx)
 		p.executed = true
@@ -38,6 +47,9 @@ func (p *RemovePlan) Next(ctx *ExecuteCtx) ([]Column, error) {
 }
 
 func (p *RemovePlan) Batch(ctx *ExecuteCtx) ([][]Column, error) {
+	if p.Returning != nil {
+		return p.executeReturning(ctx)
+	}
 	if !p.executed {
 		n, err := p.execute(ctx)
 		p.executed = true
@@ -48,11 +60,11 @@ func (p *RemovePlan) Batch(ctx *ExecuteCtx) ([][]Column, error) {
 }
 
 func (p *RemovePlan) FieldNameList() []string {
-	return []string{"Rows"}
+	return writeFieldNameList(p.Returning)
 }
 
 func (p *RemovePlan) FieldTypeList() []Type {
-	return []Type{TNUMBER}
+	return writeFieldTypeList(p.Returning)
 }
 
 func (p *RemovePlan) processKey(ekvp KVPair, ctx *ExecuteCtx, kexpr Expression) ([]byte, error) {
@@ -64,18 +76,33 @@ func (p *RemovePlan) processKey(ekvp KVPair, ctx *ExecuteCtx, kexpr Expression)
 	return key, nil
 }
 
-func (p *RemovePlan) execute(ctx *ExecuteCtx) (int, error) {
-	nks := len(p.Keys)
-	keys := make([][]byte, nks)
+func (p *RemovePlan) processKeys(ctx *ExecuteCtx) ([][]byte, error) {
+	keys := make([][]byte, len(p.Keys))
 	ekvp := NewKVPStr("", "")
 	for i, kexpr := range p.Keys {
 		key, err := p.processKey(ekvp, ctx, kexpr)
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
 		keys[i] = key
 	}
+	return keys, nil
+}
 
+func (p *RemovePlan) execute(ctx *ExecuteCtx) (int, error) {
+	if p.DryRun {
+		kvps, err := p.existKVPairs(ctx)
+		return len(kvps), err
+	}
+	keys, err := p.processKeys(ctx)
+	if err != nil {
+		return 0, err
+	}
+	nks := len(keys)
+	err = p.RowsLimit.Check(0, nks)
+	if err != nil {
+		return 0, err
+	}
 	if nks == 0 {
 		return 0, nil
 	} else if nks == 1 {
@@ -92,3 +119,49 @@ func (p *RemovePlan) execute(ctx *ExecuteCtx) (int, error) {
 		return nks, nil
 	}
 }
+
+// existKVPairs returns the key value pairs which exists in storage, the
+// not exists keys will not be returned by returning statement.
+func (p *RemovePlan) existKVPairs(ctx *ExecuteCtx) ([]KVPair, error) {
+	keys, err := p.processKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]KVPair, 0, len(keys))
+	for _, key := range keys {
+		value, err := p.Storage.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if value != nil {
+			ret = append(ret, NewKVP(key, value))
+		}
+	}
+	return ret, nil
+}
+
+func (p *RemovePlan) executeReturning(ctx *ExecuteCtx) ([][]Column, error) {
+	if p.executed {
+		return nil, nil
+	}
+	p.executed = true
+	kvps, err := p.existKVPairs(ctx)
+	if err != nil || len(kvps) == 0 {
+		return nil, err
+	}
+	if !p.DryRun {
+		err = p.RowsLimit.Check(0, len(kvps))
+		if err != nil {
+			return nil, err
+		}
+		keys := make([][]byte, len(kvps))
+		for i, kvp := range kvps {
+			keys[i] = kvp.Key
+		}
+		err = p.Storage.BatchDelete(keys)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p.Returning.project(kvps, ctx)
+}
@@ -0,0 +1,207 @@
+package kvql
+
+import (
+	"fmt"
+	"strings"
+)
+
+// parseDryRun parses `dry run` or `dry-run` prefix of write statement,
+// an optional `explain` keyword is allowed before it.
+func (p *Parser) parseDryRun() (bool, error) {
+	if p.tok.Tp == NAME && p.tok.Data == "explain" {
+		p.next()
+		if p.tok == nil {
+			return false, NewSyntaxError(-1, "Expect token dry but got EOF")
+		} else if p.tok.Tp != NAME || p.tok.Data != "dry" {
+			return false, NewSyntaxError(p.tok.Pos, "Expect token dry bug got %s", p.tok.Data)
+		}
+	}
+	if p.tok.Tp != NAME || p.tok.Data != "dry" {
+		return false, nil
+	}
+	p.next()
+	if p.tok != nil && p.tok.Tp == OPERATOR && p.tok.Data == "-" {
+		p.next()
+	}
+	if p.tok == nil {
+		return false, NewSyntaxError(-1, "Expect token run but got EOF")
+	} else if p.tok.Tp != NAME || p.tok.Data != "run" {
+		return false, NewSyntaxError(p.tok.Pos, "Expect token run bug got %s", p.tok.Data)
+	}
+	p.next()
+	if p.tok == nil {
+		return false, NewSyntaxError(-1, "Expect delete, remove or update keyword")
+	}
+	switch p.tok.Tp {
+	case DELETE, REMOVE, UPDATE:
+		return true, nil
+	}
+	return false, NewSyntaxError(p.tok.Pos, "Dry run only support delete, remove or update statement")
+}
+
+// parseReturning parses `returning <field> [as name], ...`
+func (p *Parser) parseReturning() (*ReturningStmt, error) {
+	var (
+		pos        = p.tok.Pos
+		fields     = []Expression{}
+		fieldNames = []string{}
+		fieldTypes = []Type{}
+	)
+	err := p.expect(&Token{Tp: RETURNING, Data: "returning"})
+	if err != nil {
+		return nil, err
+	}
+	if p.tok != nil && p.tok.Tp == OPERATOR && p.tok.Data == "*" {
+		p.next()
+		if p.tok != nil {
+			return nil, NewSyntaxError(p.tok.Pos, "Invalid field expression")
+		}
+		return &ReturningStmt{
+			Pos:        pos,
+			Fields:     []Expression{&FieldExpr{pos, KeyKW}, &FieldExpr{pos, ValueKW}},
+			FieldNames: []string{"KEY", "VALUE"},
+			FieldTypes: []Type{TSTR, TSTR},
+		}, nil
+	}
+	p.exprLev++
+	for p.tok != nil {
+		field, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		fieldName := field.String()
+		if p.tok != nil && p.tok.Tp == AS {
+			p.next()
+			if p.tok == nil {
+				return nil, NewSyntaxError(-1, "Require field name")
+			} else if p.tok.Tp != NAME {
+				return nil, NewSyntaxError(p.tok.Pos, "Invalid field name")
+			}
+			fieldName = p.tok.Data
+			p.next()
+		}
+		fields = append(fields, field)
+		fieldNames = append(fieldNames, fieldName)
+		fieldTypes = append(fieldTypes, field.ReturnType())
+		if p.tok == nil {
+			break
+		}
+		err = p.expect(&Token{Tp: SEP, Data: ","})
+		if err != nil {
+			return nil, err
+		}
+		if p.tok == nil {
+			return nil, NewSyntaxError(-1, "Require field expression")
+		}
+	}
+	p.exprLev--
+	if len(fields) == 0 {
+		return nil, NewSyntaxError(pos, "Empty fields in returning statement")
+	}
+	stmt := &ReturningStmt{
+		Pos:        pos,
+		Fields:     fields,
+		FieldNames: fieldNames,
+		FieldTypes: fieldTypes,
+	}
+	return stmt, stmt.Validate(&CheckCtx{})
+}
+
+func (s *ReturningStmt) Validate(ctx *CheckCtx) error {
+	for _, f := range s.Fields {
+		if err := f.Check(ctx); err != nil {
+			return err
+		}
+		hasAggr := false
+		f.Walk(func(e Expression) bool {
+			if IsAggrFuncExpr(e) {
+				hasAggr = true
+				return false
+			}
+			return true
+		})
+		if hasAggr {
+			return NewSyntaxError(f.GetPos(), "Returning statement not support aggregate function")
+		}
+	}
+	return nil
+}
+
+func (s *ReturningStmt) String() string {
+	fields := make([]string, len(s.Fields))
+	for i, f := range s.Fields {
+		fields[i] = f.String()
+	}
+	return strings.Join(fields, ", ")
+}
+
+// project evaluates returning fields on the affected key value pairs.
+func (s *ReturningStmt) project(kvps []KVPair, ctx *ExecuteCtx) ([][]Column, error) {
+	var (
+		nFields = len(s.Fields)
+		ret     = make([][]Column, len(kvps))
+		cols    = make([][]any, nFields)
+		err     error
+	)
+	if len(kvps) == 0 {
+		return nil, nil
+	}
+	for i, f := range s.Fields {
+		cols[i], err = f.ExecuteBatch(kvps, ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i := range kvps {
+		row := make([]Column, nFields)
+		for j := 0; j < nFields; j++ {
+			row[j] = cols[j][i]
+		}
+		ret[i] = row
+	}
+	return ret, nil
+}
+
+func writeFieldNameList(returning *ReturningStmt) []string {
+	if returning != nil {
+		return returning.FieldNames
+	}
+	return []string{"Rows"}
+}
+
+func writeFieldTypeList(returning *ReturningStmt) []Type {
+	if returning != nil {
+		return returning.FieldTypes
+	}
+	return []Type{TNUMBER}
+}
+
+// writePlanOptions returns the explain of returning and dry run options.
+func writePlanOptions(returning *ReturningStmt, dryRun bool) []string {
+	ret := []string{}
+	if returning != nil {
+		ret = append(ret, fmt.Sprintf("Returning = <%s>", returning.String()))
+	}
+	if dryRun {
+		ret = append(ret, "DryRun = true")
+	}
+	return ret
+}
+
+// nextReturningRow pops one row from buffer, batch is called to fill the
+// buffer when it is empty.
+func nextReturningRow(buffer *[][]Column, ctx *ExecuteCtx, batch func(*ExecuteCtx) ([][]Column, error)) ([]Column, error) {
+	for len(*buffer) == 0 {
+		rows, err := batch(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if len(rows) == 0 {
+			return nil, nil
+		}
+		*buffer = rows
+	}
+	row := (*buffer)[0]
+	*buffer = (*buffer)[1:]
+	return row, nil
+}
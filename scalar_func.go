@@ -1,11 +1,17 @@
 package kvql
 
 import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"math"
+	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func funcToLower(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
@@ -354,6 +360,10 @@ func getListLength(data any) (int, error) {
 		return len(val), nil
 	case []float64:
 		return len(val), nil
+	case []string:
+		return len(val), nil
+	case []any:
+		return len(val), nil
 	}
 	return 0, fmt.Errorf("invalid type")
 }
@@ -366,3 +376,2118 @@ func funcStrlen(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
 	ret := toString(rarg)
 	return int64(len(ret)), nil
 }
+
+// maxFuncStringLen limits the length of string generated by repeat, lpad
+// and rpad functions.
+const maxFuncStringLen = 64 << 20
+
+// valueFunc is the implementation of function on the evaluated arguments,
+// regs caches the compiled regexps in a batch and can be nil.
+type valueFunc func(args []Expression, vals []any, regs regexpCache) (any, error)
+
+type regexpCache map[string]*regexp.Regexp
+
+func (c regexpCache) compile(pattern string) (*regexp.Regexp, error) {
+	if reg, have := c[pattern]; have {
+		return reg, nil
+	}
+	reg, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if c != nil {
+		c[pattern] = reg
+	}
+	return reg, nil
+}
+
+func execValueFunc(kv KVPair, args []Expression, ctx *ExecuteCtx, fn valueFunc) (any, error) {
+	vals := make([]any, len(args))
+	for i, arg := range args {
+		rarg, err := arg.Execute(kv, ctx)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = rarg
+	}
+	return fn(args, vals, nil)
+}
+
+func checkMaxArgs(fname string, args []Expression, maxArgs int) error {
+	if len(args) > maxArgs {
+		return NewExecuteError(args[maxArgs].GetPos(), "Function %s require at most %d arguments but got %d", fname, maxArgs, len(args))
+	}
+	return nil
+}
+
+func strReplace(args []Expression, vals []any, regs regexpCache) (any, error) {
+	return strings.ReplaceAll(toString(vals[0]), toString(vals[1]), toString(vals[2])), nil
+}
+
+func funcReplace(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, strReplace)
+}
+
+func strTrimFunc(fname string, trim func(string, string) string) valueFunc {
+	return func(args []Expression, vals []any, regs regexpCache) (any, error) {
+		if err := checkMaxArgs(fname, args, 2); err != nil {
+			return nil, err
+		}
+		cutset := " \t\r\n"
+		if len(vals) > 1 {
+			cutset = toString(vals[1])
+		}
+		return trim(toString(vals[0]), cutset), nil
+	}
+}
+
+var (
+	strTrim  = strTrimFunc("trim", strings.Trim)
+	strLTrim = strTrimFunc("ltrim", strings.TrimLeft)
+	strRTrim = strTrimFunc("rtrim", strings.TrimRight)
+)
+
+func funcTrim(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, strTrim)
+}
+
+func funcLTrim(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, strLTrim)
+}
+
+func funcRTrim(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, strRTrim)
+}
+
+func strConcat(args []Expression, vals []any, regs regexpCache) (any, error) {
+	var sb strings.Builder
+	for _, val := range vals {
+		sb.WriteString(toString(val))
+	}
+	return sb.String(), nil
+}
+
+func funcConcat(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, strConcat)
+}
+
+func strStartsWith(args []Expression, vals []any, regs regexpCache) (any, error) {
+	return strings.HasPrefix(toString(vals[0]), toString(vals[1])), nil
+}
+
+func funcStartsWith(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, strStartsWith)
+}
+
+func strEndsWith(args []Expression, vals []any, regs regexpCache) (any, error) {
+	return strings.HasSuffix(toString(vals[0]), toString(vals[1])), nil
+}
+
+func funcEndsWith(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, strEndsWith)
+}
+
+func strContains(args []Expression, vals []any, regs regexpCache) (any, error) {
+	return strings.Contains(toString(vals[0]), toString(vals[1])), nil
+}
+
+func funcContains(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, strContains)
+}
+
+// strIndexOf returns the byte index of sub string, the same as substr
+// function, or -1 if not found.
+func strIndexOf(args []Expression, vals []any, regs regexpCache) (any, error) {
+	return int64(strings.Index(toString(vals[0]), toString(vals[1]))), nil
+}
+
+func funcIndexOf(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, strIndexOf)
+}
+
+func strRepeat(args []Expression, vals []any, regs regexpCache) (any, error) {
+	val := toString(vals[0])
+	count := toInt(vals[1], 0)
+	if count <= 0 || len(val) == 0 {
+		return "", nil
+	}
+	if count > maxFuncStringLen/int64(len(val)) {
+		return nil, NewExecuteError(args[1].GetPos(), "repeat function result exceeds max length %d", maxFuncStringLen)
+	}
+	return strings.Repeat(val, int(count)), nil
+}
+
+func funcRepeat(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, strRepeat)
+}
+
+// strReverse reverses the runes of string, so UTF-8 characters are kept
+func strReverse(args []Expression, vals []any, regs regexpCache) (any, error) {
+	runes := []rune(toString(vals[0]))
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes), nil
+}
+
+func funcReverse(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, strReverse)
+}
+
+// strPadFunc pads the string to length characters by pad string, default
+// is space. The string longer than length is truncated. Unlike strlen and
+// substr, the length is counted in runes so UTF-8 characters are not split.
+func strPadFunc(fname string, left bool) valueFunc {
+	return func(args []Expression, vals []any, regs regexpCache) (any, error) {
+		if err := checkMaxArgs(fname, args, 3); err != nil {
+			return nil, err
+		}
+		runes := []rune(toString(vals[0]))
+		length := toInt(vals[1], 0)
+		if length > maxFuncStringLen {
+			return nil, NewExecuteError(args[1].GetPos(), "%s function result exceeds max length %d", fname, maxFuncStringLen)
+		}
+		pad := []rune(" ")
+		if len(vals) > 2 {
+			pad = []rune(toString(vals[2]))
+		}
+		switch {
+		case length <= 0:
+			return "", nil
+		case int(length) <= len(runes):
+			return string(runes[:length]), nil
+		case len(pad) == 0:
+			return string(runes), nil
+		}
+		padding := make([]rune, 0, int(length)-len(runes))
+		for len(padding) < cap(padding) {
+			padding = append(padding, pad[len(padding)%len(pad)])
+		}
+		if left {
+			return string(padding) + string(runes), nil
+		}
+		return string(runes) + string(padding), nil
+	}
+}
+
+var (
+	strLPad = strPadFunc("lpad", true)
+	strRPad = strPadFunc("rpad", false)
+)
+
+func funcLPad(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, strLPad)
+}
+
+func funcRPad(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, strRPad)
+}
+
+// strFormat formats the arguments by printf style format string, the
+// arguments are converted to the type required by verbs, e.g. `%d` converts
+// string '10' to integer.
+func strFormat(args []Expression, vals []any, regs regexpCache) (any, error) {
+	format := toString(vals[0])
+	fargs := vals[1:]
+	idx := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		i++
+		for i < len(format) && strings.IndexByte("+-# 0123456789.", format[i]) >= 0 {
+			i++
+		}
+		if i >= len(format) || format[i] == '%' {
+			continue
+		}
+		if idx < len(fargs) {
+			fargs[idx] = formatArg(format[i], fargs[idx])
+		}
+		idx++
+	}
+	return fmt.Sprintf(format, fargs...), nil
+}
+
+func formatArg(verb byte, val any) any {
+	switch verb {
+	case 'd', 'b', 'o', 'O', 'c', 'U':
+		return toInt(val, 0)
+	case 'e', 'E', 'f', 'F', 'g', 'G':
+		return toFloat(val, 0)
+	case 's', 'q':
+		return toString(val)
+	case 'x', 'X':
+		if _, ok := val.([]byte); ok {
+			return val
+		}
+		if _, ok := convertToInt(val); ok {
+			return val
+		}
+		return toString(val)
+	case 't':
+		return val
+	}
+	if bval, ok := val.([]byte); ok {
+		return string(bval)
+	}
+	return val
+}
+
+func funcFormat(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, strFormat)
+}
+
+// strRegexpExtract returns the sub match of group, the default group is
+// the first capture group if pattern has one, otherwise the whole match.
+// Returns empty string if not match.
+func strRegexpExtract(args []Expression, vals []any, regs regexpCache) (any, error) {
+	if err := checkMaxArgs("regexp_extract", args, 3); err != nil {
+		return nil, err
+	}
+	reg, err := regs.compile(toString(vals[1]))
+	if err != nil {
+		return nil, NewExecuteError(args[1].GetPos(), "Invalid regexp: %s", err)
+	}
+	group := 0
+	if len(vals) > 2 {
+		group = int(toInt(vals[2], -1))
+	} else if reg.NumSubexp() > 0 {
+		group = 1
+	}
+	if group < 0 || group > reg.NumSubexp() {
+		return nil, NewExecuteError(args[len(args)-1].GetPos(), "regexp_extract group %d out of range, pattern has %d groups", group, reg.NumSubexp())
+	}
+	match := reg.FindStringSubmatch(toString(vals[0]))
+	if match == nil {
+		return "", nil
+	}
+	return match[group], nil
+}
+
+func funcRegexpExtract(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, strRegexpExtract)
+}
+
+// strRegexpReplace replaces all the matches, the replacement can refer
+// capture groups by `$1` or `${name}`.
+func strRegexpReplace(args []Expression, vals []any, regs regexpCache) (any, error) {
+	reg, err := regs.compile(toString(vals[1]))
+	if err != nil {
+		return nil, NewExecuteError(args[1].GetPos(), "Invalid regexp: %s", err)
+	}
+	return reg.ReplaceAllString(toString(vals[0]), toString(vals[2])), nil
+}
+
+func funcRegexpReplace(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, strRegexpReplace)
+}
+
+// toNumber converts value to int64 or float64, the numeric string is
+// parsed as integer first and then float.
+func toNumber(value any) (any, bool) {
+	if ival, ok := convertToInt(value); ok {
+		return ival, true
+	}
+	if fval, ok := convertToFloat(value); ok {
+		return fval, true
+	}
+	if sval, ok := convertToByteArray(value); ok {
+		str := strings.TrimSpace(string(sval))
+		if ival, err := strconv.ParseInt(str, 10, 64); err == nil {
+			return ival, true
+		}
+		if fval, err := strconv.ParseFloat(str, 64); err == nil {
+			return fval, true
+		}
+	}
+	return nil, false
+}
+
+func numberArg(fname string, args []Expression, vals []any, idx int) (any, error) {
+	ret, ok := toNumber(vals[idx])
+	if !ok {
+		return nil, NewExecuteError(args[idx].GetPos(), "%s function parameter require number type", fname)
+	}
+	return ret, nil
+}
+
+func floatArg(fname string, args []Expression, vals []any, idx int) (float64, error) {
+	ret, err := numberArg(fname, args, vals, idx)
+	if err != nil {
+		return 0, err
+	}
+	fval, _ := convertToFloat(ret)
+	return fval, nil
+}
+
+func mathAbs(args []Expression, vals []any, regs regexpCache) (any, error) {
+	num, err := numberArg("abs", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	switch val := num.(type) {
+	case int64:
+		if val == math.MinInt64 {
+			return nil, NewExecuteError(args[0].GetPos(), "Integer overflow: abs(%d)", val)
+		}
+		if val < 0 {
+			return -val, nil
+		}
+		return val, nil
+	default:
+		return math.Abs(val.(float64)), nil
+	}
+}
+
+func funcAbs(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, mathAbs)
+}
+
+func mathSign(args []Expression, vals []any, regs regexpCache) (any, error) {
+	fval, err := floatArg("sign", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case fval > 0:
+		return int64(1), nil
+	case fval < 0:
+		return int64(-1), nil
+	}
+	return int64(0), nil
+}
+
+func funcSign(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, mathSign)
+}
+
+// mathRoundFunc returns the rounding function, integer argument is
+// returned as is and float argument returns float.
+func mathRoundFunc(fname string, round func(float64) float64) valueFunc {
+	return func(args []Expression, vals []any, regs regexpCache) (any, error) {
+		num, err := numberArg(fname, args, vals, 0)
+		if err != nil {
+			return nil, err
+		}
+		if fval, ok := num.(float64); ok {
+			return round(fval), nil
+		}
+		return num, nil
+	}
+}
+
+var (
+	mathCeil  = mathRoundFunc("ceil", math.Ceil)
+	mathFloor = mathRoundFunc("floor", math.Floor)
+	mathTrunc = mathRoundFunc("trunc", math.Trunc)
+)
+
+func funcCeil(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, mathCeil)
+}
+
+func funcFloor(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, mathFloor)
+}
+
+func funcTrunc(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, mathTrunc)
+}
+
+// mathRound rounds half away from zero to digits after decimal point,
+// negative digits rounds the digits before decimal point.
+func mathRound(args []Expression, vals []any, regs regexpCache) (any, error) {
+	if err := checkMaxArgs("round", args, 2); err != nil {
+		return nil, err
+	}
+	num, err := numberArg("round", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	var digits int64
+	if len(vals) > 1 {
+		digits = toInt(vals[1], 0)
+	}
+	ival, isInt := num.(int64)
+	if isInt && digits >= 0 {
+		return ival, nil
+	}
+	fval, _ := convertToFloat(num)
+	if digits == 0 {
+		return math.Round(fval), nil
+	}
+	scale := math.Pow(10, float64(digits))
+	ret := math.Round(fval*scale) / scale
+	if math.IsInf(scale, 0) || math.IsNaN(ret) {
+		ret = fval
+	}
+	if isInt {
+		return int64(ret), nil
+	}
+	return ret, nil
+}
+
+func funcRound(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, mathRound)
+}
+
+// mathPow returns integer if both arguments are integers and exponent is
+// not negative, otherwise returns float.
+func mathPow(args []Expression, vals []any, regs regexpCache) (any, error) {
+	base, err := numberArg("pow", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	exp, err := numberArg("pow", args, vals, 1)
+	if err != nil {
+		return nil, err
+	}
+	ibase, bok := base.(int64)
+	iexp, eok := exp.(int64)
+	if bok && eok && iexp >= 0 {
+		ret, ok := powInt(ibase, iexp)
+		if !ok {
+			return nil, NewExecuteError(args[1].GetPos(), "Integer overflow: pow(%d, %d)", ibase, iexp)
+		}
+		return ret, nil
+	}
+	fbase, _ := convertToFloat(base)
+	fexp, _ := convertToFloat(exp)
+	ret := math.Pow(fbase, fexp)
+	if math.IsNaN(ret) {
+		return nil, NewExecuteError(args[0].GetPos(), "pow function result is not a number")
+	}
+	return ret, nil
+}
+
+func powInt(base, exp int64) (int64, bool) {
+	ret := int64(1)
+	for exp > 0 {
+		if exp&1 == 1 {
+			next := ret * base
+			if base != 0 && (next/base != ret || (base == -1 && ret == math.MinInt64)) {
+				return 0, false
+			}
+			ret = next
+		}
+		exp >>= 1
+		if exp > 0 {
+			next := base * base
+			if base != 0 && next/base != base {
+				return 0, false
+			}
+			base = next
+		}
+	}
+	return ret, true
+}
+
+func funcPow(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, mathPow)
+}
+
+func mathSqrt(args []Expression, vals []any, regs regexpCache) (any, error) {
+	fval, err := floatArg("sqrt", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	if fval < 0 {
+		return nil, NewExecuteError(args[0].GetPos(), "sqrt function parameter should not be negative")
+	}
+	return math.Sqrt(fval), nil
+}
+
+func funcSqrt(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, mathSqrt)
+}
+
+func mathExp(args []Expression, vals []any, regs regexpCache) (any, error) {
+	fval, err := floatArg("exp", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	return math.Exp(fval), nil
+}
+
+func funcExp(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, mathExp)
+}
+
+// mathLogFunc returns the logarithm function, the argument should be
+// positive.
+func mathLogFunc(fname string, log func(float64) float64) valueFunc {
+	return func(args []Expression, vals []any, regs regexpCache) (any, error) {
+		fval, err := floatArg(fname, args, vals, 0)
+		if err != nil {
+			return nil, err
+		}
+		if fval <= 0 {
+			return nil, NewExecuteError(args[0].GetPos(), "%s function parameter should be positive", fname)
+		}
+		return log(fval), nil
+	}
+}
+
+var (
+	mathLn    = mathLogFunc("ln", math.Log)
+	mathLog2  = mathLogFunc("log2", math.Log2)
+	mathLog10 = mathLogFunc("log10", math.Log10)
+)
+
+// mathLog returns natural logarithm of x by log(x), or logarithm of x to
+// base by log(base, x).
+func mathLog(args []Expression, vals []any, regs regexpCache) (any, error) {
+	if err := checkMaxArgs("log", args, 2); err != nil {
+		return nil, err
+	}
+	if len(vals) == 1 {
+		return mathLogFunc("log", math.Log)(args, vals, regs)
+	}
+	base, err := mathLogFunc("log", math.Log)(args[:1], vals[:1], regs)
+	if err != nil {
+		return nil, err
+	}
+	if base.(float64) == 0 {
+		return nil, NewExecuteError(args[0].GetPos(), "log function base should not be 1")
+	}
+	x, err := mathLogFunc("log", math.Log)(args[1:], vals[1:], regs)
+	if err != nil {
+		return nil, err
+	}
+	return x.(float64) / base.(float64), nil
+}
+
+func funcLn(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, mathLn)
+}
+
+func funcLog(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, mathLog)
+}
+
+func funcLog2(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, mathLog2)
+}
+
+func funcLog10(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, mathLog10)
+}
+
+// mathMod is the same as `%` operator
+func mathMod(args []Expression, vals []any, regs regexpCache) (any, error) {
+	left, err := numberArg("mod", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	right, err := numberArg("mod", args, vals, 1)
+	if err != nil {
+		return nil, err
+	}
+	return executeMathOp(left, right, '%', args[1])
+}
+
+func funcMod(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, mathMod)
+}
+
+// mathExtremeFunc returns greatest or least function, the result is
+// integer if all the arguments are integers, otherwise float.
+func mathExtremeFunc(fname string, greater bool) valueFunc {
+	return func(args []Expression, vals []any, regs regexpCache) (any, error) {
+		var (
+			ret    any
+			retf   float64
+			allInt = true
+		)
+		for i := range vals {
+			num, err := numberArg(fname, args, vals, i)
+			if err != nil {
+				return nil, err
+			}
+			fval, _ := convertToFloat(num)
+			if _, ok := num.(int64); !ok {
+				allInt = false
+			}
+			if i == 0 || (greater && fval > retf) || (!greater && fval < retf) {
+				ret, retf = num, fval
+			}
+		}
+		if allInt {
+			return ret, nil
+		}
+		return retf, nil
+	}
+}
+
+var (
+	mathGreatest = mathExtremeFunc("greatest", true)
+	mathLeast    = mathExtremeFunc("least", false)
+)
+
+func funcGreatest(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, mathGreatest)
+}
+
+func funcLeast(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, mathLeast)
+}
+
+func funcPi(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return math.Pi, nil
+}
+
+// timeLayouts are the layouts to parse time string without format, time
+// without zone is in UTC.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02",
+}
+
+// toTime converts time value or time string in timeLayouts into time.
+func toTime(value any) (time.Time, bool) {
+	switch val := value.(type) {
+	case time.Time:
+		return val, true
+	case string, []byte:
+		str := strings.TrimSpace(toString(val))
+		for _, layout := range timeLayouts {
+			if ret, err := time.Parse(layout, str); err == nil {
+				return ret, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+func timeArg(fname string, args []Expression, vals []any, idx int) (time.Time, error) {
+	ret, ok := toTime(vals[idx])
+	if !ok {
+		return ret, NewExecuteError(args[idx].GetPos(), "%s function parameter require time type", fname)
+	}
+	return ret, nil
+}
+
+// strftimeLayouts maps the strftime directives to Go time layout
+var strftimeLayouts = map[byte]string{
+	'Y': "2006",
+	'y': "06",
+	'm': "01",
+	'd': "02",
+	'e': "_2",
+	'H': "15",
+	'I': "03",
+	'M': "04",
+	'S': "05",
+	'f': "000000",
+	'L': "000",
+	'p': "PM",
+	'b': "Jan",
+	'B': "January",
+	'a': "Mon",
+	'A': "Monday",
+	'j': "002",
+	'z': "-0700",
+	'Z': "MST",
+	'%': "%",
+}
+
+// timeLayout converts strftime format such as `%Y-%m-%d %H:%M:%S` into
+// Go time layout, format without `%` is used as Go time layout directly.
+func timeLayout(format string) (string, error) {
+	if !strings.Contains(format, "%") {
+		return format, nil
+	}
+	var ret strings.Builder
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			ret.WriteByte(format[i])
+			continue
+		}
+		if i+1 >= len(format) {
+			return "", fmt.Errorf("Invalid time format %q", format)
+		}
+		i++
+		layout, ok := strftimeLayouts[format[i]]
+		if !ok {
+			return "", fmt.Errorf("Invalid time format directive %%%c", format[i])
+		}
+		ret.WriteString(layout)
+	}
+	return ret.String(), nil
+}
+
+func timeNow(args []Expression, vals []any, regs regexpCache) (any, error) {
+	return time.Now().UTC(), nil
+}
+
+func funcNow(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, timeNow)
+}
+
+// epochUnits is the duration of unix timestamp units
+var epochUnits = map[string]int64{
+	"s":  int64(time.Second),
+	"ms": int64(time.Millisecond),
+	"us": int64(time.Microsecond),
+	"ns": int64(time.Nanosecond),
+}
+
+func epochUnitArg(fname string, args []Expression, vals []any, idx int) (int64, error) {
+	if len(vals) <= idx {
+		return int64(time.Second), nil
+	}
+	unit, ok := epochUnits[strings.ToLower(toString(vals[idx]))]
+	if !ok {
+		return 0, NewExecuteError(args[idx].GetPos(), "%s function unit should be one of s, ms, us and ns", fname)
+	}
+	return unit, nil
+}
+
+// timeFromUnix converts unix timestamp in unit (default is second) to
+// time in UTC.
+func timeFromUnix(args []Expression, vals []any, regs regexpCache) (any, error) {
+	if err := checkMaxArgs("from_unixtime", args, 2); err != nil {
+		return nil, err
+	}
+	num, err := numberArg("from_unixtime", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	unit, err := epochUnitArg("from_unixtime", args, vals, 1)
+	if err != nil {
+		return nil, err
+	}
+	if ival, ok := num.(int64); ok {
+		return time.Unix(ival/(int64(time.Second)/unit), ival%(int64(time.Second)/unit)*unit).UTC(), nil
+	}
+	fval := num.(float64) * float64(unit)
+	if math.IsNaN(fval) || math.Abs(fval) >= math.MaxInt64 {
+		return nil, NewExecuteError(args[0].GetPos(), "from_unixtime function parameter out of range")
+	}
+	return time.Unix(0, int64(fval)).UTC(), nil
+}
+
+func funcFromUnixTime(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, timeFromUnix)
+}
+
+func timeToUnix(args []Expression, vals []any, regs regexpCache) (any, error) {
+	if err := checkMaxArgs("to_unixtime", args, 2); err != nil {
+		return nil, err
+	}
+	t, err := timeArg("to_unixtime", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	unit, err := epochUnitArg("to_unixtime", args, vals, 1)
+	if err != nil {
+		return nil, err
+	}
+	switch time.Duration(unit) {
+	case time.Second:
+		return t.Unix(), nil
+	case time.Millisecond:
+		return t.UnixMilli(), nil
+	case time.Microsecond:
+		return t.UnixMicro(), nil
+	}
+	return t.UnixNano(), nil
+}
+
+func funcToUnixTime(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, timeToUnix)
+}
+
+func timeParse(args []Expression, vals []any, regs regexpCache) (any, error) {
+	if err := checkMaxArgs("parse_time", args, 2); err != nil {
+		return nil, err
+	}
+	if len(vals) == 1 {
+		return timeArg("parse_time", args, vals, 0)
+	}
+	layout, err := timeLayout(toString(vals[1]))
+	if err != nil {
+		return nil, NewExecuteError(args[1].GetPos(), "%s", err)
+	}
+	ret, err := time.Parse(layout, toString(vals[0]))
+	if err != nil {
+		return nil, NewExecuteError(args[0].GetPos(), "parse_time function cannot parse %q", toString(vals[0]))
+	}
+	return ret, nil
+}
+
+func funcParseTime(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, timeParse)
+}
+
+func timeFormat(args []Expression, vals []any, regs regexpCache) (any, error) {
+	if err := checkMaxArgs("format_time", args, 2); err != nil {
+		return nil, err
+	}
+	t, err := timeArg("format_time", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	layout := time.RFC3339Nano
+	if len(vals) > 1 {
+		if layout, err = timeLayout(toString(vals[1])); err != nil {
+			return nil, NewExecuteError(args[1].GetPos(), "%s", err)
+		}
+	}
+	return t.Format(layout), nil
+}
+
+func funcFormatTime(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, timeFormat)
+}
+
+// timeUnit normalizes the time unit name, plural name is accepted.
+func timeUnit(unit string) string {
+	unit = strings.ToLower(strings.TrimSpace(unit))
+	switch unit {
+	case "ms", "milliseconds":
+		return "millisecond"
+	case "s", "sec":
+		return "second"
+	case "min":
+		return "minute"
+	case "h":
+		return "hour"
+	case "d":
+		return "day"
+	}
+	return strings.TrimSuffix(unit, "s")
+}
+
+// truncTime truncates t to the beginning of unit in t's location, week
+// begins on Monday.
+func truncTime(t time.Time, unit string) (time.Time, bool) {
+	year, month, day := t.Date()
+	loc := t.Location()
+	switch unit {
+	case "millisecond":
+		return t.Truncate(time.Millisecond), true
+	case "second":
+		return time.Date(year, month, day, t.Hour(), t.Minute(), t.Second(), 0, loc), true
+	case "minute":
+		return time.Date(year, month, day, t.Hour(), t.Minute(), 0, 0, loc), true
+	case "hour":
+		return time.Date(year, month, day, t.Hour(), 0, 0, 0, loc), true
+	case "day":
+		return time.Date(year, month, day, 0, 0, 0, 0, loc), true
+	case "week":
+		return time.Date(year, month, day-(int(t.Weekday())+6)%7, 0, 0, 0, 0, loc), true
+	case "month":
+		return time.Date(year, month, 1, 0, 0, 0, 0, loc), true
+	case "quarter":
+		return time.Date(year, month-(month-1)%3, 1, 0, 0, 0, 0, loc), true
+	case "year":
+		return time.Date(year, 1, 1, 0, 0, 0, 0, loc), true
+	}
+	return t, false
+}
+
+func timeTrunc(args []Expression, vals []any, regs regexpCache) (any, error) {
+	t, err := timeArg("date_trunc", args, vals, 1)
+	if err != nil {
+		return nil, err
+	}
+	ret, ok := truncTime(t, timeUnit(toString(vals[0])))
+	if !ok {
+		return nil, NewExecuteError(args[0].GetPos(), "date_trunc function invalid unit %s", toString(vals[0]))
+	}
+	return ret, nil
+}
+
+func funcDateTrunc(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, timeTrunc)
+}
+
+// addTime adds n units to t, adding months keeps the day in the target
+// month, e.g. 2024-01-31 + 1 month is 2024-02-29.
+func addTime(t time.Time, n int64, unit string) (time.Time, bool) {
+	switch unit {
+	case "nanosecond":
+		return t.Add(time.Duration(n)), true
+	case "microsecond":
+		return t.Add(time.Duration(n) * time.Microsecond), true
+	case "millisecond":
+		return t.Add(time.Duration(n) * time.Millisecond), true
+	case "second":
+		return t.Add(time.Duration(n) * time.Second), true
+	case "minute":
+		return t.Add(time.Duration(n) * time.Minute), true
+	case "hour":
+		return t.Add(time.Duration(n) * time.Hour), true
+	case "day":
+		return t.AddDate(0, 0, int(n)), true
+	case "week":
+		return t.AddDate(0, 0, int(n)*7), true
+	case "month", "quarter", "year":
+		months := int(n)
+		if unit == "quarter" {
+			months *= 3
+		} else if unit == "year" {
+			months *= 12
+		}
+		year, month, day := t.Date()
+		first := time.Date(year, month+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+		lastDay := first.AddDate(0, 1, -1).Day()
+		return first.AddDate(0, 0, min(day, lastDay)-1), true
+	}
+	return t, false
+}
+
+// parseInterval parses interval string in `<n> <unit>` form such as
+// '3 days', or Go duration form such as '1h30m'.
+func parseInterval(interval string) (int64, string, bool) {
+	fields := strings.Fields(interval)
+	if len(fields) == 2 {
+		n, err := strconv.ParseInt(fields[0], 10, 64)
+		if err != nil {
+			return 0, "", false
+		}
+		return n, timeUnit(fields[1]), true
+	}
+	if d, err := time.ParseDuration(strings.ReplaceAll(interval, " ", "")); err == nil {
+		return int64(d), "nanosecond", true
+	}
+	return 0, "", false
+}
+
+// timeAddFunc returns date_add or date_sub function, the interval can be
+// given by date_add(t, n, unit) or date_add(t, interval).
+func timeAddFunc(fname string, sign int64) valueFunc {
+	return func(args []Expression, vals []any, regs regexpCache) (any, error) {
+		if err := checkMaxArgs(fname, args, 3); err != nil {
+			return nil, err
+		}
+		t, err := timeArg(fname, args, vals, 0)
+		if err != nil {
+			return nil, err
+		}
+		var (
+			n    int64
+			unit string
+			ok   bool
+		)
+		if len(vals) == 3 {
+			n, ok = convertToInt(vals[1])
+			if !ok {
+				if n, err = strconv.ParseInt(toString(vals[1]), 10, 64); err != nil {
+					return nil, NewExecuteError(args[1].GetPos(), "%s function interval require integer type", fname)
+				}
+			}
+			unit = timeUnit(toString(vals[2]))
+		} else if n, unit, ok = parseInterval(toString(vals[1])); !ok {
+			return nil, NewExecuteError(args[1].GetPos(), "%s function invalid interval %s", fname, toString(vals[1]))
+		}
+		ret, ok := addTime(t, sign*n, unit)
+		if !ok {
+			return nil, NewExecuteError(args[len(args)-1].GetPos(), "%s function invalid unit %s", fname, unit)
+		}
+		return ret, nil
+	}
+}
+
+var (
+	timeAdd = timeAddFunc("date_add", 1)
+	timeSub = timeAddFunc("date_sub", -1)
+)
+
+func funcDateAdd(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, timeAdd)
+}
+
+func funcDateSub(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, timeSub)
+}
+
+// timeExtractFunc returns the function extracts a field of time
+func timeExtractFunc(fname string, extract func(time.Time) int) valueFunc {
+	return func(args []Expression, vals []any, regs regexpCache) (any, error) {
+		t, err := timeArg(fname, args, vals, 0)
+		if err != nil {
+			return nil, err
+		}
+		return int64(extract(t)), nil
+	}
+}
+
+var (
+	timeYear   = timeExtractFunc("year", time.Time.Year)
+	timeMonth  = timeExtractFunc("month", func(t time.Time) int { return int(t.Month()) })
+	timeDay    = timeExtractFunc("day", time.Time.Day)
+	timeHour   = timeExtractFunc("hour", time.Time.Hour)
+	timeMinute = timeExtractFunc("minute", time.Time.Minute)
+	timeSecond = timeExtractFunc("second", time.Time.Second)
+)
+
+func funcYear(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, timeYear)
+}
+
+func funcMonth(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, timeMonth)
+}
+
+func funcDay(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, timeDay)
+}
+
+func funcHour(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, timeHour)
+}
+
+func funcMinute(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, timeMinute)
+}
+
+func funcSecond(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, timeSecond)
+}
+
+func bytesHex(args []Expression, vals []any, regs regexpCache) (any, error) {
+	return hex.EncodeToString([]byte(toString(vals[0]))), nil
+}
+
+func funcHex(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, bytesHex)
+}
+
+func bytesUnhex(args []Expression, vals []any, regs regexpCache) (any, error) {
+	ret, err := hex.DecodeString(strings.TrimPrefix(strings.TrimPrefix(toString(vals[0]), "0x"), "0X"))
+	if err != nil {
+		return nil, NewExecuteError(args[0].GetPos(), "unhex function parameter is not hex string")
+	}
+	return ret, nil
+}
+
+func funcUnhex(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, bytesUnhex)
+}
+
+func bytesToBase64(args []Expression, vals []any, regs regexpCache) (any, error) {
+	return base64.StdEncoding.EncodeToString([]byte(toString(vals[0]))), nil
+}
+
+func funcToBase64(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, bytesToBase64)
+}
+
+// bytesFromBase64 decodes standard or URL base64, with or without padding
+func bytesFromBase64(args []Expression, vals []any, regs regexpCache) (any, error) {
+	str := toString(vals[0])
+	encoding := base64.StdEncoding
+	if strings.ContainsAny(str, "-_") {
+		encoding = base64.URLEncoding
+	}
+	if !strings.HasSuffix(str, "=") {
+		encoding = encoding.WithPadding(base64.NoPadding)
+	}
+	ret, err := encoding.DecodeString(str)
+	if err != nil {
+		return nil, NewExecuteError(args[0].GetPos(), "from_base64 function parameter is not base64 string")
+	}
+	return ret, nil
+}
+
+func funcFromBase64(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, bytesFromBase64)
+}
+
+// bytesValue converts value into bytes without copying bytes value
+func bytesValue(value any) []byte {
+	if bval, ok := value.([]byte); ok {
+		return bval
+	}
+	return []byte(toString(value))
+}
+
+func bytesToBytes(args []Expression, vals []any, regs regexpCache) (any, error) {
+	return bytesValue(vals[0]), nil
+}
+
+func funcToBytes(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, bytesToBytes)
+}
+
+func intArg(fname string, args []Expression, vals []any, idx int) (int64, error) {
+	num, err := numberArg(fname, args, vals, idx)
+	if err != nil {
+		return 0, err
+	}
+	ival, ok := num.(int64)
+	if !ok {
+		return 0, NewExecuteError(args[idx].GetPos(), "%s function parameter require integer type", fname)
+	}
+	return ival, nil
+}
+
+// uintArg returns the unsigned integer argument in range [0, max], the
+// value larger than max int64 can be uint64 or string.
+func uintArg(fname string, args []Expression, vals []any, idx int, max uint64) (uint64, error) {
+	var (
+		ret uint64
+		ok  bool
+	)
+	switch val := vals[idx].(type) {
+	case uint64:
+		ret, ok = val, true
+	case string, []byte:
+		uval, err := strconv.ParseUint(strings.TrimSpace(toString(val)), 10, 64)
+		ret, ok = uval, err == nil
+	default:
+		ival, isInt := convertToInt(val)
+		ret, ok = uint64(ival), isInt && ival >= 0
+	}
+	if !ok || ret > max {
+		return 0, NewExecuteError(args[idx].GetPos(), "%s function parameter require unsigned integer in range [0, %d]", fname, max)
+	}
+	return ret, nil
+}
+
+// keyDecoder decodes the field at the beginning of data, returns the value
+// and the number of bytes read.
+type keyDecoder func(data []byte) (any, int, error)
+
+func fixedDecoder(size int, decode func([]byte) any) keyDecoder {
+	return func(data []byte) (any, int, error) {
+		if len(data) < size {
+			return nil, 0, errInsufficientData
+		}
+		return decode(data[:size]), size, nil
+	}
+}
+
+// keyDecodeFunc returns the function decodes the field of key at the
+// offset, the offset is 0 if not given. It returns null if the key is too
+// short or malformed, so the comparison of field is false for such keys no
+// matter the key is filtered by full scan or the range of the field.
+func keyDecodeFunc(fname string, decode keyDecoder) valueFunc {
+	return func(args []Expression, vals []any, regs regexpCache) (any, error) {
+		if err := checkMaxArgs(fname, args, 2); err != nil {
+			return nil, err
+		}
+		data := bytesValue(vals[0])
+		var offset int64
+		if len(vals) > 1 {
+			var err error
+			offset, err = intArg(fname, args, vals, 1)
+			if err != nil {
+				return nil, err
+			}
+			if offset < 0 {
+				return nil, NewExecuteError(args[1].GetPos(), "%s function offset %d out of range", fname, offset)
+			}
+			if offset > int64(len(data)) {
+				return nil, nil
+			}
+		}
+		ret, _, err := decode(data[offset:])
+		if err != nil {
+			return nil, nil
+		}
+		return ret, nil
+	}
+}
+
+var (
+	keyDecodeUint16BE = keyDecodeFunc("decode_uint16_be", fixedDecoder(2, func(b []byte) any {
+		return int64(binary.BigEndian.Uint16(b))
+	}))
+	keyDecodeUint32BE = keyDecodeFunc("decode_uint32_be", fixedDecoder(4, func(b []byte) any {
+		return int64(binary.BigEndian.Uint32(b))
+	}))
+	keyDecodeUint64BE = keyDecodeFunc("decode_uint64_be", fixedDecoder(8, func(b []byte) any {
+		return uintValue(binary.BigEndian.Uint64(b))
+	}))
+	keyDecodeInt64BE = keyDecodeFunc("decode_int64_be", fixedDecoder(8, func(b []byte) any {
+		return int64(binary.BigEndian.Uint64(b))
+	}))
+	keyDecodeVarint = keyDecodeFunc("decode_varint", func(b []byte) (any, int, error) {
+		return decodeVarint(b)
+	})
+	keyDecodeUvarint = keyDecodeFunc("decode_uvarint", func(b []byte) (any, int, error) {
+		v, n, err := decodeUvarint(b)
+		return uintValue(v), n, err
+	})
+	keyDecodeMemcomparableBytes = keyDecodeFunc("decode_memcomparable_bytes", func(b []byte) (any, int, error) {
+		return decodeMemcomparableBytes(b)
+	})
+	keyDecodeMemcomparableInt = keyDecodeFunc("decode_memcomparable_int", func(b []byte) (any, int, error) {
+		return decodeMemcomparableInt(b)
+	})
+)
+
+func funcDecodeUint16BE(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, keyDecodeUint16BE)
+}
+
+func funcDecodeUint32BE(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, keyDecodeUint32BE)
+}
+
+func funcDecodeUint64BE(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, keyDecodeUint64BE)
+}
+
+func funcDecodeInt64BE(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, keyDecodeInt64BE)
+}
+
+func funcDecodeVarint(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, keyDecodeVarint)
+}
+
+func funcDecodeUvarint(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, keyDecodeUvarint)
+}
+
+func funcDecodeMemcomparableBytes(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, keyDecodeMemcomparableBytes)
+}
+
+func funcDecodeMemcomparableInt(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, keyDecodeMemcomparableInt)
+}
+
+// uintEncodeFunc returns the function encodes unsigned integer as big-endian
+// with size bytes
+func uintEncodeFunc(fname string, size int) valueFunc {
+	max := uint64(math.MaxUint64) >> (64 - 8*size)
+	return func(args []Expression, vals []any, regs regexpCache) (any, error) {
+		v, err := uintArg(fname, args, vals, 0, max)
+		if err != nil {
+			return nil, err
+		}
+		ret := make([]byte, 8)
+		binary.BigEndian.PutUint64(ret, v)
+		return ret[8-size:], nil
+	}
+}
+
+var (
+	keyEncodeUint16BE = uintEncodeFunc("encode_uint16_be", 2)
+	keyEncodeUint32BE = uintEncodeFunc("encode_uint32_be", 4)
+	keyEncodeUint64BE = uintEncodeFunc("encode_uint64_be", 8)
+)
+
+func keyEncodeInt64BE(args []Expression, vals []any, regs regexpCache) (any, error) {
+	v, err := intArg("encode_int64_be", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	return binary.BigEndian.AppendUint64(nil, uint64(v)), nil
+}
+
+func keyEncodeVarint(args []Expression, vals []any, regs regexpCache) (any, error) {
+	v, err := intArg("encode_varint", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	return binary.AppendVarint(nil, v), nil
+}
+
+func keyEncodeUvarint(args []Expression, vals []any, regs regexpCache) (any, error) {
+	v, err := uintArg("encode_uvarint", args, vals, 0, math.MaxUint64)
+	if err != nil {
+		return nil, err
+	}
+	return binary.AppendUvarint(nil, v), nil
+}
+
+func keyEncodeMemcomparableBytes(args []Expression, vals []any, regs regexpCache) (any, error) {
+	return encodeMemcomparableBytes(nil, bytesValue(vals[0])), nil
+}
+
+func keyEncodeMemcomparableInt(args []Expression, vals []any, regs regexpCache) (any, error) {
+	v, err := intArg("encode_memcomparable_int", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	return encodeMemcomparableInt(nil, v), nil
+}
+
+func funcEncodeUint16BE(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, keyEncodeUint16BE)
+}
+
+func funcEncodeUint32BE(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, keyEncodeUint32BE)
+}
+
+func funcEncodeUint64BE(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, keyEncodeUint64BE)
+}
+
+func funcEncodeInt64BE(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, keyEncodeInt64BE)
+}
+
+func funcEncodeVarint(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, keyEncodeVarint)
+}
+
+func funcEncodeUvarint(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, keyEncodeUvarint)
+}
+
+func funcEncodeMemcomparableBytes(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, keyEncodeMemcomparableBytes)
+}
+
+func funcEncodeMemcomparableInt(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, keyEncodeMemcomparableInt)
+}
+
+// tidbKeyFunc returns the function decodes TiDB record or index key, it
+// returns null if the key is not the kind of key or malformed, so the keys
+// of other tables and non-table keys in the same storage can be scanned.
+func tidbKeyFunc(decode func(key []byte) (any, error)) valueFunc {
+	return func(args []Expression, vals []any, regs regexpCache) (any, error) {
+		ret, err := decode(bytesValue(vals[0]))
+		if err != nil {
+			return nil, nil
+		}
+		return ret, nil
+	}
+}
+
+var (
+	tidbTable = tidbKeyFunc(func(key []byte) (any, error) {
+		return decodeTiDBTableID(key)
+	})
+	tidbIndexID = tidbKeyFunc(func(key []byte) (any, error) {
+		return decodeTiDBIndexID(key)
+	})
+	tidbHandle = tidbKeyFunc(func(key []byte) (any, error) {
+		return decodeTiDBHandle(key)
+	})
+	tidbCommonHandle = tidbKeyFunc(func(key []byte) (any, error) {
+		return decodeTiDBCommonHandle(key)
+	})
+	tidbIndexValues = tidbKeyFunc(func(key []byte) (any, error) {
+		return decodeTiDBIndexValues(key)
+	})
+)
+
+func funcTiDBTable(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, tidbTable)
+}
+
+func funcTiDBIndexID(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, tidbIndexID)
+}
+
+func funcTiDBHandle(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, tidbHandle)
+}
+
+func funcTiDBCommonHandle(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, tidbCommonHandle)
+}
+
+func funcTiDBIndexValues(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, tidbIndexValues)
+}
+
+func tidbIsRecord(args []Expression, vals []any, regs regexpCache) (any, error) {
+	return isTiDBRecordKey(bytesValue(vals[0])), nil
+}
+
+func funcTiDBIsRecord(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, tidbIsRecord)
+}
+
+func tidbIsIndex(args []Expression, vals []any, regs regexpCache) (any, error) {
+	return isTiDBIndexKey(bytesValue(vals[0])), nil
+}
+
+func funcTiDBIsIndex(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, tidbIsIndex)
+}
+
+// tidbRow decodes row format v2 value into JSON keyed by column ID, the
+// optional types argument is JSON maps column ID to type, such as
+// '{"1": "int", "2": "str"}'.
+func tidbRow(args []Expression, vals []any, regs regexpCache) (any, error) {
+	if err := checkMaxArgs("tidb_row", args, 2); err != nil {
+		return nil, err
+	}
+	types := map[string]string{}
+	if len(vals) > 1 {
+		if err := json.Unmarshal(bytesValue(vals[1]), &types); err != nil {
+			return nil, NewExecuteError(args[1].GetPos(), "tidb_row function types parameter is not JSON object of column ID to type")
+		}
+	}
+	cols, err := decodeTiDBRow(bytesValue(vals[0]))
+	if err != nil {
+		return nil, NewExecuteError(args[0].GetPos(), "tidb_row function cannot decode value: %v", err)
+	}
+	ret := make(JSON, len(cols))
+	for _, col := range cols {
+		cid := strconv.FormatInt(col.id, 10)
+		ret[cid], err = decodeTiDBRowValue(col.data, types[cid])
+		if err != nil {
+			return nil, NewExecuteError(args[0].GetPos(), "tidb_row function cannot decode column %s: %v", cid, err)
+		}
+	}
+	return ret, nil
+}
+
+func funcTiDBRow(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, tidbRow)
+}
+
+func tidbTablePrefix(args []Expression, vals []any, regs regexpCache) (any, error) {
+	tableID, err := intArg("tidb_table_prefix", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	return encodeTiDBTablePrefix(tableID), nil
+}
+
+func funcTiDBTablePrefix(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, tidbTablePrefix)
+}
+
+func tidbRecordPrefix(args []Expression, vals []any, regs regexpCache) (any, error) {
+	tableID, err := intArg("tidb_record_prefix", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	return encodeTiDBRecordPrefix(tableID), nil
+}
+
+func funcTiDBRecordPrefix(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, tidbRecordPrefix)
+}
+
+func tidbIndexPrefix(args []Expression, vals []any, regs regexpCache) (any, error) {
+	tableID, err := intArg("tidb_index_prefix", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	indexID, err := intArg("tidb_index_prefix", args, vals, 1)
+	if err != nil {
+		return nil, err
+	}
+	return encodeTiDBIndexPrefix(tableID, indexID), nil
+}
+
+func funcTiDBIndexPrefix(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, tidbIndexPrefix)
+}
+
+func tidbRecordKey(args []Expression, vals []any, regs regexpCache) (any, error) {
+	tableID, err := intArg("tidb_record_key", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	handle, err := intArg("tidb_record_key", args, vals, 1)
+	if err != nil {
+		return nil, err
+	}
+	return encodeMemcomparableInt(encodeTiDBRecordPrefix(tableID), handle), nil
+}
+
+func funcTiDBRecordKey(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, tidbRecordKey)
+}
+
+// jsonDocArg returns the JSON document argument, string is parsed as JSON
+// text and the other values such as json(value) are used directly.
+func jsonDocArg(fname string, args []Expression, vals []any, idx int) (any, error) {
+	doc, err := parseJSONDocument(vals[idx])
+	if err != nil {
+		return nil, NewExecuteError(args[idx].GetPos(), "%s function parameter is not valid JSON: %v", fname, err)
+	}
+	return doc, nil
+}
+
+func jsonPathArg(fname string, args []Expression, vals []any, idx int, definite bool) (*jsonPath, error) {
+	path, err := parseJSONPath(toString(vals[idx]))
+	if err != nil {
+		return nil, NewExecuteError(args[idx].GetPos(), "%s function invalid path: %v", fname, err)
+	}
+	if definite && !path.definite {
+		return nil, NewExecuteError(args[idx].GetPos(), "%s function path cannot contain wildcard", fname)
+	}
+	return path, nil
+}
+
+// jsonPathValue returns the value of document at the optional path
+// argument, have is false if nothing matches the path.
+func jsonPathValue(fname string, args []Expression, vals []any, pathIdx int) (val any, have bool, err error) {
+	if err := checkMaxArgs(fname, args, pathIdx+1); err != nil {
+		return nil, false, err
+	}
+	doc, err := jsonDocArg(fname, args, vals, 0)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(vals) <= pathIdx {
+		return doc, true, nil
+	}
+	path, err := jsonPathArg(fname, args, vals, pathIdx, true)
+	if err != nil {
+		return nil, false, err
+	}
+	matched := path.eval(doc)
+	if len(matched) == 0 {
+		return nil, false, nil
+	}
+	return matched[0], true, nil
+}
+
+// jsonResult converts the JSON document into function result
+func jsonResult(doc any) any {
+	if obj, ok := doc.(map[string]any); ok {
+		return JSON(obj)
+	}
+	return doc
+}
+
+// jsonExtract returns the value matched by path, or nil if not matched.
+// The path with wildcard returns the list of all matched values.
+func jsonExtract(args []Expression, vals []any, regs regexpCache) (any, error) {
+	doc, err := jsonDocArg("json_extract", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	path, err := jsonPathArg("json_extract", args, vals, 1, false)
+	if err != nil {
+		return nil, err
+	}
+	matched := path.eval(doc)
+	if !path.definite {
+		if matched == nil {
+			return []any{}, nil
+		}
+		return matched, nil
+	}
+	if len(matched) == 0 {
+		return nil, nil
+	}
+	return jsonResult(matched[0]), nil
+}
+
+func funcJsonExtract(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, jsonExtract)
+}
+
+func jsonKeys(args []Expression, vals []any, regs regexpCache) (any, error) {
+	val, _, err := jsonPathValue("json_keys", args, vals, 1)
+	if err != nil {
+		return nil, err
+	}
+	obj, ok := val.(map[string]any)
+	if !ok {
+		return nil, nil
+	}
+	ret := make([]any, 0, len(obj))
+	for _, k := range sortedKeys(obj) {
+		ret = append(ret, k)
+	}
+	return ret, nil
+}
+
+func funcJsonKeys(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, jsonKeys)
+}
+
+func jsonLength(args []Expression, vals []any, regs regexpCache) (any, error) {
+	val, have, err := jsonPathValue("json_length", args, vals, 1)
+	if err != nil || !have {
+		return nil, err
+	}
+	switch v := val.(type) {
+	case map[string]any:
+		return int64(len(v)), nil
+	case []any:
+		return int64(len(v)), nil
+	}
+	return int64(1), nil
+}
+
+func funcJsonLength(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, jsonLength)
+}
+
+func jsonType(args []Expression, vals []any, regs regexpCache) (any, error) {
+	val, have, err := jsonPathValue("json_type", args, vals, 1)
+	if err != nil || !have {
+		return nil, err
+	}
+	return jsonTypeName(val), nil
+}
+
+func funcJsonType(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, jsonType)
+}
+
+// jsonContainsFunc checks the candidate is contained in document, string
+// candidate is parsed as JSON text such as '"admin"' or '{"a": 1}'.
+func jsonContainsFunc(args []Expression, vals []any, regs regexpCache) (any, error) {
+	val, have, err := jsonPathValue("json_contains", args, vals, 2)
+	if err != nil {
+		return nil, err
+	}
+	candidate, err := jsonDocArg("json_contains", args, vals, 1)
+	if err != nil {
+		return nil, err
+	}
+	return have && jsonContains(val, candidate), nil
+}
+
+func funcJsonContains(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, jsonContainsFunc)
+}
+
+// jsonSet replaces or inserts the values at paths, the input document is
+// not modified.
+func jsonSet(args []Expression, vals []any, regs regexpCache) (any, error) {
+	if len(args)%2 == 0 {
+		return nil, NewExecuteError(args[len(args)-1].GetPos(), "json_set function require path and value pairs")
+	}
+	doc, err := jsonDocArg("json_set", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	doc = jsonCopy(doc)
+	for i := 1; i < len(args); i += 2 {
+		path, err := jsonPathArg("json_set", args, vals, i, true)
+		if err != nil {
+			return nil, err
+		}
+		doc = path.set(doc, jsonCopy(jsonNormalize(vals[i+1])))
+	}
+	return jsonResult(doc), nil
+}
+
+func funcJsonSet(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, jsonSet)
+}
+
+// jsonRemove removes the values at paths, the input document is not
+// modified.
+func jsonRemove(args []Expression, vals []any, regs regexpCache) (any, error) {
+	doc, err := jsonDocArg("json_remove", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	doc = jsonCopy(doc)
+	for i := 1; i < len(args); i++ {
+		path, err := jsonPathArg("json_remove", args, vals, i, true)
+		if err != nil {
+			return nil, err
+		}
+		if len(path.legs) == 0 {
+			return nil, NewExecuteError(args[i].GetPos(), "json_remove function cannot remove root")
+		}
+		doc = path.remove(doc)
+	}
+	return jsonResult(doc), nil
+}
+
+func funcJsonRemove(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, jsonRemove)
+}
+
+func jsonObject(args []Expression, vals []any, regs regexpCache) (any, error) {
+	if len(args)%2 != 0 {
+		return nil, NewExecuteError(args[len(args)-1].GetPos(), "json_object function require key and value pairs")
+	}
+	ret := make(JSON, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		ret[toString(vals[i])] = jsonCopy(jsonNormalize(vals[i+1]))
+	}
+	return ret, nil
+}
+
+func funcJsonObject(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, jsonObject)
+}
+
+func jsonArray(args []Expression, vals []any, regs regexpCache) (any, error) {
+	ret := make([]any, len(vals))
+	for i, val := range vals {
+		ret[i] = jsonCopy(jsonNormalize(val))
+	}
+	return ret, nil
+}
+
+func funcJsonArray(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, jsonArray)
+}
+
+// lambdaFunc is the list function with lambda argument, vals are the
+// values of the arguments except the lambda.
+type lambdaFunc func(kv KVPair, args []Expression, vals []any, lambda *LambdaExpr, ctx *ExecuteCtx) (any, error)
+
+func execLambdaFunc(kv KVPair, args []Expression, ctx *ExecuteCtx, fn lambdaFunc) (any, error) {
+	if ctx == nil {
+		// Lambda arguments are kept by context
+		ctx = &ExecuteCtx{}
+	}
+	var (
+		vals   = make([]any, len(args))
+		lambda *LambdaExpr
+	)
+	for i, arg := range args {
+		if lexpr, ok := arg.(*LambdaExpr); ok {
+			lambda = lexpr
+			continue
+		}
+		rarg, err := arg.Execute(kv, ctx)
+		if err != nil {
+			return nil, err
+		}
+		vals[i] = rarg
+	}
+	if lambda == nil {
+		return nil, NewExecuteError(args[len(args)-1].GetPos(), "Function require lambda expression argument")
+	}
+	return fn(kv, args, vals, lambda, ctx)
+}
+
+// listArg returns the list argument as []any, null and empty string are
+// the missing JSON value, they are treated as empty list.
+func listArg(fname string, args []Expression, vals []any, idx int) ([]any, error) {
+	switch val := vals[idx].(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if val == "" {
+			return nil, nil
+		}
+	}
+	list, ok := unpackArray(vals[idx])
+	if !ok {
+		return nil, NewExecuteError(args[idx].GetPos(), "%s function parameter %d require list type", fname, idx+1)
+	}
+	return list, nil
+}
+
+func lambdaBool(fname string, lambda *LambdaExpr, val any) (bool, error) {
+	ret, ok := val.(bool)
+	if !ok {
+		return false, NewExecuteError(lambda.Body.GetPos(), "%s function lambda expression result is not boolean", fname)
+	}
+	return ret, nil
+}
+
+func listMap(kv KVPair, args []Expression, vals []any, lambda *LambdaExpr, ctx *ExecuteCtx) (any, error) {
+	list, err := listArg("list_map", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]any, len(list))
+	for i, item := range list {
+		ret[i], err = lambda.call(kv, ctx, item)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func funcListMap(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execLambdaFunc(kv, args, ctx, listMap)
+}
+
+func listFilter(kv KVPair, args []Expression, vals []any, lambda *LambdaExpr, ctx *ExecuteCtx) (any, error) {
+	list, err := listArg("list_filter", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]any, 0, len(list))
+	for _, item := range list {
+		rval, err := lambda.call(kv, ctx, item)
+		if err != nil {
+			return nil, err
+		}
+		match, err := lambdaBool("list_filter", lambda, rval)
+		if err != nil {
+			return nil, err
+		}
+		if match {
+			ret = append(ret, item)
+		}
+	}
+	return ret, nil
+}
+
+func funcListFilter(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execLambdaFunc(kv, args, ctx, listFilter)
+}
+
+// listReduce calls lambda (acc, x) for each element, acc starts from the
+// initial value and is replaced by the result.
+func listReduce(kv KVPair, args []Expression, vals []any, lambda *LambdaExpr, ctx *ExecuteCtx) (any, error) {
+	list, err := listArg("list_reduce", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	acc := vals[1]
+	for _, item := range list {
+		acc, err = lambda.call(kv, ctx, acc, item)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return acc, nil
+}
+
+func funcListReduce(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execLambdaFunc(kv, args, ctx, listReduce)
+}
+
+// listMatch returns the lambda function which reports whether any or all
+// elements match the lambda, empty list matches all but not any.
+func listMatch(fname string, all bool) lambdaFunc {
+	return func(kv KVPair, args []Expression, vals []any, lambda *LambdaExpr, ctx *ExecuteCtx) (any, error) {
+		list, err := listArg(fname, args, vals, 0)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range list {
+			rval, err := lambda.call(kv, ctx, item)
+			if err != nil {
+				return nil, err
+			}
+			match, err := lambdaBool(fname, lambda, rval)
+			if err != nil {
+				return nil, err
+			}
+			if match != all {
+				return match, nil
+			}
+		}
+		return all, nil
+	}
+}
+
+func funcListAny(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execLambdaFunc(kv, args, ctx, listMatch("list_any", false))
+}
+
+func funcListAll(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execLambdaFunc(kv, args, ctx, listMatch("list_all", true))
+}
+
+// listContains uses JSON comparison rules, so 1 and 1.0 are equal but 1
+// and '1' are not.
+func listContains(args []Expression, vals []any, regs regexpCache) (any, error) {
+	list, err := listArg("list_contains", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range list {
+		if jsonEqual(item, vals[1]) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func funcListContains(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, listContains)
+}
+
+// listSlice returns the elements from start to end (exclusive), negative
+// index counts from the end of list.
+func listSlice(args []Expression, vals []any, regs regexpCache) (any, error) {
+	if err := checkMaxArgs("list_slice", args, 3); err != nil {
+		return nil, err
+	}
+	list, err := listArg("list_slice", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	index := func(idx int) (int, error) {
+		if args[idx].ReturnType() != TNUMBER {
+			return 0, NewExecuteError(args[idx].GetPos(), "list_slice function parameter %d require number type", idx+1)
+		}
+		pos := int(toInt(vals[idx], 0))
+		if pos < 0 {
+			pos += len(list)
+		}
+		return max(0, min(pos, len(list))), nil
+	}
+	start, err := index(1)
+	if err != nil {
+		return nil, err
+	}
+	end := len(list)
+	if len(args) > 2 {
+		if end, err = index(2); err != nil {
+			return nil, err
+		}
+	}
+	if start >= end {
+		return []any{}, nil
+	}
+	return append([]any{}, list[start:end]...), nil
+}
+
+func funcListSlice(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, listSlice)
+}
+
+// listSort sorts the elements by JSON comparison rules, the elements
+// should be the same type.
+func listSort(args []Expression, vals []any, regs regexpCache) (any, error) {
+	if err := checkMaxArgs("list_sort", args, 2); err != nil {
+		return nil, err
+	}
+	list, err := listArg("list_sort", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	desc := false
+	if len(args) > 1 {
+		bval, ok := vals[1].(bool)
+		if !ok {
+			return nil, NewExecuteError(args[1].GetPos(), "list_sort function second parameter require bool type")
+		}
+		desc = bval
+	}
+	ret := make([]any, len(list))
+	for i, item := range list {
+		ret[i] = jsonNormalize(item)
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		cmp, ok := jsonCompareScalar(ret[i], ret[j])
+		if !ok && err == nil {
+			err = NewExecuteError(args[0].GetPos(), "list_sort function cannot compare %s and %s", toString(ret[i]), toString(ret[j]))
+		}
+		if desc {
+			return cmp > 0
+		}
+		return cmp < 0
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+func funcListSort(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, listSort)
+}
+
+// listDistinct removes the duplicated elements, the first one is kept
+func listDistinct(args []Expression, vals []any, regs regexpCache) (any, error) {
+	list, err := listArg("list_distinct", args, vals, 0)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]any, 0, len(list))
+	for _, item := range list {
+		dup := false
+		for _, prev := range ret {
+			if jsonEqual(prev, item) {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			ret = append(ret, item)
+		}
+	}
+	return ret, nil
+}
+
+func funcListDistinct(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, listDistinct)
+}
+
+// listNumbers converts the elements to int64 or float64, the string is
+// parsed as number and null is skipped. isInt is true if all numbers are
+// integers.
+func listNumbers(fname string, args []Expression, vals []any) ([]any, bool, error) {
+	list, err := listArg(fname, args, vals, 0)
+	if err != nil {
+		return nil, false, err
+	}
+	var (
+		ret   = make([]any, 0, len(list))
+		isInt = true
+	)
+	for _, item := range list {
+		switch v := item.(type) {
+		case nil:
+			continue
+		case string, []byte:
+			str := toString(v)
+			if ival, err := strconv.ParseInt(str, 10, 64); err == nil {
+				ret = append(ret, ival)
+			} else if fval, err := strconv.ParseFloat(str, 64); err == nil {
+				ret = append(ret, fval)
+				isInt = false
+			} else {
+				return nil, false, NewExecuteError(args[0].GetPos(), "%s function element %q is not number", fname, str)
+			}
+			continue
+		}
+		if ival, ok := convertToInt(item); ok {
+			ret = append(ret, ival)
+		} else if fval, ok := convertToFloat(item); ok {
+			ret = append(ret, fval)
+			isInt = false
+		} else {
+			return nil, false, NewExecuteError(args[0].GetPos(), "%s function element %s is not number", fname, toString(item))
+		}
+	}
+	return ret, isInt, nil
+}
+
+func listSum(args []Expression, vals []any, regs regexpCache) (any, error) {
+	nums, isInt, err := listNumbers("list_sum", args, vals)
+	if err != nil {
+		return nil, err
+	}
+	if isInt {
+		var sum int64
+		for _, num := range nums {
+			ret, err := executeIntMathOp(sum, num.(int64), '+', args[0])
+			if err != nil {
+				return nil, err
+			}
+			sum = ret.(int64)
+		}
+		return sum, nil
+	}
+	var sum float64
+	for _, num := range nums {
+		sum += toFloat(num, 0)
+	}
+	return sum, nil
+}
+
+func funcListSum(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, listSum)
+}
+
+// listAvg returns null for empty list
+func listAvg(args []Expression, vals []any, regs regexpCache) (any, error) {
+	nums, _, err := listNumbers("list_avg", args, vals)
+	if err != nil || len(nums) == 0 {
+		return nil, err
+	}
+	var sum float64
+	for _, num := range nums {
+		sum += toFloat(num, 0)
+	}
+	return sum / float64(len(nums)), nil
+}
+
+func funcListAvg(kv KVPair, args []Expression, ctx *ExecuteCtx) (any, error) {
+	return execValueFunc(kv, args, ctx, listAvg)
+}
@@ -0,0 +1,1166 @@
+package kvql
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// execFuncExpr executes the expression by both row and batch executor,
+// and checks the results are same.
+func execFuncExpr(t *testing.T, expr string, kv KVPair) (any, error) {
+	stmt, err := NewParser("select " + expr + " where true").Parse()
+	if err != nil {
+		t.Fatal(expr, err)
+	}
+	field := stmt.(*SelectStmt).Fields[0]
+	ret, err := field.Execute(kv, NewExecuteCtx())
+	rets, berr := field.ExecuteBatch([]KVPair{kv, kv}, NewExecuteCtx())
+	if (err == nil) != (berr == nil) {
+		t.Fatalf("%s row error %v but batch error %v", expr, err, berr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, bret := range rets {
+		if fmt.Sprintf("%#v", bret) != fmt.Sprintf("%#v", ret) {
+			t.Fatalf("%s row result %#v but batch result %#v", expr, ret, bret)
+		}
+	}
+	return ret, nil
+}
+
+func TestStringFunctions(t *testing.T) {
+	kv := NewKVPStr("user_001", "  Hello, 世界  ")
+	tcases := []struct {
+		expr   string
+		expect any
+	}{
+		{"replace(key, '_', '-')", "user-001"},
+		{"replace(key, '', '')", "user_001"},
+		{"trim(value)", "Hello, 世界"},
+		{"ltrim(value)", "Hello, 世界  "},
+		{"rtrim(value)", "  Hello, 世界"},
+		{"trim(key, 'u1')", "ser_00"},
+		{"ltrim(key, 'use')", "r_001"},
+		{"rtrim(key, '0123456789')", "user_"},
+		{"concat(key, ':', 1, ':', true)", "user_001:1:true"},
+		{"concat(key)", "user_001"},
+		{"starts_with(key, 'user_')", true},
+		{"starts_with(key, 'admin')", false},
+		{"ends_with(key, '001')", true},
+		{"contains(value, '世')", true},
+		{"contains(value, 'bye')", false},
+		{"index_of(key, '_')", int64(4)},
+		{"index_of(key, 'x')", int64(-1)},
+		{"repeat('ab', 3)", "ababab"},
+		{"repeat(key, 0)", ""},
+		{"reverse(trim(value))", "界世 ,olleH"},
+		{"lpad(index_of(key, '0'), 5, '0')", "00005"},
+		{"lpad('7', 6, 'ab')", "ababa7"},
+		{"rpad(key, 10)", "user_001  "},
+		{"rpad('世界', 3, '!')", "世界!"},
+		{"lpad(key, 4, '0')", "user"},
+		{"lpad(key, 12, '')", "user_001"},
+		{"format('%s-%05d', key, '42')", "user_001-00042"},
+		{"printf('%.2f%%', '12.345')", "12.35%"},
+		{"format('%x|%d|%v', 'hi', 3.7, true)", "6869|3|true"},
+		{"format('%s %s', key)", "user_001 %!s(MISSING)"},
+		{"regexp_extract(key, '([a-z]+)_(\\d+)')", "user"},
+		{"regexp_extract(key, '([a-z]+)_(\\d+)', 2)", "001"},
+		{"regexp_extract(key, '[a-z]+_\\d+')", "user_001"},
+		{"regexp_extract(key, '(?P<num>\\d+)', 0)", "001"},
+		{"regexp_extract(key, 'admin_(\\d+)')", ""},
+		{"regexp_replace(key, '([a-z]+)_(\\d+)', '$2@${1}')", "001@user"},
+		{"regexp_replace(value, '\\s+', ' ')", " Hello, 世界 "},
+	}
+	for _, c := range tcases {
+		ret, err := execFuncExpr(t, c.expr, kv)
+		if err != nil {
+			t.Fatal(c.expr, err)
+		}
+		if ret != c.expect {
+			t.Fatalf("%s expect %#v but got %#v", c.expr, c.expect, ret)
+		}
+	}
+}
+
+func TestStringFunctionErrors(t *testing.T) {
+	kv := NewKVPStr("k", "v")
+	tcases := []struct {
+		expr string
+		err  string
+	}{
+		{"trim(key, 'a', 'b')", "Function trim require at most 2 arguments but got 3"},
+		{"lpad(key, 2, 'a', 'b')", "Function lpad require at most 3 arguments but got 4"},
+		{"replace(key, 'a')", "Function replace require 3 arguments but got 2"},
+		{"regexp_extract(key, '(')", "Invalid regexp"},
+		{"regexp_extract(key, '(k)', 2)", "regexp_extract group 2 out of range"},
+		{"regexp_replace(key, '[', '')", "Invalid regexp"},
+		{"repeat(key, 100000000000)", "repeat function result exceeds max length"},
+		{"rpad(key, 100000000000)", "rpad function result exceeds max length"},
+	}
+	for _, c := range tcases {
+		_, err := execFuncExpr(t, c.expr, kv)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("%s expect error %s but got %v", c.expr, c.err, err)
+		}
+	}
+}
+
+func TestStringFunctionReturnTypes(t *testing.T) {
+	tcases := map[string]Type{
+		"concat(key, value)":            TSTR,
+		"starts_with(key, 'a')":         TBOOL,
+		"index_of(key, 'a')":            TNUMBER,
+		"regexp_replace(key, 'a', 'b')": TSTR,
+	}
+	for expr, tp := range tcases {
+		stmt, err := NewParser("select " + expr + " where true").Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rtp := stmt.(*SelectStmt).FieldTypes[0]; rtp != tp {
+			t.Fatalf("%s expect type %s but got %s", expr, TypeToString[tp], TypeToString[rtp])
+		}
+	}
+	if _, err := NewParser("select key where starts_with(key, 'k') & ends_with(key, '1')").Parse(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMathFunctions(t *testing.T) {
+	kv := NewKVPStr("k", "-7")
+	tcases := []struct {
+		expr   string
+		expect any
+	}{
+		{"abs(value)", int64(7)},
+		{"abs(0 - 2.5)", 2.5},
+		{"sign(value)", int64(-1)},
+		{"sign('0.0')", int64(0)},
+		{"ceil(1.2)", 2.0},
+		{"floor(0 - 1.2)", -2.0},
+		{"floor(value)", int64(-7)},
+		{"trunc(0 - 1.7)", -1.0},
+		{"round(2.5)", 3.0},
+		{"round(0 - 2.5)", -3.0},
+		{"round(3.14159, 2)", 3.14},
+		{"round(1250, 0 - 2)", int64(1300)},
+		{"round(value, 1)", int64(-7)},
+		{"pow(2, 10)", int64(1024)},
+		{"power(value, 2)", int64(49)},
+		{"pow(2, 0 - 1)", 0.5},
+		{"pow(4, 0.5)", 2.0},
+		{"sqrt(16)", 4.0},
+		{"exp(0)", 1.0},
+		{"ln(1)", 0.0},
+		{"log(2, 8)", 3.0},
+		{"log2(1024)", 10.0},
+		{"log10('1000')", 3.0},
+		{"mod(value, 3)", int64(-1)},
+		{"mod(7.5, 2)", 1.5},
+		{"int(value) % 4", int64(-3)},
+		{"greatest(1, value, 3)", int64(3)},
+		{"least(1, value, 3)", int64(-7)},
+		{"greatest(1, 2.5)", 2.5},
+		{"least(1, 2.5)", 1.0},
+		{"round(pi(), 4)", 3.1416},
+	}
+	for _, c := range tcases {
+		ret, err := execFuncExpr(t, c.expr, kv)
+		if err != nil {
+			t.Fatal(c.expr, err)
+		}
+		if ret != c.expect {
+			t.Fatalf("%s expect %#v but got %#v", c.expr, c.expect, ret)
+		}
+	}
+}
+
+func TestMathFunctionErrors(t *testing.T) {
+	kv := NewKVPStr("k", "v")
+	tcases := []struct {
+		expr string
+		err  string
+	}{
+		{"abs(value)", "abs function parameter require number type"},
+		{"abs(0 - 9223372036854775807 - 1)", "Integer overflow"},
+		{"pow(2, 63)", "Integer overflow"},
+		{"pow(0 - 8, 0.5)", "pow function result is not a number"},
+		{"sqrt(0 - 1)", "sqrt function parameter should not be negative"},
+		{"ln(0)", "ln function parameter should be positive"},
+		{"log(1, 8)", "log function base should not be 1"},
+		{"log(2, 8, 1)", "Function log require at most 2 arguments but got 3"},
+		{"mod(1, int(key))", "Divide by zero"},
+		{"pi(1)", "Function pi require 0 arguments but got 1"},
+	}
+	for _, c := range tcases {
+		_, err := execFuncExpr(t, c.expr, kv)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("%s expect error %s but got %v", c.expr, c.err, err)
+		}
+	}
+}
+
+func TestTimeFunctions(t *testing.T) {
+	kv := NewKVPStr("ts_1704164645", "2024-01-31T13:45:30.25Z")
+	tcases := []struct {
+		expr   string
+		expect any
+	}{
+		{"str(from_unixtime(1704164645))", "2024-01-02T03:04:05Z"},
+		{"str(from_unixtime(1704164645123, 'ms'))", "2024-01-02T03:04:05.123Z"},
+		{"str(from_unixtime(1704164645.5))", "2024-01-02T03:04:05.5Z"},
+		{"to_unixtime(from_unixtime(int(replace(key, 'ts_', ''))))", int64(1704164645)},
+		{"to_unixtime(value, 'ms')", int64(1706708730250)},
+		{"to_unixtime('1970-01-01', 'ns')", int64(0)},
+		{"str(parse_time(value))", "2024-01-31T13:45:30.25Z"},
+		{"str(parse_time('2024-01-02 03:04:05'))", "2024-01-02T03:04:05Z"},
+		{"str(parse_time('02/01/2024 03:04', '%d/%m/%Y %H:%M'))", "2024-01-02T03:04:00Z"},
+		{"str(parse_time('2024-01-02 +0800', '2006-01-02 -0700'))", "2024-01-02T00:00:00+08:00"},
+		{"format_time(value, '%Y/%m/%d %H:%M:%S.%L %%')", "2024/01/31 13:45:30.250 %"},
+		{"format_time(value)", "2024-01-31T13:45:30.25Z"},
+		{"format_time(date_trunc('hour', value))", "2024-01-31T13:00:00Z"},
+		{"format_time(date_trunc('day', value))", "2024-01-31T00:00:00Z"},
+		{"format_time(date_trunc('week', value))", "2024-01-29T00:00:00Z"},
+		{"format_time(date_trunc('month', value))", "2024-01-01T00:00:00Z"},
+		{"format_time(date_trunc('quarter', '2024-05-06'))", "2024-04-01T00:00:00Z"},
+		{"format_time(date_trunc('years', value))", "2024-01-01T00:00:00Z"},
+		{"format_time(date_add(value, 1, 'month'))", "2024-02-29T13:45:30.25Z"},
+		{"format_time(date_add(value, '3 days'))", "2024-02-03T13:45:30.25Z"},
+		{"format_time(date_add(value, '1h30m'))", "2024-01-31T15:15:30.25Z"},
+		{"format_time(date_sub(value, 2, 'hours'))", "2024-01-31T11:45:30.25Z"},
+		{"format_time(date_sub(value, '1 year'))", "2023-01-31T13:45:30.25Z"},
+		{"format_time(date_sub('2024-02-29', 1, 'year'))", "2023-02-28T00:00:00Z"},
+		{"year(value)", int64(2024)},
+		{"month(value)", int64(1)},
+		{"day(value)", int64(31)},
+		{"hour(value)", int64(13)},
+		{"minute(value)", int64(45)},
+		{"second(value)", int64(30)},
+	}
+	for _, c := range tcases {
+		ret, err := execFuncExpr(t, c.expr, kv)
+		if err != nil {
+			t.Fatal(c.expr, err)
+		}
+		if ret != c.expect {
+			t.Fatalf("%s expect %#v but got %#v", c.expr, c.expect, ret)
+		}
+	}
+}
+
+func TestTimeFunctionErrors(t *testing.T) {
+	kv := NewKVPStr("k", "v")
+	tcases := []struct {
+		expr string
+		err  string
+	}{
+		{"year(value)", "year function parameter require time type"},
+		{"from_unixtime(1, 'day')", "from_unixtime function unit should be one of s, ms, us and ns"},
+		{"parse_time(value, '%Y-%m')", "parse_time function cannot parse"},
+		{"format_time(now(), '%Q')", "Invalid time format directive %Q"},
+		{"date_trunc('decade', now())", "date_trunc function invalid unit decade"},
+		{"date_add(now(), 'soon')", "date_add function invalid interval soon"},
+		{"date_add(now(), 1, 'fortnight')", "date_add function invalid unit fortnight"},
+	}
+	for _, c := range tcases {
+		_, err := execFuncExpr(t, c.expr, kv)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("%s expect error %s but got %v", c.expr, c.err, err)
+		}
+	}
+}
+
+func TestTimeQuery(t *testing.T) {
+	data := []KVPair{}
+	for i := 0; i < 6; i++ {
+		// 40 minutes apart from 2024-01-02T00:00:00Z
+		data = append(data, NewKVPStr(fmt.Sprintf("ev_%d", i), fmt.Sprintf("%d", 1704153600+(5-i)*2400)))
+	}
+	s := newMockQueryStorage(data)
+	tcases := []struct {
+		query  string
+		expect string
+	}{
+		{
+			"select key, from_unixtime(int(value)) as t where key ^= 'ev_' & t >= parse_time('2024-01-02 01:00:00') order by t",
+			"ev_3,2024-01-02 01:20:00 +0000 UTC|ev_2,2024-01-02 02:00:00 +0000 UTC|ev_1,2024-01-02 02:40:00 +0000 UTC|ev_0,2024-01-02 03:20:00 +0000 UTC",
+		},
+		{
+			"select key where from_unixtime(int(value)) = parse_time('2024-01-02T02:00:00Z')",
+			"ev_2",
+		},
+		{
+			"select date_trunc('hour', from_unixtime(int(value))) as h, count(1) where key ^= 'ev_' group by h order by h desc",
+			"2024-01-02T03:00:00Z,1|2024-01-02T02:00:00Z,2|2024-01-02T01:00:00Z,1|2024-01-02T00:00:00Z,2",
+		},
+		{
+			"with t as (select date_trunc('hour', from_unixtime(int(value))) as h, count(1) as c where key ^= 'ev_' group by h) select format_time(h, '%H:%M'), c from t where h < parse_time('2024-01-02 02:00:00') order by c",
+			"01:00,1|00:00,2",
+		},
+	}
+	for _, c := range tcases {
+		for _, batch := range []bool{false, true} {
+			rows := collectRows(t, s, c.query, batch)
+			if strings.Join(rows, "|") != c.expect {
+				t.Fatalf("%s unexpected result %v", c.query, rows)
+			}
+		}
+	}
+}
+
+func TestTimeCheck(t *testing.T) {
+	if _, err := NewParser("select * where parse_time(value) > '2024-01-01'").Parse(); err == nil {
+		t.Fatal("Compare time with string should be syntax error")
+	}
+	stmt, err := NewParser("select now() as t where true").Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tp := stmt.(*SelectStmt).FieldTypes[0]; tp != TTIME {
+		t.Fatal("now() should return time type but got", TypeToString[tp])
+	}
+}
+
+func TestBytesFunctions(t *testing.T) {
+	kv := NewKVP([]byte("t\x00\x00\x00\x01\xffk"), []byte("hello"))
+	tcases := []struct {
+		expr   string
+		expect any
+	}{
+		{"hex(key)", "7400000001ff6b"},
+		{"hex(value)", "68656c6c6f"},
+		{"str(unhex('68656c6c6f'))", "hello"},
+		{"str(unhex('0x6869'))", "hi"},
+		{"key = unhex('7400000001ff6b')", true},
+		{"key = x'7400000001ff6b'", true},
+		{"key ^= x'7400' & key != 't'", true},
+		{"key > x'74' & key < x'75'", true},
+		{"key between x'74' and to_bytes('u')", true},
+		{"key in (x'74', unhex('7400000001ff6b'))", true},
+		{"to_base64(value)", "aGVsbG8="},
+		{"to_base64(key)", "dAAAAAH/aw=="},
+		{"str(from_base64('aGVsbG8='))", "hello"},
+		{"str(from_base64('aGVsbG8'))", "hello"},
+		{"hex(from_base64('dAAAAAH_aw'))", "7400000001ff6b"},
+		{"str(to_bytes(value) + '!')", "hello!"},
+		{"e'\\x68\\u0069' = 'hi'", true},
+	}
+	for _, c := range tcases {
+		ret, err := execFuncExpr(t, c.expr, kv)
+		if err != nil {
+			t.Fatal(c.expr, err)
+		}
+		if ret != c.expect {
+			t.Fatalf("%s expect %#v but got %#v", c.expr, c.expect, ret)
+		}
+	}
+
+	errCases := []struct {
+		expr string
+		err  string
+	}{
+		{"unhex('xyz')", "unhex function parameter is not hex string"},
+		{"from_base64('!!')", "from_base64 function parameter is not base64 string"},
+	}
+	for _, c := range errCases {
+		_, err := execFuncExpr(t, c.expr, kv)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("%s expect error %s but got %v", c.expr, c.err, err)
+		}
+	}
+}
+
+func TestBytesType(t *testing.T) {
+	tcases := map[string]Type{
+		"unhex('00')":            TBYTES,
+		"from_base64('AA==')":    TBYTES,
+		"to_bytes(key)":          TBYTES,
+		"to_bytes(key) + 'a'":    TBYTES,
+		"'a' + to_bytes(key)":    TBYTES,
+		"hex(key)":               TSTR,
+		"x'00' + key":            TSTR,
+		"key = unhex(value)":     TBOOL,
+		"to_bytes(key) ^= x'00'": TBOOL,
+	}
+	for expr, tp := range tcases {
+		stmt, err := NewParser("select " + expr + " where true").Parse()
+		if err != nil {
+			t.Fatal(expr, err)
+		}
+		if rtp := stmt.(*SelectStmt).FieldTypes[0]; rtp != tp {
+			t.Fatalf("%s expect type %s but got %s", expr, TypeToString[tp], TypeToString[rtp])
+		}
+	}
+	if _, err := NewParser("select * where to_bytes(key) = 1").Parse(); err == nil {
+		t.Fatal("Compare bytes with number should be syntax error")
+	}
+	if _, err := NewParser("put (unhex('00ff'), to_bytes('v'))").Parse(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBytesQuery(t *testing.T) {
+	data := []KVPair{
+		NewKVP([]byte("t\x00\x01"), []byte("a")),
+		NewKVP([]byte("t\x00\x02"), []byte("b")),
+		NewKVP([]byte("t\x01\x01"), []byte("c")),
+		NewKVP([]byte("u"), []byte("d")),
+	}
+	s := newMockQueryStorage(data)
+	query := "select hex(key) as h, value where key ^= x'7400' order by h desc"
+	for _, batch := range []bool{false, true} {
+		rows := collectRows(t, s, query, batch)
+		if strings.Join(rows, "|") != "740002,b|740001,a" {
+			t.Fatal("Unexpected result", rows)
+		}
+	}
+	plan, err := NewOptimizer(query).BuildPlan(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if explain := strings.Join(plan.Explain(), "\n"); !strings.Contains(explain, "PrefixScanPlan") {
+		t.Fatal("Hex literal prefix should use prefix scan", explain)
+	}
+}
+
+func TestKeyCodecFunctions(t *testing.T) {
+	kv := NewKVP([]byte("u\x00\x00\x00\x00\x00\x00\x01\x00\x80\x00\x00\x00\x00\x00\x00\x05abc\x00\x00\x00\x00\x00\xfa\xac\x02"), []byte("\x03\xff\xff"))
+	tcases := []struct {
+		expr   string
+		expect any
+	}{
+		{"decode_uint64_be(key, 1)", int64(256)},
+		{"decode_uint32_be(key, 5)", int64(256)},
+		{"decode_uint16_be(value, 1)", int64(65535)},
+		{"decode_uint64_be(x'ffffffffffffffff')", uint64(math.MaxUint64)},
+		{"decode_int64_be(x'fffffffffffffffe')", int64(-2)},
+		{"decode_memcomparable_int(key, 9)", int64(5)},
+		{"str(decode_memcomparable_bytes(key, 17))", "abc"},
+		{"decode_uvarint(key, 26)", int64(300)},
+		{"decode_varint(value)", int64(-2)},
+		{"decode_uint16_be(key, 1) + 1", int64(1)},
+		{"hex(encode_uint16_be(65535))", "ffff"},
+		{"hex(encode_uint32_be(256))", "00000100"},
+		{"hex(encode_uint64_be('18446744073709551615'))", "ffffffffffffffff"},
+		{"hex(encode_int64_be(0 - 2))", "fffffffffffffffe"},
+		{"hex(encode_memcomparable_int(0 - 1))", "7fffffffffffffff"},
+		{"hex(encode_memcomparable_bytes(''))", "0000000000000000f7"},
+		{"hex(encode_memcomparable_bytes('abcdefgh'))", "6162636465666768ff0000000000000000f7"},
+		{"hex(encode_varint(0 - 2))", "03"},
+		{"hex(encode_uvarint(300))", "ac02"},
+		{"key = 'u' + encode_uint64_be(256) + encode_memcomparable_int(5) + encode_memcomparable_bytes('abc') + encode_uvarint(300)", true},
+		{"str(decode_memcomparable_bytes(encode_memcomparable_bytes(value)))", "\x03\xff\xff"},
+		// Short or malformed key is null
+		{"decode_uint64_be(value)", nil},
+		{"decode_uint32_be(key, 100)", nil},
+		{"decode_memcomparable_bytes(key, 1)", nil},
+		{"decode_memcomparable_bytes(x'6100000000000001fa')", nil},
+		{"decode_uvarint(x'ff')", nil},
+		{"decode_uint64_be(value) = 1", false},
+		{"decode_uint64_be(value) != 1", false},
+		{"decode_uint64_be(value) < 1", false},
+	}
+	for _, c := range tcases {
+		ret, err := execFuncExpr(t, c.expr, kv)
+		if err != nil {
+			t.Fatal(c.expr, err)
+		}
+		if ret != c.expect {
+			t.Fatalf("%s expect %#v but got %#v", c.expr, c.expect, ret)
+		}
+	}
+
+	errCases := []struct {
+		expr string
+		err  string
+	}{
+		{"decode_uint32_be(key, 0 - 1)", "decode_uint32_be function offset -1 out of range"},
+		{"decode_uint16_be(key, 1, 2)", "Function decode_uint16_be require at most 2 arguments"},
+		{"encode_uint16_be(65536)", "encode_uint16_be function parameter require unsigned integer in range [0, 65535]"},
+		{"encode_uint64_be(0 - 1)", "encode_uint64_be function parameter require unsigned integer"},
+		{"encode_int64_be(1.5)", "encode_int64_be function parameter require integer type"},
+	}
+	for _, c := range errCases {
+		_, err := execFuncExpr(t, c.expr, kv)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("%s expect error %s but got %v", c.expr, c.err, err)
+		}
+	}
+}
+
+func TestKeyCodecQuery(t *testing.T) {
+	s := newMockQueryStorage(nil)
+	for _, id := range []string{"1", "2", "300"} {
+		query := "put ('u' + encode_uint32_be(" + id + "), 'user" + id + "'), ('v' + encode_uint32_be(" + id + "), 'view" + id + "')"
+		collectRows(t, s, query, false)
+	}
+	tcases := []struct {
+		query  string
+		expect string
+		plan   string
+	}{
+		{
+			"select decode_uint32_be(key, 1) as id, value where key ^= 'u' & decode_uint32_be(key, 1) >= 2",
+			"2,user2|300,user300",
+			"RangeScanPlan{Start = 'u\x00\x00\x00\x02', End = 'v'",
+		},
+		{
+			"select decode_uint32_be(key, 1) as id, value where key ^= 'v' & decode_uint32_be(key, 1) between 1 and 2",
+			"1,view1|2,view2",
+			"RangeScanPlan{Start = 'v\x00\x00\x00\x01', End = 'v\x00\x00\x00\x03'",
+		},
+		{
+			"select value where key ^= 'v' & 300 = decode_uint32_be(key, 1)",
+			"view300",
+			"PrefixScanPlan{Prefix = 'v\x00\x00\x01,'",
+		},
+		{
+			"select value where key ^= 'u' + encode_uint32_be(1)",
+			"user1",
+			"PrefixScanPlan{Prefix = 'u\x00\x00\x00\x01'",
+		},
+		{
+			"select value where key < 'v' & key > 'u' + encode_uint32_be(2)",
+			"user300",
+			"RangeScanPlan{Start = 'u\x00\x00\x00\x02', End = 'v'",
+		},
+	}
+	for _, c := range tcases {
+		for _, batch := range []bool{false, true} {
+			rows := collectRows(t, s, c.query, batch)
+			if strings.Join(rows, "|") != c.expect {
+				t.Fatal(c.query, "unexpected result", rows)
+			}
+		}
+		plan, err := NewOptimizer(c.query).BuildPlan(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if explain := strings.Join(plan.Explain(), "\n"); !strings.Contains(explain, c.plan) {
+			t.Fatal(c.query, "unexpected plan", explain)
+		}
+	}
+
+	// The short keys are not matched by comparison of field, the result is
+	// the same as full scan which is forced by comparing with true.
+	collectRows(t, s, "put ('u', 'short0'), ('u\x01', 'short1'), ('u\x00\x00\x01', 'short3')", false)
+	tcases = []struct {
+		query  string
+		expect string
+		plan   string
+	}{
+		{
+			"select value where key ^= 'u' & decode_uint32_be(key, 1) < 2",
+			"user1",
+			"RangeScanPlan{Start = 'u', End = 'u\x00\x00\x00\x02'",
+		},
+		{
+			"select value where key ^= 'u' & (decode_uint32_be(key, 1) < 2) = true",
+			"user1",
+			"PrefixScanPlan{Prefix = 'u'",
+		},
+		{
+			"select value where key ^= 'u' & !(decode_uint32_be(key, 1) >= 2)",
+			"short0|user1|short3|short1",
+			"PrefixScanPlan{Prefix = 'u'",
+		},
+		{
+			"select value where decode_uint32_be(key, 1) = 1 | decode_uint32_be(key, 1) > 2 | key = 'u'",
+			"short0|user1|user300|view1|view300",
+			"FullScanPlan",
+		},
+		{
+			"select value where key ^= 'u' & decode_uint32_be(key, 1) between 1 and 2",
+			"user1|user2",
+			"RangeScanPlan{Start = 'u\x00\x00\x00\x01', End = 'u\x00\x00\x00\x03'",
+		},
+	}
+	for _, c := range tcases {
+		for _, batch := range []bool{false, true} {
+			rows := collectRows(t, s, c.query, batch)
+			if strings.Join(rows, "|") != c.expect {
+				t.Fatal(c.query, "unexpected result", rows)
+			}
+		}
+		plan, err := NewOptimizer(c.query).BuildPlan(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if explain := strings.Join(plan.Explain(), "\n"); !strings.Contains(explain, c.plan) {
+			t.Fatal(c.query, "unexpected plan", explain)
+		}
+	}
+}
+
+func TestTiDBFunctions(t *testing.T) {
+	// Record key of table 45 handle 1, the value has columns:
+	// 1 int, 2 str, 3 decimal, 4 float, 5 time, 6 uint, 7 duration, 8 null
+	kv := NewKVP(
+		[]byte("t\x80\x00\x00\x00\x00\x00\x00\x2d_r\x80\x00\x00\x00\x00\x00\x00\x01"),
+		[]byte("\x80\x00\x07\x00\x01\x00\x01\x02\x03\x04\x05\x06\x07\x08\x01\x00\x04\x00\x08\x00\x10\x00\x18\x00\x1a\x00\x1e\x00"+
+			"\x05abc\x04\x02\x8c\x22\xbf\xf8\x00\x00\x00\x00\x00\x00\x20\xa1\x07\x05\x31\x44\xb2\x19\x2c\x01\x00\xd1\x97\xa6"),
+	)
+	indexKey := "x'74800000000000002d5f698000000000000001016162630000000000fa0604028c22037fffffffffffffff'"
+	commonHandleKey := "x'74800000000000002d5f72016b31000000000000f9038000000000000002'"
+	types := `'{"1": "int", "2": "str", "3": "decimal", "4": "float", "5": "time", "6": "uint", "7": "duration"}'`
+	tcases := []struct {
+		expr   string
+		expect any
+	}{
+		{"tidb_table(key)", int64(45)},
+		{"tidb_handle(key)", int64(1)},
+		{"tidb_is_record(key)", true},
+		{"tidb_is_index(key)", false},
+		{"tidb_table(" + indexKey + ")", int64(45)},
+		{"tidb_index_id(" + indexKey + ")", int64(1)},
+		{"tidb_is_index(" + indexKey + ")", true},
+		{"tidb_index_values(" + indexKey + ")[0]", "abc"},
+		{"tidb_row(value, " + types + ")['2']", "abc"},
+		{"hex(tidb_table_prefix(45))", "74800000000000002d"},
+		{"hex(tidb_record_prefix(45))", "74800000000000002d5f72"},
+		{"hex(tidb_index_prefix(45, 1))", "74800000000000002d5f698000000000000001"},
+		{"key = tidb_record_key(45, 1)", true},
+		{"key ^= tidb_record_prefix(45)", true},
+		// Not the kind of key is null
+		{"tidb_table('m_meta')", nil},
+		{"tidb_index_id(key)", nil},
+		{"tidb_handle(" + indexKey + ")", nil},
+		{"tidb_handle(" + commonHandleKey + ")", nil},
+		{"tidb_common_handle(key)", nil},
+		{"tidb_index_values(x'74800000000000002d5f69800000000000000115')", nil},
+		{"tidb_table('m_meta') = 45", false},
+	}
+	for _, c := range tcases {
+		ret, err := execFuncExpr(t, c.expr, kv)
+		if err != nil {
+			t.Fatal(c.expr, err)
+		}
+		if ret != c.expect {
+			t.Fatalf("%s expect %#v but got %#v", c.expr, c.expect, ret)
+		}
+	}
+
+	// List and JSON results are compared in JSON
+	jsonCases := []struct {
+		expr   string
+		expect string
+	}{
+		{"tidb_index_values(" + indexKey + ")", `["abc","12.34",-1]`},
+		{"tidb_common_handle(" + commonHandleKey + ")", `["k1",2]`},
+		{"tidb_row(value, " + types + ")", `{"1":5,"2":"abc","3":"12.34","4":1.5,"5":"2024-01-02 03:04:05.5","6":300,"7":"-00:00:01.5","8":null}`},
+		{"tidb_row(value)", `{"1":5,"2":"abc","3":579600900,"4":63679,"5":1851617374131167520,"6":300,"7":-1500000000,"8":null}`},
+		{"tidb_row(x'8001010000002c0100000100000078')", `{"300":"x"}`},
+	}
+	for _, c := range jsonCases {
+		ret, err := execFuncExpr(t, c.expr, kv)
+		if err != nil {
+			t.Fatal(c.expr, err)
+		}
+		data, _ := json.Marshal(ret)
+		if string(data) != c.expect {
+			t.Fatalf("%s expect %s but got %s", c.expr, c.expect, data)
+		}
+	}
+
+	errCases := []struct {
+		expr string
+		err  string
+	}{
+		{"tidb_row('abc')", "tidb_row function cannot decode value: not row format v2"},
+		{"tidb_row(value, '[1]')", "tidb_row function types parameter is not JSON object"},
+		{"tidb_row(value, '{\"2\": \"int\"}')", "tidb_row function cannot decode column 2: invalid row data"},
+		{"tidb_row(value, '{\"1\": \"text\"}')", "unknown column type text"},
+	}
+	for _, c := range errCases {
+		_, err := execFuncExpr(t, c.expr, kv)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("%s expect error %s but got %v", c.expr, c.err, err)
+		}
+	}
+}
+
+func TestTiDBQuery(t *testing.T) {
+	s := newMockQueryStorage(nil)
+	for _, query := range []string{
+		"put (tidb_record_key(44, 1), 'a'), (tidb_record_key(45, 1), 'b'), (tidb_record_key(45, 2), 'c'), (tidb_record_key(46, 1), 'd')",
+		"put (tidb_index_prefix(45, 1) + x'038000000000000001', ''), ('m_meta', 'e')",
+	} {
+		collectRows(t, s, query, false)
+	}
+	tcases := []struct {
+		query  string
+		expect string
+		plan   string
+	}{
+		{
+			"select tidb_handle(key) as h, value where tidb_table(key) = 45 & tidb_is_record(key)",
+			"1,b|2,c",
+			"PrefixScanPlan{Prefix = 't\x80\x00\x00\x00\x00\x00\x00\x2d'",
+		},
+		{
+			"select value where tidb_table(key) = 45 & tidb_handle(key) >= 2",
+			"c",
+			"RangeScanPlan{Start = 't\x80\x00\x00\x00\x00\x00\x00\x2d_r\x80\x00\x00\x00\x00\x00\x00\x02', End = 't\x80\x00\x00\x00\x00\x00\x00\x2d_s'",
+		},
+		{
+			"select tidb_table(key) as tid, count(1) as cnt where tidb_table(key) between 44 and 45 group by tid",
+			"44,1|45,3",
+			"RangeScanPlan{Start = 't\x80\x00\x00\x00\x00\x00\x00\x2c', End = 't\x80\x00\x00\x00\x00\x00\x00\x2e'",
+		},
+		{
+			"select tidb_index_values(key)[0] where tidb_table(key) = 45 & tidb_index_id(key) = 1",
+			"1",
+			"PrefixScanPlan{Prefix = 't\x80\x00\x00\x00\x00\x00\x00\x2d_i\x80\x00\x00\x00\x00\x00\x00\x01'",
+		},
+		// Index and non-table keys are scanned without error
+		{
+			"select value where tidb_handle(key) = 1",
+			"a|b|d",
+			"FullScanPlan",
+		},
+		{
+			"select value where tidb_table(key) >= 46 | !tidb_is_record(key)",
+			"e||d",
+			"FullScanPlan",
+		},
+	}
+	for _, c := range tcases {
+		for _, batch := range []bool{false, true} {
+			rows := collectRows(t, s, c.query, batch)
+			if strings.Join(rows, "|") != c.expect {
+				t.Fatal(c.query, "unexpected result", rows)
+			}
+		}
+		plan, err := NewOptimizer(c.query).BuildPlan(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if explain := strings.Join(plan.Explain(), "\n"); !strings.Contains(explain, c.plan) {
+			t.Fatal(c.query, "unexpected plan", explain)
+		}
+	}
+}
+
+func TestDecoderFunctions(t *testing.T) {
+	// message test.User {
+	//   string name = 1; repeated int64 ids = 2; Status status = 3;
+	//   Address address = 4; map<string, uint32> tags = 5;
+	// }
+	fds := &descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{{
+		Name:    proto.String("test.proto"),
+		Package: proto.String("test"),
+		Syntax:  proto.String("proto3"),
+		MessageType: []*descriptorpb.DescriptorProto{
+			{
+				Name: proto.String("User"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					protoField("name", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+					protoField("ids", 2, descriptorpb.FieldDescriptorProto_TYPE_INT64, ""),
+					protoField("status", 3, descriptorpb.FieldDescriptorProto_TYPE_ENUM, ".test.Status"),
+					protoField("address", 4, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.Address"),
+					protoField("tags", 5, descriptorpb.FieldDescriptorProto_TYPE_MESSAGE, ".test.User.TagsEntry"),
+				},
+				NestedType: []*descriptorpb.DescriptorProto{{
+					Name: proto.String("TagsEntry"),
+					Field: []*descriptorpb.FieldDescriptorProto{
+						protoField("key", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+						protoField("value", 2, descriptorpb.FieldDescriptorProto_TYPE_UINT32, ""),
+					},
+					Options: &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)},
+				}},
+			},
+			{
+				Name: proto.String("Address"),
+				Field: []*descriptorpb.FieldDescriptorProto{
+					protoField("city", 1, descriptorpb.FieldDescriptorProto_TYPE_STRING, ""),
+				},
+			},
+		},
+		EnumType: []*descriptorpb.EnumDescriptorProto{{
+			Name: proto.String("Status"),
+			Value: []*descriptorpb.EnumValueDescriptorProto{
+				{Name: proto.String("UNKNOWN"), Number: proto.Int32(0)},
+				{Name: proto.String("ACTIVE"), Number: proto.Int32(1)},
+			},
+		}},
+	}}}
+	fds.File[0].MessageType[0].Field[1].Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	fds.File[0].MessageType[0].Field[4].Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	data, err := proto.Marshal(fds)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AddProtoDescriptorSet(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := AddProtoDescriptorSet([]byte("invalid")); err == nil {
+		t.Fatal("expect error for invalid descriptor set")
+	}
+
+	AddDecoder(&Decoder{
+		Name:       "test_kv_pairs",
+		NumArgs:    1,
+		ReturnType: TJSON,
+		Decode: func(data []byte, args []any) (any, error) {
+			ret := make(map[string]any)
+			for _, pair := range strings.Split(string(data), ";") {
+				k, v, _ := strings.Cut(pair, "=")
+				ret[k] = v
+			}
+			return ret, nil
+		},
+	})
+
+	// {"a": [1, "x"], "b": {"c": true}}
+	msgpackObj := "x'82a1619201a178a16281a163c3'"
+	cborObj := "x'a26161820161786162a16163f5'"
+	kv := NewKVP([]byte("user_1"), []byte("\x0a\x03bob\x12\x02\x01\x02\x18\x01\x22\x04\x0a\x02sf\x2a\x06\x0a\x01x\x10\xac\x02"))
+	tcases := []struct {
+		expr   string
+		expect any
+	}{
+		{"msgpack(" + msgpackObj + ")['a'][1]", "x"},
+		{"msgpack(" + msgpackObj + ")['b']['c']", true},
+		{"msgpack_list(x'93cd012cd0ffcb3ff8000000000000')[0]", int64(300)},
+		{"cbor(" + cborObj + ")['a'][1]", "x"},
+		{"cbor_list(x'8301' + x'20f93e00')[2]", float64(1.5)},
+		{"csv('a,\"b,c\",d')[1]", "b,c"},
+		{"csv('a|b', '|')[1]", "b"},
+		{"proto(value, 'test.User')['name']", "bob"},
+		{"proto(value, 'test.User')['address']['city']", "sf"},
+		{"proto(value, 'test.User')['status'] = 'ACTIVE'", true},
+		{"test_kv_pairs('a=1;b=2')['b']", "2"},
+	}
+	for _, c := range tcases {
+		ret, err := execFuncExpr(t, c.expr, kv)
+		if err != nil {
+			t.Fatal(c.expr, err)
+		}
+		if ret != c.expect {
+			t.Fatalf("%s expect %#v but got %#v", c.expr, c.expect, ret)
+		}
+	}
+
+	jsonCases := []struct {
+		expr   string
+		expect string
+	}{
+		{"msgpack(" + msgpackObj + ")", `{"a":[1,"x"],"b":{"c":true}}`},
+		{"msgpack_list(x'93cd012cd0ffcb3ff8000000000000')", `[300,-1,1.5]`},
+		{"msgpack(x'82a174d6ff00000001c4028001c0')", `{"t":"1970-01-01T00:00:01Z","x'8001'":null}`},
+		{"cbor(" + cborObj + ")", `{"a":[1,"x"],"b":{"c":true}}`},
+		{"cbor(x'bf61619f01ff6174c11a00000001ff')", `{"a":[1],"t":"1970-01-01T00:00:01Z"}`},
+		{"cbor_list(x'835f4161426262ff7f6161ff1bffffffffffffffff')", `["abb","a",18446744073709551615]`},
+		{"csv('')", `[]`},
+		{"proto(value, 'test.User')", `{"address":{"city":"sf"},"ids":[1,2],"name":"bob","status":"ACTIVE","tags":{"x":300}}`},
+	}
+	for _, c := range jsonCases {
+		ret, err := execFuncExpr(t, c.expr, kv)
+		if err != nil {
+			t.Fatal(c.expr, err)
+		}
+		data, _ := json.Marshal(ret)
+		if string(data) != c.expect {
+			t.Fatalf("%s expect %s but got %s", c.expr, c.expect, data)
+		}
+	}
+
+	errCases := []struct {
+		expr string
+		err  string
+	}{
+		{"msgpack('abc')", "msgpack function cannot decode value: extra data at 1"},
+		{"msgpack(x'01')", "msgpack function value is not a map"},
+		{"msgpack_list(x'c1')", "invalid type byte 0xc1 at 0"},
+		{"msgpack(x'81a161')", "insufficient data"},
+		{"cbor_list(" + cborObj + ")", "cbor_list function value is not a list"},
+		{"cbor(x'a1')", "insufficient data"},
+		{"csv('a|b', '||')", "separator \"||\" should be one character"},
+		{"csv('\"a')", "csv function cannot decode value"},
+		{"proto(value, 'test.Unknown')", "message test.Unknown not found"},
+		{"proto('abc', 'test.User')", "proto function cannot decode value"},
+	}
+	for _, c := range errCases {
+		_, err := execFuncExpr(t, c.expr, kv)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("%s expect error %s but got %v", c.expr, c.err, err)
+		}
+	}
+
+	// Deeply nested value
+	nested := strings.Repeat("91", maxDecodeDepth+1) + "01"
+	if _, err := execFuncExpr(t, "msgpack_list(x'"+nested+"')", kv); err == nil || !strings.Contains(err.Error(), errMaxDecodeDepth.Error()) {
+		t.Fatal("expect max depth error but got", err)
+	}
+}
+
+func protoField(name string, num int32, tp descriptorpb.FieldDescriptorProto_Type, typeName string) *descriptorpb.FieldDescriptorProto {
+	ret := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String(name),
+		JsonName: proto.String(name),
+		Number:   proto.Int32(num),
+		Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+		Type:     tp.Enum(),
+	}
+	if typeName != "" {
+		ret.TypeName = proto.String(typeName)
+	}
+	return ret
+}
+
+func TestJSONFunctions(t *testing.T) {
+	kv := NewKVP([]byte("user_1"), []byte(`{"name": "bob", "age": 30, "score": 1.5, "admin": false, "nick": null, `+
+		`"tags": ["a", "b"], "addr": {"city": "sf", "zip": "94107"}, "items": [{"id": 1, "price": 2.5}, {"id": 2, "price": 3}]}`))
+	tcases := []struct {
+		expr   string
+		expect any
+	}{
+		{"json_extract(value, '$.name')", "bob"},
+		{"json_extract(value, '$.tags[1]')", "b"},
+		{"json_extract(value, '$.tags[-1]')", "b"},
+		{"json_extract(value, '$[\"addr\"].city')", "sf"},
+		{"json_extract(value, '$.addr')['zip']", "94107"},
+		{"json_extract(json(value), '$.items[1].id') = 2", true},
+		{"json_extract(value, '$.age') > 18", true},
+		{"json_extract(value, '$.age') >= 30.0", true},
+		{"json_extract(value, '$.age') < 18", false},
+		{"json_extract(value, '$.age') = '30'", false},
+		{"json_extract(value, '$.age') != '30'", true},
+		{"json_extract(value, '$.nick') = json_extract(value, '$.missing')", true},
+		{"json_extract(value, '$.missing') > 0", false},
+		{"json(value)['age'] = 30", true},
+		{"json(value)['score'] < 2", true},
+		{"json(value)['admin'] = false", true},
+		{"json(value)['name'] = 'bob'", true},
+		{"json(value)['name'] > 'alice'", true},
+		{"json(value)['tags'] = json_array('a', 'b')", true},
+		{"json_length(value)", int64(8)},
+		{"json_length(value, '$.items')", int64(2)},
+		{"json_length(value, '$.name')", int64(1)},
+		{"json_type(value)", "OBJECT"},
+		{"json_type(value, '$.age')", "INTEGER"},
+		{"json_type(value, '$.score')", "DOUBLE"},
+		{"json_type(value, '$.nick')", "NULL"},
+		{"json_type(value, '$.tags')", "ARRAY"},
+		{"json_keys(value, '$.addr')[1]", "zip"},
+		{"json_contains(value, '\"a\"', '$.tags')", true},
+		{"json_contains(value, '[\"b\", \"a\"]', '$.tags')", true},
+		{"json_contains(value, '\"c\"', '$.tags')", false},
+		{"json_contains(value, '{\"city\": \"sf\"}', '$.addr')", true},
+		{"json_contains(value, '{\"id\": 2}', '$.items')", true},
+		{"json_contains(value, 30, '$.age')", true},
+		{"json_contains(value, '1', '$.missing')", false},
+		{"json_set(value, '$.age', 31)['age'] = 31", true},
+		{"json_extract(value, '$.age')", float64(30)},
+		{"json_object('a', 1, 'b', 'x')['b']", "x"},
+		{"json_array(1, 'x')[1]", "x"},
+		{"str(json_object('a', json_array(1, 'x')))", `{"a":[1,"x"]}`},
+	}
+	for _, c := range tcases {
+		ret, err := execFuncExpr(t, c.expr, kv)
+		if err != nil {
+			t.Fatal(c.expr, err)
+		}
+		if ret != c.expect {
+			t.Fatalf("%s expect %#v but got %#v", c.expr, c.expect, ret)
+		}
+	}
+
+	jsonCases := []struct {
+		expr   string
+		expect string
+	}{
+		{"json_extract(value, '$.items[*].price')", `[2.5,3]`},
+		{"json_extract(value, '$.addr.*')", `["sf","94107"]`},
+		{"json_extract(value, '$..id')", `[1,2]`},
+		{"json_extract(value, '$..[0]')", `[{"id":1,"price":2.5},"a"]`},
+		{"json_extract(value, '$.missing[*]')", `[]`},
+		{"json_extract(value, '$.missing')", `null`},
+		{"json_extract('[1, [2, 3]]', '$[1]')", `[2,3]`},
+		{"json_keys(value)", `["addr","admin","age","items","name","nick","score","tags"]`},
+		{"json_keys(value, '$.tags')", `null`},
+		{"json_set('{\"a\": 1}', '$.b', 'x', '$.a', json_object('c', json_array(1)))", `{"a":{"c":[1]},"b":"x"}`},
+		{"json_set('{\"a\": [1]}', '$.a[5]', 2, '$.a[0]', 0, '$.x.y', 3)", `{"a":[0,2]}`},
+		{"json_set('[1]', '$', 2)", `2`},
+		{"json_remove(value, '$.items', '$.addr.zip', '$.tags[0]', '$.name', '$.age', '$.score', '$.admin', '$.nick')", `{"addr":{"city":"sf"},"tags":["b"]}`},
+		{"json_object()", `{}`},
+		{"json_array(1.5, true, list(1, 2))", `[1.5,true,[1,2]]`},
+	}
+	for _, c := range jsonCases {
+		ret, err := execFuncExpr(t, c.expr, kv)
+		if err != nil {
+			t.Fatal(c.expr, err)
+		}
+		data, _ := json.Marshal(ret)
+		if string(data) != c.expect {
+			t.Fatalf("%s expect %s but got %s", c.expr, c.expect, data)
+		}
+	}
+
+	errCases := []struct {
+		expr string
+		err  string
+	}{
+		{"json_extract('{', '$.a')", "json_extract function parameter is not valid JSON"},
+		{"json_extract(value, 'a')", "json_extract function invalid path: path should start with $"},
+		{"json_extract(value, '$.a[x]')", "invalid array index"},
+		{"json_extract(value, '$.a[1')", "missing ]"},
+		{"json_keys(value, '$.*')", "json_keys function path cannot contain wildcard"},
+		{"json_contains(value, 'a')", "json_contains function parameter is not valid JSON"},
+		{"json_set(value, '$.a')", "Function json_set require at least 3 arguments"},
+		{"json_set(value, '$.a', 1, '$.b')", "json_set function require path and value pairs"},
+		{"json_remove(value, '$')", "json_remove function cannot remove root"},
+		{"json_object('a')", "json_object function require key and value pairs"},
+	}
+	for _, c := range errCases {
+		_, err := execFuncExpr(t, c.expr, kv)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("%s expect error %s but got %v", c.expr, c.err, err)
+		}
+	}
+}
+
+func TestJSONQuery(t *testing.T) {
+	s := newMockQueryStorage(nil)
+	for _, query := range []string{
+		"put ('u1', json_object('name', 'alice', 'age', 20, 'tags', json_array('admin')))",
+		"put ('u2', json_object('name', 'bob', 'age', 35.5, 'tags', json_array()))",
+		"put ('u3', '{\"name\": \"carol\", \"age\": \"unknown\"}')",
+		"update set value = json_set(value, '$.age', int(json_extract(value, '$.age')) + 1) where key = 'u1'",
+	} {
+		collectRows(t, s, query, false)
+	}
+	tcases := []struct {
+		query  string
+		expect string
+	}{
+		{"select key, value where key = 'u1'", `u1,{"age":21,"name":"alice","tags":["admin"]}`},
+		{"select key where json(value)['age'] > 30", "u2"},
+		{"select key where json_extract(value, '$.age') <= 21", "u1"},
+		{"select key where json_extract(value, '$.age') = 'unknown'", "u3"},
+		{"select key where json_contains(value, '\"admin\"', '$.tags')", "u1"},
+		{"select key, json_length(value, '$.tags') where json_length(value, '$.tags') = 0", "u2,0"},
+		{"select key, json_extract(value, '$.age') where key ^= 'u' & json_extract(value, '$.age') > 21", "u2,35.5"},
+	}
+	for _, c := range tcases {
+		for _, batch := range []bool{false, true} {
+			rows := collectRows(t, s, c.query, batch)
+			if strings.Join(rows, "|") != c.expect {
+				t.Fatal(c.query, "unexpected result", rows)
+			}
+		}
+	}
+}
+
+func TestListFunctions(t *testing.T) {
+	kv := NewKVP([]byte("k1"), []byte(`{"nums": [3, 1, 2.5, 1], "tags": ["a", "b", "a"], "items": [{"id": 1}, {"id": 2}], "empty": []}`))
+	tcases := []struct {
+		expr   string
+		expect string
+	}{
+		{"list_map(split('1,20,300', ','), x -> int(x) * 2)", `[2,40,600]`},
+		{"list_map(list(1, 2), x -> list_map(list(10, 20), y -> x * y))", `[[10,20],[20,40]]`},
+		{"list_map(json(value)['items'], x -> x['id'])", `[1,2]`},
+		{"list_filter(split('1,20,300', ','), x -> int(x) > 10)", `["20","300"]`},
+		{"list_filter(json(value)['nums'], x -> x >= 2)", `[3,2.5]`},
+		{"list_filter(json(value)['missing'], x -> x > 1)", `[]`},
+		{"list_reduce(split('1,20,300', ','), 0, (acc, x) -> acc + int(x))", `321`},
+		{"list_reduce(split('a,b', ','), '', (acc, x) -> x + acc)", `"ba"`},
+		{"list_any(split('1,20,300', ','), x -> int(x) > 100)", `true`},
+		{"list_any(json(value)['empty'], x -> true)", `false`},
+		{"list_all(json(value)['tags'], x -> x != 'c')", `true`},
+		{"list_all(json(value)['empty'], x -> false)", `true`},
+		{"list_contains(json(value)['nums'], 2.5)", `true`},
+		{"list_contains(list(1, 2), 1.0)", `true`},
+		{"list_contains(split('1,2', ','), 1)", `false`},
+		{"list_slice(json(value)['tags'], 1)", `["b","a"]`},
+		{"list_slice(list(1, 2, 3, 4), 1, 3)", `[2,3]`},
+		{"list_slice(list(1, 2, 3, 4), 0 - 2, 10)", `[3,4]`},
+		{"list_slice(list(1, 2, 3, 4), 3, 1)", `[]`},
+		{"list_sort(json(value)['nums'])", `[1,1,2.5,3]`},
+		{"list_sort(json(value)['tags'], true)", `["b","a","a"]`},
+		{"list_distinct(json(value)['nums'])", `[3,1,2.5]`},
+		{"list_distinct(list_map(list(1, 2, 3), x -> x % 2))", `[1,0]`},
+		{"list_sum(split('1,20,300', ','))", `321`},
+		{"list_sum(json(value)['nums'])", `7.5`},
+		{"list_sum(json(value)['empty'])", `0`},
+		{"list_avg(list(1, 2))", `1.5`},
+		{"list_avg(json(value)['empty'])", `null`},
+		{"len(list_filter(json(value)['tags'], x -> x = 'a'))", `2`},
+	}
+	for _, c := range tcases {
+		ret, err := execFuncExpr(t, c.expr, kv)
+		if err != nil {
+			t.Fatal(c.expr, err)
+		}
+		data, _ := json.Marshal(ret)
+		if string(data) != c.expect {
+			t.Fatalf("%s expect %s but got %s", c.expr, c.expect, data)
+		}
+	}
+
+	errCases := []struct {
+		expr string
+		err  string
+	}{
+		{"list_map(value, x -> x)", "list_map function parameter 1 require list type"},
+		{"list_any(json(value)['tags'], x -> x)", "list_any function lambda expression result is not boolean"},
+		{"list_sum(json(value)['tags'])", `list_sum function element "a" is not number`},
+		{"list_sum(list(9223372036854775807, 1))", "Integer overflow"},
+		{"list_sort(json(value)['items'])", "list_sort function cannot compare"},
+		{"list_sort(list(1), 1)", "list_sort function second parameter require bool type"},
+		{"list_slice(list(1), 'a')", "list_slice function parameter 2 require number type"},
+		{"list_slice(list(1), 0, 1, 2)", "Function list_slice require at most 3 arguments"},
+	}
+	for _, c := range errCases {
+		_, err := execFuncExpr(t, c.expr, kv)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("%s expect error %s but got %v", c.expr, c.err, err)
+		}
+	}
+}
+
+func TestListQuery(t *testing.T) {
+	s := newMockQueryStorage([]KVPair{
+		NewKVPStr("k1", "1,150,3"),
+		NewKVPStr("k2", "1,2,3"),
+		NewKVPStr("k3", "200,99"),
+	})
+	tcases := []struct {
+		query  string
+		expect string
+	}{
+		{"select key where list_any(split(value, ','), x -> int(x) > 100)", "k1|k3"},
+		{"select key where list_all(split(value, ','), x -> int(x) < 100) & key ^= 'k'", "k2"},
+		{"select key, list_sum(list_filter(split(value, ','), x -> int(x) < 100)) as s where s > 4", "k2,6|k3,99"},
+		{"select key, list_map(split(value, ','), x -> int(x) * 2) as l where list_contains(list_map(split(value, ','), x -> int(x) * 2), 4)", "k2,[2 4 6]"},
+		{"select sum(list_reduce(split(value, ','), 0, (acc, x) -> acc + int(x))) as total where true", "459"},
+		{"select key where list_any(split(value, ','), x -> list_any(split(key, 'k'), y -> y = x))", "k1|k2"},
+	}
+	for _, c := range tcases {
+		for _, batch := range []bool{false, true} {
+			rows := collectRows(t, s, c.query, batch)
+			if strings.Join(rows, "|") != c.expect {
+				t.Fatal(c.query, "unexpected result", rows)
+			}
+		}
+	}
+
+	// Lists are written as JSON text
+	for _, batch := range []bool{false, true} {
+		collectRows(t, s, "put ('l1', split('a,b', ',')), ('l2', int_list('1', '2'))", batch)
+		collectRows(t, s, "update set value = float_list('1.5', 2) where key = 'k3'", batch)
+		rows := collectRows(t, s, "select key, value where key in ('l1', 'l2', 'k3')", batch)
+		if strings.Join(rows, "|") != `k3,[1.5,2]|l1,["a","b"]|l2,[1,2]` {
+			t.Fatal("Unexpected written lists", rows)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package kvql
 
 import (
 	"encoding/json"
+	"math"
 	"strconv"
 	"strings"
 )
@@ -352,3 +353,475 @@ func funcStrlenVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, e
 	}
 	return ret, nil
 }
+
+func execValueFuncVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx, fn valueFunc) ([]any, error) {
+	cols := make([][]any, len(args))
+	for i, arg := range args {
+		col, err := arg.ExecuteBatch(chunk, ctx)
+		if err != nil {
+			return nil, err
+		}
+		cols[i] = col
+	}
+	var (
+		ret  = make([]any, len(chunk))
+		vals = make([]any, len(args))
+		regs = make(regexpCache)
+		err  error
+	)
+	for i := 0; i < len(chunk); i++ {
+		for j := range cols {
+			vals[j] = cols[j][i]
+		}
+		ret[i], err = fn(args, vals, regs)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func funcReplaceVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, strReplace)
+}
+
+func funcTrimVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, strTrim)
+}
+
+func funcLTrimVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, strLTrim)
+}
+
+func funcRTrimVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, strRTrim)
+}
+
+func funcConcatVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, strConcat)
+}
+
+func funcStartsWithVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, strStartsWith)
+}
+
+func funcEndsWithVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, strEndsWith)
+}
+
+func funcContainsVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, strContains)
+}
+
+func funcIndexOfVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, strIndexOf)
+}
+
+func funcRepeatVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, strRepeat)
+}
+
+func funcReverseVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, strReverse)
+}
+
+func funcLPadVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, strLPad)
+}
+
+func funcRPadVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, strRPad)
+}
+
+func funcFormatVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, strFormat)
+}
+
+func funcRegexpExtractVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, strRegexpExtract)
+}
+
+func funcRegexpReplaceVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, strRegexpReplace)
+}
+
+func funcAbsVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, mathAbs)
+}
+
+func funcSignVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, mathSign)
+}
+
+func funcCeilVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, mathCeil)
+}
+
+func funcFloorVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, mathFloor)
+}
+
+func funcTruncVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, mathTrunc)
+}
+
+func funcRoundVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, mathRound)
+}
+
+func funcPowVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, mathPow)
+}
+
+func funcSqrtVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, mathSqrt)
+}
+
+func funcExpVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, mathExp)
+}
+
+func funcLnVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, mathLn)
+}
+
+func funcLogVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, mathLog)
+}
+
+func funcLog2Vec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, mathLog2)
+}
+
+func funcLog10Vec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, mathLog10)
+}
+
+func funcModVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, mathMod)
+}
+
+func funcGreatestVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, mathGreatest)
+}
+
+func funcLeastVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, mathLeast)
+}
+
+func funcPiVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	ret := make([]any, len(chunk))
+	for i := range ret {
+		ret[i] = math.Pi
+	}
+	return ret, nil
+}
+
+func funcNowVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, timeNow)
+}
+
+func funcFromUnixTimeVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, timeFromUnix)
+}
+
+func funcToUnixTimeVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, timeToUnix)
+}
+
+func funcParseTimeVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, timeParse)
+}
+
+func funcFormatTimeVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, timeFormat)
+}
+
+func funcDateTruncVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, timeTrunc)
+}
+
+func funcDateAddVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, timeAdd)
+}
+
+func funcDateSubVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, timeSub)
+}
+
+func funcYearVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, timeYear)
+}
+
+func funcMonthVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, timeMonth)
+}
+
+func funcDayVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, timeDay)
+}
+
+func funcHourVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, timeHour)
+}
+
+func funcMinuteVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, timeMinute)
+}
+
+func funcSecondVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, timeSecond)
+}
+
+func funcHexVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, bytesHex)
+}
+
+func funcUnhexVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, bytesUnhex)
+}
+
+func funcToBase64Vec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, bytesToBase64)
+}
+
+func funcFromBase64Vec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, bytesFromBase64)
+}
+
+func funcToBytesVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, bytesToBytes)
+}
+
+func funcDecodeUint16BEVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, keyDecodeUint16BE)
+}
+
+func funcDecodeUint32BEVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, keyDecodeUint32BE)
+}
+
+func funcDecodeUint64BEVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, keyDecodeUint64BE)
+}
+
+func funcDecodeInt64BEVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, keyDecodeInt64BE)
+}
+
+func funcDecodeVarintVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, keyDecodeVarint)
+}
+
+func funcDecodeUvarintVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, keyDecodeUvarint)
+}
+
+func funcDecodeMemcomparableBytesVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, keyDecodeMemcomparableBytes)
+}
+
+func funcDecodeMemcomparableIntVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, keyDecodeMemcomparableInt)
+}
+
+func funcEncodeUint16BEVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, keyEncodeUint16BE)
+}
+
+func funcEncodeUint32BEVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, keyEncodeUint32BE)
+}
+
+func funcEncodeUint64BEVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, keyEncodeUint64BE)
+}
+
+func funcEncodeInt64BEVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, keyEncodeInt64BE)
+}
+
+func funcEncodeVarintVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, keyEncodeVarint)
+}
+
+func funcEncodeUvarintVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, keyEncodeUvarint)
+}
+
+func funcEncodeMemcomparableBytesVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, keyEncodeMemcomparableBytes)
+}
+
+func funcEncodeMemcomparableIntVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, keyEncodeMemcomparableInt)
+}
+
+func funcTiDBTableVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, tidbTable)
+}
+
+func funcTiDBIndexIDVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, tidbIndexID)
+}
+
+func funcTiDBHandleVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, tidbHandle)
+}
+
+func funcTiDBCommonHandleVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, tidbCommonHandle)
+}
+
+func funcTiDBIndexValuesVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, tidbIndexValues)
+}
+
+func funcTiDBIsRecordVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, tidbIsRecord)
+}
+
+func funcTiDBIsIndexVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, tidbIsIndex)
+}
+
+func funcTiDBRowVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, tidbRow)
+}
+
+func funcTiDBTablePrefixVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, tidbTablePrefix)
+}
+
+func funcTiDBRecordPrefixVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, tidbRecordPrefix)
+}
+
+func funcTiDBIndexPrefixVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, tidbIndexPrefix)
+}
+
+func funcTiDBRecordKeyVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, tidbRecordKey)
+}
+
+func funcJsonExtractVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, jsonExtract)
+}
+
+func funcJsonKeysVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, jsonKeys)
+}
+
+func funcJsonLengthVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, jsonLength)
+}
+
+func funcJsonTypeVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, jsonType)
+}
+
+func funcJsonContainsVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, jsonContainsFunc)
+}
+
+func funcJsonSetVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, jsonSet)
+}
+
+func funcJsonRemoveVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, jsonRemove)
+}
+
+func funcJsonObjectVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, jsonObject)
+}
+
+func funcJsonArrayVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, jsonArray)
+}
+
+func execLambdaFuncVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx, fn lambdaFunc) ([]any, error) {
+	if ctx == nil {
+		ctx = &ExecuteCtx{}
+	}
+	var (
+		cols   = make([][]any, len(args))
+		lambda *LambdaExpr
+	)
+	for i, arg := range args {
+		if lexpr, ok := arg.(*LambdaExpr); ok {
+			lambda = lexpr
+			continue
+		}
+		col, err := arg.ExecuteBatch(chunk, ctx)
+		if err != nil {
+			return nil, err
+		}
+		cols[i] = col
+	}
+	if lambda == nil {
+		return nil, NewExecuteError(args[len(args)-1].GetPos(), "Function require lambda expression argument")
+	}
+	var (
+		ret  = make([]any, len(chunk))
+		vals = make([]any, len(args))
+		err  error
+	)
+	for i := 0; i < len(chunk); i++ {
+		for j := range cols {
+			if cols[j] != nil {
+				vals[j] = cols[j][i]
+			}
+		}
+		ret[i], err = fn(chunk[i], args, vals, lambda, ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func funcListMapVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execLambdaFuncVec(chunk, args, ctx, listMap)
+}
+
+func funcListFilterVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execLambdaFuncVec(chunk, args, ctx, listFilter)
+}
+
+func funcListReduceVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execLambdaFuncVec(chunk, args, ctx, listReduce)
+}
+
+func funcListAnyVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execLambdaFuncVec(chunk, args, ctx, listMatch("list_any", false))
+}
+
+func funcListAllVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execLambdaFuncVec(chunk, args, ctx, listMatch("list_all", true))
+}
+
+func funcListContainsVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, listContains)
+}
+
+func funcListSliceVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, listSlice)
+}
+
+func funcListSortVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, listSort)
+}
+
+func funcListDistinctVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, listDistinct)
+}
+
+func funcListSumVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, listSum)
+}
+
+func funcListAvgVec(chunk []KVPair, args []Expression, ctx *ExecuteCtx) ([]any, error) {
+	return execValueFuncVec(chunk, args, ctx, listAvg)
+}
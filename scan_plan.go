@@ -62,8 +62,6 @@ func (p *FullScanPlan) Batch(ctx *ExecuteCtx) ([]KVPair, error) {
 		filterBatch = make([]KVPair, 0, PlanBatchSize)
 		count       = 0
 		finish      = false
-		chooseIdxes = make([]int, 0, 2*PlanBatchSize)
-		bidx        = 0
 	)
 	for !finish {
 		filterBatch = filterBatch[:0]
@@ -86,17 +84,14 @@ func (p *FullScanPlan) Batch(ctx *ExecuteCtx) ([]KVPair, error) {
 			for i, m := range matchs {
 				if m {
 					ret = append(ret, filterBatch[i])
-					chooseIdxes = append(chooseIdxes, bidx)
 					count += 1
 				}
-				bidx += 1
 			}
 			if count >= PlanBatchSize {
 				finish = true
 			}
 		}
 	}
-	ctx.AdjustChunkCache(chooseIdxes)
 	return ret, nil
 }
 
@@ -158,8 +153,6 @@ func (p *PrefixScanPlan) Batch(ctx *ExecuteCtx) ([]KVPair, error) {
 		count       = 0
 		finish      = false
 		pb          = []byte(p.Prefix)
-		chooseIdxes = make([]int, 0, 2*PlanBatchSize)
-		bidx        = 0
 	)
 	for !finish {
 		filterBatch = filterBatch[:0]
@@ -187,17 +180,14 @@ func (p *PrefixScanPlan) Batch(ctx *ExecuteCtx) ([]KVPair, error) {
 			for i, m := range matchs {
 				if m {
 					ret = append(ret, filterBatch[i])
-					chooseIdxes = append(chooseIdxes, bidx)
 					count += 1
 				}
-				bidx += 1
 			}
 			if count >= PlanBatchSize {
 				finish = true
 			}
 		}
 	}
-	ctx.AdjustChunkCache(chooseIdxes)
 	return ret, nil
 }
 
@@ -273,8 +263,6 @@ func (p *RangeScanPlan) Batch(ctx *ExecuteCtx) ([]KVPair, error) {
 		filterBatch = make([]KVPair, 0, PlanBatchSize)
 		count       = 0
 		finish      = false
-		chooseIdxes = make([]int, 0, 2*PlanBatchSize)
-		bidx        = 0
 	)
 	for !finish {
 		filterBatch = filterBatch[:0]
@@ -303,17 +291,14 @@ func (p *RangeScanPlan) Batch(ctx *ExecuteCtx) ([]KVPair, error) {
 			for i, m := range matchs {
 				if m {
 					ret = append(ret, filterBatch[i])
-					chooseIdxes = append(chooseIdxes, bidx)
 					count += 1
 				}
-				bidx += 1
 			}
 			if count >= PlanBatchSize {
 				finish = true
 			}
 		}
 	}
-	ctx.AdjustChunkCache(chooseIdxes)
 	return ret, nil
 }
 
@@ -388,8 +373,6 @@ func (p *MultiGetPlan) Batch(ctx *ExecuteCtx) ([]KVPair, error) {
 		filterBatch = make([]KVPair, 0, PlanBatchSize)
 		count       = 0
 		finish      = false
-		chooseIdxes = make([]int, 0, 2*PlanBatchSize)
-		bidx        = 0
 	)
 	for !finish {
 		filterBatch = filterBatch[:0]
@@ -418,7 +401,6 @@ func (p *MultiGetPlan) Batch(ctx *ExecuteCtx) ([]KVPair, error) {
 			for i, m := range matchs {
 				if m {
 					ret = append(ret, filterBatch[i])
-					chooseIdxes = append(chooseIdxes, bidx)
 					count += 1
 				}
 			}
@@ -427,7 +409,6 @@ func (p *MultiGetPlan) Batch(ctx *ExecuteCtx) ([]KVPair, error) {
 			finish = true
 		}
 	}
-	ctx.AdjustChunkCache(chooseIdxes)
 	return ret, nil
 }
 
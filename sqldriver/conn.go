@@ -0,0 +1,301 @@
+package sqldriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+
+	"github.com/c4pt0r/kvql"
+)
+
+var (
+	_ driver.Conn               = (*conn)(nil)
+	_ driver.ConnPrepareContext = (*conn)(nil)
+	_ driver.ConnBeginTx        = (*conn)(nil)
+	_ driver.ExecerContext      = (*conn)(nil)
+	_ driver.QueryerContext     = (*conn)(nil)
+	_ driver.Pinger             = (*conn)(nil)
+	_ driver.Stmt               = (*stmt)(nil)
+	_ driver.StmtExecContext    = (*stmt)(nil)
+	_ driver.StmtQueryContext   = (*stmt)(nil)
+	_ driver.Tx                 = (*tx)(nil)
+)
+
+type conn struct {
+	storage kvql.Storage
+	policy  *kvql.ExecutePolicy
+	txn     kvql.Txn
+	// txnPolicy is the policy of read only transaction
+	txnPolicy *kvql.ExecutePolicy
+	// warnings of the last statement
+	warnings []string
+	closed   bool
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if c.closed {
+		return nil, driver.ErrBadConn
+	}
+	ps, err := kvql.Prepare(query)
+	if err != nil {
+		return nil, err
+	}
+	return &stmt{
+		conn: c,
+		ps:   ps,
+	}, nil
+}
+
+func (c *conn) Close() error {
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if c.txn != nil {
+		txn := c.txn
+		c.txn = nil
+		return txn.Rollback()
+	}
+	return nil
+}
+
+func (c *conn) Ping(ctx context.Context) error {
+	if c.closed {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if c.txn != nil {
+		return nil, kvql.ErrTxnAlreadyBegin
+	}
+	if opts.Isolation != driver.IsolationLevel(0) {
+		return nil, errors.New("kvql: isolation level not supported")
+	}
+	ts, ok := c.storage.(kvql.TxnStorage)
+	if !ok {
+		return nil, kvql.ErrTxnNotSupported
+	}
+	txn, err := ts.Begin()
+	if err != nil {
+		return nil, err
+	}
+	c.txn = txn
+	c.txnPolicy = nil
+	if opts.ReadOnly {
+		policy := kvql.ExecutePolicy{}
+		if c.policy != nil {
+			policy = *c.policy
+		}
+		policy.ReadOnly = true
+		c.txnPolicy = &policy
+	}
+	return &tx{conn: c}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	s, err := c.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	return s.(*stmt).ExecContext(ctx, args)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	s, err := c.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+	return s.(*stmt).QueryContext(ctx, args)
+}
+
+func (c *conn) currentStorage() kvql.Storage {
+	if c.txn != nil {
+		return c.txn
+	}
+	return c.storage
+}
+
+func (c *conn) currentPolicy() *kvql.ExecutePolicy {
+	if c.txn != nil && c.txnPolicy != nil {
+		return c.txnPolicy
+	}
+	return c.policy
+}
+
+type tx struct {
+	conn *conn
+}
+
+func (t *tx) Commit() error {
+	txn := t.conn.txn
+	if txn == nil {
+		return kvql.ErrTxnNotBegin
+	}
+	t.conn.txn = nil
+	return txn.Commit()
+}
+
+func (t *tx) Rollback() error {
+	txn := t.conn.txn
+	if txn == nil {
+		return kvql.ErrTxnNotBegin
+	}
+	t.conn.txn = nil
+	return txn.Rollback()
+}
+
+type stmt struct {
+	conn *conn
+	ps   *kvql.PreparedStmt
+}
+
+func (s *stmt) Close() error {
+	return nil
+}
+
+func (s *stmt) NumInput() int {
+	return s.ps.NumParams()
+}
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *stmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	plan, err := s.buildPlan(args)
+	if err != nil {
+		return nil, err
+	}
+	ectx := kvql.NewExecuteCtx()
+	affected, err := executePlan(ctx, ectx, s.ps.Statement(), plan)
+	s.conn.warnings = ectx.Warnings
+	if err != nil {
+		return nil, err
+	}
+	return result(affected), nil
+}
+
+func (s *stmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	plan, err := s.buildPlan(args)
+	if err != nil {
+		return nil, err
+	}
+	return newRows(ctx, s.conn, plan), nil
+}
+
+func (s *stmt) buildPlan(args []driver.NamedValue) (kvql.FinalPlan, error) {
+	if s.conn.closed {
+		return nil, driver.ErrBadConn
+	}
+	s.conn.warnings = nil
+	if len(args) > 0 || s.ps.NumParams() > 0 {
+		bargs := make([]any, len(args))
+		for i, arg := range args {
+			if arg.Name != "" {
+				bargs[i] = kvql.Named(arg.Name, arg.Value)
+			} else {
+				bargs[i] = arg.Value
+			}
+		}
+		if err := s.ps.Bind(bargs...); err != nil {
+			return nil, err
+		}
+	}
+	return s.ps.BuildPlan(s.conn.currentStorage(), s.conn.currentPolicy())
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	ret := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		ret[i] = driver.NamedValue{
+			Ordinal: i + 1,
+			Value:   arg,
+		}
+	}
+	return ret
+}
+
+// executePlan executes the plan and returns the affected rows. Write
+// statement without returning clause returns the affected rows in the
+// first column, otherwise the returned rows are counted.
+func executePlan(ctx context.Context, ectx *kvql.ExecuteCtx, stmt kvql.Statement, plan kvql.FinalPlan) (int64, error) {
+	var (
+		affected int64
+		countCol = returnsAffectedRows(stmt)
+	)
+	for {
+		if err := ctx.Err(); err != nil {
+			return affected, err
+		}
+		rows, err := plan.Batch(ectx)
+		if err != nil {
+			return affected, err
+		}
+		if len(rows) == 0 {
+			return affected, nil
+		}
+		if !countCol {
+			affected += int64(len(rows))
+		} else {
+			for _, row := range rows {
+				n, err := toInt64(row[0])
+				if err != nil {
+					return affected, err
+				}
+				affected += n
+			}
+		}
+		ectx.Clear()
+	}
+}
+
+func returnsAffectedRows(stmt kvql.Statement) bool {
+	switch s := stmt.(type) {
+	case *kvql.PutStmt:
+		return true
+	case *kvql.RemoveStmt:
+		return s.Returning == nil
+	case *kvql.DeleteStmt:
+		return s.Returning == nil
+	case *kvql.UpdateStmt:
+		return s.Returning == nil
+	}
+	return false
+}
+
+func toInt64(v any) (int64, error) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), nil
+	case int64:
+		return n, nil
+	}
+	return 0, fmt.Errorf("kvql: invalid affected rows %v", v)
+}
+
+type result int64
+
+func (r result) LastInsertId() (int64, error) {
+	return 0, errors.New("kvql: LastInsertId not supported")
+}
+
+func (r result) RowsAffected() (int64, error) {
+	return int64(r), nil
+}
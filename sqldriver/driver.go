@@ -0,0 +1,163 @@
+// Package sqldriver is the database/sql driver of kvql. The storage should
+// be registered by name, and the name is used as data source name:
+//
+//	sqldriver.Register("mydb", storage)
+//	db, err := sql.Open("kvql", "mydb")
+//	rows, err := db.Query("select key, value where key ^= ?", "user_")
+//
+// Data source name can set the execute policy by query parameters:
+//
+//	mydb?read_only=true&max_affected_rows=1000&deny_full_scan_write=true
+//
+// The warnings of the last statement executed by a connection, such as the
+// non-atomic fallback of conditional put, are returned by Warnings.
+package sqldriver
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/c4pt0r/kvql"
+)
+
+const DriverName = "kvql"
+
+var (
+	_ driver.Driver        = (*Driver)(nil)
+	_ driver.DriverContext = (*Driver)(nil)
+	_ driver.Connector     = (*connector)(nil)
+
+	storagesMu sync.RWMutex
+	storages   = make(map[string]kvql.Storage)
+)
+
+func init() {
+	sql.Register(DriverName, &Driver{})
+}
+
+// Register makes the storage available by name in data source name, it
+// replaces the storage registered with the same name.
+func Register(name string, s kvql.Storage) {
+	storagesMu.Lock()
+	defer storagesMu.Unlock()
+	storages[name] = s
+}
+
+func Unregister(name string) {
+	storagesMu.Lock()
+	defer storagesMu.Unlock()
+	delete(storages, name)
+}
+
+func getStorage(name string) (kvql.Storage, bool) {
+	storagesMu.RLock()
+	defer storagesMu.RUnlock()
+	s, have := storages[name]
+	return s, have
+}
+
+// Warnings returns the warnings of the last statement executed by the
+// connection, the warnings of query are complete after rows are read.
+func Warnings(c *sql.Conn) ([]string, error) {
+	var ret []string
+	err := c.Raw(func(dc any) error {
+		kc, ok := dc.(*conn)
+		if !ok {
+			return fmt.Errorf("kvql: not a kvql connection")
+		}
+		ret = append(ret, kc.warnings...)
+		return nil
+	})
+	return ret, err
+}
+
+type Driver struct{}
+
+func (d *Driver) Open(dsn string) (driver.Conn, error) {
+	c, err := d.OpenConnector(dsn)
+	if err != nil {
+		return nil, err
+	}
+	return c.Connect(context.Background())
+}
+
+func (d *Driver) OpenConnector(dsn string) (driver.Connector, error) {
+	name, policy, err := parseDSN(dsn)
+	if err != nil {
+		return nil, err
+	}
+	s, have := getStorage(name)
+	if !have {
+		return nil, fmt.Errorf("kvql: storage %s not registered", name)
+	}
+	return &connector{
+		driver:  d,
+		storage: s,
+		policy:  policy,
+	}, nil
+}
+
+// NewConnector returns connector of storage without registration, it can
+// be used by sql.OpenDB. policy can be nil.
+func NewConnector(s kvql.Storage, policy *kvql.ExecutePolicy) driver.Connector {
+	return &connector{
+		driver:  &Driver{},
+		storage: s,
+		policy:  policy,
+	}
+}
+
+type connector struct {
+	driver  *Driver
+	storage kvql.Storage
+	policy  *kvql.ExecutePolicy
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &conn{
+		storage: c.storage,
+		policy:  c.policy,
+	}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return c.driver
+}
+
+func parseDSN(dsn string) (string, *kvql.ExecutePolicy, error) {
+	name, query, _ := strings.Cut(dsn, "?")
+	if name == "" {
+		return "", nil, fmt.Errorf("kvql: require storage name in data source name")
+	}
+	if query == "" {
+		return name, nil, nil
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return "", nil, fmt.Errorf("kvql: invalid data source name: %w", err)
+	}
+	policy := &kvql.ExecutePolicy{}
+	for key, vals := range params {
+		val := vals[len(vals)-1]
+		switch key {
+		case "read_only":
+			policy.ReadOnly, err = strconv.ParseBool(val)
+		case "deny_full_scan_write":
+			policy.DenyFullScanWrite, err = strconv.ParseBool(val)
+		case "max_affected_rows":
+			policy.MaxAffectedRows, err = strconv.Atoi(val)
+		default:
+			return "", nil, fmt.Errorf("kvql: unknown parameter %s in data source name", key)
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("kvql: invalid parameter %s: %w", key, err)
+		}
+	}
+	return name, policy, nil
+}
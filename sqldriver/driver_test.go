@@ -0,0 +1,219 @@
+package sqldriver
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/c4pt0r/kvql"
+	"github.com/c4pt0r/kvql/memstore"
+)
+
+func openTestDB(t *testing.T, dsn string) (*sql.DB, *memstore.Store) {
+	s := memstore.New()
+	for i := 0; i < 10; i++ {
+		s.Put([]byte(fmt.Sprintf("k_%02d", i)), []byte(fmt.Sprintf(`{"id": %d}`, i)))
+	}
+	name, _, _ := strings.Cut(dsn, "?")
+	Register(name, s)
+	t.Cleanup(func() {
+		Unregister(name)
+	})
+	db, err := sql.Open(DriverName, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+	})
+	return db, s
+}
+
+func TestQuery(t *testing.T) {
+	db, _ := openTestDB(t, "query_test")
+	rows, err := db.Query("select key, int(json(value)['id']) * 2 as v, json(value) as j where key ^= ? & int(json(value)['id']) >= ?", "k_0", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	cols, _ := rows.Columns()
+	if strings.Join(cols, ",") != "KEY,v,j" {
+		t.Fatal("Unexpected columns", cols)
+	}
+	ctypes, _ := rows.ColumnTypes()
+	tnames := []string{}
+	for _, ct := range ctypes {
+		tnames = append(tnames, ct.DatabaseTypeName())
+	}
+	if strings.Join(tnames, ",") != "STR,NUMBER,JSON" {
+		t.Fatal("Unexpected column types", tnames)
+	}
+	ret := []string{}
+	for rows.Next() {
+		var (
+			key string
+			v   int64
+			j   string
+		)
+		if err := rows.Scan(&key, &v, &j); err != nil {
+			t.Fatal(err)
+		}
+		ret = append(ret, fmt.Sprintf("%s:%d:%s", key, v, j))
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(ret, "|") != `k_07:14:{"id":7}|k_08:16:{"id":8}|k_09:18:{"id":9}` {
+		t.Fatal("Unexpected result", ret)
+	}
+
+	var cnt int64
+	err = db.QueryRow("select count(1) where key between :start and :end", sql.Named("start", "k_02"), sql.Named("end", "k_05")).Scan(&cnt)
+	if err != nil || cnt != 4 {
+		t.Fatal("Unexpected count", cnt, err)
+	}
+
+	var ts time.Time
+	err = db.QueryRow("select from_unixtime(1704164645) where key = 'k_01'").Scan(&ts)
+	if err != nil || !ts.Equal(time.Unix(1704164645, 0)) {
+		t.Fatal("Unexpected time", ts, err)
+	}
+
+	_, err = db.Query("select * where key ^=")
+	var serr *kvql.SyntaxError
+	if !errors.As(err, &serr) {
+		t.Fatal("Should get syntax error", err)
+	}
+}
+
+func TestExec(t *testing.T) {
+	db, s := openTestDB(t, "exec_test")
+	tdata := []struct {
+		query    string
+		args     []any
+		affected int64
+	}{
+		{"put (?, ?), (?, ?)", []any{"n_1", "v1", "n_2", "v2"}, 2},
+		{"put if not exists ('n_1', 'x'), ('n_3', 'v3')", nil, 1},
+		{"update set value = 'new' where key ^= $1", []any{"n_"}, 3},
+		{"delete where key ^= 'k_0' & key < 'k_03'", nil, 3},
+		{"remove 'n_1', 'n_2'", nil, 2},
+		{"dry run delete where key ^= 'k_'", nil, 7},
+	}
+	for _, item := range tdata {
+		ret, err := db.Exec(item.query, item.args...)
+		if err != nil {
+			t.Fatal(item.query, err)
+		}
+		n, _ := ret.RowsAffected()
+		if n != item.affected {
+			t.Fatalf("%s expect %d affected rows but got %d", item.query, item.affected, n)
+		}
+	}
+	if s.Len() != 8 {
+		t.Fatal("Unexpected number of keys", s.Len())
+	}
+
+	stmt, err := db.Prepare("put (?, ?)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	for i := 0; i < 3; i++ {
+		if _, err = stmt.Exec(fmt.Sprintf("p_%d", i), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var val string
+	if err = db.QueryRow("select value where key = 'p_2'").Scan(&val); err != nil || val != "2" {
+		t.Fatal("Unexpected value", val, err)
+	}
+}
+
+func TestTxAndPolicy(t *testing.T) {
+	db, s := openTestDB(t, "tx_test?max_affected_rows=5")
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tx.Exec("put ('t_1', 'v1')"); err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := s.Get([]byte("t_1")); val != nil {
+		t.Fatal("Write should be invisible before commit")
+	}
+	if err = tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if val, _ := s.Get([]byte("t_1")); string(val) != "v1" {
+		t.Fatal("Write should be visible after commit")
+	}
+
+	_, err = db.Exec("delete where key ^= 'k_'")
+	var perr *kvql.PolicyError
+	if !errors.As(err, &perr) || perr.Rule != kvql.PolicyMaxAffectedRows {
+		t.Fatal("Should get policy error", err)
+	}
+
+	tx, err = db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err = tx.Exec("put ('t_2', 'v2')"); !errors.As(err, &perr) || perr.Rule != kvql.PolicyReadOnly {
+		t.Fatal("Should get read only policy error", err)
+	}
+
+	if _, err = sql.Open(DriverName, "tx_test?unknown=1"); err == nil {
+		t.Fatal("Should fail with unknown parameter")
+	}
+	if _, err = sql.Open(DriverName, "not_registered"); err == nil {
+		t.Fatal("Should fail with unregistered storage")
+	}
+	if err = sql.OpenDB(NewConnector(s, nil)).Ping(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// nonAtomicStorage hides the atomic writes of memstore
+type nonAtomicStorage struct {
+	kvql.Storage
+}
+
+func TestWarnings(t *testing.T) {
+	db := sql.OpenDB(NewConnector(nonAtomicStorage{memstore.New()}, nil))
+	defer db.Close()
+	ctx := context.Background()
+	c, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err = c.ExecContext(ctx, "put if not exists ('a', '1')"); err != nil {
+		t.Fatal(err)
+	}
+	warnings, err := Warnings(c)
+	if err != nil || len(warnings) != 1 || !strings.Contains(warnings[0], "not atomic") {
+		t.Fatal("Unexpected warnings", warnings, err)
+	}
+	rows, err := c.QueryContext(ctx, "put if not exists ('b', '2')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+	}
+	rows.Close()
+	if warnings, err = Warnings(c); err != nil || len(warnings) != 1 {
+		t.Fatal("Unexpected warnings of query", warnings, err)
+	}
+	if _, err = c.ExecContext(ctx, "put ('c', '3')"); err != nil {
+		t.Fatal(err)
+	}
+	if warnings, err = Warnings(c); err != nil || len(warnings) != 0 {
+		t.Fatal("Warnings should be cleared", warnings, err)
+	}
+}
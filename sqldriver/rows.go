@@ -0,0 +1,136 @@
+package sqldriver
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/c4pt0r/kvql"
+)
+
+var (
+	_ driver.Rows                           = (*rows)(nil)
+	_ driver.RowsColumnTypeDatabaseTypeName = (*rows)(nil)
+	_ driver.RowsColumnTypeScanType         = (*rows)(nil)
+
+	scanTypeAny    = reflect.TypeOf((*any)(nil)).Elem()
+	scanTypeBool   = reflect.TypeOf(false)
+	scanTypeString = reflect.TypeOf("")
+	scanTypeTime   = reflect.TypeOf(time.Time{})
+)
+
+// rows streams the plan results batch by batch
+type rows struct {
+	ctx   context.Context
+	conn  *conn
+	plan  kvql.FinalPlan
+	ectx  *kvql.ExecuteCtx
+	names []string
+	types []kvql.Type
+	buf   [][]kvql.Column
+	done  bool
+}
+
+func newRows(ctx context.Context, c *conn, plan kvql.FinalPlan) *rows {
+	return &rows{
+		ctx:   ctx,
+		conn:  c,
+		plan:  plan,
+		ectx:  kvql.NewExecuteCtx(),
+		names: plan.FieldNameList(),
+		types: plan.FieldTypeList(),
+	}
+}
+
+func (r *rows) Columns() []string {
+	return r.names
+}
+
+func (r *rows) Close() error {
+	r.done = true
+	r.buf = nil
+	return nil
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	for len(r.buf) == 0 {
+		if r.done {
+			return io.EOF
+		}
+		if err := r.ctx.Err(); err != nil {
+			return err
+		}
+		r.ectx.Clear()
+		batch, err := r.plan.Batch(r.ectx)
+		r.conn.warnings = r.ectx.Warnings
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			r.done = true
+			return io.EOF
+		}
+		r.buf = batch
+	}
+	row := r.buf[0]
+	r.buf = r.buf[1:]
+	for i := range dest {
+		if i >= len(row) {
+			dest[i] = nil
+			continue
+		}
+		val, err := driverValue(row[i])
+		if err != nil {
+			return err
+		}
+		dest[i] = val
+	}
+	return nil
+}
+
+func (r *rows) ColumnTypeDatabaseTypeName(index int) string {
+	return kvql.TypeToString[r.types[index]]
+}
+
+func (r *rows) ColumnTypeScanType(index int) reflect.Type {
+	switch r.types[index] {
+	case kvql.TBOOL:
+		return scanTypeBool
+	case kvql.TSTR, kvql.TIDENT, kvql.TJSON, kvql.TLIST:
+		return scanTypeString
+	case kvql.TTIME:
+		return scanTypeTime
+	}
+	return scanTypeAny
+}
+
+// driverValue converts the column to driver value, JSON and list are
+// encoded to JSON string.
+func driverValue(col kvql.Column) (driver.Value, error) {
+	switch v := col.(type) {
+	case nil, int64, float64, bool, []byte, string, time.Time:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case int32:
+		return int64(v), nil
+	case uint64:
+		if v > math.MaxInt64 {
+			return strconv.FormatUint(v, 10), nil
+		}
+		return int64(v), nil
+	case float32:
+		return float64(v), nil
+	}
+	data, err := json.Marshal(col)
+	if err != nil {
+		return nil, fmt.Errorf("kvql: cannot convert %T column: %w", col, err)
+	}
+	return string(data), nil
+}
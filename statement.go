@@ -9,6 +9,10 @@ var (
 	_ Statement = (*LimitStmt)(nil)
 	_ Statement = (*PutStmt)(nil)
 	_ Statement = (*RemoveStmt)(nil)
+	_ Statement = (*WithStmt)(nil)
+	_ Statement = (*FromStmt)(nil)
+	_ Statement = (*UpdateStmt)(nil)
+	_ Statement = (*ReturningStmt)(nil)
 )
 
 type Statement interface {
@@ -25,6 +29,8 @@ type SelectStmt struct {
 	Order      *OrderStmt
 	Limit      *LimitStmt
 	GroupBy    *GroupByStmt
+	From       *FromStmt
+	With       *WithStmt
 }
 
 func (s *SelectStmt) Name() string {
@@ -79,6 +85,37 @@ func (s *LimitStmt) Name() string {
 	return "LIMIT"
 }
 
+type CTEStmt struct {
+	Pos          int
+	Name         string
+	Select       *SelectStmt
+	Refs         int
+	Materialized bool
+	result       *cteResult
+}
+
+type WithStmt struct {
+	Pos  int
+	CTEs []*CTEStmt
+}
+
+func (s *WithStmt) Name() string {
+	return "WITH"
+}
+
+type FromStmt struct {
+	Pos int
+	// CTE is the common table expression named in from clause
+	CTE *CTEStmt
+	// Scan is the materialized CTE the select statement reads rows from,
+	// nil means the select statement reads from storage directly.
+	Scan *CTEStmt
+}
+
+func (s *FromStmt) Name() string {
+	return "FROM"
+}
+
 type PutKVPair struct {
 	Key   Expression
 	Value Expression
@@ -91,6 +128,19 @@ func (p *PutKVPair) String() string {
 type PutStmt struct {
 	Pos     int
 	KVPairs []*PutKVPair
+	// Select is not nil when put statement reads key value pairs from
+	// select statement: put select key, value where ...
+	Select *SelectStmt
+	// IfNotExists only puts the key which not exists:
+	// put if not exists ('k', 'v')
+	IfNotExists bool
+	// OldValue is not nil means compare and set:
+	// put ('k', 'new') if value = 'old'
+	OldValue Expression
+}
+
+func (s *PutStmt) Conditional() bool {
+	return s.IfNotExists || s.OldValue != nil
 }
 
 func (s *PutStmt) Name() string {
@@ -98,8 +148,10 @@ func (s *PutStmt) Name() string {
 }
 
 type RemoveStmt struct {
-	Pos  int
-	Keys []Expression
+	Pos       int
+	Keys      []Expression
+	Returning *ReturningStmt
+	DryRun    bool
 }
 
 func (s *RemoveStmt) Name() string {
@@ -107,19 +159,47 @@ func (s *RemoveStmt) Name() string {
 }
 
 type DeleteStmt struct {
-	Pos   int
-	Where *WhereStmt
-	Limit *LimitStmt
+	Pos       int
+	Where     *WhereStmt
+	Limit     *LimitStmt
+	Returning *ReturningStmt
+	DryRun    bool
 }
 
 func (s *DeleteStmt) Name() string {
 	return "DELETE"
 }
 
+type UpdateStmt struct {
+	Pos       int
+	Value     Expression
+	Where     *WhereStmt
+	Limit     *LimitStmt
+	Returning *ReturningStmt
+	DryRun    bool
+}
+
+func (s *UpdateStmt) Name() string {
+	return "UPDATE"
+}
+
+// ReturningStmt returns the affected rows of write statement:
+// delete where key ^= 'k' returning key, value
+type ReturningStmt struct {
+	Pos        int
+	Fields     []Expression
+	FieldNames []string
+	FieldTypes []Type
+}
+
+func (s *ReturningStmt) Name() string {
+	return "RETURNING"
+}
+
 func (s *RemoveStmt) Validate(ctx *CheckCtx) error {
 	for _, expr := range s.Keys {
 		rtype := expr.ReturnType()
-		if rtype != TSTR && rtype != TNUMBER {
+		if !isStringType(rtype) && rtype != TNUMBER && !isUnboundParam(expr) {
 			return NewSyntaxError(expr.GetPos(), "need str or number type")
 		}
 		if err := expr.Check(ctx); err != nil {
@@ -130,11 +210,53 @@ func (s *RemoveStmt) Validate(ctx *CheckCtx) error {
 }
 
 func (s *PutStmt) Validate(ctx *CheckCtx) error {
+	if s.Select != nil {
+		return s.validateSelect()
+	}
 	for _, kv := range s.KVPairs {
 		if err := s.validateKVPair(kv, ctx); err != nil {
 			return err
 		}
 	}
+	if s.OldValue != nil {
+		if err := s.OldValue.Check(ctx); err != nil {
+			return err
+		}
+		switch s.OldValue.ReturnType() {
+		case TSTR, TBYTES, TNUMBER:
+			break
+		default:
+			if !isUnboundParam(s.OldValue) {
+				return NewSyntaxError(s.OldValue.GetPos(), "need str or number type")
+			}
+		}
+	}
+	return nil
+}
+
+func (s *PutStmt) validateSelect() error {
+	if s.Select.AllFields {
+		return nil
+	}
+	if len(s.Select.Fields) != 2 {
+		return NewSyntaxError(s.Select.Pos, "put select statement require key and value fields")
+	}
+	for i, f := range s.Select.Fields {
+		switch f.ReturnType() {
+		case TSTR, TBYTES, TNUMBER:
+			break
+		case TJSON, TLIST:
+			if i == 1 {
+				// Value field is written as JSON text
+				break
+			}
+			return NewSyntaxError(f.GetPos(), "need str or number type")
+		default:
+			if !isUnboundParam(f) {
+				return NewSyntaxError(f.GetPos(), "need str or number type")
+			}
+		}
+	}
 	return nil
 }
 
@@ -143,19 +265,24 @@ func (s *PutStmt) validateKVPair(kv *PutKVPair, ctx *CheckCtx) error {
 		return err
 	}
 	switch kv.Key.ReturnType() {
-	case TSTR, TNUMBER:
+	case TSTR, TBYTES, TNUMBER:
 		break
 	default:
-		return NewSyntaxError(kv.Key.GetPos(), "need str or number type")
+		if !isUnboundParam(kv.Key) {
+			return NewSyntaxError(kv.Key.GetPos(), "need str or number type")
+		}
 	}
 	if err := kv.Value.Check(ctx); err != nil {
 		return err
 	}
 	switch kv.Value.ReturnType() {
-	case TSTR, TNUMBER:
+	case TSTR, TBYTES, TNUMBER, TJSON, TLIST:
+		// JSON and list values are written as JSON text
 		break
 	default:
-		return NewSyntaxError(kv.Value.GetPos(), "need str or number type")
+		if !isUnboundParam(kv.Value) {
+			return NewSyntaxError(kv.Value.GetPos(), "need str, number or JSON type")
+		}
 	}
 	return nil
 }
@@ -234,3 +361,18 @@ func (s *SelectStmt) checkAggrFuncArg(arg Expression) error {
 	}
 	return nil
 }
+
+func (s *UpdateStmt) Validate(ctx *CheckCtx) error {
+	if err := s.Value.Check(ctx); err != nil {
+		return err
+	}
+	switch s.Value.ReturnType() {
+	case TSTR, TBYTES, TNUMBER, TJSON, TLIST:
+		break
+	default:
+		if !isUnboundParam(s.Value) {
+			return NewSyntaxError(s.Value.GetPos(), "need str, number or JSON type")
+		}
+	}
+	return s.Where.Expr.Check(ctx)
+}
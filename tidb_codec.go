@@ -0,0 +1,465 @@
+package kvql
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+/*
+TiDB key and value layout:
+
+	Record key: t{tableID}_r{handle}
+	Index key:  t{tableID}_i{indexID}{indexValues}{handle}
+
+The table ID, index ID and int handle are memcomparable integers. The index
+values and common handle are datums, each datum has a flag byte followed by
+the encoded data. The record value is in row format v2:
+
+	| 128 | flag | notNullCount | nullCount | colIDs | offsets | data |
+
+The counts are uint16 little-endian. The column IDs are 1 byte and offsets
+are uint16 if the row is small, otherwise they are uint32. The offsets are
+the end offsets of not null columns in data.
+*/
+
+const (
+	tidbTableKeyPrefix  = "t"
+	tidbRecordPrefixSep = "_r"
+	tidbIndexPrefixSep  = "_i"
+
+	// tidbPrefixLen is the length of t{tableID}
+	tidbPrefixLen = 9
+	// tidbRecordPrefixLen is the length of t{tableID}_r or t{tableID}_i{indexID}
+	tidbRecordPrefixLen = 11
+	tidbIndexPrefixLen  = 19
+
+	tidbRowCodecVer   = byte(128)
+	tidbRowFlagLarge  = byte(1)
+	tidbRowHeaderSize = 6
+)
+
+// Datum flags of TiDB codec
+const (
+	datumNilFlag          byte = 0
+	datumBytesFlag        byte = 1
+	datumCompactBytesFlag byte = 2
+	datumIntFlag          byte = 3
+	datumUintFlag         byte = 4
+	datumFloatFlag        byte = 5
+	datumDecimalFlag      byte = 6
+	datumDurationFlag     byte = 7
+	datumVarintFlag       byte = 8
+	datumUvarintFlag      byte = 9
+)
+
+var (
+	errNotTableKey  = errors.New("not a table key")
+	errNotRecordKey = errors.New("not a record key")
+	errNotIndexKey  = errors.New("not an index key")
+	errNotIntHandle = errors.New("not an int handle")
+	errNotRowV2     = errors.New("not row format v2")
+	errInvalidRow   = errors.New("invalid row data")
+)
+
+func encodeTiDBTablePrefix(tableID int64) []byte {
+	return encodeMemcomparableInt([]byte(tidbTableKeyPrefix), tableID)
+}
+
+func encodeTiDBRecordPrefix(tableID int64) []byte {
+	return append(encodeTiDBTablePrefix(tableID), tidbRecordPrefixSep...)
+}
+
+func encodeTiDBIndexPrefix(tableID, indexID int64) []byte {
+	return encodeMemcomparableInt(append(encodeTiDBTablePrefix(tableID), tidbIndexPrefixSep...), indexID)
+}
+
+func decodeTiDBTableID(key []byte) (int64, error) {
+	if len(key) < tidbPrefixLen || string(key[:1]) != tidbTableKeyPrefix {
+		return 0, errNotTableKey
+	}
+	tableID, _, err := decodeMemcomparableInt(key[1:])
+	return tableID, err
+}
+
+func isTiDBRecordKey(key []byte) bool {
+	return len(key) > tidbRecordPrefixLen && string(key[:1]) == tidbTableKeyPrefix &&
+		string(key[tidbPrefixLen:tidbRecordPrefixLen]) == tidbRecordPrefixSep
+}
+
+func isTiDBIndexKey(key []byte) bool {
+	return len(key) >= tidbIndexPrefixLen && string(key[:1]) == tidbTableKeyPrefix &&
+		string(key[tidbPrefixLen:tidbRecordPrefixLen]) == tidbIndexPrefixSep
+}
+
+// decodeTiDBHandle returns the int handle of record key
+func decodeTiDBHandle(key []byte) (int64, error) {
+	if !isTiDBRecordKey(key) {
+		return 0, errNotRecordKey
+	}
+	if len(key) != tidbRecordPrefixLen+8 {
+		return 0, errNotIntHandle
+	}
+	handle, _, err := decodeMemcomparableInt(key[tidbRecordPrefixLen:])
+	return handle, err
+}
+
+// decodeTiDBCommonHandle returns the datums of common handle of record key
+func decodeTiDBCommonHandle(key []byte) ([]any, error) {
+	if !isTiDBRecordKey(key) {
+		return nil, errNotRecordKey
+	}
+	if len(key) == tidbRecordPrefixLen+8 {
+		return nil, errors.New("not a common handle")
+	}
+	return decodeDatums(key[tidbRecordPrefixLen:])
+}
+
+func decodeTiDBIndexID(key []byte) (int64, error) {
+	if !isTiDBIndexKey(key) {
+		return 0, errNotIndexKey
+	}
+	indexID, _, err := decodeMemcomparableInt(key[tidbRecordPrefixLen:])
+	return indexID, err
+}
+
+// decodeTiDBIndexValues returns the datums after index ID, the handle is
+// included if the index is not unique.
+func decodeTiDBIndexValues(key []byte) ([]any, error) {
+	if !isTiDBIndexKey(key) {
+		return nil, errNotIndexKey
+	}
+	return decodeDatums(key[tidbIndexPrefixLen:])
+}
+
+func decodeDatums(b []byte) ([]any, error) {
+	ret := []any{}
+	for len(b) > 0 {
+		datum, n, err := decodeDatum(b)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, datum)
+		b = b[n:]
+	}
+	return ret, nil
+}
+
+// decodeDatum decodes the datum encoded in key, returns the value and the
+// number of bytes read. Bytes is returned as string, binary data which is
+// not valid UTF-8 is returned as hex string literal.
+func decodeDatum(b []byte) (any, int, error) {
+	if len(b) == 0 {
+		return nil, 0, errInsufficientData
+	}
+	var (
+		flag = b[0]
+		data = b[1:]
+		ret  any
+		n    int
+		err  error
+	)
+	switch flag {
+	case datumNilFlag:
+		return nil, 1, nil
+	case datumBytesFlag:
+		var bval []byte
+		bval, n, err = decodeMemcomparableBytes(data)
+		ret = binaryString(bval)
+	case datumCompactBytesFlag:
+		var length int64
+		length, n, err = decodeVarint(data)
+		if err == nil {
+			if length < 0 || int64(len(data)-n) < length {
+				return nil, 0, errInsufficientData
+			}
+			ret = binaryString(data[n : n+int(length)])
+			n += int(length)
+		}
+	case datumIntFlag:
+		ret, n, err = decodeMemcomparableInt(data)
+	case datumUintFlag:
+		if len(data) < 8 {
+			return nil, 0, errInsufficientData
+		}
+		ret, n = uintValue(binary.BigEndian.Uint64(data)), 8
+	case datumFloatFlag:
+		if len(data) < 8 {
+			return nil, 0, errInsufficientData
+		}
+		ret, n = decodeComparableFloat(data), 8
+	case datumDecimalFlag:
+		ret, n, err = decodeDecimal(data)
+	case datumDurationFlag:
+		var nanos int64
+		nanos, n, err = decodeMemcomparableInt(data)
+		ret = formatDuration(nanos)
+	case datumVarintFlag:
+		ret, n, err = decodeVarint(data)
+	case datumUvarintFlag:
+		var v uint64
+		v, n, err = decodeUvarint(data)
+		ret = uintValue(v)
+	default:
+		return nil, 0, fmt.Errorf("unsupported datum flag %d", flag)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return ret, n + 1, nil
+}
+
+// decodeComparableFloat decodes the float which sign bit is flipped for
+// positive number and all bits are flipped for negative number.
+func decodeComparableFloat(b []byte) float64 {
+	u := binary.BigEndian.Uint64(b)
+	if u&signMask > 0 {
+		u &^= signMask
+	} else {
+		u = ^u
+	}
+	return math.Float64frombits(u)
+}
+
+const (
+	decimalDigitsPerWord = 9
+	decimalWordSize      = 4
+)
+
+var decimalDig2Bytes = [10]int{0, 1, 1, 2, 2, 3, 3, 4, 4, 4}
+
+/*
+decodeDecimal decodes the decimal encoded as precision, frac and the MySQL
+binary format, returns the decimal string and the number of bytes read.
+
+The integer and fraction digits are stored in words of 9 digits, the
+leftover digits use the least bytes. The sign bit of first byte is flipped
+and all bytes are flipped if the number is negative.
+*/
+func decodeDecimal(b []byte) (string, int, error) {
+	if len(b) < 2 {
+		return "", 0, errInsufficientData
+	}
+	precision, frac := int(b[0]), int(b[1])
+	if precision == 0 || frac > precision {
+		return "", 0, errors.New("invalid decimal precision")
+	}
+	digitsInt := precision - frac
+	wordsInt, leadingDigits := digitsInt/decimalDigitsPerWord, digitsInt%decimalDigitsPerWord
+	wordsFrac, trailingDigits := frac/decimalDigitsPerWord, frac%decimalDigitsPerWord
+	size := wordsInt*decimalWordSize + decimalDig2Bytes[leadingDigits] +
+		wordsFrac*decimalWordSize + decimalDig2Bytes[trailingDigits]
+	if len(b)-2 < size {
+		return "", 0, errInsufficientData
+	}
+	bin := make([]byte, size)
+	copy(bin, b[2:])
+	negative := bin[0]&0x80 == 0
+	bin[0] ^= 0x80
+	if negative {
+		for i := range bin {
+			bin[i] = ^bin[i]
+		}
+	}
+
+	var sb strings.Builder
+	readWord := func(size, digits int) {
+		var v uint64
+		for _, c := range bin[:size] {
+			v = v<<8 | uint64(c)
+		}
+		bin = bin[size:]
+		fmt.Fprintf(&sb, "%0*d", digits, v)
+	}
+	if leadingDigits > 0 {
+		readWord(decimalDig2Bytes[leadingDigits], leadingDigits)
+	}
+	for i := 0; i < wordsInt; i++ {
+		readWord(decimalWordSize, decimalDigitsPerWord)
+	}
+	intPart := strings.TrimLeft(sb.String(), "0")
+	if intPart == "" {
+		intPart = "0"
+	}
+	sb.Reset()
+	for i := 0; i < wordsFrac; i++ {
+		readWord(decimalWordSize, decimalDigitsPerWord)
+	}
+	if trailingDigits > 0 {
+		readWord(decimalDig2Bytes[trailingDigits], trailingDigits)
+	}
+	ret := intPart
+	if frac > 0 {
+		ret += "." + sb.String()
+	}
+	if negative && strings.Trim(ret, "0.") != "" {
+		ret = "-" + ret
+	}
+	return ret, size + 2, nil
+}
+
+// formatDuration formats nanoseconds as MySQL time such as -01:02:03.5
+func formatDuration(nanos int64) string {
+	sign := ""
+	d := uint64(nanos)
+	if nanos < 0 {
+		sign = "-"
+		d = uint64(-nanos)
+	}
+	secs := d / 1e9
+	ret := fmt.Sprintf("%s%02d:%02d:%02d", sign, secs/3600, secs/60%60, secs%60)
+	if micros := d % 1e9 / 1e3; micros > 0 {
+		ret += strings.TrimRight(fmt.Sprintf(".%06d", micros), "0")
+	}
+	return ret
+}
+
+// formatPackedTime formats the time packed as uint64 by TiDB, the layout
+// from high bits is year * 13 + month, day, hour, minute, second and
+// microsecond.
+func formatPackedTime(v uint64) string {
+	ymdhms := v >> 24
+	ymd := ymdhms >> 17
+	day := ymd & (1<<5 - 1)
+	ym := ymd >> 5
+	year, month := ym/13, ym%13
+	hms := ymdhms & (1<<17 - 1)
+	hour, minute, second := hms>>12, (hms>>6)&(1<<6-1), hms&(1<<6-1)
+	ret := fmt.Sprintf("%04d-%02d-%02d %02d:%02d:%02d", year, month, day, hour, minute, second)
+	if micros := v & (1<<24 - 1); micros > 0 {
+		ret += strings.TrimRight(fmt.Sprintf(".%06d", micros), "0")
+	}
+	return ret
+}
+
+// tidbRowColumn is the column of row format v2, data is nil if the column
+// is null.
+type tidbRowColumn struct {
+	id   int64
+	data []byte
+}
+
+func decodeTiDBRow(b []byte) ([]tidbRowColumn, error) {
+	if len(b) < tidbRowHeaderSize || b[0] != tidbRowCodecVer {
+		return nil, errNotRowV2
+	}
+	var (
+		large        = b[1]&tidbRowFlagLarge != 0
+		notNullCount = int(binary.LittleEndian.Uint16(b[2:]))
+		nullCount    = int(binary.LittleEndian.Uint16(b[4:]))
+		idSize       = 1
+		offsetSize   = 2
+	)
+	if large {
+		idSize, offsetSize = 4, 4
+	}
+	colCount := notNullCount + nullCount
+	idsEnd := tidbRowHeaderSize + colCount*idSize
+	offsetsEnd := idsEnd + notNullCount*offsetSize
+	if len(b) < offsetsEnd {
+		return nil, errInvalidRow
+	}
+	data := b[offsetsEnd:]
+	cols := make([]tidbRowColumn, colCount)
+	start := 0
+	for i := range cols {
+		pos := tidbRowHeaderSize + i*idSize
+		if large {
+			cols[i].id = int64(binary.LittleEndian.Uint32(b[pos:]))
+		} else {
+			cols[i].id = int64(b[pos])
+		}
+		if i >= notNullCount {
+			continue
+		}
+		pos = idsEnd + i*offsetSize
+		var end int
+		if large {
+			end = int(binary.LittleEndian.Uint32(b[pos:]))
+		} else {
+			end = int(binary.LittleEndian.Uint16(b[pos:]))
+		}
+		if end < start || end > len(data) {
+			return nil, errInvalidRow
+		}
+		cols[i].data = data[start:end]
+		start = end
+	}
+	return cols, nil
+}
+
+// tidbRowTypes are the column types can be given to decode row value
+var tidbRowTypes = []string{"int", "uint", "float", "decimal", "str", "bytes", "time", "duration"}
+
+// decodeTiDBRowValue decodes column data by type, empty type guesses the
+// type: printable text is string, 1, 2, 4 or 8 bytes is integer and others
+// are bytes.
+func decodeTiDBRowValue(data []byte, tp string) (any, error) {
+	if data == nil {
+		return nil, nil
+	}
+	if tp == "" {
+		switch {
+		case utf8.Valid(data) && isPrintable(string(data)):
+			tp = "str"
+		case len(data) == 1 || len(data) == 2 || len(data) == 4 || len(data) == 8:
+			tp = "int"
+		default:
+			tp = "bytes"
+		}
+	}
+	switch tp {
+	case "int", "duration":
+		var v int64
+		switch len(data) {
+		case 1:
+			v = int64(int8(data[0]))
+		case 2:
+			v = int64(int16(binary.LittleEndian.Uint16(data)))
+		case 4:
+			v = int64(int32(binary.LittleEndian.Uint32(data)))
+		case 8:
+			v = int64(binary.LittleEndian.Uint64(data))
+		default:
+			return nil, errInvalidRow
+		}
+		if tp == "duration" {
+			return formatDuration(v), nil
+		}
+		return v, nil
+	case "uint", "time":
+		var v uint64
+		switch len(data) {
+		case 1:
+			v = uint64(data[0])
+		case 2:
+			v = uint64(binary.LittleEndian.Uint16(data))
+		case 4:
+			v = uint64(binary.LittleEndian.Uint32(data))
+		case 8:
+			v = binary.LittleEndian.Uint64(data)
+		default:
+			return nil, errInvalidRow
+		}
+		if tp == "time" {
+			return formatPackedTime(v), nil
+		}
+		return uintValue(v), nil
+	case "float":
+		if len(data) != 8 {
+			return nil, errInvalidRow
+		}
+		return decodeComparableFloat(data), nil
+	case "decimal":
+		ret, _, err := decodeDecimal(data)
+		return ret, err
+	case "str":
+		return string(data), nil
+	case "bytes":
+		return DisplayString(string(data)), nil
+	}
+	return nil, fmt.Errorf("unknown column type %s, supported: %s", tp, strings.Join(tidbRowTypes, ", "))
+}
@@ -0,0 +1,208 @@
+package kvql
+
+import "strings"
+
+// TokenKind is the kind of token for syntax highlighting
+type TokenKind int
+
+const (
+	TokenKeyword TokenKind = iota + 1
+	TokenFunction
+	TokenField
+	TokenIdent
+	TokenString
+	TokenNumber
+	TokenBool
+	TokenParam
+	TokenOperator
+	TokenPunct
+)
+
+var TokenKindToString = map[TokenKind]string{
+	TokenKeyword:  "keyword",
+	TokenFunction: "function",
+	TokenField:    "field",
+	TokenIdent:    "ident",
+	TokenString:   "string",
+	TokenNumber:   "number",
+	TokenBool:     "bool",
+	TokenParam:    "param",
+	TokenOperator: "operator",
+	TokenPunct:    "punct",
+}
+
+func (k TokenKind) String() string {
+	if s, have := TokenKindToString[k]; have {
+		return s
+	}
+	return "unknown"
+}
+
+// SpanToken is the token with its kind and span in query. Text is the raw
+// text in query, for string token it includes the quotes. Unterminated is
+// set for the string which is not closed at the end of query.
+type SpanToken struct {
+	*Token
+	Kind         TokenKind
+	Start        int
+	End          int
+	Text         string
+	Unterminated bool
+}
+
+// Tokenize splits query into tokens by Lexer.Split and keeps the kind and
+// span of each token for syntax highlighting. It never fails, the
+// incomplete input such as unterminated string is returned as is.
+func Tokenize(query string) []*SpanToken {
+	var (
+		toks  = NewLexer(query).Split()
+		ret   = make([]*SpanToken, 0, len(toks))
+		strAt = unterminatedString(query)
+	)
+	for i, tok := range toks {
+		if strAt >= 0 && tok.Pos >= strAt {
+			// Lexer returns the rest of unterminated string as name
+			break
+		}
+		st := &SpanToken{
+			Token: tok,
+			Start: tok.Pos,
+			End:   tokenEnd(query, tok),
+		}
+		st.Text = query[st.Start:st.End]
+		var prev, next *Token
+		if i > 0 {
+			prev = toks[i-1]
+		}
+		if i < len(toks)-1 {
+			next = toks[i+1]
+		}
+		st.Kind = tokenKind(tok, prev, next)
+		ret = append(ret, st)
+	}
+	if strAt >= 0 {
+		data := query[strAt+1:]
+		if isStringPrefix(query[strAt]) {
+			data = query[strAt+2:]
+		}
+		ret = append(ret, &SpanToken{
+			Token: &Token{
+				Tp:   STRING,
+				Data: data,
+				Pos:  strAt,
+			},
+			Kind:         TokenString,
+			Start:        strAt,
+			End:          len(query),
+			Text:         query[strAt:],
+			Unterminated: true,
+		})
+	}
+	return ret
+}
+
+// unterminatedString returns the position of the quote which is not
+// closed, or the position of its prefix for hex or escape string, -1 if
+// all strings are closed.
+func unterminatedString(query string) int {
+	var (
+		start  = -1
+		quote  byte
+		escape bool
+	)
+	for i := 0; i < len(query); i++ {
+		switch c := query[i]; c {
+		case '\\':
+			if start >= 0 && escape {
+				i++
+			}
+		case '\'', '"', '`':
+			if start < 0 {
+				start = i
+				quote = c
+				escape = false
+				if c != '`' && isPrefixedString(query, i) {
+					start = i - 1
+					escape = query[i-1]|0x20 == 'e'
+				}
+			} else if quote == c {
+				start = -1
+			}
+		}
+	}
+	if start >= 0 && quote == '`' {
+		// Unterminated quoted name is not a string
+		return -1
+	}
+	return start
+}
+
+func tokenEnd(query string, tok *Token) int {
+	if (tok.Tp == STRING || tok.Tp == INVALID) && tok.Pos < len(query) && isStringPrefix(query[tok.Pos]) {
+		// Hex or escape string, data is decoded
+		return stringLiteralEnd(query, tok.Pos)
+	}
+	end := tok.Pos + len(tok.Data)
+	if tok.Pos < len(query) {
+		switch query[tok.Pos] {
+		case '\'', '"', '`':
+			if tok.Tp == STRING || tok.Tp == NAME {
+				// Quoted string or name, data does not include quotes
+				end += 2
+			}
+		}
+	}
+	if end > len(query) {
+		end = len(query)
+	}
+	return end
+}
+
+func tokenKind(tok *Token, prev *Token, next *Token) TokenKind {
+	switch tok.Tp {
+	case STRING, INVALID:
+		return TokenString
+	case NUMBER, FLOAT:
+		return TokenNumber
+	case TRUE, FALSE:
+		return TokenBool
+	case PARAM:
+		return TokenParam
+	case KEY, VALUE:
+		return TokenField
+	case LPAREN, RPAREN, LBRACK, RBRACK, SEP, SEMI:
+		return TokenPunct
+	case OPERATOR:
+		switch tok.Data {
+		case "and", "or", "in", "between":
+			return TokenKeyword
+		}
+		return TokenOperator
+	case NAME:
+		if next != nil && next.Tp == LPAREN {
+			return TokenFunction
+		}
+		if isContextKeyword(tok, prev, next) {
+			return TokenKeyword
+		}
+		return TokenIdent
+	}
+	return TokenKeyword
+}
+
+// isContextKeyword checks the keywords which are lexed as name, they are
+// keyword only in the position of statement syntax.
+func isContextKeyword(tok *Token, prev *Token, next *Token) bool {
+	stmtStart := prev == nil || prev.Tp == SEMI
+	switch strings.ToLower(tok.Data) {
+	case "not":
+		return prev != nil && prev.Tp == IF
+	case "dry":
+		return stmtStart && next != nil && next.Tp == NAME && next.Data == "run"
+	case "run":
+		return prev != nil && prev.Tp == NAME && prev.Data == "dry"
+	case "begin", "commit", "rollback":
+		return stmtStart && (next == nil || next.Tp == SEMI)
+	}
+	return false
+}
@@ -0,0 +1,226 @@
+package kvql
+
+import (
+	"errors"
+	"strings"
+)
+
+// TxnStorage is an optional interface for Storage which supports
+// transaction. Statements executed by Session or ExecuteInTxn will be
+// applied atomically if the storage implements it.
+type TxnStorage interface {
+	Storage
+	// Begin starts a transaction, reads in the transaction should
+	// based on the snapshot when transaction starts.
+	Begin() (Txn, error)
+}
+
+// Txn is a transaction of TxnStorage, writes in the transaction are
+// invisible to others until commit.
+type Txn interface {
+	Storage
+	Commit() error
+	Rollback() error
+}
+
+var (
+	ErrTxnNotSupported = errors.New("Storage not support transaction")
+	ErrTxnNotBegin     = errors.New("Transaction not begin")
+	ErrTxnAlreadyBegin = errors.New("Transaction already begin")
+)
+
+// PlanCallback consumes the plan of each statement, the plan should be
+// fully executed before the callback returns.
+type PlanCallback func(query string, plan FinalPlan) error
+
+// SplitStatements splits the query into statements by `;`, the `;` in
+// string will be ignored.
+func SplitStatements(query string) []string {
+	var (
+		ret   = []string{}
+		start = 0
+	)
+	appendStmt := func(stmt string) {
+		stmt = strings.TrimSpace(stmt)
+		if len(stmt) > 0 {
+			ret = append(ret, stmt)
+		}
+	}
+	for _, tok := range NewLexer(query).Split() {
+		if tok.Tp == SEMI {
+			appendStmt(query[start:tok.Pos])
+			start = tok.Pos + 1
+		}
+	}
+	appendStmt(query[start:])
+	return ret
+}
+
+// Session executes statements on storage, statements between `begin`
+// and `commit` will be executed in a transaction.
+//
+//	begin; put ('k1', 'v1'); delete where key ^= 'tmp_'; commit;
+type Session struct {
+	Storage Storage
+	// Policy restricts the write statements executed by session
+	Policy *ExecutePolicy
+	// PlanCache caches the statements if it is not nil
+	PlanCache *PlanCache
+	txn       Txn
+}
+
+func NewSession(s Storage) *Session {
+	return &Session{
+		Storage: s,
+	}
+}
+
+func (s *Session) InTxn() bool {
+	return s.txn != nil
+}
+
+func (s *Session) Begin() error {
+	if s.txn != nil {
+		return ErrTxnAlreadyBegin
+	}
+	ts, ok := s.Storage.(TxnStorage)
+	if !ok {
+		return ErrTxnNotSupported
+	}
+	txn, err := ts.Begin()
+	if err != nil {
+		return err
+	}
+	s.txn = txn
+	return nil
+}
+
+func (s *Session) Commit() error {
+	if s.txn == nil {
+		return ErrTxnNotBegin
+	}
+	txn := s.txn
+	s.txn = nil
+	return txn.Commit()
+}
+
+func (s *Session) Rollback() error {
+	if s.txn == nil {
+		return ErrTxnNotBegin
+	}
+	txn := s.txn
+	s.txn = nil
+	return txn.Rollback()
+}
+
+func (s *Session) currentStorage() Storage {
+	if s.txn != nil {
+		return s.txn
+	}
+	return s.Storage
+}
+
+// Execute executes all the statements in query. If any statement fails
+// in transaction, the transaction will be rolled back. If cb is nil
+// the result of statements will be discarded.
+func (s *Session) Execute(query string, cb PlanCallback) error {
+	stmts := SplitStatements(query)
+	// Check syntax before execute any statement
+	for _, stmt := range stmts {
+		if isTxnStatement(stmt) != "" {
+			continue
+		}
+		if _, err := NewParser(stmt).Parse(); err != nil {
+			if qerr, ok := err.(QueryBinder); ok {
+				qerr.BindQuery(stmt)
+			}
+			return err
+		}
+	}
+	for _, stmt := range stmts {
+		err := s.executeStatement(stmt, cb)
+		if err != nil {
+			if s.txn != nil {
+				s.Rollback()
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Session) executeStatement(query string, cb PlanCallback) error {
+	switch isTxnStatement(query) {
+	case "begin":
+		return s.Begin()
+	case "commit":
+		return s.Commit()
+	case "rollback":
+		return s.Rollback()
+	}
+	var (
+		plan FinalPlan
+		err  error
+	)
+	if s.PlanCache != nil {
+		plan, err = s.PlanCache.BuildPlan(query, s.currentStorage(), s.Policy)
+	} else {
+		plan, err = NewOptimizer(query).BuildPlan(s.currentStorage(), s.Policy)
+	}
+	if err != nil {
+		if qerr, ok := err.(QueryBinder); ok {
+			qerr.BindQuery(query)
+		}
+		return err
+	}
+	if cb != nil {
+		return cb(query, plan)
+	}
+	ctx := NewExecuteCtx()
+	for {
+		rows, err := plan.Batch(ctx)
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+		ctx.Clear()
+	}
+}
+
+// ExecuteInTxn executes all the statements in query within one
+// transaction, if storage not support transaction it returns
+// ErrTxnNotSupported.
+func ExecuteInTxn(s Storage, query string, cb PlanCallback) error {
+	for _, stmt := range SplitStatements(query) {
+		if tstmt := isTxnStatement(stmt); tstmt != "" {
+			return NewSyntaxError(-1, "Cannot use %s statement in transaction", tstmt)
+		}
+	}
+	sess := NewSession(s)
+	err := sess.Begin()
+	if err != nil {
+		return err
+	}
+	err = sess.Execute(query, cb)
+	if err != nil {
+		if sess.InTxn() {
+			sess.Rollback()
+		}
+		return err
+	}
+	return sess.Commit()
+}
+
+func isTxnStatement(query string) string {
+	toks := NewLexer(query).Split()
+	if len(toks) != 1 || toks[0].Tp != NAME {
+		return ""
+	}
+	switch toks[0].Data {
+	case "begin", "commit", "rollback":
+		return toks[0].Data
+	}
+	return ""
+}
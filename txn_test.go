@@ -0,0 +1,133 @@
+package kvql
+
+import (
+	"strings"
+	"testing"
+)
+
+type mockTxnStorage struct {
+	*mockQueryStorage
+}
+
+type mockTxn struct {
+	*mockQueryStorage
+	parent *mockTxnStorage
+}
+
+func (t *mockTxnStorage) Begin() (Txn, error) {
+	// Copy data as snapshot
+	data := make([]KVPair, len(t.data))
+	copy(data, t.data)
+	return &mockTxn{
+		mockQueryStorage: newMockQueryStorage(data),
+		parent:           t,
+	}, nil
+}
+
+func (t *mockTxn) Commit() error {
+	t.parent.data = t.data
+	return nil
+}
+
+func (t *mockTxn) Rollback() error {
+	return nil
+}
+
+func newMockTxnStorage() *mockTxnStorage {
+	return &mockTxnStorage{
+		mockQueryStorage: newMockQueryStorage([]KVPair{
+			NewKVPStr("k1", "1"),
+			NewKVPStr("k2", "0"),
+			NewKVPStr("k3", "3"),
+		}),
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	stmts := SplitStatements("begin; put ('k;1', 'v;1') ;\n delete where key = ';' ;; commit;")
+	expected := []string{"begin", "put ('k;1', 'v;1')", "delete where key = ';'", "commit"}
+	if strings.Join(stmts, "|") != strings.Join(expected, "|") {
+		t.Fatal("Unexpected statements", stmts)
+	}
+}
+
+func TestSessionTxn(t *testing.T) {
+	txn := newMockTxnStorage()
+	sess := NewSession(txn)
+	results := []string{}
+	cb := func(query string, plan FinalPlan) error {
+		ctx := NewExecuteCtx()
+		row, err := plan.Next(ctx)
+		if err != nil {
+			return err
+		}
+		results = append(results, toString(row[0]))
+		return nil
+	}
+	err := sess.Execute("begin; put ('k4', '4'); delete where key in ('k1', 'k2'); select count(1) where true", cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sess.InTxn() {
+		t.Fatal("Session should in transaction")
+	}
+	// Changes should not visible before commit
+	rows := collectRows(t, txn, "select * where true", true)
+	if strings.Join(rows, "|") != "k1,1|k2,0|k3,3" {
+		t.Fatal("Should not see uncommitted changes", rows)
+	}
+	err = sess.Execute("commit;", cb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(results, "|") != "1|2|2" {
+		t.Fatal("Unexpected results", results)
+	}
+	rows = collectRows(t, txn, "select * where true", true)
+	if strings.Join(rows, "|") != "k3,3|k4,4" {
+		t.Fatal("Unexpected data after commit", rows)
+	}
+
+	err = sess.Execute("commit", nil)
+	if err != ErrTxnNotBegin {
+		t.Fatal("Should get transaction not begin error")
+	}
+}
+
+func TestExecuteInTxnRollback(t *testing.T) {
+	txn := newMockTxnStorage()
+	// Second statement fails when divide by zero
+	err := ExecuteInTxn(txn, "put ('k4', '4'); update set value = 10 / int(value) where key ^= 'k'", nil)
+	if _, ok := err.(*ExecuteError); !ok {
+		t.Fatal("Should get execute error", err)
+	}
+	rows := collectRows(t, txn, "select * where true", true)
+	if strings.Join(rows, "|") != "k1,1|k2,0|k3,3" {
+		t.Fatal("Changes should be rolled back", rows)
+	}
+
+	// Syntax error should not execute any statement
+	err = ExecuteInTxn(txn, "put ('k4', '4'); delete key = 'k1'", nil)
+	if _, ok := err.(*SyntaxError); !ok {
+		t.Fatal("Should get syntax error", err)
+	}
+
+	err = ExecuteInTxn(txn, "begin; put ('k4', '4'); commit", nil)
+	if err == nil {
+		t.Fatal("Should not allow transaction statement")
+	}
+
+	err = ExecuteInTxn(newMockQueryStorage(nil), "put ('k4', '4')", nil)
+	if err != ErrTxnNotSupported {
+		t.Fatal("Should get transaction not supported error")
+	}
+
+	err = ExecuteInTxn(txn, "put ('k4', '4'); delete where key = 'k1'", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows = collectRows(t, txn, "select * where true", true)
+	if strings.Join(rows, "|") != "k2,0|k3,3|k4,4" {
+		t.Fatal("Unexpected data after commit", rows)
+	}
+}
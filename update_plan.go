@@ -0,0 +1,131 @@
+package kvql
+
+import (
+	"fmt"
+	"strings"
+)
+
+type UpdatePlan struct {
+	Storage   Storage
+	Value     Expression
+	ChildPlan Plan
+	Returning *ReturningStmt
+	DryRun    bool
+	RowsLimit *RowsLimit
+	executed  bool
+	buffer    [][]Column
+	affected  int
+}
+
+func (p *UpdatePlan) Init() error {
+	p.executed = false
+	p.buffer = nil
+	p.affected = 0
+	return p.ChildPlan.Init()
+}
+
+func (p *UpdatePlan) String() string {
+	opts := append([]string{fmt.Sprintf("Value = '%s'", p.Value.String())}, writePlanOptions(p.Returning, p.DryRun)...)
+	return fmt.Sprintf("UpdatePlan{%s}", strings.Join(opts, ", "))
+}
+
+func (p *UpdatePlan) Explain() []string {
+	ret := []string{p.String()}
+	for _, plan := range p.ChildPlan.Explain() {
+		ret = append(ret, plan)
+	}
+	return ret
+}
+
+func (p *UpdatePlan) FieldNameList() []string {
+	return writeFieldNameList(p.Returning)
+}
+
+func (p *UpdatePlan) FieldTypeList() []Type {
+	return writeFieldTypeList(p.Returning)
+}
+
+func (p *UpdatePlan) Next(ctx *ExecuteCtx) ([]Column, error) {
+	if p.Returning != nil {
+		return nextReturningRow(&p.buffer, ctx, p.executeReturning)
+	}
+	if !p.executed {
+		n, err := p.execute(ctx)
+		p.executed = true
+		return []Column{n}, err
+	}
+	return nil, nil
+}
+
+func (p *UpdatePlan) Batch(ctx *ExecuteCtx) ([][]Column, error) {
+	if p.Returning != nil {
+		return p.executeReturning(ctx)
+	}
+	if !p.executed {
+		n, err := p.execute(ctx)
+		p.executed = true
+		row := []Column{n}
+		return [][]Column{row}, err
+	}
+	return nil, nil
+}
+
+// updateBatch updates next batch of rows from child plan and returns the
+// updated key value pairs, if dry run the rows will not be written.
+func (p *UpdatePlan) updateBatch(ctx *ExecuteCtx) ([]KVPair, error) {
+	ctx.Clear()
+	rows, err := p.ChildPlan.Batch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	nrows := len(rows)
+	if nrows == 0 {
+		return nil, nil
+	}
+	values, err := p.Value.ExecuteBatch(rows, ctx)
+	if err != nil {
+		return nil, err
+	}
+	kvps := make([]KVPair, nrows)
+	for i, kv := range rows {
+		kvps[i] = NewKVP(kv.Key, []byte(toString(values[i])))
+	}
+	if p.DryRun {
+		return kvps, nil
+	}
+	err = p.RowsLimit.Check(p.affected, nrows)
+	if err != nil {
+		return nil, err
+	}
+	err = p.Storage.BatchPut(kvps)
+	if err != nil {
+		return nil, err
+	}
+	p.affected += nrows
+	return kvps, nil
+}
+
+func (p *UpdatePlan) execute(ctx *ExecuteCtx) (int, error) {
+	count := 0
+	for {
+		kvps, err := p.updateBatch(ctx)
+		if err != nil {
+			return count, err
+		}
+		nrows := len(kvps)
+		if nrows == 0 {
+			return count, nil
+		}
+		count += nrows
+	}
+}
+
+func (p *UpdatePlan) executeReturning(ctx *ExecuteCtx) ([][]Column, error) {
+	kvps, err := p.updateBatch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Cached results are calculated by old values
+	ctx.Clear()
+	return p.Returning.project(kvps, ctx)
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"math"
 	"reflect"
 )
 
@@ -30,6 +31,8 @@ func convertToByteArray(value any) ([]byte, bool) {
 	}
 }
 
+// convertToInt converts the integer types to int64, uint64 larger than
+// max int64 is not convertible.
 func convertToInt(value any) (int64, bool) {
 	switch ret := value.(type) {
 	case int:
@@ -43,7 +46,7 @@ func convertToInt(value any) (int64, bool) {
 	case int64:
 		return ret, true
 	case uint:
-		return int64(ret), true
+		return int64(ret), uint64(ret) <= math.MaxInt64
 	case uint8:
 		return int64(ret), true
 	case uint16:
@@ -51,95 +54,128 @@ func convertToInt(value any) (int64, bool) {
 	case uint32:
 		return int64(ret), true
 	case uint64:
-		return int64(ret), true
+		return int64(ret), ret <= math.MaxInt64
 	default:
 		return 0, false
 	}
 }
 
+// convertToFloat converts the float and integer types to float64, it is
+// used to promote integer when the other operand is float.
 func convertToFloat(value any) (float64, bool) {
 	switch ret := value.(type) {
 	case float32:
 		return float64(ret), true
 	case float64:
 		return ret, true
+	case int:
+		return float64(ret), true
+	case int8:
+		return float64(ret), true
+	case int16:
+		return float64(ret), true
+	case int32:
+		return float64(ret), true
+	case int64:
+		return float64(ret), true
+	case uint:
+		return float64(ret), true
+	case uint8:
+		return float64(ret), true
+	case uint16:
+		return float64(ret), true
+	case uint32:
+		return float64(ret), true
+	case uint64:
+		return float64(ret), true
 	default:
 		return 0, false
 	}
 }
 
+/*
+executeMathOp executes + - * / % on numbers:
+
+  - Both operands are integers: the result is int64, `/` truncates toward
+    zero and `%` has the sign of left operand. Overflow of int64 returns
+    error instead of wrapping around.
+  - Otherwise the integer operand is promoted to float64 and the result is
+    float64, `%` is the floating-point remainder.
+  - Divide by zero returns error for both integer and float.
+*/
 func executeMathOp(left any, right any, op byte, rightExpr Expression) (any, error) {
 	lint, liok := convertToInt(left)
 	rint, riok := convertToInt(right)
 	if liok && riok {
-		switch op {
-		case '+':
-			return lint + rint, nil
-		case '-':
-			return lint - rint, nil
-		case '*':
-			return lint * rint, nil
-		case '/':
-			if rint == 0 {
-				return 0, NewExecuteError(rightExpr.GetPos(), "Divide by zero")
-			}
-			return lint / rint, nil
-		default:
-			return 0.0, errors.New("Unknown operator")
-		}
+		return executeIntMathOp(lint, rint, op, rightExpr)
 	}
-	// Float
 	lfloat, lfok := convertToFloat(left)
 	rfloat, rfok := convertToFloat(right)
-	if lfok && rfok {
-		switch op {
-		case '+':
-			return lfloat + rfloat, nil
-		case '-':
-			return lfloat - rfloat, nil
-		case '*':
-			return lfloat * rfloat, nil
-		case '/':
-			if rfloat == 0.0 {
-				return 0, NewExecuteError(rightExpr.GetPos(), "Divide by zero")
-			}
-			return lfloat / rfloat, nil
-		default:
-			return 0.0, errors.New("Unknown operator")
+	if !lfok || !rfok {
+		return 0.0, fmt.Errorf("Invalid operator %c left or right parameter type", op)
+	}
+	switch op {
+	case '+':
+		return lfloat + rfloat, nil
+	case '-':
+		return lfloat - rfloat, nil
+	case '*':
+		return lfloat * rfloat, nil
+	case '/':
+		if rfloat == 0.0 {
+			return 0, NewExecuteError(rightExpr.GetPos(), "Divide by zero")
+		}
+		return lfloat / rfloat, nil
+	case '%':
+		if rfloat == 0.0 {
+			return 0, NewExecuteError(rightExpr.GetPos(), "Divide by zero")
 		}
+		return math.Mod(lfloat, rfloat), nil
+	default:
+		return 0.0, errors.New("Unknown operator")
 	}
+}
 
+func executeIntMathOp(left int64, right int64, op byte, rightExpr Expression) (any, error) {
 	var (
-		lfval float64
-		rfval float64
+		ret      int64
+		overflow bool
 	)
-	if liok && rfok {
-		lfval = float64(lint)
-		rfval = rfloat
-	} else if lfok && riok {
-		lfval = lfloat
-		rfval = float64(rint)
-	} else {
-		return 0.0, fmt.Errorf("Invalid operator %v left or right parameter type", op)
-	}
 	switch op {
 	case '+':
-		return lfval + rfval, nil
+		ret = left + right
+		overflow = (left^ret)&(right^ret) < 0
 	case '-':
-		return lfval - rfval, nil
+		ret = left - right
+		overflow = (left^right)&(left^ret) < 0
 	case '*':
-		return lfval * rfval, nil
+		ret = left * right
+		overflow = left != 0 && (ret/left != right || (left == -1 && right == math.MinInt64))
 	case '/':
-		if rfval == 0.0 {
+		if right == 0 {
+			return 0, NewExecuteError(rightExpr.GetPos(), "Divide by zero")
+		}
+		ret = left / right
+		overflow = left == math.MinInt64 && right == -1
+	case '%':
+		if right == 0 {
 			return 0, NewExecuteError(rightExpr.GetPos(), "Divide by zero")
 		}
-		return lfval / rfval, nil
+		ret = left % right
 	default:
-		return 0.0, errors.New("Unknown operator")
+		return 0, errors.New("Unknown operator")
 	}
+	if overflow {
+		return 0, NewExecuteError(rightExpr.GetPos(), "Integer overflow: %d %c %d", left, op, right)
+	}
+	return ret, nil
 }
 
 func execNumberCompare(left any, right any, op string) (bool, error) {
+	// Comparison with null is false
+	if left == nil || right == nil {
+		return false, nil
+	}
 	lint, liok := convertToInt(left)
 	rint, riok := convertToInt(right)
 	if liok && riok {
@@ -184,6 +220,9 @@ func execNumberCompare(left any, right any, op string) (bool, error) {
 }
 
 func execStringCompare(left any, right any, op string) (bool, error) {
+	if left == nil || right == nil {
+		return false, nil
+	}
 	lstr, lsok := convertToByteArray(left)
 	rstr, rsok := convertToByteArray(right)
 	if lsok && rsok {
@@ -207,9 +246,37 @@ func execStringCompare(left any, right any, op string) (bool, error) {
 	return false, fmt.Errorf("Invalid operator %v left or right parameter type", op)
 }
 
+func execTimeCompare(left any, right any, op string) (bool, error) {
+	if left == nil || right == nil {
+		return false, nil
+	}
+	ltime, lok := toTime(left)
+	rtime, rok := toTime(right)
+	if lok && rok {
+		switch op {
+		case ">":
+			return ltime.After(rtime), nil
+		case ">=":
+			return !ltime.Before(rtime), nil
+		case "<":
+			return ltime.Before(rtime), nil
+		case "<=":
+			return !ltime.After(rtime), nil
+		case "=":
+			return ltime.Equal(rtime), nil
+		default:
+			return false, errors.New("Unknown operator")
+		}
+	}
+
+	return false, fmt.Errorf("Invalid operator %v left or right parameter type", op)
+}
+
 func unpackArray(s any) ([]any, bool) {
 	var ret []any
 	switch val := s.(type) {
+	case []any:
+		return val, true
 	case []string:
 		ret = make([]any, len(val))
 		for i, item := range val {
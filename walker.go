@@ -17,6 +17,10 @@ func (e *FieldReferenceExpr) Walk(cb WalkCallback) {
 	}
 }
 
+func (e *CTEColumnExpr) Walk(cb WalkCallback) {
+	cb(e)
+}
+
 func (e *StringExpr) Walk(cb WalkCallback) {
 	cb(e)
 }
@@ -52,6 +56,10 @@ func (e *BoolExpr) Walk(cb WalkCallback) {
 	cb(e)
 }
 
+func (e *ParamExpr) Walk(cb WalkCallback) {
+	cb(e)
+}
+
 func (e *ListExpr) Walk(cb WalkCallback) {
 	if cb(e) {
 		for _, item := range e.List {
@@ -66,3 +74,13 @@ func (e *FieldAccessExpr) Walk(cb WalkCallback) {
 		e.FieldName.Walk(cb)
 	}
 }
+
+func (e *LambdaExpr) Walk(cb WalkCallback) {
+	if cb(e) {
+		e.Body.Walk(cb)
+	}
+}
+
+func (e *LambdaArgExpr) Walk(cb WalkCallback) {
+	cb(e)
+}